@@ -2,21 +2,20 @@ package aof
 
 import (
 	"context"
-	"io"
+	"fmt"
 	"myredis/config"
 	"myredis/interface/database"
 	"myredis/lib/logger"
 	"myredis/lib/utils"
 	"myredis/myredis/connection"
-	"myredis/myredis/parser"
 	"myredis/protocol"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	rdb "github.com/hdt3213/rdb/core"
 )
 
 const (
@@ -41,19 +40,25 @@ type Listener interface {
 	Callback([]CmdLine)
 }
 
+// 加载 AOF 时报告进度，单位为字节；传给 NewPersister 的数据库实现该接口时 LoadAof 会调用它
+type LoadingProgress interface {
+	SetLoadingTotal(total int64)
+	SetLoadingLoaded(loaded int64)
+}
+
 type Persister struct {
 	/* 上下文控制，用于扫描任务的控制 */
 	ctx    context.Context
 	cancel context.CancelFunc
-	/* 数据库实例，用于执行命令 */
+	/* 数据库实例，用于执行命令，重写时从中获取快照 */
 	db database.DBEngine
-	/* AOF 重写过程中创建临时数据库实例，通过加载 AOF 文件实现 */
-	tmpDBMaker func() database.DBEngine
 	/* 接收写入 AOF 的命令 */
 	aofChan chan *payload
 	/* 管理 AOF 文件 */
-	aofFile     *os.File
-	aofFilename string
+	aofFile     *os.File     // 当前写入的 incr 文件
+	aofFilename string       // 配置的 AOF 文件名，作为 multi-part 文件名前缀
+	aofDir      string       // 存放 base、incr 文件与清单的目录
+	manifest    *aofManifest // 当前生效的清单，由 pausingAof 保护
 	/* fsync 策略 */
 	aofFsyncStrategy string
 	aofFinshed       chan struct{}
@@ -61,31 +66,52 @@ type Persister struct {
 	pausingAof sync.Mutex
 	/* 记录当前的数据库编号，避免写入不必要的 SELECT 命令，减少数据库切换开销 */
 	currentDB int
+	/* 最后一次写入时间戳注释的时间，开启 aof-timestamp-enabled 时每秒最多写入一次 */
+	lastTimestamp int64
 
 	// 监听器集合，用于写入 AOF 后通知其他组件
 	listeners map[Listener]struct{}
 	buffer    []CmdLine
+
+	/* 重写状态 */
+	rewriting       atomic.Bool  // 是否有正在进行的重写
+	lastRewriteErr  atomic.Bool  // 上次重写是否失败
+	lastRewriteCost atomic.Int64 // 上次重写耗时（秒），-1 表示从未重写
+	baseSize        atomic.Int64 // 启动或上次重写完成时 AOF 的总大小，用于判断增长比例
 }
 
-func NewPersister(db database.DBEngine, filename string, load bool, fsyncStrategy string, tmpDBMaker func() database.DBEngine) (*Persister, error) {
+func NewPersister(db database.DBEngine, filename string, load bool, fsyncStrategy string) (*Persister, error) {
+	dirname := defaultAofDirname
+	if config.Properties != nil && config.Properties.AppendDirname != "" {
+		dirname = config.Properties.AppendDirname
+	}
 	persister := &Persister{
 		aofFilename:      filename,
+		aofDir:           filepath.Join(filepath.Dir(filename), dirname),
 		aofFsyncStrategy: fsyncStrategy,
 		db:               db,
-		tmpDBMaker:       tmpDBMaker,
 		currentDB:        0,
 	}
+	// 读取清单，必要时从单文件 AOF 升级
+	err := persister.initManifest()
+	if err != nil {
+		return nil, err
+	}
 
 	// 如果加载 aof file
 	if load {
-		persister.LoadAof(0)
+		err = persister.LoadAof()
+		if err != nil {
+			return nil, err
+		}
 	}
-	// 打开或创建一个 AOF 日志文件，支持读写，每次写入自动追加到末尾，并确保文件权限安全
-	aofFile, err := os.OpenFile(persister.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	// 继续写入最后一个 incr 文件，或者创建新的 incr 文件
+	err = persister.openIncrFile(load)
 	if err != nil {
 		return nil, err
 	}
-	persister.aofFile = aofFile
+	persister.lastRewriteCost.Store(-1)
+	persister.baseSize.Store(persister.aofSize())
 	persister.aofChan = make(chan *payload)
 	persister.aofFinshed = make(chan struct{})
 	persister.listeners = make(map[Listener]struct{})
@@ -104,6 +130,114 @@ func NewPersister(db database.DBEngine, filename string, load bool, fsyncStrateg
 	return persister, nil
 }
 
+// 清单文件的路径
+func (persister *Persister) manifestPath() string {
+	return filepath.Join(persister.aofDir, filepath.Base(persister.aofFilename)+manifestSuffix)
+}
+
+// 读取清单；不存在清单但存在旧的单文件 AOF 时，将其移入目录作为 base 文件
+func (persister *Persister) initManifest() error {
+	err := os.MkdirAll(persister.aofDir, 0755)
+	if err != nil {
+		return err
+	}
+	manifest, err := loadManifest(persister.manifestPath())
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		manifest = &aofManifest{}
+		info, err := os.Stat(persister.aofFilename)
+		if err == nil && !info.IsDir() {
+			base := manifest.nextBase(filepath.Base(persister.aofFilename), false)
+			err = os.Rename(persister.aofFilename, filepath.Join(persister.aofDir, base.name))
+			if err != nil {
+				return err
+			}
+			logger.Info("upgrade " + persister.aofFilename + " to multi part aof")
+		}
+		err = saveManifest(persister.manifestPath(), manifest)
+		if err != nil {
+			return err
+		}
+	}
+	persister.manifest = manifest
+	// 清理上次重写后残留的 history 文件
+	persister.cleanupHistory()
+	return nil
+}
+
+// 打开用于追加写入的 incr 文件
+//
+// 加载过数据时继续写入最后一个 incr 文件（此时 currentDB 与文件末尾一致），
+// 否则创建一个新的 incr 文件
+func (persister *Persister) openIncrFile(loaded bool) error {
+	if loaded && len(persister.manifest.incrs) > 0 {
+		last := persister.manifest.incrs[len(persister.manifest.incrs)-1]
+		aofFile, err := os.OpenFile(filepath.Join(persister.aofDir, last.name), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+		if err != nil {
+			return err
+		}
+		persister.aofFile = aofFile
+		return nil
+	}
+	_, err := persister.rotateIncr()
+	return err
+}
+
+// 创建新的 incr 文件并切换写入，调用者需要持有 pausingAof（初始化时除外）
+//
+// 新文件以 SELECT 开头，保证其可以独立于之前的文件被正确重放
+func (persister *Persister) rotateIncr() (*aofInfo, error) {
+	manifest := persister.manifest.copy()
+	info := manifest.nextIncr(filepath.Base(persister.aofFilename))
+	aofFile, err := os.OpenFile(filepath.Join(persister.aofDir, info.name), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	data := protocol.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(persister.currentDB))).ToBytes()
+	_, err = aofFile.Write(data)
+	if err == nil {
+		err = saveManifest(persister.manifestPath(), manifest)
+	}
+	if err != nil {
+		_ = aofFile.Close()
+		_ = os.Remove(aofFile.Name())
+		return nil, err
+	}
+	if persister.aofFile != nil {
+		_ = persister.aofFile.Sync()
+		_ = persister.aofFile.Close()
+	}
+	persister.aofFile = aofFile
+	persister.manifest = manifest
+	// 新的 incr 文件在第一条命令之前写入时间戳
+	persister.lastTimestamp = 0
+	return info, nil
+}
+
+// 删除清单中的 history 文件，调用者需要持有 pausingAof（初始化时除外）
+func (persister *Persister) cleanupHistory() {
+	if len(persister.manifest.history) == 0 {
+		return
+	}
+	manifest := persister.manifest.copy()
+	for _, info := range manifest.history {
+		err := os.Remove(filepath.Join(persister.aofDir, info.name))
+		if err != nil && !os.IsNotExist(err) {
+			logger.Warn("remove history aof file failed: " + err.Error())
+			return
+		}
+	}
+	manifest.history = nil
+	err := saveManifest(persister.manifestPath(), manifest)
+	if err != nil {
+		logger.Warn("save aof manifest failed: " + err.Error())
+		return
+	}
+	persister.manifest = manifest
+}
+
 func (persister *Persister) RemoveListener(listener Listener) {
 	persister.pausingAof.Lock()
 	defer persister.pausingAof.Unlock()
@@ -134,16 +268,44 @@ func (persister *Persister) SaveCmdLine(dbIndex int, cmdline CmdLine) {
 // 监听通道，异步保存 AOF 文件
 func (persister *Persister) listenCmdLine() {
 	for payload := range persister.aofChan {
+		// 屏障，通知等待者之前的命令均已写入
+		if payload.wg != nil {
+			payload.wg.Done()
+			continue
+		}
 		persister.WriteAof(payload)
 	}
 	persister.aofFinshed <- struct{}{}
 }
 
+// 等待已经进入 aofChan 的命令全部写入文件
+func (persister *Persister) flushPending() {
+	if persister.aofChan == nil {
+		return
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	persister.aofChan <- &payload{wg: wg}
+	wg.Wait()
+}
+
 func (persister *Persister) WriteAof(payload *payload) {
 	// 设置为空切片
 	persister.buffer = persister.buffer[:0]
 	persister.pausingAof.Lock()
 	defer persister.pausingAof.Unlock()
+	// 写入时间戳注释，用于按时间点恢复
+	if timestampEnabled() {
+		now := time.Now().Unix()
+		if now > persister.lastTimestamp {
+			_, err := persister.aofFile.Write(makeTimestampAnnotation(now))
+			if err != nil {
+				logger.Warn(err)
+				return
+			}
+			persister.lastTimestamp = now
+		}
+	}
 	// 判断是否需要写入数据库切换
 	if payload.dbIndex != persister.currentDB {
 		// 修改当前数据库
@@ -177,82 +339,76 @@ func (persister *Persister) WriteAof(payload *payload) {
 	}
 }
 
-// 加载 AOF 文件，重建数据库
-func (persister *Persister) LoadAof(maxBytes int) {
+// 按照清单依次加载 base 与 incr 文件，重建数据库
+//
+// 文件中间损坏时返回错误；最后一个文件末尾的命令不完整时，
+// 若开启了 aof-load-truncated 则截去不完整的部分并继续，否则返回错误
+func (persister *Persister) LoadAof() error {
 	// 确保在加载 AOF 文件时的 aofChan 不会发送新的数据
 	aofChan := persister.aofChan
-	persister = nil
+	persister.aofChan = nil
 	defer func(aofChan chan *payload) {
 		persister.aofChan = aofChan
 	}(aofChan)
 
-	// 读取 AOF 文件
-	file, err := os.Open(persister.aofFilename)
-	if err != nil {
-		if _, ok := err.(*os.PathError); ok {
-			return
-		}
-		logger.Warn(err)
-		return
+	// 加载进度以清单中全部文件的总大小为准
+	progress, _ := persister.db.(LoadingProgress)
+	if progress != nil {
+		progress.SetLoadingTotal(persister.aofSize())
 	}
-	defer file.Close()
-
-	// 解码可能的 RDB 二进制文件前缀
-	decoder := rdb.NewDecoder(file)
-	err = persister.db.LoadRDB(decoder)
-	if err != nil {
-		// 没有 RDB 文件前缀
-		file.Seek(0, io.SeekStart)
-	} else {
-		_, _ = file.Seek(int64(decoder.GetReadCount())+1, io.SeekStart)
-		maxBytes = maxBytes - decoder.GetReadCount()
+	var loadedBefore int64
+	files := persister.manifest.files()
+	for i, info := range files {
+		filename := filepath.Join(persister.aofDir, info.name)
+		err := persister.loadAofFile(filename, i == len(files)-1, func(offset int64) {
+			if progress != nil {
+				progress.SetLoadingLoaded(loadedBefore + offset)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if stat, err := os.Stat(filename); err == nil {
+			loadedBefore += stat.Size()
+		}
 	}
+	return nil
+}
 
-	var reader io.Reader
-	if maxBytes > 0 {
-		reader = io.LimitReader(file, int64(maxBytes))
-	} else {
-		reader = file
-	}
-	// 从 AOF 文件流解析 redis 协议，需要为 MultiBulkReply
-	channel := parser.ParseStream(reader)
+// 加载单个 AOF 文件，文件可以以 RDB 格式开头，每重放一条命令以文件内的偏移量调用 onProgress
+func (persister *Persister) loadAofFile(filename string, last bool, onProgress func(offset int64)) error {
 	// 用于重建数据库的临时连接
 	simpleConn := connection.NewSimpleConn()
-	// 监听 redis 协议
-	for ch := range channel {
-		if ch.Err != nil {
-			if ch.Err == io.EOF {
-				break
-			}
-			logger.Error("parse error: " + ch.Err.Error())
-			continue
-		}
-		if ch.Data == nil {
-			logger.Error("empty payload")
-			continue
-		}
-		// 得到对应的命令
-		reply, ok := ch.Data.(*protocol.MultiBulkReply)
-		if !ok {
-			logger.Error("require multi bulk protocol")
-			continue
-		}
+	result, err := scanAofFile(filename, persister.db.LoadRDB, func(cmdLine CmdLine, offset int64) {
+		onProgress(offset)
 		// 执行对应命令，重建数据库
-		res := persister.db.Exec(simpleConn, reply.Args)
+		res := persister.db.Exec(simpleConn, cmdLine)
 		if protocol.IsErrorReply(res) {
 			logger.Error("exec err", string(res.ToBytes()))
 		}
 		// 确保当前数据库索引正确
-		if strings.ToLower(string(reply.Args[0])) == "select" {
-			// execSelect success, here must be no error
-			dbIndex, err := strconv.Atoi(string(reply.Args[1]))
+		if strings.ToLower(string(cmdLine[0])) == "select" && len(cmdLine) == 2 {
+			dbIndex, err := strconv.Atoi(string(cmdLine[1]))
 			if err == nil {
 				persister.currentDB = dbIndex
 			}
 		}
-
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("read aof file %s failed: %v", filename, err)
 	}
-
+	if result.Err != nil {
+		return fmt.Errorf("bad aof file %s: %v, use myredis-check-aof to inspect it", filename, result.Err)
+	}
+	if !result.Truncated {
+		return nil
+	}
+	if !last || config.Properties == nil || !config.Properties.AofLoadTruncated {
+		return fmt.Errorf("aof file %s is truncated at offset %d, use myredis-check-aof --fix to repair it", filename, result.ValidSize)
+	}
+	logger.Warn(fmt.Sprintf("aof file %s is truncated, discard %d bytes after offset %d",
+		filename, result.Size-result.ValidSize, result.ValidSize))
+	return truncateAof(filename, result.ValidSize)
 }
 
 // 停止 aof channel，保存文件
@@ -295,13 +451,16 @@ func (persister *Persister) Close() {
 	persister.cancel()
 }
 
-// 用于重写过程中，将临时文件写入 AOF
+// 用于重写过程中，将快照中的数据以最简命令写入临时文件
 func (persister *Persister) generateAof(ctx *RewriteContext) error {
-	// 获取临时文件、临时保存实例
 	tempFile := ctx.tempFile
-	tempAofHandler := persister.newRewriteHandler()
-	// 加载临时文件
-	tempAofHandler.LoadAof(int(ctx.fileSize))
+	// base 文件以切点时刻的时间戳开头
+	if timestampEnabled() {
+		_, err := tempFile.Write(makeTimestampAnnotation(ctx.timestamp))
+		if err != nil {
+			return err
+		}
+	}
 	for i := 0; i < config.Properties.Databases; i++ {
 		// Select database
 		data := protocol.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(i))).ToBytes()
@@ -309,21 +468,31 @@ func (persister *Persister) generateAof(ctx *RewriteContext) error {
 		if err != nil {
 			return err
 		}
-		// 遍历每个数据库
-		tempAofHandler.db.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+		// 遍历快照中的每个数据库
+		ctx.snapshot.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
 			cmd := EntityToCmd(key, entity)
 			// 写入维护数据库内容的最简命令
 			if cmd != nil {
-				_, _ = tempFile.Write(cmd.ToBytes())
+				_, err = tempFile.Write(cmd.ToBytes())
 			}
-			if expiration != nil {
+			if err == nil && expiration != nil {
 				cmd := MakeExpiredCmd(key, *expiration)
 				if cmd != nil {
-					_, _ = tempFile.Write(cmd.ToBytes())
+					_, err = tempFile.Write(cmd.ToBytes())
+				}
+			}
+			// 哈希字段的过期时间
+			for _, cmd := range FieldExpireCmds(key, entity) {
+				if err != nil {
+					break
 				}
+				_, err = tempFile.Write(cmd.ToBytes())
 			}
-			return true
+			return err == nil
 		})
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -0,0 +1,260 @@
+// check.go 实现了 AOF 与 RDB 文件的校验：
+//
+//   - 加载 AOF 与 myredis-check-aof 使用同一个解析过程 scanAofFile
+//   - 末尾不完整的命令视为截断（通常是写入过程中宕机），可以截去后继续使用
+//   - 文件中间的格式错误视为损坏，报告出错位置
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"myredis/myredis/parser"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/crc64jones"
+	rdb "github.com/hdt3213/rdb/parser"
+)
+
+// RDB 文件以此开头
+var rdbMagic = []byte("REDIS")
+
+// 单个 AOF 文件的校验结果
+type CheckResult struct {
+	Filename    string
+	Size        int64 // 文件大小
+	ValidSize   int64 // 最后一条完整命令的结束位置
+	Commands    int   // 完整命令的数量
+	RdbPreamble bool  // 是否以 RDB 格式开头
+	Truncated   bool  // 末尾存在不完整的命令
+	Err         error // 格式错误，包含出错位置
+}
+
+// 文件是否完好
+func (result *CheckResult) OK() bool {
+	return result.Err == nil && !result.Truncated
+}
+
+// 依次读取 AOF 文件中的 RDB 前缀与命令
+//
+// loadRDB 处理 RDB 前缀，exec 处理每条完整的命令及其之后的偏移量，onAnnotation 处理注释（可以为 nil）；
+// 返回的 error 仅表示文件无法读取，格式问题记录在 CheckResult 中
+func scanAofFile(filename string, loadRDB func(*core.Decoder) error, exec func(cmdLine CmdLine, offset int64),
+	onAnnotation func(annotation string, offset int64)) (*CheckResult, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	result := &CheckResult{
+		Filename: filename,
+		Size:     stat.Size(),
+	}
+
+	var offset int64
+	head := make([]byte, len(rdbMagic))
+	n, _ := io.ReadFull(file, head)
+	if n == len(rdbMagic) && bytes.Equal(head, rdbMagic) {
+		// 解码 RDB 前缀
+		result.RdbPreamble = true
+		_, _ = file.Seek(0, io.SeekStart)
+		decoder := rdb.NewDecoder(file)
+		err = loadRDB(decoder)
+		if err != nil {
+			result.Err = fmt.Errorf("bad rdb preamble: %v", err)
+			return result, nil
+		}
+		offset = int64(decoder.GetReadCount())
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	result.ValidSize = offset
+
+	reader := parser.NewCommandReader(file, offset)
+	reader.OnAnnotation = onAnnotation
+	for {
+		cmdLine, err := reader.ReadCommand()
+		if err != nil {
+			result.ValidSize = reader.Offset()
+			if err == io.EOF {
+				break
+			}
+			if err == io.ErrUnexpectedEOF {
+				result.Truncated = true
+				break
+			}
+			var corruption *parser.CorruptionError
+			if errors.As(err, &corruption) {
+				result.Err = corruption
+				break
+			}
+			return nil, err
+		}
+		result.Commands++
+		if exec != nil {
+			exec(cmdLine, reader.Offset())
+		}
+	}
+	return result, nil
+}
+
+// 校验单个 AOF 文件，不执行其中的命令
+func CheckAofFile(filename string) (*CheckResult, error) {
+	return scanAofFile(filename, skipRDB, nil, nil)
+}
+
+// 仅解析 RDB 前缀而不加载数据
+func skipRDB(decoder *core.Decoder) error {
+	return decoder.Parse(func(object rdb.RedisObject) bool {
+		return true
+	})
+}
+
+// 校验 AOF，path 可以是单个 AOF 文件、AOF 目录或清单文件
+//
+// 对于 multi-part AOF，按照清单的加载顺序返回每个文件的结果
+func CheckAof(path string) ([]*CheckResult, error) {
+	manifestPath, err := findManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	if manifestPath == "" {
+		result, err := CheckAofFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return []*CheckResult{result}, nil
+	}
+	manifest, err := loadManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, fmt.Errorf("aof manifest %s not found", manifestPath)
+	}
+	dir := filepath.Dir(manifestPath)
+	var results []*CheckResult
+	for _, info := range manifest.files() {
+		result, err := CheckAofFile(filepath.Join(dir, info.name))
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// 返回 path 对应的清单文件，path 为普通 AOF 文件时返回空字符串
+func findManifest(path string) (string, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !stat.IsDir() {
+		if strings.HasSuffix(path, manifestSuffix) {
+			return path, nil
+		}
+		return "", nil
+	}
+	matches, err := filepath.Glob(filepath.Join(path, "*"+manifestSuffix))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) != 1 {
+		return "", fmt.Errorf("expect exactly one aof manifest in %s, found %d", path, len(matches))
+	}
+	return matches[0], nil
+}
+
+// 截去文件末尾不完整的命令
+func FixTruncatedAof(result *CheckResult) error {
+	if result.Err != nil {
+		return fmt.Errorf("%s is corrupted, refuse to truncate: %v", result.Filename, result.Err)
+	}
+	if !result.Truncated {
+		return nil
+	}
+	return truncateAof(result.Filename, result.ValidSize)
+}
+
+func truncateAof(filename string, size int64) error {
+	file, err := os.OpenFile(filename, os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err = file.Truncate(size); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// RDB 文件的校验结果
+type RDBCheckResult struct {
+	Filename string
+	Size     int64
+	Keys     int   // 键的数量
+	Checksum bool  // 文件是否包含校验和（为 0 表示未启用）
+	Err      error // 解析失败或校验和不匹配
+}
+
+// 校验 RDB 文件：完整解析全部数据并核对末尾的 CRC64 校验和
+func CheckRDBFile(filename string) (*RDBCheckResult, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	result := &RDBCheckResult{
+		Filename: filename,
+		Size:     stat.Size(),
+	}
+	decoder := rdb.NewDecoder(file)
+	err = decoder.Parse(func(object rdb.RedisObject) bool {
+		result.Keys++
+		return true
+	})
+	if err != nil {
+		result.Err = err
+		return result, nil
+	}
+	end := int64(decoder.GetReadCount())
+	if end > result.Size || end < 8 {
+		result.Err = errors.New("unexpected end of rdb file, checksum is missing")
+		return result, nil
+	}
+	// 校验和覆盖除自身以外的全部内容，按小端序存储
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(file)
+	hash := crc64jones.New()
+	if _, err = io.CopyN(hash, reader, end-8); err != nil {
+		return nil, err
+	}
+	sum := make([]byte, 8)
+	if _, err = io.ReadFull(reader, sum); err != nil {
+		return nil, err
+	}
+	expected := binary.LittleEndian.Uint64(sum)
+	result.Checksum = expected != 0
+	if result.Checksum && expected != hash.Sum64() {
+		result.Err = fmt.Errorf("rdb checksum mismatch, expect %016x, actually %016x", expected, hash.Sum64())
+	}
+	return result, nil
+}
@@ -0,0 +1,99 @@
+package aof
+
+import (
+	"myredis/lib/utils"
+	"myredis/protocol"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func makeAofData(cmds ...[]string) []byte {
+	var data []byte
+	for _, cmd := range cmds {
+		data = append(data, protocol.MakeMultiBulkReply(utils.ToCmdLine(cmd...)).ToBytes()...)
+	}
+	return data
+}
+
+func TestCheckAof(t *testing.T) {
+	dir := t.TempDir()
+	valid := makeAofData([]string{"SELECT", "0"}, []string{"SET", "a", "1"})
+	filename := filepath.Join(dir, "appendonly.aof")
+
+	// 完好的文件
+	if err := os.WriteFile(filename, valid, 0600); err != nil {
+		t.Fatal(err)
+	}
+	results, err := CheckAof(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !results[0].OK() || results[0].Commands != 2 {
+		t.Errorf("wrong result %+v", results[0])
+	}
+
+	// 末尾被截断，修复后恢复完好
+	truncated := append(append([]byte{}, valid...), "*3\r\n$3\r\nSET\r\n$1\r\nb"...)
+	if err := os.WriteFile(filename, truncated, 0600); err != nil {
+		t.Fatal(err)
+	}
+	result, err := CheckAofFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Truncated || result.ValidSize != int64(len(valid)) || result.Commands != 2 {
+		t.Fatalf("wrong result %+v", result)
+	}
+	if err := FixTruncatedAof(result); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filename); string(data) != string(valid) {
+		t.Errorf("wrong data after fix %q", data)
+	}
+
+	// 中间损坏，拒绝修复
+	corrupted := append(append([]byte{}, valid[:len(valid)-3]...), "xx\r\n"...)
+	corrupted = append(corrupted, makeAofData([]string{"SET", "b", "1"})...)
+	if err := os.WriteFile(filename, corrupted, 0600); err != nil {
+		t.Fatal(err)
+	}
+	result, err = CheckAofFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selectLen := len(makeAofData([]string{"SELECT", "0"}))
+	if result.Err == nil || result.Commands != 1 || result.ValidSize != int64(selectLen) {
+		t.Fatalf("wrong result %+v", result)
+	}
+	if !strings.Contains(result.Err.Error(), "offset") {
+		t.Errorf("error should contain offset: %v", result.Err)
+	}
+	if err := FixTruncatedAof(result); err == nil {
+		t.Error("corrupted file should not be fixed")
+	}
+}
+
+func TestCheckMultiPartAof(t *testing.T) {
+	dir := t.TempDir()
+	m := &aofManifest{}
+	base := m.nextBase("appendonly.aof", false)
+	incr := m.nextIncr("appendonly.aof")
+	if err := saveManifest(filepath.Join(dir, "appendonly.aof"+manifestSuffix), m); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.WriteFile(filepath.Join(dir, base.name), makeAofData([]string{"SET", "a", "1"}), 0600)
+	_ = os.WriteFile(filepath.Join(dir, incr.name), []byte("*1\r\n$4\r\nPI"), 0600)
+
+	results, err := CheckAof(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || !results[0].OK() || !results[1].Truncated || results[1].ValidSize != 0 {
+		t.Errorf("wrong results %+v %+v", results[0], results[1])
+	}
+	if filepath.Base(results[1].Filename) != incr.name {
+		t.Errorf("incr file should be checked last")
+	}
+}
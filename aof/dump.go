@@ -0,0 +1,328 @@
+// dump.go 实现了 DUMP/RESTORE 使用的序列化格式，与 Redis 兼容：
+//
+//	<type><value><rdb version: 2 字节小端序><crc64: 8 字节小端序>
+//
+// type 与 value 与 RDB 文件中对象的编码相同，不包含键名与过期时间，
+// 校验和覆盖之前的全部内容。
+// 带字段过期时间的哈希使用 Redis 7.4 的 RDB_TYPE_HASH_METADATA 编码，RDB 版本为 12：
+//
+//	<最早过期时间: 8 字节小端序毫秒><字段数>{<ttl><field><value>}...
+//
+// ttl 为长度编码，0 表示字段没有过期时间，否则为过期时间减去最早过期时间再加 1。
+// RDB 编码器不支持这一类型，由 encodeHashMetadata 与 decodeHashMetadata 直接读写
+package aof
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"myredis/datastruct/dict"
+	"myredis/interface/database"
+	"strconv"
+
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/crc64jones"
+	"github.com/hdt3213/rdb/lzf"
+	"github.com/hdt3213/rdb/model"
+)
+
+const (
+	// 生成的序列化数据使用的 RDB 版本，与编码器写入的文件头一致
+	dumpRDBVersion = 11
+	// 可以解析的最高 RDB 版本，也是带字段过期时间的哈希使用的版本
+	maxRestoreRDBVersion = 12
+	// Redis 7.4 中带字段过期时间的哈希的 RDB 类型
+	rdbTypeHashMetadata = 24
+	// 文件头 REDIS0011 (9) + SELECTDB 0 (2) + RESIZEDB 1 0 (3)
+	dumpObjectOffset = 14
+	// 版本号与校验和的长度
+	dumpFooterSize = 10
+)
+
+var (
+	ErrBadDumpPayload = errors.New("DUMP payload version or checksum are wrong")
+	ErrBadDumpFormat  = errors.New("Bad data format")
+)
+
+// 将数据库实体序列化为 DUMP 格式
+//
+// 借助 RDB 编码器生成只包含一个空键名对象的 RDB 文件，再从中截取对象的编码
+func DumpEntity(entity *database.DataEntity) ([]byte, error) {
+	if hash, ok := entity.Data.(*dict.ExpireDict); ok && hash.ExpireLen() > 0 {
+		return appendDumpFooter(encodeHashMetadata(hash), maxRestoreRDBVersion), nil
+	}
+	buf := &bytes.Buffer{}
+	encoder := setZipListOpt(core.NewEncoder(buf))
+	err := encoder.WriteHeader()
+	if err == nil {
+		err = encoder.WriteDBHeader(0, 1, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if buf.Len() != dumpObjectOffset {
+		return nil, fmt.Errorf("unexpected rdb header size %d", buf.Len())
+	}
+	err = writeEntity(encoder, "", entity)
+	if err != nil {
+		return nil, err
+	}
+	if buf.Len() == dumpObjectOffset {
+		return nil, fmt.Errorf("unsupported data type %T", entity.Data)
+	}
+	raw := buf.Bytes()
+	// 空键名编码为一个长度字节 0
+	if raw[dumpObjectOffset+1] != 0 {
+		return nil, errors.New("unexpected key encoding")
+	}
+	payload := make([]byte, 0, len(raw)-dumpObjectOffset+dumpFooterSize)
+	payload = append(payload, raw[dumpObjectOffset])
+	payload = append(payload, raw[dumpObjectOffset+2:]...)
+	return appendDumpFooter(payload, dumpRDBVersion), nil
+}
+
+// 在类型与值之后追加 RDB 版本与校验和
+func appendDumpFooter(payload []byte, version uint16) []byte {
+	payload = binary.LittleEndian.AppendUint16(payload, version)
+	hash := crc64jones.New()
+	_, _ = hash.Write(payload)
+	return binary.LittleEndian.AppendUint64(payload, hash.Sum64())
+}
+
+// 校验并解析 DUMP 格式的数据
+func RestoreEntity(payload []byte) (*database.DataEntity, error) {
+	if len(payload) < dumpFooterSize+1 {
+		return nil, ErrBadDumpPayload
+	}
+	body := payload[:len(payload)-dumpFooterSize]
+	version := binary.LittleEndian.Uint16(payload[len(body):])
+	if version < 1 || version > maxRestoreRDBVersion {
+		return nil, ErrBadDumpPayload
+	}
+	hash := crc64jones.New()
+	_, _ = hash.Write(payload[:len(payload)-8])
+	if hash.Sum64() != binary.LittleEndian.Uint64(payload[len(payload)-8:]) {
+		return nil, ErrBadDumpPayload
+	}
+	if body[0] == rdbTypeHashMetadata {
+		if version < maxRestoreRDBVersion {
+			return nil, ErrBadDumpFormat
+		}
+		return decodeHashMetadata(body[1:])
+	}
+
+	// 还原为只包含一个空键名对象的 RDB 文件，交给 RDB 解码器解析
+	raw := make([]byte, 0, len(body)+dumpObjectOffset+10)
+	raw = append(raw, fmt.Sprintf("REDIS%04d", version)...)
+	raw = append(raw, 0xFE, 0x00)
+	raw = append(raw, body[0], 0x00)
+	raw = append(raw, body[1:]...)
+	raw = append(raw, 0xFF, 0, 0, 0, 0, 0, 0, 0, 0)
+	decoder := core.NewDecoder(bytes.NewReader(raw)).WithSpecialOpCode()
+	var object model.RedisObject
+	err := decoder.Parse(func(o model.RedisObject) bool {
+		// 只允许一个对象
+		if object != nil || o.GetType() == model.AuxType {
+			object = nil
+			return false
+		}
+		object = o
+		return true
+	})
+	// 编码需要恰好用完全部数据，包括末尾的结束标记与 8 字节校验和
+	if err != nil || object == nil || decoder.GetReadCount() != len(raw) {
+		return nil, ErrBadDumpFormat
+	}
+	entity := RDBObjectToEntity(object)
+	if entity == nil {
+		return nil, ErrBadDumpFormat
+	}
+	return entity, nil
+}
+
+// 按 RDB_TYPE_HASH_METADATA 编码哈希，字段与值都写为不压缩的原始字符串
+func encodeHashMetadata(hash *dict.ExpireDict) []byte {
+	minExpire, _ := hash.NextExpire()
+	payload := []byte{rdbTypeHashMetadata}
+	payload = binary.LittleEndian.AppendUint64(payload, uint64(minExpire))
+	payload = appendRDBLength(payload, uint64(hash.Len()))
+	hash.ForEach(func(field string, val interface{}) bool {
+		var ttl uint64
+		if expireAt, ok := hash.GetExpire(field); ok {
+			ttl = uint64(expireAt-minExpire) + 1
+		}
+		value, _ := val.([]byte)
+		payload = appendRDBLength(payload, ttl)
+		payload = appendRDBLength(payload, uint64(len(field)))
+		payload = append(payload, field...)
+		payload = appendRDBLength(payload, uint64(len(value)))
+		payload = append(payload, value...)
+		return true
+	})
+	return payload
+}
+
+// 解析 RDB_TYPE_HASH_METADATA 编码的值，data 不包含类型字节
+func decodeHashMetadata(data []byte) (*database.DataEntity, error) {
+	if len(data) < 8 {
+		return nil, ErrBadDumpFormat
+	}
+	minExpire := int64(binary.LittleEndian.Uint64(data))
+	reader := &rdbReader{data: data[8:]}
+	size, err := reader.readLength()
+	if err != nil {
+		return nil, err
+	}
+	hash := dict.MakeExpireDict(dict.MakeCompact())
+	for i := uint64(0); i < size; i++ {
+		ttl, err := reader.readLength()
+		if err != nil {
+			return nil, err
+		}
+		field, err := reader.readString()
+		if err != nil {
+			return nil, err
+		}
+		value, err := reader.readString()
+		if err != nil {
+			return nil, err
+		}
+		hash.Put(string(field), value)
+		if ttl > 0 {
+			hash.SetExpire(string(field), minExpire+int64(ttl)-1)
+		}
+	}
+	if len(reader.data) > 0 {
+		return nil, ErrBadDumpFormat
+	}
+	return &database.DataEntity{
+		Data: hash,
+	}, nil
+}
+
+// 按 RDB 的长度编码追加 length
+func appendRDBLength(buf []byte, length uint64) []byte {
+	switch {
+	case length < 1<<6:
+		return append(buf, byte(length))
+	case length < 1<<14:
+		return append(buf, byte(length>>8)|0x40, byte(length))
+	case length <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, 0x80), uint32(length))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, 0x81), length)
+	}
+}
+
+// rdbReader 从内存中读取 RDB 的长度与字符串编码，数据不足或编码错误时返回 ErrBadDumpFormat
+type rdbReader struct {
+	data []byte
+}
+
+func (r *rdbReader) next(n uint64) ([]byte, error) {
+	if uint64(len(r.data)) < n {
+		return nil, ErrBadDumpFormat
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b, nil
+}
+
+// 读取一个长度，special 表示首字节的高 2 位为 11，此时返回值为特殊编码的类型
+func (r *rdbReader) readLengthOrEncoding() (length uint64, special bool, err error) {
+	b, err := r.next(1)
+	if err != nil {
+		return 0, false, err
+	}
+	switch b[0] >> 6 {
+	case 0:
+		return uint64(b[0] & 0x3f), false, nil
+	case 1:
+		next, err := r.next(1)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b[0]&0x3f)<<8 | uint64(next[0]), false, nil
+	case 2:
+		switch b[0] {
+		case 0x80:
+			buf, err := r.next(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(buf)), false, nil
+		case 0x81:
+			buf, err := r.next(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(buf), false, nil
+		}
+		return 0, false, ErrBadDumpFormat
+	}
+	return uint64(b[0] & 0x3f), true, nil
+}
+
+func (r *rdbReader) readLength() (uint64, error) {
+	length, special, err := r.readLengthOrEncoding()
+	if err == nil && special {
+		err = ErrBadDumpFormat
+	}
+	return length, err
+}
+
+// 读取一个字符串，支持原始字符串、整数编码与 LZF 压缩
+func (r *rdbReader) readString() ([]byte, error) {
+	length, special, err := r.readLengthOrEncoding()
+	if err != nil {
+		return nil, err
+	}
+	if !special {
+		return r.next(length)
+	}
+	switch length {
+	case 0:
+		// 8 位整数
+		b, err := r.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int8(b[0])), 10), nil
+	case 1:
+		// 16 位整数
+		b, err := r.next(2)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int16(binary.LittleEndian.Uint16(b))), 10), nil
+	case 2:
+		// 32 位整数
+		b, err := r.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int32(binary.LittleEndian.Uint32(b))), 10), nil
+	case 3:
+		// LZF 压缩：压缩后长度、原始长度、压缩数据
+		compressedLen, err := r.readLength()
+		if err != nil {
+			return nil, err
+		}
+		rawLen, err := r.readLength()
+		if err != nil {
+			return nil, err
+		}
+		compressed, err := r.next(compressedLen)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := lzf.Decompress(compressed, int(compressedLen), int(rawLen))
+		if err != nil || uint64(len(raw)) != rawLen {
+			return nil, ErrBadDumpFormat
+		}
+		return raw, nil
+	}
+	return nil, ErrBadDumpFormat
+}
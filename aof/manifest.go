@@ -0,0 +1,250 @@
+// manifest.go 实现了 multi-part AOF 的清单文件：
+//
+//   - appendonlydir 中包含一个 base 文件（RDB 或 AOF 格式）与若干 incr 文件
+//   - 清单按顺序记录这些文件，加载时先加载 base，再依次重放 incr
+//   - 重写时只需打开新的 incr 文件，完成后原子替换清单，旧文件转为 history 并被清理
+//
+// 清单格式与 Redis 7 保持一致，每行描述一个文件：
+//
+//	file appendonly.aof.1.base.rdb seq 1 type b
+//	file appendonly.aof.1.incr.aof seq 1 type i
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 默认的 AOF 目录名称
+const defaultAofDirname = "appendonlydir"
+
+const (
+	manifestSuffix = ".manifest"
+	baseAofSuffix  = ".base.aof"
+	baseRdbSuffix  = ".base.rdb"
+	incrSuffix     = ".incr.aof"
+)
+
+type aofFileType string
+
+const (
+	aofFileBase    aofFileType = "b" // 基础文件，重写生成
+	aofFileHistory aofFileType = "h" // 已被新 base 取代，等待删除
+	aofFileIncr    aofFileType = "i" // 增量文件，记录 base 之后的写命令
+)
+
+// 清单中的一项
+type aofInfo struct {
+	name     string
+	seq      int64
+	fileType aofFileType
+}
+
+type aofManifest struct {
+	base    *aofInfo
+	incrs   []*aofInfo
+	history []*aofInfo
+	baseSeq int64 // 当前最大的 base 序号
+	incrSeq int64 // 当前最大的 incr 序号
+}
+
+// 深拷贝清单，修改副本中的文件记录不会影响正在使用的清单
+func (m *aofManifest) copy() *aofManifest {
+	result := &aofManifest{
+		baseSeq: m.baseSeq,
+		incrSeq: m.incrSeq,
+		incrs:   copyAofInfos(m.incrs),
+		history: copyAofInfos(m.history),
+	}
+	if m.base != nil {
+		base := *m.base
+		result.base = &base
+	}
+	return result
+}
+
+func copyAofInfos(infos []*aofInfo) []*aofInfo {
+	result := make([]*aofInfo, len(infos))
+	for i, info := range infos {
+		clone := *info
+		result[i] = &clone
+	}
+	return result
+}
+
+// 新建一个 incr 文件记录并加入清单
+func (m *aofManifest) nextIncr(basename string) *aofInfo {
+	m.incrSeq++
+	info := &aofInfo{
+		name:     basename + "." + strconv.FormatInt(m.incrSeq, 10) + incrSuffix,
+		seq:      m.incrSeq,
+		fileType: aofFileIncr,
+	}
+	m.incrs = append(m.incrs, info)
+	return info
+}
+
+// 新建一个 base 文件记录，旧的 base 转为 history
+func (m *aofManifest) nextBase(basename string, rdbFormat bool) *aofInfo {
+	m.baseSeq++
+	suffix := baseAofSuffix
+	if rdbFormat {
+		suffix = baseRdbSuffix
+	}
+	if m.base != nil {
+		m.base.fileType = aofFileHistory
+		m.history = append(m.history, m.base)
+	}
+	m.base = &aofInfo{
+		name:     basename + "." + strconv.FormatInt(m.baseSeq, 10) + suffix,
+		seq:      m.baseSeq,
+		fileType: aofFileBase,
+	}
+	return m.base
+}
+
+// 将序号小于 seq 的 incr 文件转为 history
+func (m *aofManifest) retireIncrsBefore(seq int64) {
+	incrs := make([]*aofInfo, 0, len(m.incrs))
+	for _, info := range m.incrs {
+		if info.seq < seq {
+			info.fileType = aofFileHistory
+			m.history = append(m.history, info)
+		} else {
+			incrs = append(incrs, info)
+		}
+	}
+	m.incrs = incrs
+}
+
+// 按加载顺序返回所有有效文件
+func (m *aofManifest) files() []*aofInfo {
+	result := make([]*aofInfo, 0, len(m.incrs)+1)
+	if m.base != nil {
+		result = append(result, m.base)
+	}
+	return append(result, m.incrs...)
+}
+
+func (m *aofManifest) marshal() []byte {
+	var sb strings.Builder
+	write := func(info *aofInfo) {
+		sb.WriteString(fmt.Sprintf("file %s seq %d type %s\n", info.name, info.seq, info.fileType))
+	}
+	if m.base != nil {
+		write(m.base)
+	}
+	for _, info := range m.history {
+		write(info)
+	}
+	for _, info := range m.incrs {
+		write(info)
+	}
+	return []byte(sb.String())
+}
+
+// 解析清单内容，未知的字段会被忽略
+func parseManifest(data []byte) (*aofManifest, error) {
+	m := &aofManifest{}
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields)%2 != 0 {
+			return nil, fmt.Errorf("invalid aof manifest line %d: %s", lineNum, line)
+		}
+		info := &aofInfo{}
+		for i := 0; i < len(fields); i += 2 {
+			switch fields[i] {
+			case "file":
+				info.name = fields[i+1]
+			case "seq":
+				seq, err := strconv.ParseInt(fields[i+1], 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid aof manifest line %d: %s", lineNum, line)
+				}
+				info.seq = seq
+			case "type":
+				info.fileType = aofFileType(fields[i+1])
+			}
+		}
+		if info.name == "" || strings.ContainsAny(info.name, `/\`) {
+			return nil, fmt.Errorf("invalid aof manifest line %d: %s", lineNum, line)
+		}
+		switch info.fileType {
+		case aofFileBase:
+			if m.base != nil {
+				return nil, errors.New("found duplicate base file in aof manifest")
+			}
+			m.base = info
+			m.baseSeq = info.seq
+		case aofFileIncr:
+			m.incrs = append(m.incrs, info)
+			if info.seq > m.incrSeq {
+				m.incrSeq = info.seq
+			}
+		case aofFileHistory:
+			m.history = append(m.history, info)
+		default:
+			return nil, fmt.Errorf("unknown aof file type in manifest line %d: %s", lineNum, line)
+		}
+	}
+	return m, scanner.Err()
+}
+
+// 读取清单文件，不存在时返回 nil
+func loadManifest(path string) (*aofManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return parseManifest(data)
+}
+
+// 原子地写入清单：先写临时文件并同步，再重命名覆盖
+func saveManifest(path string, m *aofManifest) error {
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(m.marshal())
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// 同步目录，确保重命名等元数据操作落盘
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	_ = d.Sync()
+	return nil
+}
@@ -0,0 +1,78 @@
+package aof
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	m := &aofManifest{}
+	m.nextBase("appendonly.aof", true)
+	m.nextIncr("appendonly.aof")
+	m.nextIncr("appendonly.aof")
+	// 重写：新的 base 取代旧的 base 与第一个 incr
+	m.nextBase("appendonly.aof", false)
+	m.retireIncrsBefore(2)
+
+	path := filepath.Join(t.TempDir(), "appendonly.aof.manifest")
+	if err := saveManifest(path, m); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.base.name != "appendonly.aof.2.base.aof" || loaded.baseSeq != 2 {
+		t.Errorf("wrong base: %+v", loaded.base)
+	}
+	if len(loaded.incrs) != 1 || loaded.incrs[0].name != "appendonly.aof.2.incr.aof" || loaded.incrSeq != 2 {
+		t.Errorf("wrong incrs: %+v", loaded.incrs)
+	}
+	if len(loaded.history) != 2 {
+		t.Errorf("expect 2 history files, actually %d", len(loaded.history))
+	}
+	files := loaded.files()
+	if len(files) != 2 || files[0].fileType != aofFileBase || files[1].fileType != aofFileIncr {
+		t.Errorf("wrong load order: %+v", files)
+	}
+
+	missing, err := loadManifest(filepath.Join(t.TempDir(), "missing"))
+	if missing != nil || err != nil {
+		t.Error("missing manifest should return nil")
+	}
+	badLines := []string{
+		"file a seq 1 type x\n",
+		"file a seq x type b\n",
+		"file a seq 1\n",
+		"file ../a seq 1 type i\n",
+		"file a seq 1 type b\nfile b seq 2 type b\n",
+	}
+	for _, line := range badLines {
+		if _, err := parseManifest([]byte(line)); err == nil {
+			t.Errorf("expect error for %q", line)
+		}
+	}
+}
+
+func TestManifestCopy(t *testing.T) {
+	m := &aofManifest{}
+	m.nextBase("appendonly.aof", true)
+	m.nextIncr("appendonly.aof")
+	m.nextIncr("appendonly.aof")
+
+	// 重写失败时丢弃副本，原清单中的文件记录不能被修改
+	manifest := m.copy()
+	manifest.nextBase("appendonly.aof", true)
+	manifest.retireIncrsBefore(2)
+	if len(manifest.history) != 2 {
+		t.Errorf("expect 2 history files in copy, actually %d", len(manifest.history))
+	}
+	if m.base.fileType != aofFileBase || len(m.history) != 0 {
+		t.Errorf("base changed: %+v", m.base)
+	}
+	for _, info := range m.incrs {
+		if info.fileType != aofFileIncr {
+			t.Errorf("incr changed: %+v", info)
+		}
+	}
+}
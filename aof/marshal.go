@@ -4,6 +4,7 @@ import (
 	"myredis/datastruct/dict"
 	List "myredis/datastruct/list"
 	SortedSet "myredis/datastruct/sortedset"
+	"sort"
 	"strconv"
 	"time"
 
@@ -22,6 +23,8 @@ func EntityToCmd(key string, entity *database.DataEntity) *protocol.MultiBulkRep
 	switch val := entity.Data.(type) {
 	case []byte:
 		cmd = stringToCmd(key, val)
+	case int64:
+		cmd = stringToCmd(key, strconv.AppendInt(nil, val, 10))
 	case List.List:
 		cmd = listToCmd(key, val)
 	case *set.Set:
@@ -120,3 +123,35 @@ func MakeExpiredCmd(key string, expireAt time.Time) *protocol.MultiBulkReply {
 	args[2] = []byte(strconv.FormatInt(expireAt.UnixNano()/1e6, 10))
 	return protocol.MakeMultiBulkReply(args)
 }
+
+var hPExpireAtBytes = []byte("HPEXPIREAT")
+
+// 将哈希字段的过期时间转化为命令行，过期时间相同的字段合并为一条 HPEXPIREAT 命令
+func FieldExpireCmds(key string, entity *database.DataEntity) []*protocol.MultiBulkReply {
+	hash, ok := entity.Data.(*dict.ExpireDict)
+	if !ok || hash.ExpireLen() == 0 {
+		return nil
+	}
+	fieldsByTime := make(map[int64][]string)
+	var times []int64
+	hash.ForEachExpire(func(field string, expireAt int64) bool {
+		if _, ok := fieldsByTime[expireAt]; !ok {
+			times = append(times, expireAt)
+		}
+		fieldsByTime[expireAt] = append(fieldsByTime[expireAt], field)
+		return true
+	})
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	cmds := make([]*protocol.MultiBulkReply, 0, len(times))
+	for _, expireAt := range times {
+		fields := fieldsByTime[expireAt]
+		args := make([][]byte, 0, 5+len(fields))
+		args = append(args, hPExpireAtBytes, []byte(key), []byte(strconv.FormatInt(expireAt, 10)),
+			[]byte("FIELDS"), []byte(strconv.Itoa(len(fields))))
+		for _, field := range fields {
+			args = append(args, []byte(field))
+		}
+		cmds = append(cmds, protocol.MakeMultiBulkReply(args))
+	}
+	return cmds
+}
@@ -1,24 +1,60 @@
 package aof
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
 	"myredis/config"
-	"myredis/lib/logger"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"myredis/datastruct/dict"
 	List "myredis/datastruct/list"
 	"myredis/datastruct/set"
 	"myredis/datastruct/sortedset"
+	"myredis/datastruct/strobj"
 	"myredis/interface/database"
+	"myredis/myredis/parser"
+	"myredis/protocol"
 
+	"github.com/hdt3213/rdb/crc64jones"
 	rdb "github.com/hdt3213/rdb/encoder"
 	"github.com/hdt3213/rdb/model"
 )
 
+// 写入 RDB 文件 myredis-version 辅助字段的格式版本，加载时拒绝更高版本写入的文件
+const RDBFormatVersion = "0.0.2"
+
+// 检查 RDB 文件的 myredis-version 辅助字段，版本号高于 RDBFormatVersion 或格式错误时返回错误
+func CheckRDBFormatVersion(version string) error {
+	current := strings.Split(RDBFormatVersion, ".")
+	fields := strings.Split(version, ".")
+	if len(fields) != len(current) {
+		return fmt.Errorf("invalid myredis-version %q", version)
+	}
+	for i := range fields {
+		v, err := strconv.Atoi(fields[i])
+		if err != nil || v < 0 {
+			return fmt.Errorf("invalid myredis-version %q", version)
+		}
+		c, _ := strconv.Atoi(current[i])
+		if v > c {
+			return fmt.Errorf("rdb file is written by myredis-version %s, newer than %s", version, RDBFormatVersion)
+		}
+		if v < c {
+			break
+		}
+	}
+	return nil
+}
+
 /*
-从AOF文件生成RDB快照文件
+从在线数据的快照生成RDB文件
 
 参数rdbFilename: 输出的RDB文件路径
 
@@ -26,14 +62,17 @@ import (
 */
 func (persister *Persister) GenerateRDB(rdbFileName string) error {
 	// 获取上下文，准备生成 RDB
-	RewriteCtx, err := persister.prepareGenerateRDB(nil, nil)
+	RewriteCtx, err := persister.prepareSnapshot(nil, nil, false)
 	if err != nil {
 		return err
 	}
 
 	// RDB 生成结束
 	err = persister.generateRDB(RewriteCtx)
+	RewriteCtx.snapshot.Release()
 	if err != nil {
+		_ = RewriteCtx.tempFile.Close()
+		_ = os.Remove(RewriteCtx.tempFile.Name())
 		return err
 	}
 
@@ -48,6 +87,35 @@ func (persister *Persister) GenerateRDB(rdbFileName string) error {
 	return nil
 }
 
+/*
+不依赖 AOF 持久化器，直接从数据库快照生成 RDB 文件（用于 SAVE/BGSAVE）
+
+参数onCut: 在快照切点执行，调用者可以在其中记录切点时刻的状态
+
+写入同目录下的临时文件，完成后原子替换目标文件
+*/
+func SaveRDB(db database.DBEngine, rdbFileName string, onCut func()) error {
+	snapshot := db.Snapshot(onCut)
+	defer snapshot.Release()
+
+	tempFile, err := os.CreateTemp(filepath.Dir(rdbFileName), "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+	err = writeRDB(tempFile, snapshot, false, 0)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tempFile.Name())
+		return err
+	}
+	return os.Rename(tempFile.Name(), rdbFileName)
+}
+
 /*
 为副本同步异步生成RDB文件
 
@@ -61,13 +129,14 @@ func (persister *Persister) GenerateRDB(rdbFileName string) error {
 */
 func (persister *Persister) GenerateRDBForReplication(rdbFileName string, listener Listener, hook func()) error {
 	// 获取上下文，准备生成 RDB
-	RewriteCtx, err := persister.prepareGenerateRDB(listener, hook)
+	RewriteCtx, err := persister.prepareSnapshot(listener, hook, false)
 	if err != nil {
 		return err
 	}
 
 	// RDB 生成结束
 	err = persister.generateRDB(RewriteCtx)
+	RewriteCtx.snapshot.Release()
 	if err != nil {
 		return err
 	}
@@ -79,77 +148,51 @@ func (persister *Persister) GenerateRDBForReplication(rdbFileName string, listen
 	return nil
 }
 
-/*
-RDB生成前的准备工作
-
-暂停AOF写入、同步磁盘、创建临时文件
-
-返回重写上下文，支持监听器和钩子函数
-*/
-func (persister *Persister) prepareGenerateRDB(newListener Listener, hook func()) (*RewriteContext, error) {
-	persister.pausingAof.Lock()
-	defer persister.pausingAof.Unlock()
-
-	// 确保 AOF 文件已被存储
-	err := persister.aofFile.Sync()
-	if err != nil {
-		logger.Warn("fsync failed")
-		return nil, err
-	}
-
-	// 获取当前 AOF 文件的大小，用于重写
-	fileInfo, _ := os.Stat(persister.aofFilename)
-	fileSize := fileInfo.Size()
-
-	tempFile, err := os.CreateTemp(config.GetTmpDir(), "*.aof")
-	if err != nil {
-		logger.Warn("temp file create failed")
-		return nil, err
-	}
-
-	if newListener != nil {
-		persister.listeners[newListener] = struct{}{}
-	}
-	// 执行钩子函数
-	if hook != nil {
-		hook()
-	}
-	return &RewriteContext{
-		tempFile: tempFile,
-		fileSize: fileSize,
-	}, nil
-}
-
 /*
 执行RDB文件内容生成
 
-遍历内存数据，按RDB格式编码写入临时文件
+遍历快照数据，按RDB格式编码写入临时文件
 
 支持字符串、列表、集合、哈希、有序集合等数据类型
 
 包含版本信息、过期时间等元数据
 */
 func (persister *Persister) generateRDB(ctx *RewriteContext) error {
-	// 加载 AOF 文件，获取数据库状态
-	tempHandler := persister.newRewriteHandler()
-	tempHandler.LoadAof(int(ctx.fileSize))
+	// 与 AOF 格式的 base 文件相同，开启时间戳时记录切点时刻
+	var timestamp int64
+	if timestampEnabled() {
+		timestamp = ctx.timestamp
+	}
+	return writeRDB(ctx.tempFile, ctx.snapshot, ctx.rdbFormat, timestamp)
+}
+
+/*
+将快照按 RDB 格式写入 writer
 
-	encoder := rdb.NewEncoder(ctx.tempFile).EnableCompress()
+参数preamble: 是否作为 AOF 的 RDB 前导部分
+参数baseTimestamp: 大于 0 时作为 AOF base 文件切点时刻的时间戳写入辅助字段
+*/
+func writeRDB(writer io.Writer, snapshot database.Snapshot, preamble bool, baseTimestamp int64) error {
+	checksum := &checksumWriter{writer: writer, crc: crc64jones.New()}
+	encoder := setZipListOpt(rdb.NewEncoder(checksum).EnableCompress())
 	err := encoder.WriteHeader()
 	if err != nil {
 		return err
 	}
 	// aof-preamble：RDB前导机制，将 RDB 快照数据作为 AOF 文件的开头部分
 	auxMap := map[string]string{
-		"myredis-version": "0.0.1",
+		"myredis-version": RDBFormatVersion,
 		"redis-bits":      "64",
 		"aof-preamble":    "0",
 		"ctime":           strconv.FormatInt(time.Now().Unix(), 10),
 	}
 
-	if config.Properties.AofUseRdbPreamble {
+	if preamble {
 		auxMap["aof-preamble"] = "1"
 	}
+	if baseTimestamp > 0 {
+		auxMap[baseTimestampAux] = strconv.FormatInt(baseTimestamp, 10)
+	}
 	// 写入 AUXMAP（Redis服务器元数据信息）
 	for key, val := range auxMap {
 		err := encoder.WriteAux(key, val)
@@ -160,7 +203,7 @@ func (persister *Persister) generateRDB(ctx *RewriteContext) error {
 
 	// 写入数据库信息
 	for i := 0; i < config.Properties.Databases; i++ {
-		keyCount, ttlCount := tempHandler.db.GetDBSize(i)
+		keyCount, ttlCount := snapshot.GetDBSize(i)
 		if keyCount == 0 {
 			continue
 		}
@@ -171,48 +214,17 @@ func (persister *Persister) generateRDB(ctx *RewriteContext) error {
 		}
 		var err2 error
 		// 遍历每个键值对，根据不同的类型使用 RDB 库写入键值对
-		tempHandler.db.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+		snapshot.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
 			var options []interface{}
 			if expiration != nil {
 				options = append(options, rdb.WithTTL(uint64(expiration.UnixNano()/1e6)))
 			}
-			switch object := entity.Data.(type) {
-			case []byte:
-				// string
-				err = encoder.WriteStringObject(key, object, options...)
-			case List.List:
-				values := make([][]byte, object.Len())
-				object.ForEach(func(i int, val interface{}) bool {
-					bytes, _ := val.([]byte)
-					values = append(values, bytes)
-					return true
-				})
-				err = encoder.WriteListObject(key, values, options...)
-			case dict.Dict:
-				hashTable := make(map[string][]byte)
-				object.ForEach(func(key string, val interface{}) bool {
-					bytes, _ := val.([]byte)
-					hashTable[key] = bytes
-					return true
-				})
-				err = encoder.WriteHashMapObject(key, hashTable, options)
-			case *set.Set:
-				values := make([][]byte, object.Len())
-				object.ForEach(func(member string) bool {
-					values = append(values, []byte(member))
-					return true
-				})
-				err = encoder.WriteSetObject(key, values, options...)
-			case *sortedset.SortedSet:
-				var entries []*model.ZSetEntry
-				object.ForEachByRank(int64(0), int64(object.Len()), true, func(element *sortedset.Element) bool {
-					entries = append(entries, &model.ZSetEntry{
-						Member: element.Member,
-						Score:  element.Score,
-					})
-					return true
-				})
-				err = encoder.WriteZSetObject(key, entries, options...)
+			// 字段过期时间写在哈希之前，加载哈希时即可恢复
+			if hash, ok := entity.Data.(*dict.ExpireDict); ok && hash.ExpireLen() > 0 {
+				err = writeAux(checksum, FieldExpiresAux, marshalFieldExpires(i, key, hash))
+			}
+			if err == nil {
+				err = writeEntity(encoder, key, entity, options...)
 			}
 			if err != nil {
 				err2 = err
@@ -224,10 +236,236 @@ func (persister *Persister) generateRDB(ctx *RewriteContext) error {
 			return err2
 		}
 	}
-	// 写入结尾
-	err = encoder.WriteEnd()
+	// 写入结尾，校验和覆盖编码器之外写入的辅助字段
+	_, err = checksum.Write([]byte{rdbOpCodeEOF})
 	if err != nil {
 		return err
 	}
+	_, err = writer.Write(checksum.crc.Sum(nil))
+	return err
+}
+
+// RDB 文件的结束标记
+const rdbOpCodeEOF = 0xFF
+
+// checksumWriter 计算写入内容的 CRC64 校验和
+//
+// 编码器只允许在数据库之前写入辅助字段，之后的辅助字段直接写入 checksumWriter，
+// 文件结尾与校验和也由 checksumWriter 写入
+type checksumWriter struct {
+	writer io.Writer
+	crc    hash.Hash64
+}
+
+func (w *checksumWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	_, _ = w.crc.Write(p[:n])
+	return n, err
+}
+
+// 返回一条辅助字段在 RDB 文件中的编码
+func encodeAux(key, value string) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	encoder := rdb.NewEncoder(buf)
+	err := encoder.WriteHeader()
+	if err != nil {
+		return nil, err
+	}
+	headerSize := buf.Len()
+	err = encoder.WriteAux(key, value)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes()[headerSize:], nil
+}
+
+// 不经过编码器写入一条辅助字段
+func writeAux(writer io.Writer, key, value string) error {
+	aux, err := encodeAux(key, value)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(aux)
+	return err
+}
+
+// 保存哈希字段过期时间的辅助字段。RDB 格式没有对应的类型，每个带字段过期时间的哈希在它之前写入一条，
+// 值为 [db, key, field, 过期毫秒时间戳, ...] 形式的 RESP 数组，加载时先暂存，读到对应的哈希后再恢复
+const FieldExpiresAux = "myredis-hfe"
+
+// 将哈希字段的过期时间编码为辅助字段的值
+func marshalFieldExpires(dbIndex int, key string, hash *dict.ExpireDict) string {
+	args := make([][]byte, 0, 2+2*hash.ExpireLen())
+	args = append(args, []byte(strconv.Itoa(dbIndex)), []byte(key))
+	hash.ForEachExpire(func(field string, expireAt int64) bool {
+		args = append(args, []byte(field), []byte(strconv.FormatInt(expireAt, 10)))
+		return true
+	})
+	return string(protocol.MakeMultiBulkReply(args).ToBytes())
+}
+
+type fieldExpiresKey struct {
+	dbIndex int
+	key     string
+}
+
+// FieldExpires 暂存加载 RDB 文件时读到的哈希字段过期时间
+type FieldExpires struct {
+	pending map[fieldExpiresKey]map[string]int64
+}
+
+func MakeFieldExpires() *FieldExpires {
+	return &FieldExpires{
+		pending: make(map[fieldExpiresKey]map[string]int64),
+	}
+}
+
+// Add 解析一条 FieldExpiresAux 辅助字段的值
+func (f *FieldExpires) Add(value string) error {
+	reply, err := parser.ParseOne([]byte(value))
+	if err != nil {
+		return err
+	}
+	multiBulk, ok := reply.(*protocol.MultiBulkReply)
+	if !ok || len(multiBulk.Args) < 2 || len(multiBulk.Args)%2 != 0 {
+		return errors.New("invalid hash field expires")
+	}
+	dbIndex, err := strconv.Atoi(string(multiBulk.Args[0]))
+	if err != nil {
+		return errors.New("invalid hash field expires")
+	}
+	expires := make(map[string]int64, len(multiBulk.Args)/2-1)
+	for i := 2; i < len(multiBulk.Args); i += 2 {
+		expireAt, err := strconv.ParseInt(string(multiBulk.Args[i+1]), 10, 64)
+		if err != nil {
+			return errors.New("invalid hash field expires")
+		}
+		expires[string(multiBulk.Args[i])] = expireAt
+	}
+	f.pending[fieldExpiresKey{dbIndex: dbIndex, key: string(multiBulk.Args[1])}] = expires
+	return nil
+}
+
+// Apply 为 dbIndex 中 key 对应的实体恢复字段的过期时间，实体不是哈希或没有记录时原样返回
+func (f *FieldExpires) Apply(dbIndex int, key string, entity *database.DataEntity) *database.DataEntity {
+	id := fieldExpiresKey{dbIndex: dbIndex, key: key}
+	expires, ok := f.pending[id]
+	if !ok {
+		return entity
+	}
+	delete(f.pending, id)
+	hash, ok := entity.Data.(dict.Dict)
+	if !ok {
+		return entity
+	}
+	expireDict := dict.MakeExpireDict(hash)
+	for field, expireAt := range expires {
+		if _, exists := hash.Get(field); exists {
+			expireDict.SetExpire(field, expireAt)
+		}
+	}
+	return &database.DataEntity{
+		Data: expireDict,
+	}
+}
+
+// 使用与内存中 listpack 编码相同的阈值，小的哈希与有序集合在 RDB 中同样以 ziplist 编码保存
+func setZipListOpt(encoder *rdb.Encoder) *rdb.Encoder {
+	return encoder.
+		SetHashZipListOpt(dict.ListpackMaxValue, dict.ListpackMaxEntries).
+		SetZSetZipListOpt(sortedset.ListpackMaxValue, sortedset.ListpackMaxEntries)
+}
+
+// 按照 RDB 格式写入一个键值对，不支持的类型将被忽略
+func writeEntity(encoder *rdb.Encoder, key string, entity *database.DataEntity, options ...interface{}) error {
+	switch object := entity.Data.(type) {
+	case []byte:
+		// string
+		return encoder.WriteStringObject(key, object, options...)
+	case int64:
+		// 整数编码的 string，编码器会将其写为 RDB 的整数字符串
+		return encoder.WriteStringObject(key, strconv.AppendInt(nil, object, 10), options...)
+	case List.List:
+		values := make([][]byte, 0, object.Len())
+		object.ForEach(func(i int, val interface{}) bool {
+			bytes, _ := val.([]byte)
+			values = append(values, bytes)
+			return true
+		})
+		return encoder.WriteListObject(key, values, options...)
+	case dict.Dict:
+		hashTable := make(map[string][]byte)
+		object.ForEach(func(key string, val interface{}) bool {
+			bytes, _ := val.([]byte)
+			hashTable[key] = bytes
+			return true
+		})
+		return encoder.WriteHashMapObject(key, hashTable, options...)
+	case *set.Set:
+		values := make([][]byte, 0, object.Len())
+		object.ForEach(func(member string) bool {
+			values = append(values, []byte(member))
+			return true
+		})
+		return encoder.WriteSetObject(key, values, options...)
+	case *sortedset.SortedSet:
+		var entries []*model.ZSetEntry
+		object.ForEachByRank(int64(0), int64(object.Len()), true, func(element *sortedset.Element) bool {
+			entries = append(entries, &model.ZSetEntry{
+				Member: element.Member,
+				Score:  element.Score,
+			})
+			return true
+		})
+		return encoder.WriteZSetObject(key, entries, options...)
+	}
+	return nil
+}
+
+// 将 RDB 中解析出的对象转换为数据库实体，不支持的类型返回 nil
+func RDBObjectToEntity(object model.RedisObject) *database.DataEntity {
+	switch object.GetType() {
+	case model.StringType:
+		str := object.(*model.StringObject)
+		return &database.DataEntity{
+			Data: strobj.Make(str.Value),
+		}
+	case model.ListType:
+		listObj := object.(*model.ListObject)
+		list := List.MakeCompact()
+		for _, v := range listObj.Values {
+			list.Add(v)
+		}
+		return &database.DataEntity{
+			Data: list,
+		}
+	case model.HashType:
+		hashObj := object.(*model.HashObject)
+		hash := dict.MakeCompact()
+		for k, v := range hashObj.Hash {
+			hash.Put(k, v)
+		}
+		return &database.DataEntity{
+			Data: hash,
+		}
+	case model.SetType:
+		setObj := object.(*model.SetObject)
+		members := set.Make()
+		for _, mem := range setObj.Members {
+			members.Add(string(mem))
+		}
+		return &database.DataEntity{
+			Data: members,
+		}
+	case model.ZSetType:
+		zsetObj := object.(*model.ZSetObject)
+		zSet := sortedset.Make()
+		for _, e := range zsetObj.Entries {
+			zSet.Add(e.Member, e.Score)
+		}
+		return &database.DataEntity{
+			Data: zSet,
+		}
+	}
 	return nil
 }
@@ -1,85 +1,183 @@
 package aof
 
 import (
-	"io"
+	"errors"
 	"myredis/config"
+	"myredis/interface/database"
 	"myredis/lib/logger"
-	"myredis/lib/utils"
-	"myredis/protocol"
 	"os"
-	"strconv"
+	"path/filepath"
+	"time"
 )
 
-/* 重写所需实例，包含 AOF 文件名称以及临时数据库 */
-func (persister *Persister) newRewriteHandler() *Persister {
-	h := &Persister{}
-	h.aofFilename = persister.aofFilename
-	h.db = persister.tmpDBMaker()
-	return h
-}
+// 已有重写在进行时返回的错误
+var ErrRewriteInProgress = errors.New("background append only file rewriting already in progress")
 
 /*
 重写操作：
 
-	用一个包含了当前内存数据状态的、更紧凑的新AOF文件，
-	去替换掉体积庞大、含有冗余命令的旧AOF文件
+	将当前内存数据写入新的 base 文件，替换旧的 base 与 incr 文件。
+	重写开始时切换到新的 incr 文件，重写期间的写命令直接写入新的 incr 文件，
+	完成后只需原子地替换清单，无需复制增量数据
 */
 func (persister *Persister) Rewrite() error {
+	if !persister.rewriting.CompareAndSwap(false, true) {
+		return ErrRewriteInProgress
+	}
+	defer persister.rewriting.Store(false)
+	return persister.rewrite()
+}
+
+// 在后台执行重写，已有重写在进行时返回 ErrRewriteInProgress
+func (persister *Persister) BackgroundRewrite() error {
+	if !persister.rewriting.CompareAndSwap(false, true) {
+		return ErrRewriteInProgress
+	}
+	go func() {
+		defer persister.rewriting.Store(false)
+		if err := persister.rewrite(); err != nil {
+			logger.Error("background aof rewrite failed: " + err.Error())
+		}
+	}()
+	return nil
+}
+
+// 执行重写并记录结果，调用者需要先设置 rewriting 标志
+func (persister *Persister) rewrite() (err error) {
+	start := time.Now()
+	defer func() {
+		persister.lastRewriteErr.Store(err != nil)
+		persister.lastRewriteCost.Store(int64(time.Since(start) / time.Second))
+		if err == nil {
+			persister.baseSize.Store(persister.aofSize())
+		}
+	}()
 	ctx, err := persister.PrepareRewrite()
 	if err != nil {
 		return err
 	}
 	err = persister.DoRewrite(ctx)
 	if err != nil {
+		_ = ctx.tempFile.Close()
+		_ = os.Remove(ctx.tempFile.Name())
 		return err
 	}
+	return persister.FinishRewrite(ctx)
+}
 
-	persister.FinishRewrite(ctx)
-	return nil
+// AOF 重写相关的状态，用于 INFO persistence 与自动重写
+type RewriteStatus struct {
+	InProgress  bool
+	LastFailed  bool
+	LastCostSec int64 // 上次重写耗时（秒），-1 表示从未重写
+	CurrentSize int64 // 清单中全部文件的总大小
+	BaseSize    int64 // 启动或上次重写完成时的总大小
+}
+
+func (persister *Persister) GetRewriteStatus() *RewriteStatus {
+	return &RewriteStatus{
+		InProgress:  persister.rewriting.Load(),
+		LastFailed:  persister.lastRewriteErr.Load(),
+		LastCostSec: persister.lastRewriteCost.Load(),
+		CurrentSize: persister.aofSize(),
+		BaseSize:    persister.baseSize.Load(),
+	}
+}
+
+// 计算清单中 base 与 incr 文件的总大小
+func (persister *Persister) aofSize() int64 {
+	persister.pausingAof.Lock()
+	files := persister.manifest.files()
+	persister.pausingAof.Unlock()
+	var size int64
+	for _, info := range files {
+		if stat, err := os.Stat(filepath.Join(persister.aofDir, info.name)); err == nil {
+			size += stat.Size()
+		}
+	}
+	return size
 }
 
 // 重写操作所需要的上下文
 type RewriteContext struct {
-	tempFile *os.File // 存储精简命令的 AOF 文件，用于重写
-	fileSize int64
-	dbIndex  int // 开始重写时，当前数据库索引
+	tempFile  *os.File          // 存储快照数据的临时文件
+	incrSeq   int64             // 切点处新建的 incr 文件序号，之前的 incr 文件将被新的 base 取代
+	rdbFormat bool              // 临时文件是否为 RDB 格式
+	snapshot  database.Snapshot // 切点时刻的数据库快照
+	timestamp int64             // 切点时刻的时间戳
 }
 
 // 为重写操作准备上下文
+//
+// 在写命令暂停期间切换到新的 incr 文件并生成数据库快照，
+// 保证快照恰好包含旧的 base 与 incr 文件中的全部命令
 func (persister *Persister) PrepareRewrite() (*RewriteContext, error) {
-	// 暂停 AOF 文件的写入
-	persister.pausingAof.Lock()
-	defer persister.pausingAof.Unlock()
-
-	// 确保操作系统缓冲区里所有待写入数据写入磁盘
-	err := persister.aofFile.Sync()
-	if err != nil {
-		logger.Warn("fsync failed")
-		return nil, err
-	}
+	return persister.prepareSnapshot(nil, nil, true)
+}
 
-	// 保存旧 AOF 文件的最后写入位置
-	fileInfo, _ := os.Stat(persister.aofFilename)
-	fileSize := fileInfo.Size()
+// 生成快照，rotate 为 true 时同时切换到新的 incr 文件
+//
+// newListener 与 hook 在切点处执行，用于主从复制接收快照之后的增量命令
+func (persister *Persister) prepareSnapshot(newListener Listener, hook func(), rotate bool) (*RewriteContext, error) {
+	var ctx *RewriteContext
+	var err error
+	snapshot := persister.db.Snapshot(func() {
+		// 确保切点之前的命令均已写入文件
+		persister.flushPending()
+		// 暂停 AOF 文件的写入
+		persister.pausingAof.Lock()
+		defer persister.pausingAof.Unlock()
+
+		ctx = &RewriteContext{timestamp: time.Now().Unix()}
+		tmpDir := config.GetTmpDir()
+		if rotate {
+			info, err2 := persister.rotateIncr()
+			if err2 != nil {
+				logger.Warn("open new incr aof file failed")
+				err = err2
+				return
+			}
+			ctx.incrSeq = info.seq
+			// 临时文件与目标文件位于同一目录，保证重命名是原子的
+			tmpDir = persister.aofDir
+		} else {
+			// 确保操作系统缓冲区里所有待写入数据写入磁盘
+			err = persister.aofFile.Sync()
+			if err != nil {
+				logger.Warn("fsync failed")
+				return
+			}
+		}
 
-	// 创建临时 AOF 文件
-	file, err := os.CreateTemp(config.GetTmpDir(), "*.aof")
+		// 创建临时文件
+		ctx.tempFile, err = os.CreateTemp(tmpDir, "temp-rewrite-*.aof")
+		if err != nil {
+			logger.Warn("temp file create failed")
+			return
+		}
+		if newListener != nil {
+			persister.listeners[newListener] = struct{}{}
+		}
+		// 执行钩子函数
+		if hook != nil {
+			hook()
+		}
+	})
 	if err != nil {
-		logger.Warn("temp file create failed")
+		snapshot.Release()
 		return nil, err
 	}
-
-	return &RewriteContext{
-		tempFile: file,
-		fileSize: fileSize,
-		dbIndex:  persister.currentDB,
-	}, nil
+	ctx.snapshot = snapshot
+	return ctx, nil
 }
 
 // 执行重写操作
 func (persister *Persister) DoRewrite(ctx *RewriteContext) (err error) {
-	// 旧 AOF 文件重写时，将精简的命令保存到临时文件
-	if !config.Properties.AofUseRdbPreamble {
+	// 写入完成后快照不再需要
+	defer ctx.snapshot.Release()
+	// 将快照中的数据保存到临时文件
+	ctx.rdbFormat = config.Properties.AofUseRdbPreamble
+	if !ctx.rdbFormat {
 		// 使用 AOF
 		logger.Info("generate aof preamble")
 		err = persister.generateAof(ctx)
@@ -88,72 +186,38 @@ func (persister *Persister) DoRewrite(ctx *RewriteContext) (err error) {
 		logger.Info("generate rdb preamble")
 		err = persister.generateRDB(ctx)
 	}
+	if err == nil {
+		err = ctx.tempFile.Sync()
+	}
 	return err
 }
 
-// 将重写期间产生的新命令追加到新文件中，并以原子操作安全地替换掉旧文件
-func (persister *Persister) FinishRewrite(ctx *RewriteContext) {
-	// 暂停 AOF 文件的写入
+// 将临时文件作为新的 base 文件，原子地替换清单，并清理被取代的旧文件
+func (persister *Persister) FinishRewrite(ctx *RewriteContext) error {
+	err := ctx.tempFile.Close()
+	if err != nil {
+		_ = os.Remove(ctx.tempFile.Name())
+		return err
+	}
+	// 清单的修改与切换 incr 文件互斥
 	persister.pausingAof.Lock()
 	defer persister.pausingAof.Unlock()
-	tempFile := ctx.tempFile
 
-	errOccurs := func() bool {
-		// 读取重写期间执行的写命令（写入到旧的 AOF 文件中）
-		src, err := os.Open(persister.aofFilename)
-		if err != nil {
-			logger.Error("open aofFilename failed: " + err.Error())
-			return true
-		}
-		defer func() {
-			_ = src.Close()
-			_ = tempFile.Close()
-		}()
-		// 定位到快照点，这之后的数据需要更新
-		_, err = src.Seek(ctx.fileSize, 0)
-		if err != nil {
-			logger.Error("seek failed: " + err.Error())
-			return true
-		}
-
-		// 将增量数据复制到临时文件之前，确保增量命令的数据库上下文正确
-		data := protocol.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(ctx.dbIndex))).ToBytes()
-		_, err = tempFile.Write(data)
-		if err != nil {
-			logger.Error("tmp file rewrite failed: " + err.Error())
-			return true
-		}
-
-		// 复制增量数据（即重写期间的新命令）
-		_, err = io.Copy(tempFile, src)
-		if err != nil {
-			logger.Error("copy aof filed failed: " + err.Error())
-			return true
-		}
-		return false
-	}()
-
-	// 如果在复制命令过程中发生错误，则直接返回，不进行文件替换
-	if errOccurs {
-		return
-	}
-
-	_ = persister.aofFile.Close()
-	// 原子性替换
-	if err := os.Rename(tempFile.Name(), persister.aofFilename); err != nil {
-		logger.Warn(err)
-	}
-
-	aofFile, err := os.OpenFile(persister.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	manifest := persister.manifest.copy()
+	base := manifest.nextBase(filepath.Base(persister.aofFilename), ctx.rdbFormat)
+	manifest.retireIncrsBefore(ctx.incrSeq)
+	err = os.Rename(ctx.tempFile.Name(), filepath.Join(persister.aofDir, base.name))
 	if err != nil {
-		panic(err) // 如果重新打开文件失败，程序无法继续运行，直接panic
+		_ = os.Remove(ctx.tempFile.Name())
+		return err
 	}
-
-	persister.aofFile = aofFile
-	// 确保新激活的 AOF 文件的数据库上下文正确
-	data := protocol.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(persister.currentDB))).ToBytes()
-	_, err = persister.aofFile.Write(data)
+	// 清单替换成功之前，新的 base 文件不会被加载
+	err = saveManifest(persister.manifestPath(), manifest)
 	if err != nil {
-		panic(err) // 如果写入失败，程序无法正常工作，直接panic
+		_ = os.Remove(filepath.Join(persister.aofDir, base.name))
+		return err
 	}
+	persister.manifest = manifest
+	persister.cleanupHistory()
+	return nil
 }
@@ -0,0 +1,170 @@
+// timestamp.go 实现了 AOF 中的时间戳注释与按时间点恢复：
+//
+//   - 开启 aof-timestamp-enabled 后，写入命令前若时间（秒）发生变化，先写入一行 #TS:<unix>
+//   - 加载时注释被跳过，不影响旧版本的加载逻辑
+//   - RDB 格式的 base 文件没有注释，切点时刻的时间戳记录在辅助字段 myredis-aof-timestamp 中
+//   - TruncateAofToTimestamp 在第一个晚于指定时间的注释处截断，得到该时间点的数据
+package aof
+
+import (
+	"errors"
+	"fmt"
+	"myredis/config"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hdt3213/rdb/core"
+	rdb "github.com/hdt3213/rdb/parser"
+)
+
+const timestampPrefix = "TS:"
+
+// RDB 格式的 base 文件中记录切点时刻时间戳的辅助字段
+const baseTimestampAux = "myredis-aof-timestamp"
+
+func timestampEnabled() bool {
+	return config.Properties != nil && config.Properties.AofTimestampEnabled
+}
+
+func makeTimestampAnnotation(timestamp int64) []byte {
+	return []byte("#" + timestampPrefix + strconv.FormatInt(timestamp, 10) + "\r\n")
+}
+
+// 解析注释中的时间戳，不是时间戳注释时返回 false
+func parseTimestampAnnotation(annotation string) (int64, bool) {
+	if !strings.HasPrefix(annotation, timestampPrefix) {
+		return 0, false
+	}
+	timestamp, err := strconv.ParseInt(annotation[len(timestampPrefix):], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return timestamp, true
+}
+
+// 按时间点截断的结果
+type TimestampTruncateResult struct {
+	Filename string   // 被截断的文件
+	Offset   int64    // 截断后的文件大小
+	Removed  []string // 整个晚于时间点而被移出清单并删除的 incr 文件
+}
+
+// 将 AOF 截断到 timestamp（含）之前的状态，path 可以是单个 AOF 文件、AOF 目录或清单文件
+//
+// 从第一个晚于 timestamp 的时间戳注释处截断，对于 multi-part AOF，之后的 incr 文件将被删除。
+// base 文件只包含切点时刻的数据，timestamp 早于 base 文件的时间戳或 base 文件没有时间戳时返回错误。
+// 没有晚于 timestamp 的注释时不做修改并返回 nil。调用时服务器不能正在写入该 AOF
+func TruncateAofToTimestamp(path string, timestamp int64) (*TimestampTruncateResult, error) {
+	manifestPath, err := findManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest *aofManifest
+	var files []string
+	if manifestPath == "" {
+		files = []string{path}
+	} else {
+		manifest, err = loadManifest(manifestPath)
+		if err != nil {
+			return nil, err
+		}
+		if manifest == nil {
+			return nil, fmt.Errorf("aof manifest %s not found", manifestPath)
+		}
+		for _, info := range manifest.files() {
+			files = append(files, filepath.Join(filepath.Dir(manifestPath), info.name))
+		}
+	}
+
+	for i, filename := range files {
+		if manifest != nil && manifest.base != nil && i == 0 {
+			baseTimestamp, err := readBaseTimestamp(filename)
+			if err != nil {
+				return nil, err
+			}
+			if baseTimestamp < 0 {
+				return nil, fmt.Errorf("base aof file %s has no timestamp, cannot truncate to a point in time", filename)
+			}
+			if timestamp < baseTimestamp {
+				return nil, errors.New("the timestamp is earlier than the base aof file, cannot truncate to it")
+			}
+			continue
+		}
+		offset := int64(-1)
+		result, err := scanAofFile(filename, skipRDB, nil, func(annotation string, pos int64) {
+			if ts, ok := parseTimestampAnnotation(annotation); ok && ts > timestamp && offset < 0 {
+				offset = pos
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if result.Err != nil {
+			return nil, fmt.Errorf("bad aof file %s: %v", filename, result.Err)
+		}
+		if offset < 0 {
+			if result.Truncated {
+				return nil, fmt.Errorf("aof file %s is truncated, fix it first", filename)
+			}
+			continue
+		}
+		if err = truncateAof(filename, offset); err != nil {
+			return nil, err
+		}
+		truncateResult := &TimestampTruncateResult{
+			Filename: filename,
+			Offset:   offset,
+		}
+		if manifest == nil {
+			return truncateResult, nil
+		}
+		// 先原子地更新清单，再删除之后的 incr 文件
+		keep := i
+		if manifest.base == nil {
+			keep = i + 1
+		}
+		removed := manifest.incrs[keep:]
+		manifest.incrs = manifest.incrs[:keep]
+		if err = saveManifest(manifestPath, manifest); err != nil {
+			return nil, err
+		}
+		for _, info := range removed {
+			name := filepath.Join(filepath.Dir(manifestPath), info.name)
+			if err = os.Remove(name); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			truncateResult.Removed = append(truncateResult.Removed, name)
+		}
+		return truncateResult, nil
+	}
+	return nil, nil
+}
+
+// 读取 base 文件切点时刻的时间戳：RDB 格式读取辅助字段，AOF 格式读取开头的注释，没有时返回 -1
+func readBaseTimestamp(filename string) (int64, error) {
+	timestamp := int64(-1)
+	loadRDB := func(decoder *core.Decoder) error {
+		return decoder.WithSpecialOpCode().Parse(func(object rdb.RedisObject) bool {
+			if aux, ok := object.(*rdb.AuxObject); ok && aux.Key == baseTimestampAux {
+				if ts, err := strconv.ParseInt(aux.Value, 10, 64); err == nil {
+					timestamp = ts
+				}
+			}
+			return true
+		})
+	}
+	result, err := scanAofFile(filename, loadRDB, nil, func(annotation string, pos int64) {
+		if ts, ok := parseTimestampAnnotation(annotation); ok && timestamp < 0 {
+			timestamp = ts
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	if result.Err != nil {
+		return 0, fmt.Errorf("bad aof file %s: %v", filename, result.Err)
+	}
+	return timestamp, nil
+}
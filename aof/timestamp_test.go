@@ -0,0 +1,133 @@
+package aof
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hdt3213/rdb/core"
+)
+
+func TestTruncateAofToTimestamp(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "appendonly.aof")
+	before := append(makeTimestampAnnotation(100), makeAofData([]string{"SET", "a", "1"})...)
+	before = append(before, makeTimestampAnnotation(200)...)
+	before = append(before, makeAofData([]string{"SET", "b", "1"})...)
+	data := append(append([]byte{}, before...), makeTimestampAnnotation(300)...)
+	data = append(data, makeAofData([]string{"FLUSHALL"})...)
+	if err := os.WriteFile(filename, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if result, err := TruncateAofToTimestamp(filename, 300); err != nil || result != nil {
+		t.Fatalf("nothing should be truncated, %+v %v", result, err)
+	}
+	result, err := TruncateAofToTimestamp(filename, 299)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Offset != int64(len(before)) {
+		t.Errorf("wrong offset %d", result.Offset)
+	}
+	if content, _ := os.ReadFile(filename); string(content) != string(before) {
+		t.Errorf("wrong content %q", content)
+	}
+	if check, _ := CheckAofFile(filename); !check.OK() || check.Commands != 2 {
+		t.Errorf("truncated file should be valid, %+v", check)
+	}
+}
+
+func TestTruncateMultiPartAofToTimestamp(t *testing.T) {
+	dir := t.TempDir()
+	m := &aofManifest{}
+	base := m.nextBase("appendonly.aof", false)
+	incr1 := m.nextIncr("appendonly.aof")
+	incr2 := m.nextIncr("appendonly.aof")
+	manifestPath := filepath.Join(dir, "appendonly.aof"+manifestSuffix)
+	if err := saveManifest(manifestPath, m); err != nil {
+		t.Fatal(err)
+	}
+	baseData := append(makeTimestampAnnotation(100), makeAofData([]string{"SET", "a", "1"})...)
+	incr1Data := append(makeAofData([]string{"SELECT", "0"}), makeTimestampAnnotation(200)...)
+	incr1Data = append(incr1Data, makeAofData([]string{"SET", "b", "1"})...)
+	incr2Data := append(makeAofData([]string{"SELECT", "0"}), makeTimestampAnnotation(300)...)
+	incr2Data = append(incr2Data, makeAofData([]string{"FLUSHALL"})...)
+	_ = os.WriteFile(filepath.Join(dir, base.name), baseData, 0600)
+	_ = os.WriteFile(filepath.Join(dir, incr1.name), incr1Data, 0600)
+	_ = os.WriteFile(filepath.Join(dir, incr2.name), incr2Data, 0600)
+
+	// 早于 base 文件的时间点无法恢复
+	if _, err := TruncateAofToTimestamp(dir, 50); err == nil {
+		t.Error("expect error when truncating before base")
+	}
+	// 截断第一个 incr 文件，并删除之后的 incr 文件
+	result, err := TruncateAofToTimestamp(dir, 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(result.Filename) != incr1.name || len(result.Removed) != 1 {
+		t.Fatalf("wrong result %+v", result)
+	}
+	if _, err := os.Stat(filepath.Join(dir, incr2.name)); !os.IsNotExist(err) {
+		t.Error("later incr file should be removed")
+	}
+	loaded, err := loadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.incrs) != 1 || loaded.incrs[0].name != incr1.name {
+		t.Errorf("wrong incrs %+v", loaded.incrs)
+	}
+	results, err := CheckAof(dir)
+	if err != nil || len(results) != 2 || !results[1].OK() || results[1].Commands != 1 {
+		t.Errorf("wrong check results %v", err)
+	}
+}
+
+// RDB 格式的 base 文件没有注释，通过辅助字段判断时间点是否早于 base 文件
+func TestTruncateRdbBaseAofToTimestamp(t *testing.T) {
+	dir := t.TempDir()
+	m := &aofManifest{}
+	base := m.nextBase("appendonly.aof", true)
+	incr := m.nextIncr("appendonly.aof")
+	manifestPath := filepath.Join(dir, "appendonly.aof"+manifestSuffix)
+	if err := saveManifest(manifestPath, m); err != nil {
+		t.Fatal(err)
+	}
+	writeBase := func(aux map[string]string) {
+		buf := &bytes.Buffer{}
+		encoder := core.NewEncoder(buf)
+		_ = encoder.WriteHeader()
+		for key, value := range aux {
+			_ = encoder.WriteAux(key, value)
+		}
+		_ = encoder.WriteDBHeader(0, 1, 0)
+		_ = encoder.WriteStringObject("a", []byte("1"))
+		_ = encoder.WriteEnd()
+		_ = os.WriteFile(filepath.Join(dir, base.name), buf.Bytes(), 0600)
+	}
+	incrData := append(makeAofData([]string{"SELECT", "0"}), makeTimestampAnnotation(200)...)
+	incrData = append(incrData, makeAofData([]string{"SET", "b", "1"})...)
+	_ = os.WriteFile(filepath.Join(dir, incr.name), incrData, 0600)
+
+	writeBase(map[string]string{"aof-preamble": "1"})
+	if _, err := TruncateAofToTimestamp(dir, 150); err == nil {
+		t.Error("expect error when the base has no timestamp")
+	}
+	writeBase(map[string]string{"aof-preamble": "1", baseTimestampAux: "100"})
+	if _, err := TruncateAofToTimestamp(dir, 50); err == nil {
+		t.Error("expect error when truncating before base")
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, incr.name)); !bytes.Equal(data, incrData) {
+		t.Error("incr file should not be modified")
+	}
+	result, err := TruncateAofToTimestamp(dir, 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(result.Filename) != incr.name || result.Offset != int64(len(makeAofData([]string{"SELECT", "0"}))) {
+		t.Errorf("wrong result %+v", result)
+	}
+}
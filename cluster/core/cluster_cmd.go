@@ -0,0 +1,163 @@
+package core
+
+import (
+	"myredis/cluster/raft"
+	"myredis/interface/myredis"
+	"myredis/lib/hashslot"
+	"myredis/protocol"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// CLUSTER 命令的子命令处理函数，args 不包含 CLUSTER 和子命令本身
+type subCmdFunc func(cluster *Cluster, c myredis.Connection, args [][]byte) myredis.Reply
+
+var clusterSubCommands = make(map[string]subCmdFunc)
+
+func registerClusterSubCmd(name string, fn subCmdFunc) {
+	clusterSubCommands[strings.ToLower(name)] = fn
+}
+
+// execCluster 分发 CLUSTER 子命令
+// 格式: CLUSTER <SUBCOMMAND> [ARG ...]
+func execCluster(cluster *Cluster, c myredis.Connection, cmdLine CmdLine) myredis.Reply {
+	if len(cmdLine) < 2 {
+		return protocol.MakeArgNumErrReply("cluster")
+	}
+	subCmd := strings.ToLower(string(cmdLine[1]))
+	fn, ok := clusterSubCommands[subCmd]
+	if !ok {
+		return protocol.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try CLUSTER HELP.")
+	}
+	return fn(cluster, c, cmdLine[2:])
+}
+
+// 解析槽位参数，槽位范围为 [0, SlotCount)
+func parseSlot(arg []byte) (uint32, protocol.ErrorReply) {
+	slot, err := strconv.Atoi(string(arg))
+	if err != nil || slot < 0 || slot >= hashslot.SlotCount {
+		return 0, protocol.MakeErrReply("ERR Invalid or out of range slot")
+	}
+	return uint32(slot), nil
+}
+
+// 格式: CLUSTER KEYSLOT key
+func execKeySlot(cluster *Cluster, c myredis.Connection, args [][]byte) myredis.Reply {
+	if len(args) != 1 {
+		return protocol.MakeArgNumErrReply("cluster|keyslot")
+	}
+	return protocol.MakeIntReply(int64(GetSlot(string(args[0]))))
+}
+
+// 格式: CLUSTER COUNTKEYSINSLOT slot
+func execCountKeysInSlot(cluster *Cluster, c myredis.Connection, args [][]byte) myredis.Reply {
+	if len(args) != 1 {
+		return protocol.MakeArgNumErrReply("cluster|countkeysinslot")
+	}
+	slot, errReply := parseSlot(args[0])
+	if errReply != nil {
+		return errReply
+	}
+	return protocol.MakeIntReply(int64(cluster.CountKeysInSlot(slot)))
+}
+
+// 格式: CLUSTER GETKEYSINSLOT slot count
+func execGetKeysInSlot(cluster *Cluster, c myredis.Connection, args [][]byte) myredis.Reply {
+	if len(args) != 2 {
+		return protocol.MakeArgNumErrReply("cluster|getkeysinslot")
+	}
+	slot, errReply := parseSlot(args[0])
+	if errReply != nil {
+		return errReply
+	}
+	count, err := strconv.Atoi(string(args[1]))
+	if err != nil || count < 0 {
+		return protocol.MakeErrReply("ERR Invalid number of keys")
+	}
+	keys := cluster.GetKeysInSlot(slot, count)
+	result := make([][]byte, len(keys))
+	for i, key := range keys {
+		result[i] = []byte(key)
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
+
+// 将节点 ID（即 Redis 服务地址）转换为 [host, port, id] 形式
+func makeNodeReply(nodeID string) myredis.Reply {
+	host, portStr, err := net.SplitHostPort(nodeID)
+	if err != nil {
+		host = nodeID
+	}
+	port, _ := strconv.Atoi(portStr)
+	return protocol.MakeMultiRawReply([]myredis.Reply{
+		protocol.MakeBulkReply([]byte(host)),
+		protocol.MakeIntReply(int64(port)),
+		protocol.MakeBulkReply([]byte(nodeID)),
+	})
+}
+
+// 返回槽位区间与节点的映射，连续且属于同一节点的槽位合并为一个区间
+// 格式: CLUSTER SLOTS
+// 回复: [[start, end, [host, port, id], [slave host, port, id]...]...]
+func execClusterSlots(cluster *Cluster, c myredis.Connection, args [][]byte) myredis.Reply {
+	if len(args) != 0 {
+		return protocol.MakeArgNumErrReply("cluster|slots")
+	}
+	var result []myredis.Reply
+	cluster.raftNode.FSM.WithReadLock(func(fsm *raft.FSM) {
+		appendRange := func(start, end int, nodeID string) {
+			item := []myredis.Reply{
+				protocol.MakeIntReply(int64(start)),
+				protocol.MakeIntReply(int64(end)),
+				makeNodeReply(nodeID),
+			}
+			if ms := fsm.MasterSlaves[nodeID]; ms != nil {
+				for _, slave := range ms.Slaves {
+					item = append(item, makeNodeReply(slave))
+				}
+			}
+			result = append(result, protocol.MakeMultiRawReply(item))
+		}
+		start, owner := -1, ""
+		for i := 0; i < hashslot.SlotCount; i++ {
+			nodeID := fsm.Slot2Node[uint32(i)]
+			if nodeID == owner {
+				continue
+			}
+			if owner != "" {
+				appendRange(start, i-1, owner)
+			}
+			start, owner = i, nodeID
+		}
+		if owner != "" {
+			appendRange(start, hashslot.SlotCount-1, owner)
+		}
+	})
+	if len(result) == 0 {
+		return protocol.MakeEmptyMultiBulkReply()
+	}
+	return protocol.MakeMultiRawReply(result)
+}
+
+// 以 JSON 格式返回 raft 状态与 FSM 元数据（槽位、主从、迁移与故障转移任务）
+// 格式: CLUSTER RAFTSTATE
+func execRaftState(cluster *Cluster, c myredis.Connection, args [][]byte) myredis.Reply {
+	if len(args) != 0 {
+		return protocol.MakeArgNumErrReply("cluster|raftstate")
+	}
+	data, err := cluster.raftNode.DumpState()
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
+	return protocol.MakeBulkReply(data)
+}
+
+func init() {
+	RegisterCmd("cluster", execCluster)
+	registerClusterSubCmd("keyslot", execKeySlot)
+	registerClusterSubCmd("countkeysinslot", execCountKeysInSlot)
+	registerClusterSubCmd("getkeysinslot", execGetKeysInSlot)
+	registerClusterSubCmd("slots", execClusterSlots)
+	registerClusterSubCmd("raftstate", execRaftState)
+}
@@ -1,11 +1,13 @@
 package core
 
 import (
+	"fmt"
 	"myredis/config"
 	"myredis/database"
 	"myredis/interface/myredis"
 	"myredis/lib/logger"
 	"myredis/protocol"
+	"runtime/debug"
 	"strings"
 )
 
@@ -30,8 +32,26 @@ func (cluster *Cluster) Exec(c myredis.Connection, cmdLine [][]byte) (result myr
 		return database.Auth(c, cmdLine[1:])
 	}
 	if !isAuthenticated(c) {
-		return
+		return protocol.MakeErrReply("NOAUTH Authentication required")
 	}
+	// 事务状态下，除事务控制命令外都加入队列
+	if c.InMultiState() && !isTxControlCommand(cmdName) {
+		return database.EnqueueCmd(c, cmdLine)
+	}
+	cmdFunc, ok := commands[cmdName]
+	if !ok {
+		// 未注册的集群命令直接交给本地数据库执行
+		return cluster.db.Exec(c, cmdLine)
+	}
+	return cmdFunc(cluster, c, cmdLine)
+}
+
+func isTxControlCommand(cmdName string) bool {
+	switch cmdName {
+	case "multi", "exec", "discard", "watch":
+		return true
+	}
+	return false
 }
 
 func isAuthenticated(c myredis.Connection) bool {
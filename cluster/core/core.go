@@ -2,22 +2,46 @@ package core
 
 import (
 	"myredis/cluster/raft"
+	"myredis/datastruct/dict"
 	"myredis/datastruct/set"
 	"myredis/interface/database"
+	"myredis/lib/idgenerator"
 	"sync"
 )
 
 type Cluster struct {
-	raftNode    raft.Node
-	db          database.DBEngine
-	connections ConnectionFactory
-	config      *Config
+	raftNode     *raft.Node
+	db           database.DBEngine
+	connections  ConnectionFactory
+	config       *Config
+	slotsManager *slotsManage
+	idGenerator  *idgenerator.IDGenerator
+	transactions dict.Dict // 参与者上进行中的跨节点事务，txID -> *Transaction
 }
 
 type Config struct {
 	raft.RaftConfig
 }
 
+// 创建集群实例：启动 raft 节点，并为已有数据建立槽位索引
+func NewCluster(cfg *Config, db database.DBEngine) (*Cluster, error) {
+	raftNode, err := raft.StartNode(&cfg.RaftConfig)
+	if err != nil {
+		return nil, err
+	}
+	cluster := &Cluster{
+		raftNode:     raftNode,
+		db:           db,
+		connections:  newDefaultClientFactory(),
+		config:       cfg,
+		slotsManager: newSlotsManage(),
+		idGenerator:  idgenerator.MakeIDGenerator(cfg.ID()),
+		transactions: dict.MakeConcurrent(1),
+	}
+	cluster.injectSlotCallbacks()
+	return cluster, nil
+}
+
 type slotsManage struct {
 	mu            *sync.RWMutex
 	slots         map[uint32]*slotStatus
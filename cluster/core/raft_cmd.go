@@ -0,0 +1,140 @@
+package core
+
+import (
+	"errors"
+	"myredis/interface/myredis"
+	"myredis/lib/utils"
+	"myredis/protocol"
+	"strings"
+
+	hraft "github.com/hashicorp/raft"
+)
+
+// 将 raft 操作的错误转换为 RESP 错误，非 leader 时附带 leader 地址
+func raftErrReply(cluster *Cluster, err error) myredis.Reply {
+	if errors.Is(err, hraft.ErrNotLeader) {
+		leader := cluster.raftNode.GetLeaderRedisAddress()
+		if leader != "" {
+			return protocol.MakeErrReply("ERR " + err.Error() + ", leader is " + leader)
+		}
+	}
+	return protocol.MakeErrReply("ERR " + err.Error())
+}
+
+// 列出 raft 集群成员及其状态
+// 格式: CLUSTER RAFTMEMBERS
+// 回复: [[id, raft address, Voter|Nonvoter|Staging, leader|follower]...]
+func execRaftMembers(cluster *Cluster, c myredis.Connection, args [][]byte) myredis.Reply {
+	if len(args) != 0 {
+		return protocol.MakeArgNumErrReply("cluster|raftmembers")
+	}
+	members, err := cluster.raftNode.GetMembers()
+	if err != nil {
+		return raftErrReply(cluster, err)
+	}
+	result := make([]myredis.Reply, len(members))
+	for i, member := range members {
+		role := "follower"
+		if member.Leader {
+			role = "leader"
+		}
+		result[i] = protocol.MakeMultiBulkReply([][]byte{
+			[]byte(member.ID),
+			[]byte(member.Address),
+			[]byte(member.Suffrage),
+			[]byte(role),
+		})
+	}
+	if len(result) == 0 {
+		return protocol.MakeEmptyMultiBulkReply()
+	}
+	return protocol.MakeMultiRawReply(result)
+}
+
+// 以非投票者身份加入节点，待其追上日志后使用 RAFTPROMOTE 提升为投票者
+// 格式: CLUSTER RAFTADD nodeID raftAddr
+func execRaftAdd(cluster *Cluster, c myredis.Connection, args [][]byte) myredis.Reply {
+	if len(args) != 2 {
+		return protocol.MakeArgNumErrReply("cluster|raftadd")
+	}
+	if err := cluster.raftNode.AddNonVoter(string(args[0]), string(args[1])); err != nil {
+		return raftErrReply(cluster, err)
+	}
+	return protocol.MakeOkReply()
+}
+
+// 返回本节点最后一条 raft 日志的索引
+// 格式: CLUSTER RAFTINDEX
+func execRaftIndex(cluster *Cluster, c myredis.Connection, args [][]byte) myredis.Reply {
+	if len(args) != 0 {
+		return protocol.MakeArgNumErrReply("cluster|raftindex")
+	}
+	return protocol.MakeIntReply(int64(cluster.raftNode.LastIndex()))
+}
+
+// 向目标节点查询日志进度，日志追上 leader 的提交位置后才提升为投票者
+// 格式: CLUSTER RAFTPROMOTE nodeID
+func execRaftPromote(cluster *Cluster, c myredis.Connection, args [][]byte) myredis.Reply {
+	if len(args) != 1 {
+		return protocol.MakeArgNumErrReply("cluster|raftpromote")
+	}
+	nodeID := string(args[0])
+	reply := cluster.relay(nodeID, nil, utils.ToCmdLine("CLUSTER", "RAFTINDEX"))
+	indexReply, ok := reply.(*protocol.IntReply)
+	if !ok {
+		return protocol.MakeErrReply("ERR cannot get raft log index of " + nodeID + ": " +
+			strings.TrimSpace(string(reply.ToBytes())))
+	}
+	if err := cluster.raftNode.PromoteToVoter(nodeID, uint64(indexReply.Code)); err != nil {
+		return raftErrReply(cluster, err)
+	}
+	return protocol.MakeOkReply()
+}
+
+// 格式: CLUSTER RAFTDEMOTE nodeID
+func execRaftDemote(cluster *Cluster, c myredis.Connection, args [][]byte) myredis.Reply {
+	if len(args) != 1 {
+		return protocol.MakeArgNumErrReply("cluster|raftdemote")
+	}
+	if err := cluster.raftNode.DemoteVoter(string(args[0])); err != nil {
+		return raftErrReply(cluster, err)
+	}
+	return protocol.MakeOkReply()
+}
+
+// 格式: CLUSTER RAFTREMOVE nodeID
+func execRaftRemove(cluster *Cluster, c myredis.Connection, args [][]byte) myredis.Reply {
+	if len(args) != 1 {
+		return protocol.MakeArgNumErrReply("cluster|raftremove")
+	}
+	if err := cluster.raftNode.HandleEvict(string(args[0])); err != nil {
+		return raftErrReply(cluster, err)
+	}
+	return protocol.MakeOkReply()
+}
+
+// 转移 leader 身份，未指定目标时由 raft 自行选择
+// 格式: CLUSTER RAFTTRANSFER [nodeID]
+func execRaftTransfer(cluster *Cluster, c myredis.Connection, args [][]byte) myredis.Reply {
+	if len(args) > 1 {
+		return protocol.MakeArgNumErrReply("cluster|rafttransfer")
+	}
+	target := ""
+	if len(args) == 1 {
+		target = string(args[0])
+	}
+	if err := cluster.raftNode.TransferLeadership(target); err != nil {
+		return raftErrReply(cluster, err)
+	}
+	return protocol.MakeOkReply()
+}
+
+func init() {
+	registerClusterSubCmd("raftmembers", execRaftMembers)
+	registerClusterSubCmd("raftadd", execRaftAdd)
+	registerClusterSubCmd("raftindex", execRaftIndex)
+	registerClusterSubCmd("raftpromote", execRaftPromote)
+	registerClusterSubCmd("raftdemote", execRaftDemote)
+	registerClusterSubCmd("raftremove", execRaftRemove)
+	registerClusterSubCmd("rafttransfer", execRaftTransfer)
+}
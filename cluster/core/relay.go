@@ -0,0 +1,51 @@
+package core
+
+import (
+	"myredis/interface/myredis"
+	"myredis/lib/utils"
+	"myredis/myredis/connection"
+	"myredis/protocol"
+	"strconv"
+)
+
+// 返回当前节点 ID
+func (cluster *Cluster) self() string {
+	return cluster.raftNode.Self()
+}
+
+// 返回负责 key 的节点 ID
+func (cluster *Cluster) pickNode(key string) string {
+	return cluster.raftNode.PickNode(GetSlot(key))
+}
+
+// 将命令转发到指定节点执行
+//
+// 目标为当前节点时直接在本地执行，否则借用到目标节点的连接发送，
+// 远程执行前会先切换到与客户端相同的数据库
+func (cluster *Cluster) relay(node string, c myredis.Connection, cmdLine CmdLine) myredis.Reply {
+	if node == cluster.self() {
+		// 使用独立的连接执行，避免受客户端事务状态影响
+		conn := connection.NewSimpleConn()
+		if c != nil {
+			conn.SelectDB(c.GetDBIndex())
+			conn.SetPassword(c.GetPassword())
+		}
+		return cluster.Exec(conn, cmdLine)
+	}
+	peerClient, err := cluster.connections.BorrowPeerClient(node)
+	if err != nil {
+		return protocol.MakeErrReply(err.Error())
+	}
+	defer func() {
+		_ = cluster.connections.ReturnPeerClient(peerClient)
+	}()
+	dbIndex := 0
+	if c != nil {
+		dbIndex = c.GetDBIndex()
+	}
+	selectReply := peerClient.Send(utils.ToCmdLine("SELECT", strconv.Itoa(dbIndex)))
+	if protocol.IsErrorReply(selectReply) {
+		return selectReply
+	}
+	return peerClient.Send(cmdLine)
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"myredis/datastruct/set"
+	"myredis/interface/database"
+	"myredis/lib/hashslot"
+	"sync"
+	"time"
+)
+
+// 槽位在当前节点上的状态
+const (
+	slotStateHost      = iota // 由当前节点正常提供服务
+	slotStateImporting        // 正在从其他节点导入
+	slotStateExporting        // 正在向其他节点导出
+)
+
+// 计算 key 所属的槽位
+func GetSlot(key string) uint32 {
+	return hashslot.GetSlot(key)
+}
+
+func newSlotsManage() *slotsManage {
+	return &slotsManage{
+		mu:    &sync.RWMutex{},
+		slots: make(map[uint32]*slotStatus),
+	}
+}
+
+func newSlotStatus() *slotStatus {
+	return &slotStatus{
+		mu:    &sync.RWMutex{},
+		state: slotStateHost,
+		keys:  set.Make(),
+	}
+}
+
+// 获取槽位状态，不存在时创建
+func (ssm *slotsManage) getSlot(index uint32) *slotStatus {
+	ssm.mu.RLock()
+	slot := ssm.slots[index]
+	ssm.mu.RUnlock()
+	if slot != nil {
+		return slot
+	}
+
+	ssm.mu.Lock()
+	defer ssm.mu.Unlock()
+	// double check，防止并发创建
+	slot = ssm.slots[index]
+	if slot == nil {
+		slot = newSlotStatus()
+		ssm.slots[index] = slot
+	}
+	return slot
+}
+
+// 清空所有槽位中的 key 索引，槽位状态保持不变
+func (ssm *slotsManage) clearKeys() {
+	ssm.mu.RLock()
+	defer ssm.mu.RUnlock()
+	for _, slot := range ssm.slots {
+		slot.mu.Lock()
+		slot.keys = set.Make()
+		slot.mu.Unlock()
+	}
+}
+
+// 将 key 加入所属槽位的索引
+//
+// 槽位处于导出状态时，同时记录为脏 key，迁移结束前需要重新同步
+func (slot *slotStatus) addKey(key string) {
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	slot.keys.Add(key)
+	if slot.state == slotStateExporting && slot.dirtyKeys != nil {
+		slot.dirtyKeys.Add(key)
+	}
+}
+
+// 将 key 从所属槽位的索引中移除
+func (slot *slotStatus) removeKey(key string) {
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	slot.keys.Remove(key)
+	if slot.state == slotStateExporting && slot.dirtyKeys != nil {
+		slot.dirtyKeys.Add(key)
+	}
+}
+
+// 向数据库注册 key 插入/删除的回调，维护槽位到 key 的索引
+//
+// 集群模式下只使用 0 号数据库，其他数据库中的同名 key 不能影响索引。
+// 注册前已经存在的 key 立即建立索引，AOF/RDB 在后台加载完成后再重建一次
+func (cluster *Cluster) injectSlotCallbacks() {
+	cluster.db.SetKeyInsertedCallback(func(dbIndex int, key string, entity *database.DataEntity) {
+		if dbIndex != 0 {
+			return
+		}
+		cluster.slotsManager.getSlot(GetSlot(key)).addKey(key)
+	})
+	cluster.db.SetKeyDeletedCallback(func(dbIndex int, key string, entity *database.DataEntity) {
+		if dbIndex != 0 {
+			return
+		}
+		cluster.slotsManager.getSlot(GetSlot(key)).removeKey(key)
+	})
+	cluster.db.SetLoadedCallback(cluster.rebuildSlotIndex)
+	cluster.rebuildSlotIndex()
+}
+
+// 根据数据库中现有的 key 重建槽位索引
+func (cluster *Cluster) rebuildSlotIndex() {
+	cluster.slotsManager.clearKeys()
+	// 集群模式下只使用 0 号数据库
+	cluster.db.ForEach(0, func(key string, data *database.DataEntity, expiration *time.Time) bool {
+		cluster.slotsManager.getSlot(GetSlot(key)).addKey(key)
+		return true
+	})
+}
+
+// 返回槽位中 key 的数量，直接读取索引，无需扫描数据库
+func (cluster *Cluster) CountKeysInSlot(index uint32) int {
+	slot := cluster.slotsManager.getSlot(index)
+	slot.mu.RLock()
+	defer slot.mu.RUnlock()
+	return slot.keys.Len()
+}
+
+// 返回槽位中最多 count 个 key
+func (cluster *Cluster) GetKeysInSlot(index uint32, count int) []string {
+	slot := cluster.slotsManager.getSlot(index)
+	slot.mu.RLock()
+	defer slot.mu.RUnlock()
+	keys := make([]string, 0, min(count, slot.keys.Len()))
+	slot.keys.ForEach(func(member string) bool {
+		if len(keys) >= count {
+			return false
+		}
+		keys = append(keys, member)
+		return true
+	})
+	return keys
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"myredis/config"
+	"myredis/database"
+	"myredis/lib/utils"
+	"myredis/myredis/connection"
+	"myredis/protocol"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSlotIndex(t *testing.T) {
+	cluster := &Cluster{slotsManager: newSlotsManage()}
+	keys := []string{"{a}1", "{a}2", "{a}3"}
+	slot := GetSlot(keys[0])
+	for _, key := range keys {
+		cluster.slotsManager.getSlot(GetSlot(key)).addKey(key)
+	}
+	if n := cluster.CountKeysInSlot(slot); n != 3 {
+		t.Errorf("expect 3 keys, actually %d", n)
+	}
+	if n := len(cluster.GetKeysInSlot(slot, 2)); n != 2 {
+		t.Errorf("expect 2 keys, actually %d", n)
+	}
+	cluster.slotsManager.getSlot(slot).removeKey(keys[0])
+	if n := cluster.CountKeysInSlot(slot); n != 2 {
+		t.Errorf("expect 2 keys, actually %d", n)
+	}
+	cluster.slotsManager.clearKeys()
+	if n := cluster.CountKeysInSlot(slot); n != 0 {
+		t.Errorf("expect 0 keys, actually %d", n)
+	}
+}
+
+func TestSlotIndexIgnoresOtherDBs(t *testing.T) {
+	cluster := makeTestCluster()
+	cluster.injectSlotCallbacks()
+	conn := connection.NewSimpleConn()
+	slot := GetSlot("x")
+
+	cluster.db.Exec(conn, utils.ToCmdLine("set", "x", "1"))
+	cluster.db.Exec(conn, utils.ToCmdLine("copy", "x", "x", "db", "1"))
+	cluster.db.Exec(conn, utils.ToCmdLine("select", "1"))
+	cluster.db.Exec(conn, utils.ToCmdLine("set", "y", "1"))
+	cluster.db.Exec(conn, utils.ToCmdLine("del", "x"))
+	if n := cluster.CountKeysInSlot(slot); n != 1 {
+		t.Errorf("expect 1 key, actually %d", n)
+	}
+	if n := cluster.CountKeysInSlot(GetSlot("y")); n != 0 {
+		t.Errorf("expect 0 keys, actually %d", n)
+	}
+}
+
+func TestSlotIndexAfterLoading(t *testing.T) {
+	dir := t.TempDir()
+	old := config.Properties
+	config.Properties = &config.ServerProperties{
+		Dir:         dir,
+		Databases:   16,
+		RDBFilename: filepath.Join(dir, "dump.rdb"),
+	}
+	t.Cleanup(func() {
+		config.Properties = old
+	})
+	conn := connection.NewSimpleConn()
+	server := database.MakeAuxiliaryServer()
+	server.Exec(conn, utils.ToCmdLine("set", "{a}1", "1"))
+	server.Exec(conn, utils.ToCmdLine("set", "{a}2", "1"))
+	server.Exec(conn, utils.ToCmdLine("save"))
+	slot := GetSlot("{a}")
+
+	// 注册回调前已经存在的 key
+	cluster := &Cluster{db: server, slotsManager: newSlotsManage()}
+	cluster.injectSlotCallbacks()
+	if n := cluster.CountKeysInSlot(slot); n != 2 {
+		t.Errorf("expect 2 keys, actually %d", n)
+	}
+
+	// 后台从 RDB 加载的 key
+	loaded := database.NewStandaloneServer()
+	defer loaded.Close()
+	cluster = &Cluster{db: loaded, slotsManager: newSlotsManage()}
+	cluster.injectSlotCallbacks()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, isErr := loaded.Exec(conn, utils.ToCmdLine("get", "{a}1")).(protocol.ErrorReply)
+		if !isErr || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := cluster.CountKeysInSlot(slot); n != 2 {
+		t.Errorf("expect 2 keys, actually %d", n)
+	}
+}
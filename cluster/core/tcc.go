@@ -0,0 +1,534 @@
+/*
+tcc.go 实现跨节点事务（try-commit-catch），使 MULTI/EXEC 可以涉及不同节点上的槽位。
+
+协调者（收到 EXEC 的节点）按 key 所属节点对命令分组，然后：
+
+	1. Prepare：每个参与者锁定相关 key，检查 WATCH 的版本，并记录 undo logs
+	2. Commit：所有参与者准备成功后依次提交，执行命令并释放锁
+	3. Rollback：任意阶段失败时通知全部参与者回滚；已提交的参与者执行 undo logs
+
+参与者在 Prepare 之后若迟迟收不到 Commit/Rollback（例如协调者宕机），
+会在 maxLockTime 后自动回滚并释放锁，避免 key 被永久锁定。
+*/
+package core
+
+import (
+	"fmt"
+	"myredis/database"
+	"myredis/interface/myredis"
+	"myredis/lib/logger"
+	"myredis/lib/timewheel"
+	"myredis/lib/utils"
+	"myredis/myredis/connection"
+	"myredis/protocol"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 参与者准备后持有锁的最长时间
+	maxLockTime = 3 * time.Second
+	// 提交后保留事务状态的时间，用于处理其他参与者失败导致的回滚
+	waitBeforeCleanTx = 2 * maxLockTime
+)
+
+// 事务在参与者上的状态
+const (
+	createdStatus = iota
+	preparedStatus
+	committedStatus
+	rolledBackStatus
+)
+
+// 参与者一侧的事务
+type Transaction struct {
+	id       string
+	cluster  *Cluster
+	dbIndex  int
+	cmdLines []CmdLine
+	watching map[string]uint32
+
+	writeKeys  []string
+	readKeys   []string
+	keysLocked bool
+	undoLogs   [][]CmdLine
+
+	status int8
+	mu     *sync.Mutex
+}
+
+func newTransaction(cluster *Cluster, id string, dbIndex int, cmdLines []CmdLine, watching map[string]uint32) *Transaction {
+	return &Transaction{
+		id:       id,
+		cluster:  cluster,
+		dbIndex:  dbIndex,
+		cmdLines: cmdLines,
+		watching: watching,
+		status:   createdStatus,
+		mu:       &sync.Mutex{},
+	}
+}
+
+// 时间轮中事务超时任务的 key
+func genTaskKey(txID string) string {
+	return "tx:" + txID
+}
+
+func (tx *Transaction) lockKeys() {
+	if !tx.keysLocked {
+		tx.cluster.db.RWLocks(tx.dbIndex, tx.writeKeys, tx.readKeys)
+		tx.keysLocked = true
+	}
+}
+
+func (tx *Transaction) unLockKeys() {
+	if tx.keysLocked {
+		tx.cluster.db.RWUnLocks(tx.dbIndex, tx.writeKeys, tx.readKeys)
+		tx.keysLocked = false
+	}
+}
+
+// prepare 锁定相关 key，检查 WATCH 的版本并记录 undo logs
+func (tx *Transaction) prepare() error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	for _, cmdLine := range tx.cmdLines {
+		write, read := database.GetRelatedKeys(cmdLine)
+		tx.writeKeys = append(tx.writeKeys, write...)
+		tx.readKeys = append(tx.readKeys, read...)
+	}
+	for key := range tx.watching {
+		tx.readKeys = append(tx.readKeys, key)
+	}
+	tx.lockKeys()
+
+	for key, version := range tx.watching {
+		if tx.cluster.db.GetVersion(tx.dbIndex, key) != version {
+			tx.unLockKeys()
+			return errWatchingChanged
+		}
+	}
+
+	// key 已被锁定，undo logs 均基于事务开始前的状态生成，回滚时逆序执行
+	tx.undoLogs = make([][]CmdLine, 0, len(tx.cmdLines))
+	for _, cmdLine := range tx.cmdLines {
+		tx.undoLogs = append(tx.undoLogs, tx.cluster.db.GetUndoLogs(tx.dbIndex, cmdLine))
+	}
+	tx.status = preparedStatus
+
+	// 超时未收到提交或回滚，自动回滚
+	timewheel.Delay(maxLockTime, genTaskKey(tx.id), func() {
+		tx.mu.Lock()
+		defer tx.mu.Unlock()
+		if tx.status == preparedStatus {
+			logger.Info("abort transaction: " + tx.id)
+			_ = tx.rollbackWithLock()
+		}
+	})
+	return nil
+}
+
+// commit 执行事务中的命令，返回每条命令的结果
+//
+// 某条命令执行失败时，已执行的命令会被撤销
+func (tx *Transaction) commit(conn myredis.Connection) ([]myredis.Reply, error) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.status != preparedStatus {
+		return nil, fmt.Errorf("transaction %s is not prepared", tx.id)
+	}
+	timewheel.Cancel(genTaskKey(tx.id))
+
+	results := make([]myredis.Reply, 0, len(tx.cmdLines))
+	for _, cmdLine := range tx.cmdLines {
+		result := tx.cluster.db.ExecWithLock(conn, cmdLine)
+		if protocol.IsErrorReply(result) {
+			// 只撤销已经执行的命令
+			tx.undo(tx.undoLogs[:len(results)])
+			tx.unLockKeys()
+			tx.status = rolledBackStatus
+			tx.cluster.transactions.Remove(tx.id)
+			return nil, protocol.Try2ErrorReply(result)
+		}
+		results = append(results, result)
+	}
+	tx.cluster.db.AddVersion(tx.dbIndex, tx.writeKeys...)
+	tx.unLockKeys()
+	tx.status = committedStatus
+
+	// 保留一段时间，以便其他参与者提交失败时仍能回滚
+	timewheel.Delay(waitBeforeCleanTx, genTaskKey(tx.id), func() {
+		tx.cluster.transactions.Remove(tx.id)
+	})
+	return results, nil
+}
+
+// 逆序执行 undo logs，调用者需要持有相关 key 的锁
+func (tx *Transaction) undo(undoLogs [][]CmdLine) {
+	conn := connection.NewSimpleConn()
+	conn.SelectDB(tx.dbIndex)
+	for i := len(undoLogs) - 1; i >= 0; i-- {
+		for _, cmdLine := range undoLogs[i] {
+			tx.cluster.db.ExecWithLock(conn, cmdLine)
+		}
+	}
+}
+
+// 回滚事务，调用者需要持有 tx.mu
+func (tx *Transaction) rollbackWithLock() error {
+	if tx.status == rolledBackStatus {
+		return nil
+	}
+	tx.lockKeys()
+	// 已提交的事务需要撤销执行过的命令，仅准备过的事务释放锁即可
+	if tx.status == committedStatus {
+		tx.undo(tx.undoLogs)
+		tx.cluster.db.AddVersion(tx.dbIndex, tx.writeKeys...)
+	}
+	tx.unLockKeys()
+	tx.status = rolledBackStatus
+	tx.cluster.transactions.Remove(tx.id)
+	return nil
+}
+
+func (tx *Transaction) rollback() error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	timewheel.Cancel(genTaskKey(tx.id))
+	return tx.rollbackWithLock()
+}
+
+var errWatchingChanged = fmt.Errorf("watching keys changed")
+
+const watchingChangedMsg = "WATCHING CHANGED"
+
+// ******************** Participant Commands ********************
+
+// 将事务参数编码到命令行中，格式：
+//
+//	<watchCount> [key version]... <cmdCount> [<argc> arg...]...
+func marshalTxArgs(watching map[string]uint32, cmdLines []CmdLine) [][]byte {
+	args := [][]byte{[]byte(strconv.Itoa(len(watching)))}
+	for key, version := range watching {
+		args = append(args, []byte(key), []byte(strconv.FormatUint(uint64(version), 10)))
+	}
+	args = append(args, []byte(strconv.Itoa(len(cmdLines))))
+	for _, cmdLine := range cmdLines {
+		args = append(args, []byte(strconv.Itoa(len(cmdLine))))
+		args = append(args, cmdLine...)
+	}
+	return args
+}
+
+func unmarshalTxArgs(args [][]byte) (map[string]uint32, []CmdLine, error) {
+	errSyntax := fmt.Errorf("illegal transaction arguments")
+	next := func() (int, error) {
+		if len(args) == 0 {
+			return 0, errSyntax
+		}
+		n, err := strconv.Atoi(string(args[0]))
+		args = args[1:]
+		if err != nil || n < 0 {
+			return 0, errSyntax
+		}
+		return n, nil
+	}
+	watchCount, err := next()
+	if err != nil || len(args) < 2*watchCount {
+		return nil, nil, errSyntax
+	}
+	watching := make(map[string]uint32, watchCount)
+	for i := 0; i < watchCount; i++ {
+		version, err := strconv.ParseUint(string(args[1]), 10, 32)
+		if err != nil {
+			return nil, nil, errSyntax
+		}
+		watching[string(args[0])] = uint32(version)
+		args = args[2:]
+	}
+	cmdCount, err := next()
+	if err != nil {
+		return nil, nil, err
+	}
+	cmdLines := make([]CmdLine, 0, cmdCount)
+	for i := 0; i < cmdCount; i++ {
+		argc, err := next()
+		if err != nil || argc == 0 || len(args) < argc {
+			return nil, nil, errSyntax
+		}
+		cmdLines = append(cmdLines, args[:argc])
+		args = args[argc:]
+	}
+	return watching, cmdLines, nil
+}
+
+// execPrepare 在参与者上准备事务
+// 格式: PREPARE txID dbIndex <tx args>
+func execPrepare(cluster *Cluster, c myredis.Connection, cmdLine CmdLine) myredis.Reply {
+	if len(cmdLine) < 5 {
+		return protocol.MakeArgNumErrReply("prepare")
+	}
+	txID := string(cmdLine[1])
+	dbIndex, err := strconv.Atoi(string(cmdLine[2]))
+	if err != nil {
+		return protocol.MakeErrReply("ERR invalid db index")
+	}
+	watching, cmdLines, err := unmarshalTxArgs(cmdLine[3:])
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
+	tx := newTransaction(cluster, txID, dbIndex, cmdLines, watching)
+	cluster.transactions.Put(txID, tx)
+	err = tx.prepare()
+	if err != nil {
+		cluster.transactions.Remove(txID)
+		if err == errWatchingChanged {
+			return protocol.MakeErrReply(watchingChangedMsg)
+		}
+		return protocol.MakeErrReply(err.Error())
+	}
+	return protocol.MakeOkReply()
+}
+
+// execCommit 提交参与者上已准备的事务
+//
+// 每条命令的结果以原始 RESP 格式放在数组中返回，由协调者还原
+// 格式: COMMIT txID
+func execCommit(cluster *Cluster, c myredis.Connection, cmdLine CmdLine) myredis.Reply {
+	if len(cmdLine) != 2 {
+		return protocol.MakeArgNumErrReply("commit")
+	}
+	txID := string(cmdLine[1])
+	raw, ok := cluster.transactions.Get(txID)
+	if !ok {
+		return protocol.MakeErrReply("ERR transaction not found: " + txID)
+	}
+	tx := raw.(*Transaction)
+	conn := connection.NewSimpleConn()
+	conn.SelectDB(tx.dbIndex)
+	results, err := tx.commit(conn)
+	if err != nil {
+		return protocol.MakeErrReply(err.Error())
+	}
+	encoded := make([][]byte, len(results))
+	for i, result := range results {
+		encoded[i] = result.ToBytes()
+	}
+	return protocol.MakeMultiBulkReply(encoded)
+}
+
+// execRollback 回滚参与者上的事务，事务不存在时视为已回滚
+// 格式: ROLLBACK txID
+func execRollback(cluster *Cluster, c myredis.Connection, cmdLine CmdLine) myredis.Reply {
+	if len(cmdLine) != 2 {
+		return protocol.MakeArgNumErrReply("rollback")
+	}
+	raw, ok := cluster.transactions.Get(string(cmdLine[1]))
+	if !ok {
+		return protocol.MakeIntReply(0)
+	}
+	tx := raw.(*Transaction)
+	if err := tx.rollback(); err != nil {
+		return protocol.MakeErrReply(err.Error())
+	}
+	return protocol.MakeIntReply(1)
+}
+
+// execGetVersion 返回 key 在参与者上的版本号，用于跨节点 WATCH
+// 格式: GETVER dbIndex key [key...]
+func execGetVersion(cluster *Cluster, c myredis.Connection, cmdLine CmdLine) myredis.Reply {
+	if len(cmdLine) < 3 {
+		return protocol.MakeArgNumErrReply("getver")
+	}
+	dbIndex, err := strconv.Atoi(string(cmdLine[1]))
+	if err != nil {
+		return protocol.MakeErrReply("ERR invalid db index")
+	}
+	versions := make([][]byte, 0, len(cmdLine)-2)
+	for _, key := range cmdLine[2:] {
+		version := cluster.db.GetVersion(dbIndex, string(key))
+		versions = append(versions, []byte(strconv.FormatUint(uint64(version), 10)))
+	}
+	return protocol.MakeMultiBulkReply(versions)
+}
+
+// ******************** Coordinator ********************
+
+// 已编码的回复，直接输出参与者返回的 RESP 字节
+type rawReply []byte
+
+func (r rawReply) ToBytes() []byte {
+	return r
+}
+
+// 事务中分配给某个节点的命令
+type txGroup struct {
+	indexes  []int // 命令在原事务中的位置
+	cmdLines []CmdLine
+	watching map[string]uint32
+}
+
+// execWatch 记录 key 在所属节点上的版本
+// 格式: WATCH key [key...]
+func execWatch(cluster *Cluster, c myredis.Connection, cmdLine CmdLine) myredis.Reply {
+	if len(cmdLine) < 2 {
+		return protocol.MakeArgNumErrReply("watch")
+	}
+	if c.InMultiState() {
+		return protocol.MakeErrReply("ERR WATCH inside MULTI is not allowed")
+	}
+	dbIndex := strconv.Itoa(c.GetDBIndex())
+	groups := make(map[string][]string)
+	for _, arg := range cmdLine[1:] {
+		key := string(arg)
+		node := cluster.pickNode(key)
+		groups[node] = append(groups[node], key)
+	}
+	watching := c.GetWatching()
+	for node, keys := range groups {
+		reply := cluster.relay(node, c, utils.ToCmdLine2("GETVER", append([]string{dbIndex}, keys...)...))
+		versions, ok := reply.(*protocol.MultiBulkReply)
+		if !ok || len(versions.Args) != len(keys) {
+			return protocol.MakeErrReply("ERR get version failed from " + node)
+		}
+		for i, key := range keys {
+			version, _ := strconv.ParseUint(string(versions.Args[i]), 10, 32)
+			watching[key] = uint32(version)
+		}
+	}
+	return protocol.MakeOkReply()
+}
+
+// execMultiExec 以协调者身份执行事务
+// 格式: EXEC
+func execMultiExec(cluster *Cluster, c myredis.Connection, cmdLine CmdLine) myredis.Reply {
+	if len(cmdLine) != 1 {
+		return protocol.MakeArgNumErrReply("exec")
+	}
+	if !c.InMultiState() {
+		return protocol.MakeErrReply("ERR EXEC without MULTI")
+	}
+	defer func() {
+		c.SetMultiState(false)
+	}()
+	if len(c.GetTxErrors()) > 0 {
+		return protocol.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+	cmdLines := c.GetQueuedCmdLine()
+	watching := c.GetWatching()
+
+	// 按 key 所属节点分组，不涉及 key 的命令在本地执行
+	groups := make(map[string]*txGroup)
+	getGroup := func(node string) *txGroup {
+		group := groups[node]
+		if group == nil {
+			group = &txGroup{watching: make(map[string]uint32)}
+			groups[node] = group
+		}
+		return group
+	}
+	for i, cmdLine := range cmdLines {
+		write, read := database.GetRelatedKeys(cmdLine)
+		node := ""
+		for _, key := range append(write, read...) {
+			keyNode := cluster.pickNode(key)
+			if node != "" && keyNode != node {
+				return protocol.MakeErrReply("CROSSSLOT Keys in request don't hash to the same node")
+			}
+			node = keyNode
+		}
+		if node == "" {
+			node = cluster.self()
+		}
+		group := getGroup(node)
+		group.indexes = append(group.indexes, i)
+		group.cmdLines = append(group.cmdLines, cmdLine)
+	}
+	for key, version := range watching {
+		getGroup(cluster.pickNode(key)).watching[key] = version
+	}
+
+	// 只涉及当前节点，直接使用单机事务
+	if len(groups) == 1 {
+		if _, ok := groups[cluster.self()]; ok {
+			return cluster.db.ExecMulti(c, watching, cmdLines)
+		}
+	}
+	return cluster.execCrossNodeMulti(c, groups, len(cmdLines))
+}
+
+func (cluster *Cluster) execCrossNodeMulti(c myredis.Connection, groups map[string]*txGroup, cmdCount int) myredis.Reply {
+	genID, err := cluster.idGenerator.NextID()
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
+	txID := strconv.FormatInt(genID, 10)
+	dbIndex := strconv.Itoa(c.GetDBIndex())
+
+	// 通知所有参与过的节点回滚
+	rollback := func(nodes []string) {
+		for _, node := range nodes {
+			reply := cluster.relay(node, c, utils.ToCmdLine("ROLLBACK", txID))
+			if protocol.IsErrorReply(reply) {
+				logger.Warn("rollback transaction " + txID + " on " + node + " failed: " + string(reply.ToBytes()))
+			}
+		}
+	}
+
+	// Prepare 阶段
+	prepared := make([]string, 0, len(groups))
+	for node, group := range groups {
+		prepareCmd := append(utils.ToCmdLine("PREPARE", txID, dbIndex), marshalTxArgs(group.watching, group.cmdLines)...)
+		reply := cluster.relay(node, c, prepareCmd)
+		if protocol.IsErrorReply(reply) {
+			rollback(prepared)
+			errMsg := protocol.Try2ErrorReply(reply).Error()
+			if strings.HasPrefix(errMsg, watchingChangedMsg) {
+				return protocol.MakeEmptyMultiBulkReply()
+			}
+			logger.Warn("prepare transaction " + txID + " on " + node + " failed: " + errMsg)
+			return protocol.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
+		}
+		prepared = append(prepared, node)
+	}
+
+	// Commit 阶段，任一节点失败则回滚全部节点
+	results := make([]myredis.Reply, cmdCount)
+	for node, group := range groups {
+		reply := cluster.relay(node, c, utils.ToCmdLine("COMMIT", txID))
+		committed, ok := reply.(*protocol.MultiBulkReply)
+		if !ok || len(committed.Args) != len(group.indexes) {
+			logger.Warn("commit transaction " + txID + " on " + node + " failed: " + string(reply.ToBytes()))
+			rollback(prepared)
+			return protocol.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
+		}
+		for i, index := range group.indexes {
+			results[index] = rawReply(committed.Args[i])
+		}
+	}
+	return protocol.MakeMultiRawReply(results)
+}
+
+func init() {
+	RegisterCmd("prepare", execPrepare)
+	RegisterCmd("commit", execCommit)
+	RegisterCmd("rollback", execRollback)
+	RegisterCmd("getver", execGetVersion)
+	RegisterCmd("watch", execWatch)
+	RegisterCmd("exec", execMultiExec)
+	RegisterCmd("multi", func(cluster *Cluster, c myredis.Connection, cmdLine CmdLine) myredis.Reply {
+		if len(cmdLine) != 1 {
+			return protocol.MakeArgNumErrReply("multi")
+		}
+		return database.StartMulti(c)
+	})
+	RegisterCmd("discard", func(cluster *Cluster, c myredis.Connection, cmdLine CmdLine) myredis.Reply {
+		if len(cmdLine) != 1 {
+			return protocol.MakeArgNumErrReply("discard")
+		}
+		return database.DiscardMulti(c)
+	})
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"myredis/config"
+	"myredis/database"
+	"myredis/datastruct/dict"
+	"myredis/lib/utils"
+	"myredis/myredis/connection"
+	"myredis/protocol"
+	"myredis/protocol/assert"
+	"testing"
+)
+
+func makeTestCluster() *Cluster {
+	if config.Properties == nil {
+		config.Properties = &config.ServerProperties{Databases: 16}
+	}
+	return &Cluster{
+		db:           database.MakeAuxiliaryServer(),
+		slotsManager: newSlotsManage(),
+		transactions: dict.MakeConcurrent(1),
+	}
+}
+
+func TestMarshalTxArgs(t *testing.T) {
+	watching := map[string]uint32{"a": 1, "b": 2}
+	cmdLines := []CmdLine{
+		utils.ToCmdLine("SET", "a", "1"),
+		utils.ToCmdLine("DEL", "b"),
+	}
+	watching2, cmdLines2, err := unmarshalTxArgs(marshalTxArgs(watching, cmdLines))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(watching2) != 2 || watching2["a"] != 1 || watching2["b"] != 2 {
+		t.Errorf("wrong watching: %v", watching2)
+	}
+	if len(cmdLines2) != 2 || string(cmdLines2[0][2]) != "1" || string(cmdLines2[1][0]) != "DEL" {
+		t.Errorf("wrong cmdLines: %v", cmdLines2)
+	}
+	_, _, err = unmarshalTxArgs(utils.ToCmdLine("1", "a"))
+	if err == nil {
+		t.Error("expect error for truncated args")
+	}
+}
+
+func TestTransactionCommitAndRollback(t *testing.T) {
+	cluster := makeTestCluster()
+	conn := connection.NewSimpleConn()
+	cluster.db.ExecWithLock(conn, utils.ToCmdLine("SET", "a", "old"))
+
+	cmdLines := []CmdLine{
+		utils.ToCmdLine("SET", "a", "new"),
+		utils.ToCmdLine("SET", "b", "1"),
+	}
+	tx := newTransaction(cluster, "1", 0, cmdLines, nil)
+	cluster.transactions.Put("1", tx)
+	if err := tx.prepare(); err != nil {
+		t.Fatal(err)
+	}
+	results, err := tx.commit(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expect 2 results, actually %d", len(results))
+	}
+	assert.AssertBulkReply(t, cluster.db.ExecWithLock(conn, utils.ToCmdLine("GET", "a")), "new")
+
+	// 其他参与者失败，已提交的事务需要撤销
+	if err := tx.rollback(); err != nil {
+		t.Fatal(err)
+	}
+	assert.AssertBulkReply(t, cluster.db.ExecWithLock(conn, utils.ToCmdLine("GET", "a")), "old")
+	if _, ok := cluster.db.ExecWithLock(conn, utils.ToCmdLine("GET", "b")).(*protocol.NullBulkReply); !ok {
+		t.Error("expect b to be deleted")
+	}
+	if _, ok := cluster.transactions.Get("1"); ok {
+		t.Error("transaction should be removed after rollback")
+	}
+}
+
+func TestTransactionWatch(t *testing.T) {
+	cluster := makeTestCluster()
+	conn := connection.NewSimpleConn()
+	version := cluster.db.GetVersion(0, "w")
+	cluster.db.AddVersion(0, "w")
+
+	tx := newTransaction(cluster, "2", 0, []CmdLine{utils.ToCmdLine("SET", "w", "1")},
+		map[string]uint32{"w": version})
+	if err := tx.prepare(); err != errWatchingChanged {
+		t.Errorf("expect watching changed, actually %v", err)
+	}
+	// 锁已释放，后续命令可以正常执行
+	cluster.db.RWLocks(0, []string{"w"}, nil)
+	cluster.db.RWUnLocks(0, []string{"w"}, nil)
+	if _, ok := cluster.db.ExecWithLock(conn, utils.ToCmdLine("GET", "w")).(*protocol.NullBulkReply); !ok {
+		t.Error("expect w not to be set")
+	}
+}
@@ -19,12 +19,16 @@
 //   - 状态变更只能通过 Raft 日志进行（强一致性）
 //   - 所有字段由读写锁保护（并发安全）
 //   - 快照中只保存核心状态，派生字段在恢复时重建
+//   - 快照带有版本号，恢复时兼容旧版本格式
 
 package raft
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
+	"myredis/lib/logger"
+	"sort"
 	"sync"
 
 	"github.com/hashicorp/raft"
@@ -41,6 +45,17 @@ type FSM struct {
 	changed      func(*FSM)                // 状态变化回调函数
 }
 
+func newFSM() *FSM {
+	return &FSM{
+		Node2Slot:    make(map[string][]uint32),
+		Slot2Node:    make(map[uint32]string),
+		Migratings:   make(map[string]*MigratingTask),
+		MasterSlaves: make(map[string]*MasterSlave),
+		SlaveMasters: make(map[string]string),
+		Failovers:    make(map[string]*FailoverTask),
+	}
+}
+
 // 表示一个正在进行的槽位迁移任务，不可变
 type MigratingTask struct {
 	ID         string
@@ -153,12 +168,21 @@ func (fsm *FSM) Apply(log *raft.Log) interface{} {
 	return nil
 }
 
+// 快照格式的当前版本
+//
+// 版本 0 为早期格式，只包含 Slot2Node、Migratings 与 MasterSlaves；
+// 版本 1 增加了 Failovers。新增字段时需要递增版本号，
+// 并保证旧版本的快照仍能被正确解码
+const snapshotVersion = 1
+
 // 表示某一时刻 FSM 的状态快照，用于快速恢复，避免重放大量历史日志
-// 只需下列三个成员，其余可以通过映射得到
+// Node2Slot 与 SlaveMasters 可以由其余字段推导，不写入快照
 type FSMSnapshot struct {
+	Version      int
 	Slot2Node    map[uint32]string
 	Migratings   map[string]*MigratingTask
 	MasterSlaves map[string]*MasterSlave
+	Failovers    map[string]*FailoverTask
 }
 
 // 将快照写入磁盘
@@ -177,61 +201,114 @@ func (snapshot *FSMSnapshot) Persist(sink raft.SnapshotSink) error {
 		return sink.Close()
 	}()
 	if err != nil {
-		sink.Close() // 出错则取消写入
+		sink.Cancel() // 出错则取消写入
 	}
 	return err
 }
 
 func (snapshot *FSMSnapshot) Release() {}
 
-// 生成当前 FSM 的快照对象，用于 Raft 在适当时机调用它
-func (fsm *FSM) Snapshot() (raft.FSMSnapshot, error) {
-	fsm.mu.Lock()
-	defer fsm.mu.Unlock()
-
-	slot2Node := make(map[uint32]string)
+// 深拷贝当前状态，调用者需要持有 fsm.mu
+func (fsm *FSM) makeSnapshot() *FSMSnapshot {
+	slot2Node := make(map[uint32]string, len(fsm.Slot2Node))
 	for key, val := range fsm.Slot2Node {
 		slot2Node[key] = val
 	}
-	migratings := make(map[string]*MigratingTask)
+	migratings := make(map[string]*MigratingTask, len(fsm.Migratings))
 	for k, v := range fsm.Migratings {
-		migratings[k] = v
+		task := *v
+		task.Slots = append([]uint32(nil), v.Slots...)
+		migratings[k] = &task
 	}
-	masterSlaves := make(map[string]*MasterSlave)
+	masterSlaves := make(map[string]*MasterSlave, len(fsm.MasterSlaves))
 	for k, v := range fsm.MasterSlaves {
-		masterSlaves[k] = v
+		masterSlaves[k] = &MasterSlave{
+			MasterID: v.MasterID,
+			Slaves:   append([]string(nil), v.Slaves...),
+		}
+	}
+	failovers := make(map[string]*FailoverTask, len(fsm.Failovers))
+	for k, v := range fsm.Failovers {
+		task := *v
+		failovers[k] = &task
 	}
 	return &FSMSnapshot{
+		Version:      snapshotVersion,
 		Slot2Node:    slot2Node,
 		Migratings:   migratings,
 		MasterSlaves: masterSlaves,
-	}, nil
+		Failovers:    failovers,
+	}
+}
+
+// 生成当前 FSM 的快照对象，用于 Raft 在适当时机调用它
+func (fsm *FSM) Snapshot() (raft.FSMSnapshot, error) {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+	return fsm.makeSnapshot(), nil
+}
+
+// 解码快照数据，兼容旧版本格式
+//
+// 旧版本快照缺少的字段会被初始化为空；
+// 新版本快照中无法识别的字段会被忽略，以便回滚到旧版本程序后仍能恢复
+func decodeSnapshot(data []byte) (*FSMSnapshot, error) {
+	snapshot := &FSMSnapshot{}
+	err := json.Unmarshal(data, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.Version > snapshotVersion {
+		logger.Warn(fmt.Sprintf("raft snapshot version %d is newer than %d, unknown fields are ignored",
+			snapshot.Version, snapshotVersion))
+	}
+	if snapshot.Slot2Node == nil {
+		snapshot.Slot2Node = make(map[uint32]string)
+	}
+	if snapshot.Migratings == nil {
+		snapshot.Migratings = make(map[string]*MigratingTask)
+	}
+	if snapshot.MasterSlaves == nil {
+		snapshot.MasterSlaves = make(map[string]*MasterSlave)
+	}
+	if snapshot.Failovers == nil {
+		snapshot.Failovers = make(map[string]*FailoverTask)
+	}
+	return snapshot, nil
 }
 
 // 从快照中恢复 FSM 状态，在节点重启或新节点加入时可能被调用
 func (fsm *FSM) Restore(src io.ReadCloser) error {
-	fsm.mu.Lock()
-	defer fsm.mu.Unlock()
+	defer src.Close()
 	// 读取快照数据
 	data, err := io.ReadAll(src)
 	if err != nil {
 		return err
 	}
 	// 反序列化为快照对象
-	snapshot := &FSMSnapshot{}
-	err = json.Unmarshal(data, snapshot)
+	snapshot, err := decodeSnapshot(data)
 	if err != nil {
 		return err
 	}
+
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
 	// 恢复核心映射
 	fsm.Slot2Node = snapshot.Slot2Node
 	fsm.Migratings = snapshot.Migratings
 	fsm.MasterSlaves = snapshot.MasterSlaves
+	fsm.Failovers = snapshot.Failovers
 	// 重建剩余成员
 	fsm.Node2Slot = make(map[string][]uint32)
 	for slot, node := range snapshot.Slot2Node {
 		fsm.Node2Slot[node] = append(fsm.Node2Slot[node], slot)
 	}
+	for _, slots := range fsm.Node2Slot {
+		sort.Slice(slots, func(i, j int) bool {
+			return slots[i] < slots[j]
+		})
+	}
+	fsm.SlaveMasters = make(map[string]string)
 	for master, slaves := range snapshot.MasterSlaves {
 		for _, slave := range slaves.Slaves {
 			fsm.SlaveMasters[slave] = master
@@ -243,3 +320,11 @@ func (fsm *FSM) Restore(src io.ReadCloser) error {
 	}
 	return nil
 }
+
+// 以 JSON 格式导出当前集群元数据，用于审计与排查
+func (fsm *FSM) Dump() ([]byte, error) {
+	fsm.mu.RLock()
+	snapshot := fsm.makeSnapshot()
+	fsm.mu.RUnlock()
+	return json.Marshal(snapshot)
+}
@@ -0,0 +1,133 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+// 内存中的快照写入端
+type memorySink struct {
+	bytes.Buffer
+	cancelled bool
+}
+
+func (sink *memorySink) ID() string    { return "test" }
+func (sink *memorySink) Close() error  { return nil }
+func (sink *memorySink) Cancel() error { sink.cancelled = true; return nil }
+
+func applyEntry(t *testing.T, fsm *FSM, entry *LogEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsm.Apply(&raft.Log{Data: data})
+}
+
+// 生成快照并恢复到一个新的 FSM 中
+func snapshotAndRestore(t *testing.T, fsm *FSM) *FSM {
+	snapshot, err := fsm.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &memorySink{}
+	if err := snapshot.Persist(sink); err != nil {
+		t.Fatal(err)
+	}
+	restored := newFSM()
+	if err := restored.Restore(io.NopCloser(&sink.Buffer)); err != nil {
+		t.Fatal(err)
+	}
+	return restored
+}
+
+func makeTestFSM(t *testing.T) *FSM {
+	fsm := newFSM()
+	applyEntry(t, fsm, &LogEntry{Event: EventSeedStart, InitTask: &InitTask{Leader: "a", SlotCount: 8}})
+	applyEntry(t, fsm, &LogEntry{Event: EventJoin, JoinTask: &JoinTask{NodeID: "b"}})
+	applyEntry(t, fsm, &LogEntry{Event: EventJoin, JoinTask: &JoinTask{NodeID: "a1", Master: "a"}})
+	return fsm
+}
+
+func TestSnapshotDuringMigration(t *testing.T) {
+	fsm := makeTestFSM(t)
+	task := &MigratingTask{ID: "m1", SrcNode: "a", TargetNode: "b", Slots: []uint32{6, 7}}
+	applyEntry(t, fsm, &LogEntry{Event: EventStartMigrate, MigratingTask: task})
+
+	restored := snapshotAndRestore(t, fsm)
+	if restored.Migratings["m1"] == nil || len(restored.Migratings["m1"].Slots) != 2 {
+		t.Fatalf("migrating task lost: %v", restored.Migratings)
+	}
+	if restored.SlaveMasters["a1"] != "a" {
+		t.Errorf("slave-master relation lost: %v", restored.SlaveMasters)
+	}
+	if len(restored.Node2Slot["a"]) != 8 {
+		t.Errorf("expect 8 slots on a, actually %d", len(restored.Node2Slot["a"]))
+	}
+
+	// 恢复后的状态机可以继续完成迁移
+	applyEntry(t, restored, &LogEntry{Event: EventFinishMigrate, MigratingTask: task})
+	if restored.Slot2Node[7] != "b" || len(restored.Node2Slot["b"]) != 2 || len(restored.Migratings) != 0 {
+		t.Errorf("finish migration failed: %v", restored.Slot2Node)
+	}
+}
+
+func TestSnapshotDuringFailover(t *testing.T) {
+	fsm := makeTestFSM(t)
+	task := &FailoverTask{ID: "f1", OldMasterID: "a", NewMasterID: "a1"}
+	applyEntry(t, fsm, &LogEntry{Event: EventStartFailover, FailoverTask: task})
+
+	restored := snapshotAndRestore(t, fsm)
+	if restored.Failovers["f1"] == nil || restored.Failovers["f1"].NewMasterID != "a1" {
+		t.Fatalf("failover task lost: %v", restored.Failovers)
+	}
+
+	applyEntry(t, restored, &LogEntry{Event: EventFinishFailover, FailoverTask: task})
+	if restored.Slot2Node[0] != "a1" || len(restored.Failovers) != 0 {
+		t.Errorf("finish failover failed: %v", restored.Slot2Node)
+	}
+	if restored.SlaveMasters["a"] != "a1" {
+		t.Errorf("old master should become slave of a1: %v", restored.SlaveMasters)
+	}
+}
+
+func TestSnapshotIsolation(t *testing.T) {
+	fsm := makeTestFSM(t)
+	task := &MigratingTask{ID: "m1", SrcNode: "a", TargetNode: "b", Slots: []uint32{1}}
+	applyEntry(t, fsm, &LogEntry{Event: EventStartMigrate, MigratingTask: task})
+	snapshot, _ := fsm.Snapshot()
+
+	// 快照生成后的修改不影响快照内容
+	applyEntry(t, fsm, &LogEntry{Event: EventFinishMigrate, MigratingTask: task})
+	applyEntry(t, fsm, &LogEntry{Event: EventJoin, JoinTask: &JoinTask{NodeID: "a2", Master: "a"}})
+	s := snapshot.(*FSMSnapshot)
+	if s.Slot2Node[1] != "a" || s.Migratings["m1"] == nil || len(s.MasterSlaves["a"].Slaves) != 1 {
+		t.Errorf("snapshot changed after apply: %+v", s)
+	}
+}
+
+func TestRestoreLegacySnapshot(t *testing.T) {
+	// 版本 0 的快照没有 Version 与 Failovers 字段
+	legacy := `{"Slot2Node":{"0":"a","1":"b"},"Migratings":{},"MasterSlaves":{"a":{"MasterID":"","Slaves":["a1"]},"b":{"MasterID":"","Slaves":null}}}`
+	fsm := newFSM()
+	if err := fsm.Restore(io.NopCloser(bytes.NewBufferString(legacy))); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Failovers == nil || fsm.SlaveMasters["a1"] != "a" || fsm.Slot2Node[1] != "b" {
+		t.Errorf("restore legacy snapshot failed: %+v", fsm)
+	}
+	applyEntry(t, fsm, &LogEntry{Event: EventStartFailover, FailoverTask: &FailoverTask{ID: "f1"}})
+
+	// 更高版本的快照中的未知字段被忽略
+	future := `{"Version":99,"Slot2Node":{"0":"a"},"Unknown":[1,2,3]}`
+	fsm = newFSM()
+	if err := fsm.Restore(io.NopCloser(bytes.NewBufferString(future))); err != nil {
+		t.Fatal(err)
+	}
+	if fsm.Slot2Node[0] != "a" || fsm.Migratings == nil || fsm.MasterSlaves == nil {
+		t.Errorf("restore future snapshot failed: %+v", fsm)
+	}
+}
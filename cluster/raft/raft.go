@@ -85,14 +85,7 @@ func StartNode(cfg *RaftConfig) (*Node, error) {
 	}
 
 	// 初始化状态机
-	fsm := &FSM{
-		Node2Slot:    make(map[string][]uint32),
-		Slot2Node:    make(map[uint32]string),
-		Migratings:   make(map[string]*MigratingTask),
-		MasterSlaves: make(map[string]*MasterSlave),
-		SlaveMasters: make(map[string]string),
-		Failovers:    make(map[string]*FailoverTask),
-	}
+	fsm := newFSM()
 
 	// 创建两个存储
 	logStore := boltDB
@@ -244,21 +237,132 @@ func (node *Node) AddToRaft(redisAddr, raftAddr string) error {
 //
 // 参数 redisAddr: 要移除的节点的 Redis 地址（即 Raft ID）
 func (node *Node) HandleEvict(redisAddr string) error {
-	// 获取集群配置
+	server, err := node.findServer(redisAddr)
+	if err != nil {
+		return err
+	}
+	if server == nil {
+		return errors.New("node not in cluster")
+	}
+	// 移除对应 id
+	return node.inner.RemoveServer(server.ID, 0, 0).Error()
+}
+
+// Raft 集群成员信息
+type Member struct {
+	ID       string // 节点 ID（即 Redis 地址）
+	Address  string // Raft 通信地址
+	Suffrage string // Voter / Nonvoter / Staging
+	Leader   bool   // 是否为当前 leader
+}
+
+// 获取当前集群配置，并找到指定节点
+func (node *Node) findServer(redisAddr string) (*raft.Server, error) {
 	configFuture := node.inner.GetConfiguration()
 	if err := configFuture.Error(); err != nil {
-		return fmt.Errorf("failed to get raft configuration: %v", err)
+		return nil, fmt.Errorf("failed to get raft configuration: %v", err)
 	}
-
 	id := raft.ServerID(redisAddr)
-	// 移除对应 id
 	for _, server := range configFuture.Configuration().Servers {
 		if server.ID == id {
-			err := node.inner.RemoveServer(id, 0, 0).Error()
-			if err != nil {
-				return err
-			}
+			return &server, nil
 		}
 	}
-	return nil
+	return nil, nil
+}
+
+// 列出 Raft 集群中的所有成员（包括投票者与非投票者）
+func (node *Node) GetMembers() ([]*Member, error) {
+	configFuture := node.inner.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return nil, fmt.Errorf("failed to get raft configuration: %v", err)
+	}
+	_, leaderID := node.inner.LeaderWithID()
+	servers := configFuture.Configuration().Servers
+	members := make([]*Member, 0, len(servers))
+	for _, server := range servers {
+		members = append(members, &Member{
+			ID:       string(server.ID),
+			Address:  string(server.Address),
+			Suffrage: server.Suffrage.String(),
+			Leader:   server.ID == leaderID,
+		})
+	}
+	return members, nil
+}
+
+// 以非投票者身份将节点加入 Raft 集群，必须由当前 leader 调用
+//
+// 非投票者只复制日志而不参与选举，待其追上日志后再通过 PromoteToVoter 提升，
+// 避免新节点拖慢多数派的提交
+func (node *Node) AddNonVoter(redisAddr, raftAddr string) error {
+	server, err := node.findServer(redisAddr)
+	if err != nil {
+		return err
+	}
+	if server != nil {
+		return errors.New("already in cluster")
+	}
+	future := node.inner.AddNonvoter(raft.ServerID(redisAddr), raft.ServerAddress(raftAddr), 0, 0)
+	return future.Error()
+}
+
+// 非投票者的日志落后于 leader 的提交位置时拒绝提升
+var ErrNotCaughtUp = errors.New("node has not caught up with the leader's log, retry later")
+
+// 将非投票者提升为投票者，必须由当前 leader 调用
+//
+// lastIndex 为该节点上报的最后一条日志的索引，小于 leader 的提交索引时返回 ErrNotCaughtUp，
+// 避免提升后多数派需要等待它追赶日志
+func (node *Node) PromoteToVoter(redisAddr string, lastIndex uint64) error {
+	server, err := node.findServer(redisAddr)
+	if err != nil {
+		return err
+	}
+	if server == nil {
+		return errors.New("node not in cluster")
+	}
+	if server.Suffrage == raft.Voter {
+		return errors.New("node is already a voter")
+	}
+	if lastIndex < node.inner.CommitIndex() {
+		return ErrNotCaughtUp
+	}
+	future := node.inner.AddVoter(server.ID, server.Address, 0, 0)
+	return future.Error()
+}
+
+// 将投票者降级为非投票者，必须由当前 leader 调用
+func (node *Node) DemoteVoter(redisAddr string) error {
+	server, err := node.findServer(redisAddr)
+	if err != nil {
+		return err
+	}
+	if server == nil {
+		return errors.New("node not in cluster")
+	}
+	if server.Suffrage != raft.Voter {
+		return errors.New("node is not a voter")
+	}
+	future := node.inner.DemoteVoter(server.ID, 0, 0)
+	return future.Error()
+}
+
+// 将 leader 身份转移给指定节点，redisAddr 为空时由 raft 自行选择目标
+func (node *Node) TransferLeadership(redisAddr string) error {
+	if redisAddr == "" {
+		return node.inner.LeadershipTransfer().Error()
+	}
+	server, err := node.findServer(redisAddr)
+	if err != nil {
+		return err
+	}
+	if server == nil {
+		return errors.New("node not in cluster")
+	}
+	if server.Suffrage != raft.Voter {
+		return errors.New("target node is not a voter")
+	}
+	future := node.inner.LeadershipTransferToServer(server.ID, server.Address)
+	return future.Error()
 }
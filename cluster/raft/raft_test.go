@@ -0,0 +1,84 @@
+package raft
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// 获取一个本地空闲端口
+func freeAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func TestMembership(t *testing.T) {
+	node, err := StartNode(&RaftConfig{
+		RedisAdvertiseAddr: "127.0.0.1:6399",
+		RaftListenAddr:     freeAddr(t),
+		Dir:                t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.inner.Shutdown()
+	if err := node.BootstrapCluster(16); err != nil {
+		t.Fatal(err)
+	}
+
+	// 非投票者不计入多数派，目标节点不可达时依然可以加入
+	if err := node.AddNonVoter("127.0.0.1:6400", freeAddr(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.AddNonVoter("127.0.0.1:6400", freeAddr(t)); err == nil {
+		t.Error("expect error when adding existing node")
+	}
+	members, err := node.GetMembers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("expect 2 members, actually %d", len(members))
+	}
+	for _, member := range members {
+		switch member.ID {
+		case "127.0.0.1:6399":
+			if member.Suffrage != "Voter" || !member.Leader {
+				t.Errorf("wrong leader state: %+v", member)
+			}
+		case "127.0.0.1:6400":
+			if member.Suffrage != "Nonvoter" || member.Leader {
+				t.Errorf("wrong non-voter state: %+v", member)
+			}
+		}
+	}
+
+	// 日志落后于 leader 的提交位置时不能提升
+	if err := node.PromoteToVoter("127.0.0.1:6400", 0); !errors.Is(err, ErrNotCaughtUp) {
+		t.Errorf("expect ErrNotCaughtUp, actually %v", err)
+	}
+	if err := node.PromoteToVoter("127.0.0.1:6399", node.LastIndex()); err == nil {
+		t.Error("expect error when promoting voter")
+	}
+
+	if err := node.DemoteVoter("127.0.0.1:6400"); err == nil {
+		t.Error("expect error when demoting non-voter")
+	}
+	if err := node.TransferLeadership("127.0.0.1:6400"); err == nil {
+		t.Error("expect error when transferring to non-voter")
+	}
+	if err := node.HandleEvict("127.0.0.1:6400"); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.HandleEvict("127.0.0.1:6400"); err == nil {
+		t.Error("expect error when removing unknown node")
+	}
+	members, _ = node.GetMembers()
+	if len(members) != 1 {
+		t.Errorf("expect 1 member, actually %d", len(members))
+	}
+}
@@ -1,6 +1,10 @@
 package raft
 
-import "github.com/hashicorp/raft"
+import (
+	"encoding/json"
+
+	"github.com/hashicorp/raft"
+)
 
 // 返回 raft 节点自身 ID
 func (node *Node) Self() string {
@@ -17,7 +21,41 @@ func (node *Node) GetSlaves(id string) *MasterSlave {
 	return node.FSM.MasterSlaves[id]
 }
 
+// 返回本节点最后一条日志的索引，leader 据此判断非投票者是否已经追上日志
+func (node *Node) LastIndex() uint64 {
+	return node.inner.LastIndex()
+}
+
 func (node *Node) GetLeaderRedisAddress() string {
 	_, id := node.inner.LeaderWithID()
 	return string(id)
 }
+
+// 返回负责指定槽位的节点 ID，槽位未分配时返回 ""
+func (node *Node) PickNode(slot uint32) string {
+	nodeID := ""
+	node.FSM.WithReadLock(func(fsm *FSM) {
+		nodeID = fsm.Slot2Node[slot]
+	})
+	return nodeID
+}
+
+// 返回 raft 运行状态与 FSM 元数据的 JSON 表示，用于审计集群拓扑
+func (node *Node) DumpState() ([]byte, error) {
+	fsmData, err := node.FSM.Dump()
+	if err != nil {
+		return nil, err
+	}
+	state := struct {
+		ID    string            `json:"id"`
+		State string            `json:"state"`
+		Raft  map[string]string `json:"raft"`
+		FSM   json.RawMessage   `json:"fsm"`
+	}{
+		ID:    node.Self(),
+		State: node.inner.State().String(),
+		Raft:  node.inner.Stats(),
+		FSM:   fsmData,
+	}
+	return json.Marshal(state)
+}
@@ -0,0 +1,89 @@
+// myredis-check-aof 校验 AOF 文件，并可以修复末尾被截断的文件，或者截断到指定时间点
+//
+// 用法: myredis-check-aof [--fix | --truncate-to-timestamp <unix>] <file.aof | appendonlydir | file.manifest>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"myredis/aof"
+	"os"
+)
+
+func main() {
+	fix := flag.Bool("fix", false, "truncate the incomplete command at the end of the last file")
+	timestamp := flag.Int64("truncate-to-timestamp", 0, "truncate the aof to the state at the given unix time, requires aof-timestamp-enabled")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [--fix | --truncate-to-timestamp <unix>] <file.aof | appendonlydir | file.manifest>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || (*fix && *timestamp > 0) {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *timestamp > 0 {
+		truncateToTimestamp(flag.Arg(0), *timestamp)
+		return
+	}
+
+	results, err := aof.CheckAof(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot check aof: %v\n", err)
+		os.Exit(1)
+	}
+	ok := true
+	for i, result := range results {
+		last := i == len(results)-1
+		format := "AOF"
+		if result.RdbPreamble {
+			format = "RDB preamble + AOF"
+		}
+		fmt.Printf("Checking %s (%s, %d bytes)\n", result.Filename, format, result.Size)
+		switch {
+		case result.Err != nil:
+			ok = false
+			fmt.Printf("  Corrupted: %v\n", result.Err)
+			fmt.Printf("  %d commands are valid, the file cannot be repaired automatically\n", result.Commands)
+		case result.Truncated:
+			fmt.Printf("  Truncated: %d commands are valid, %d bytes after offset %d are incomplete\n",
+				result.Commands, result.Size-result.ValidSize, result.ValidSize)
+			if !last {
+				// 只有最后一个文件可能在写入时被截断
+				ok = false
+				fmt.Println("  Only the last file can be truncated, refuse to repair")
+			} else if *fix {
+				if err := aof.FixTruncatedAof(result); err != nil {
+					ok = false
+					fmt.Printf("  Failed to repair: %v\n", err)
+				} else {
+					fmt.Printf("  Successfully truncated to %d bytes\n", result.ValidSize)
+				}
+			} else {
+				ok = false
+				fmt.Println("  Run with --fix to truncate it")
+			}
+		default:
+			fmt.Printf("  OK: %d commands\n", result.Commands)
+		}
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+func truncateToTimestamp(path string, timestamp int64) {
+	result, err := aof.TruncateAofToTimestamp(path, timestamp)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot truncate aof: %v\n", err)
+		os.Exit(1)
+	}
+	if result == nil {
+		fmt.Printf("No annotation after %d found, nothing to truncate\n", timestamp)
+		return
+	}
+	fmt.Printf("Truncated %s to %d bytes\n", result.Filename, result.Offset)
+	for _, name := range result.Removed {
+		fmt.Printf("Removed %s\n", name)
+	}
+}
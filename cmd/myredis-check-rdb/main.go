@@ -0,0 +1,31 @@
+// myredis-check-rdb 校验 RDB 文件的内容与校验和
+//
+// 用法: myredis-check-rdb <dump.rdb>
+package main
+
+import (
+	"fmt"
+	"myredis/aof"
+	"os"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s <dump.rdb>\n", os.Args[0])
+		os.Exit(2)
+	}
+	result, err := aof.CheckRDBFile(os.Args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot check rdb: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Checking %s (%d bytes)\n", result.Filename, result.Size)
+	if result.Err != nil {
+		fmt.Printf("  Corrupted after %d keys: %v\n", result.Keys, result.Err)
+		os.Exit(1)
+	}
+	if !result.Checksum {
+		fmt.Println("  Checksum is disabled")
+	}
+	fmt.Printf("  OK: %d keys\n", result.Keys)
+}
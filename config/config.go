@@ -11,14 +11,36 @@ type ServerProperties struct {
 	RunID string `cfg:"runid"`
 	Port  int    `cfg:"port"`
 
-	Dir               string `cfg:"dir"`
-	Databases         int    `cfg:"databases"`
-	AppendOnly        bool   `cfg:"appendonly"`
-	AppendFilename    string `cfg:"appendfilename"`
-	AofUseRdbPreamble bool   `cfg:"aof-use-rdb-preamble"`
-	AppendFsync       string `cfg:"appendfsync"`
-	RequirePass       string `cfg:"requirepass"`
-	RDBFilename       string `cfg:"rdbfilename"`
+	Dir                      string `cfg:"dir"`
+	Databases                int    `cfg:"databases"`
+	AppendOnly               bool   `cfg:"appendonly"`
+	AppendFilename           string `cfg:"appendfilename"`
+	AppendDirname            string `cfg:"appenddirname"`
+	AofUseRdbPreamble        bool   `cfg:"aof-use-rdb-preamble"`
+	AppendFsync              string `cfg:"appendfsync"`
+	AofLoadTruncated         bool   `cfg:"aof-load-truncated"`          // 截去 AOF 末尾不完整的命令后继续加载
+	AofTimestampEnabled      bool   `cfg:"aof-timestamp-enabled"`       // 在 AOF 中写入 #TS:<unix> 时间戳注释
+	AutoAofRewritePercentage int    `cfg:"auto-aof-rewrite-percentage"` // 0 表示关闭自动重写
+	AutoAofRewriteMinSize    int64  `cfg:"auto-aof-rewrite-min-size"`   // 单位为字节
+	Save                     string `cfg:"save"`                        // "<seconds> <changes> ..."，为空表示关闭
+	RequirePass              string `cfg:"requirepass"`
+	RDBFilename              string `cfg:"rdbfilename"`
+
+	// 小集合使用紧凑编码的阈值，为 0 时使用默认值
+	HashMaxListpackEntries int `cfg:"hash-max-listpack-entries"`
+	HashMaxListpackValue   int `cfg:"hash-max-listpack-value"`
+	SetMaxIntsetEntries    int `cfg:"set-max-intset-entries"`
+	SetMaxListpackEntries  int `cfg:"set-max-listpack-entries"`
+	SetMaxListpackValue    int `cfg:"set-max-listpack-value"`
+	ZSetMaxListpackEntries int `cfg:"zset-max-listpack-entries"`
+	ZSetMaxListpackValue   int `cfg:"zset-max-listpack-value"`
+	ListMaxListpackSize    int `cfg:"list-max-listpack-size"` // 正数为元素个数，-1 到 -5 为 4KB 到 64KB
+
+	LazyfreeLazyExpire    bool `cfg:"lazyfree-lazy-expire"`     // 在后台释放过期 key 的值
+	LazyfreeLazyUserDel   bool `cfg:"lazyfree-lazy-user-del"`   // DEL 与 UNLINK 相同
+	LazyfreeLazyUserFlush bool `cfg:"lazyfree-lazy-user-flush"` // 未指定 SYNC/ASYNC 的 FLUSHDB/FLUSHALL 使用 ASYNC
+
+	MaxmemoryPolicy string `cfg:"maxmemory-policy"` // allkeys-lfu 或 volatile-lfu 时 OBJECT FREQ 可用
 
 	ClusterEnable bool `cfg:"cluster-enable"`
 
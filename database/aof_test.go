@@ -3,16 +3,22 @@ package database
 import (
 	//"myredis/aof"
 	//"myredis/config"
+	"myredis/aof"
+	"myredis/config"
 	"myredis/interface/database"
 	"myredis/interface/myredis"
 	"myredis/lib/utils"
 	"myredis/myredis/connection"
 	"myredis/protocol"
 	"myredis/protocol/assert"
+	"os"
+	"path/filepath"
 	//"os"
 	//"path"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func makeTestData(db database.DB, dbIndex int, prefix string, size int) {
@@ -126,3 +132,279 @@ func validateTestData(t *testing.T, db database.DB, dbIndex int, prefix string,
 // 	}
 // 	aofReadDB.Close()
 // }
+
+func TestMultiPartAof(t *testing.T) {
+	dir := t.TempDir()
+	aofFilename := filepath.Join(dir, "appendonly.aof")
+	config.Properties = &config.ServerProperties{
+		Dir:               dir,
+		Databases:         4,
+		AppendOnly:        true,
+		AppendFilename:    aofFilename,
+		AofUseRdbPreamble: true,
+		AppendFsync:       aof.FsyncAlways,
+	}
+	_ = os.MkdirAll(config.GetTmpDir(), os.ModePerm)
+	// 旧版本的单文件 AOF 会被移入目录作为 base 文件
+	legacy := protocol.MakeMultiBulkReply(utils.ToCmdLine("SET", "legacy", "1")).ToBytes()
+	if err := os.WriteFile(aofFilename, legacy, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := MakeAuxiliaryServer()
+	persister, err := NewPersister(server, aofFilename, true, aof.FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.bindPersister(persister)
+	conn := connection.NewSimpleConn()
+	assert.AssertBulkReply(t, server.Exec(conn, utils.ToCmdLine("GET", "legacy")), "1")
+	server.Exec(conn, utils.ToCmdLine("SELECT", "2"))
+	server.Exec(conn, utils.ToCmdLine("RPUSH", "list", "a", "b"))
+	if err := persister.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	server.Exec(conn, utils.ToCmdLine("RPUSH", "list", "c"))
+	server.Exec(conn, utils.ToCmdLine("SET", "after", "1"))
+	persister.Close()
+
+	aofDir := filepath.Join(dir, "appendonlydir")
+	entries, _ := os.ReadDir(aofDir)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	expected := []string{"appendonly.aof.2.base.rdb", "appendonly.aof.2.incr.aof", "appendonly.aof.manifest"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected files in aof dir: %v", names)
+	}
+	if _, err := os.Stat(aofFilename); !os.IsNotExist(err) {
+		t.Error("legacy aof file should be moved")
+	}
+
+	loaded := MakeAuxiliaryServer()
+	persister2, err := NewPersister(loaded, aofFilename, true, aof.FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer persister2.Close()
+	conn2 := connection.NewSimpleConn()
+	assert.AssertBulkReply(t, loaded.Exec(conn2, utils.ToCmdLine("GET", "legacy")), "1")
+	loaded.Exec(conn2, utils.ToCmdLine("SELECT", "2"))
+	assert.AssertMultiBulkReply(t, loaded.Exec(conn2, utils.ToCmdLine("LRANGE", "list", "0", "-1")), []string{"a", "b", "c"})
+	assert.AssertBulkReply(t, loaded.Exec(conn2, utils.ToCmdLine("GET", "after")), "1")
+}
+
+func TestLoadDamagedAof(t *testing.T) {
+	dir := t.TempDir()
+	aofFilename := filepath.Join(dir, "appendonly.aof")
+	config.Properties = &config.ServerProperties{
+		Dir:            dir,
+		Databases:      4,
+		AppendOnly:     true,
+		AppendFilename: aofFilename,
+		AppendFsync:    aof.FsyncAlways,
+	}
+	server := MakeAuxiliaryServer()
+	persister, err := NewPersister(server, aofFilename, true, aof.FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.bindPersister(persister)
+	conn := connection.NewSimpleConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "1"))
+	server.Exec(conn, utils.ToCmdLine("SET", "b", "2"))
+	persister.Close()
+
+	incrFile := filepath.Join(dir, "appendonlydir", "appendonly.aof.1.incr.aof")
+	valid, err := os.ReadFile(incrFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 模拟写入最后一条命令时宕机
+	if err := os.WriteFile(incrFile, append(append([]byte{}, valid...), "*3\r\n$3\r\nSET\r\n$1\r"...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPersister(MakeAuxiliaryServer(), aofFilename, true, aof.FsyncAlways); err == nil {
+		t.Fatal("truncated aof should not be loaded without aof-load-truncated")
+	}
+	config.Properties.AofLoadTruncated = true
+	loaded := MakeAuxiliaryServer()
+	persister2, err := NewPersister(loaded, aofFilename, true, aof.FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	persister2.Close()
+	assert.AssertBulkReply(t, loaded.Exec(conn, utils.ToCmdLine("GET", "b")), "2")
+	if data, _ := os.ReadFile(incrFile); string(data) != string(valid) {
+		t.Error("incomplete command should be truncated")
+	}
+
+	// 文件中间损坏时即使开启 aof-load-truncated 也拒绝加载
+	corrupted := strings.Replace(string(valid), "$1\r\n1\r\n", "$1\r\n1x\r\n", 1)
+	if err := os.WriteFile(incrFile, []byte(corrupted), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewPersister(MakeAuxiliaryServer(), aofFilename, true, aof.FsyncAlways)
+	if err == nil || !strings.Contains(err.Error(), "offset") {
+		t.Errorf("expect corruption error with offset, actually %v", err)
+	}
+}
+
+func TestCheckRDB(t *testing.T) {
+	dir := t.TempDir()
+	config.Properties = &config.ServerProperties{
+		Dir:         dir,
+		Databases:   4,
+		RDBFilename: filepath.Join(dir, "dump.rdb"),
+	}
+	server := MakeAuxiliaryServer()
+	conn := connection.NewSimpleConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "1"))
+	server.Exec(conn, utils.ToCmdLine("RPUSH", "list", "x"))
+	assert.AssertStatusReply(t, server.Exec(conn, utils.ToCmdLine("SAVE")), "OK")
+
+	result, err := aof.CheckRDBFile(config.Properties.RDBFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Err != nil || result.Keys != 2 || !result.Checksum {
+		t.Errorf("wrong result %+v", result)
+	}
+	data, _ := os.ReadFile(config.Properties.RDBFilename)
+	idx := strings.Index(string(data), "list")
+	data[idx] = 'L'
+	_ = os.WriteFile(config.Properties.RDBFilename, data, 0600)
+	result, err = aof.CheckRDBFile(config.Properties.RDBFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Err == nil {
+		t.Error("checksum mismatch should be detected")
+	}
+}
+
+func TestAofTimestamp(t *testing.T) {
+	dir := t.TempDir()
+	aofFilename := filepath.Join(dir, "appendonly.aof")
+	config.Properties = &config.ServerProperties{
+		Dir:                 dir,
+		Databases:           4,
+		AppendOnly:          true,
+		AppendFilename:      aofFilename,
+		AppendFsync:         aof.FsyncAlways,
+		AofTimestampEnabled: true,
+	}
+	_ = os.MkdirAll(config.GetTmpDir(), os.ModePerm)
+	server := MakeAuxiliaryServer()
+	persister, err := NewPersister(server, aofFilename, true, aof.FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.bindPersister(persister)
+	conn := connection.NewSimpleConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "1"))
+	if err := persister.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	server.Exec(conn, utils.ToCmdLine("SET", "b", "1"))
+	persister.Close()
+
+	// base 文件与新的 incr 文件都带有时间戳
+	aofDir := filepath.Join(dir, "appendonlydir")
+	for _, name := range []string{"appendonly.aof.1.base.aof", "appendonly.aof.2.incr.aof"} {
+		data, err := os.ReadFile(filepath.Join(aofDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "#TS:") {
+			t.Errorf("expect timestamp annotation in %s: %q", name, data)
+		}
+	}
+
+	loaded := MakeAuxiliaryServer()
+	persister2, err := NewPersister(loaded, aofFilename, true, aof.FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer persister2.Close()
+	assert.AssertBulkReply(t, loaded.Exec(conn, utils.ToCmdLine("GET", "a")), "1")
+	assert.AssertBulkReply(t, loaded.Exec(conn, utils.ToCmdLine("GET", "b")), "1")
+}
+
+// RDB 格式的 base 文件记录切点时刻，早于它的时间点不能截断
+func TestAofTimestampRdbPreamble(t *testing.T) {
+	dir := t.TempDir()
+	aofFilename := filepath.Join(dir, "appendonly.aof")
+	config.Properties = &config.ServerProperties{
+		Dir:                 dir,
+		Databases:           4,
+		AppendOnly:          true,
+		AppendFilename:      aofFilename,
+		AppendFsync:         aof.FsyncAlways,
+		AofUseRdbPreamble:   true,
+		AofTimestampEnabled: true,
+	}
+	_ = os.MkdirAll(config.GetTmpDir(), os.ModePerm)
+	server := MakeAuxiliaryServer()
+	persister, err := NewPersister(server, aofFilename, true, aof.FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.bindPersister(persister)
+	conn := connection.NewSimpleConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "1"))
+	if err := persister.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	server.Exec(conn, utils.ToCmdLine("SET", "b", "1"))
+	persister.Close()
+
+	aofDir := filepath.Join(dir, "appendonlydir")
+	if _, err := aof.TruncateAofToTimestamp(aofDir, time.Now().Unix()-100); err == nil {
+		t.Error("expect error when truncating before the rdb base")
+	}
+	if result, err := aof.TruncateAofToTimestamp(aofDir, time.Now().Unix()+100); err != nil || result != nil {
+		t.Errorf("nothing should be truncated, %+v %v", result, err)
+	}
+}
+
+func TestAofMoveAndCopy(t *testing.T) {
+	dir := t.TempDir()
+	aofFilename := filepath.Join(dir, "appendonly.aof")
+	config.Properties = &config.ServerProperties{
+		Dir:            dir,
+		Databases:      4,
+		AppendOnly:     true,
+		AppendFilename: aofFilename,
+		AppendFsync:    aof.FsyncAlways,
+	}
+	_ = os.MkdirAll(config.GetTmpDir(), os.ModePerm)
+	server := MakeAuxiliaryServer()
+	persister, err := NewPersister(server, aofFilename, true, aof.FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.bindPersister(persister)
+	conn := connection.NewSimpleConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "1"))
+	server.Exec(conn, utils.ToCmdLine("SET", "b", "2"))
+	server.Exec(conn, utils.ToCmdLine("MOVE", "a", "1"))
+	server.Exec(conn, utils.ToCmdLine("COPY", "b", "c", "DB", "2"))
+	persister.Close()
+
+	// 跨库命令在重放时同样由 Server 分发
+	loaded := MakeAuxiliaryServer()
+	persister2, err := NewPersister(loaded, aofFilename, true, aof.FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer persister2.Close()
+	conn2 := connection.NewSimpleConn()
+	assert.AssertIntReply(t, loaded.Exec(conn2, utils.ToCmdLine("EXISTS", "a")), 0)
+	assert.AssertBulkReply(t, loaded.Exec(conn2, utils.ToCmdLine("GET", "b")), "2")
+	loaded.Exec(conn2, utils.ToCmdLine("SELECT", "1"))
+	assert.AssertBulkReply(t, loaded.Exec(conn2, utils.ToCmdLine("GET", "a")), "1")
+	loaded.Exec(conn2, utils.ToCmdLine("SELECT", "2"))
+	assert.AssertBulkReply(t, loaded.Exec(conn2, utils.ToCmdLine("GET", "c")), "2")
+}
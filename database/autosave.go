@@ -0,0 +1,240 @@
+// autosave.go 实现了自动持久化：
+//
+//   - 按照 save <seconds> <changes> 规则在后台生成 RDB 文件
+//   - AOF 大小相比上次重写增长超过 auto-aof-rewrite-percentage 时自动重写
+//   - SAVE、BGSAVE、BGREWRITEAOF、LASTSAVE 命令
+package database
+
+import (
+	"errors"
+	"fmt"
+	"myredis/aof"
+	"myredis/config"
+	"myredis/interface/myredis"
+	"myredis/lib/logger"
+	"myredis/protocol"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 定时检查持久化条件的间隔
+const persistenceCronInterval = 100 * time.Millisecond
+
+// BGSAVE 失败后，至少间隔该时间才会再次自动触发
+const bgsaveRetryDelay = 5 * time.Second
+
+var errBgsaveInProgress = errors.New("ERR Background save already in progress")
+
+// 距离上次保存超过 seconds 秒且至少有 changes 次修改时触发保存
+type saveRule struct {
+	seconds int64
+	changes int64
+}
+
+// 解析保存规则，格式为 "<seconds> <changes> [<seconds> <changes> ...]"
+func parseSaveRules(value string) ([]saveRule, error) {
+	fields := strings.Fields(value)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("invalid save rules: %s", value)
+	}
+	rules := make([]saveRule, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err1 := strconv.ParseInt(fields[i], 10, 64)
+		changes, err2 := strconv.ParseInt(fields[i+1], 10, 64)
+		if err1 != nil || err2 != nil || seconds <= 0 || changes <= 0 {
+			return nil, fmt.Errorf("invalid save rules: %s", value)
+		}
+		rules = append(rules, saveRule{seconds: seconds, changes: changes})
+	}
+	return rules, nil
+}
+
+// 返回 RDB 文件路径
+func rdbFilename() string {
+	if config.Properties.RDBFilename != "" {
+		return config.Properties.RDBFilename
+	}
+	return "dump.rdb"
+}
+
+// 启动定时任务，检查保存规则与 AOF 自动重写条件
+//
+// 配置在启动时读取，未配置任何自动持久化规则时不启动
+func (server *Server) startPersistenceCron() {
+	rules, err := parseSaveRules(config.Properties.Save)
+	if err != nil {
+		logger.Error(err.Error())
+	}
+	percentage := int64(config.Properties.AutoAofRewritePercentage)
+	if !config.Properties.AppendOnly {
+		percentage = 0
+	}
+	minSize := config.Properties.AutoAofRewriteMinSize
+	if len(rules) == 0 && percentage <= 0 {
+		return
+	}
+	server.cronStop = make(chan struct{})
+	stop := server.cronStop
+	ticker := time.NewTicker(persistenceCronInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				server.checkSaveRules(rules, time.Now())
+				server.checkAofRewrite(percentage, minSize)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// 满足任意一条保存规则时在后台生成 RDB
+func (server *Server) checkSaveRules(rules []saveRule, now time.Time) {
+	if len(rules) == 0 || server.bgsaveInProgress.Load() {
+		return
+	}
+	// 上次保存失败时，延迟一段时间再重试
+	if server.lastBgsaveErr.Load() && now.Sub(time.Unix(server.lastBgsaveTry.Load(), 0)) < bgsaveRetryDelay {
+		return
+	}
+	dirty := server.dirty.Load()
+	elapsed := now.Unix() - server.lastSave.Load()
+	for _, rule := range rules {
+		if dirty >= rule.changes && elapsed >= rule.seconds {
+			logger.Info(fmt.Sprintf("%d changes in %d seconds. Saving...", rule.changes, rule.seconds))
+			_ = server.bgSave()
+			return
+		}
+	}
+}
+
+// AOF 大小超过 minSize 且相比上次重写增长超过 percentage% 时在后台重写
+func (server *Server) checkAofRewrite(percentage int64, minSize int64) {
+	if server.persister == nil || percentage <= 0 {
+		return
+	}
+	status := server.persister.GetRewriteStatus()
+	if status.InProgress || status.CurrentSize < minSize {
+		return
+	}
+	base := status.BaseSize
+	if base <= 0 {
+		base = 1
+	}
+	growth := (status.CurrentSize - base) * 100 / base
+	if growth >= percentage {
+		logger.Info(fmt.Sprintf("starting automatic rewriting of AOF on %d%% growth", growth))
+		_ = server.persister.BackgroundRewrite()
+	}
+}
+
+// 生成 RDB 文件，成功后重置修改计数
+func (server *Server) saveRDB() error {
+	var dirty int64
+	server.lastBgsaveTry.Store(time.Now().Unix())
+	err := aof.SaveRDB(server, rdbFilename(), func() {
+		// 切点时刻的修改均包含在快照中
+		dirty = server.dirty.Load()
+	})
+	server.lastBgsaveErr.Store(err != nil)
+	if err != nil {
+		logger.Error("rdb save failed: " + err.Error())
+		return err
+	}
+	server.dirty.Add(-dirty)
+	server.lastSave.Store(time.Now().Unix())
+	return nil
+}
+
+// 在后台生成 RDB 文件
+func (server *Server) bgSave() error {
+	if !server.bgsaveInProgress.CompareAndSwap(false, true) {
+		return errBgsaveInProgress
+	}
+	go func() {
+		defer server.bgsaveInProgress.Store(false)
+		_ = server.saveRDB()
+	}()
+	return nil
+}
+
+// 格式: SAVE
+func execSave(server *Server, args [][]byte) myredis.Reply {
+	if !server.bgsaveInProgress.CompareAndSwap(false, true) {
+		return protocol.MakeErrReply(errBgsaveInProgress.Error())
+	}
+	defer server.bgsaveInProgress.Store(false)
+	if err := server.saveRDB(); err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
+	return protocol.MakeOkReply()
+}
+
+// 格式: BGSAVE
+func execBgSave(server *Server, args [][]byte) myredis.Reply {
+	if err := server.bgSave(); err != nil {
+		return protocol.MakeErrReply(err.Error())
+	}
+	return protocol.MakeStatusReply("Background saving started")
+}
+
+// 格式: BGREWRITEAOF
+func execBgRewriteAof(server *Server, args [][]byte) myredis.Reply {
+	if server.persister == nil {
+		return protocol.MakeErrReply("ERR Append only file is not enabled")
+	}
+	if err := server.persister.BackgroundRewrite(); err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
+	return protocol.MakeStatusReply("Background append only file rewriting started")
+}
+
+// 返回上次成功保存 RDB 的时间戳
+// 格式: LASTSAVE
+func execLastSave(server *Server, args [][]byte) myredis.Reply {
+	return protocol.MakeIntReply(server.lastSave.Load())
+}
+
+// 生成 INFO persistence 的内容
+func genPersistenceInfo(server *Server) []byte {
+	var sb strings.Builder
+	sb.WriteString("# Persistence\r\n")
+	status := func(failed bool) string {
+		if failed {
+			return "err"
+		}
+		return "ok"
+	}
+	genLoadingInfo(server, &sb)
+	fmt.Fprintf(&sb, "rdb_changes_since_last_save:%d\r\n", server.dirty.Load())
+	fmt.Fprintf(&sb, "rdb_bgsave_in_progress:%d\r\n", boolToInt(server.bgsaveInProgress.Load()))
+	fmt.Fprintf(&sb, "rdb_last_save_time:%d\r\n", server.lastSave.Load())
+	fmt.Fprintf(&sb, "rdb_last_bgsave_status:%s\r\n", status(server.lastBgsaveErr.Load()))
+	aofEnabled := server.persister != nil && config.Properties.AppendOnly
+	fmt.Fprintf(&sb, "aof_enabled:%d\r\n", boolToInt(aofEnabled))
+	if server.persister == nil {
+		sb.WriteString("aof_rewrite_in_progress:0\r\n")
+		sb.WriteString("aof_last_rewrite_time_sec:-1\r\n")
+		sb.WriteString("aof_last_bgrewrite_status:ok\r\n")
+		return []byte(sb.String())
+	}
+	rewrite := server.persister.GetRewriteStatus()
+	fmt.Fprintf(&sb, "aof_rewrite_in_progress:%d\r\n", boolToInt(rewrite.InProgress))
+	fmt.Fprintf(&sb, "aof_last_rewrite_time_sec:%d\r\n", rewrite.LastCostSec)
+	fmt.Fprintf(&sb, "aof_last_bgrewrite_status:%s\r\n", status(rewrite.LastFailed))
+	if aofEnabled {
+		fmt.Fprintf(&sb, "aof_current_size:%d\r\n", rewrite.CurrentSize)
+		fmt.Fprintf(&sb, "aof_base_size:%d\r\n", rewrite.BaseSize)
+	}
+	return []byte(sb.String())
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
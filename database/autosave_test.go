@@ -0,0 +1,161 @@
+package database
+
+import (
+	"myredis/aof"
+	"myredis/config"
+	"myredis/lib/utils"
+	"myredis/myredis/connection"
+	"myredis/protocol"
+	"myredis/protocol/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSaveRules(t *testing.T) {
+	rules, err := parseSaveRules("900 1 300 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[0] != (saveRule{900, 1}) || rules[1] != (saveRule{300, 10}) {
+		t.Errorf("wrong rules: %+v", rules)
+	}
+	if rules, err := parseSaveRules(""); err != nil || len(rules) != 0 {
+		t.Error("empty rules should disable saving")
+	}
+	for _, value := range []string{"900", "a 1", "900 0", "-1 1"} {
+		if _, err := parseSaveRules(value); err == nil {
+			t.Errorf("expect error for %q", value)
+		}
+	}
+}
+
+func TestSaveAndDirty(t *testing.T) {
+	dir := t.TempDir()
+	config.Properties = &config.ServerProperties{
+		Dir:         dir,
+		Databases:   4,
+		RDBFilename: filepath.Join(dir, "dump.rdb"),
+	}
+	server := MakeAuxiliaryServer()
+	conn := connection.NewSimpleConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "1"))
+	server.Exec(conn, utils.ToCmdLine("GET", "a"))
+	server.Exec(conn, utils.ToCmdLine("RPUSH", "list", "x", "y"))
+	if dirty := server.dirty.Load(); dirty != 2 {
+		t.Errorf("expect 2 changes, actually %d", dirty)
+	}
+
+	server.lastSave.Store(0)
+	assert.AssertStatusReply(t, server.Exec(conn, utils.ToCmdLine("SAVE")), "OK")
+	if dirty := server.dirty.Load(); dirty != 0 {
+		t.Errorf("expect dirty reset after save, actually %d", dirty)
+	}
+	reply, ok := server.Exec(conn, utils.ToCmdLine("LASTSAVE")).(*protocol.IntReply)
+	if !ok || reply.Code < time.Now().Unix()-1 {
+		t.Errorf("wrong lastsave %v", reply)
+	}
+	if _, err := os.Stat(config.Properties.RDBFilename); err != nil {
+		t.Fatal(err)
+	}
+
+	// 保存的 RDB 文件可以被重新加载
+	loaded := MakeAuxiliaryServer()
+	if err := loaded.loadRdbFile(); err != nil {
+		t.Fatal(err)
+	}
+	assert.AssertBulkReply(t, loaded.Exec(conn, utils.ToCmdLine("GET", "a")), "1")
+	assert.AssertMultiBulkReply(t, loaded.Exec(conn, utils.ToCmdLine("LRANGE", "list", "0", "-1")), []string{"x", "y"})
+}
+
+func TestSaveRulesTrigger(t *testing.T) {
+	dir := t.TempDir()
+	config.Properties = &config.ServerProperties{
+		Dir:         dir,
+		Databases:   4,
+		RDBFilename: filepath.Join(dir, "dump.rdb"),
+	}
+	server := MakeAuxiliaryServer()
+	conn := connection.NewSimpleConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "1"))
+	rules := []saveRule{{seconds: 60, changes: 1}}
+
+	// 距离上次保存的时间不足
+	server.checkSaveRules(rules, time.Now())
+	if _, err := os.Stat(config.Properties.RDBFilename); !os.IsNotExist(err) {
+		t.Fatal("save should not be triggered")
+	}
+	server.checkSaveRules(rules, time.Now().Add(time.Minute))
+	waitFor(t, func() bool { return !server.bgsaveInProgress.Load() && server.dirty.Load() == 0 })
+	if _, err := os.Stat(config.Properties.RDBFilename); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAutoAofRewrite(t *testing.T) {
+	dir := t.TempDir()
+	config.Properties = &config.ServerProperties{
+		Dir:            dir,
+		Databases:      4,
+		AppendOnly:     true,
+		AppendFilename: filepath.Join(dir, "appendonly.aof"),
+		AppendFsync:    aof.FsyncAlways,
+	}
+	server := MakeAuxiliaryServer()
+	persister, err := NewPersister(server, config.Properties.AppendFilename, false, config.Properties.AppendFsync)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.bindPersister(persister)
+	defer persister.Close()
+	conn := connection.NewSimpleConn()
+	for i := 0; i < 50; i++ {
+		server.Exec(conn, utils.ToCmdLine("SET", "key", "value"))
+	}
+	sizeBefore := persister.GetRewriteStatus().CurrentSize
+
+	// 未达到最小大小时不重写
+	server.checkAofRewrite(100, 1<<20)
+	if status := persister.GetRewriteStatus(); status.InProgress || status.LastCostSec != -1 {
+		t.Fatalf("rewrite should not be triggered, status %+v", status)
+	}
+	server.checkAofRewrite(100, 64)
+	waitFor(t, func() bool {
+		status := persister.GetRewriteStatus()
+		return !status.InProgress && status.LastCostSec >= 0
+	})
+	status := persister.GetRewriteStatus()
+	if status.LastFailed || status.CurrentSize >= sizeBefore || status.BaseSize != status.CurrentSize {
+		t.Errorf("expect aof rewritten, status %+v", status)
+	}
+	// 重写后增长不足，不会再次触发
+	server.checkAofRewrite(100, 0)
+	if persister.GetRewriteStatus().InProgress {
+		t.Error("rewrite should not be triggered again")
+	}
+
+	config.EachTimeServerInfo = &config.ServerInfo{StartUpTime: time.Now()}
+	info := string(server.Exec(conn, utils.ToCmdLine("INFO", "persistence")).(*protocol.BulkReply).Arg)
+	for _, field := range []string{
+		"rdb_changes_since_last_save:50",
+		"aof_enabled:1",
+		"aof_rewrite_in_progress:0",
+		"aof_last_bgrewrite_status:ok",
+	} {
+		if !strings.Contains(info, field) {
+			t.Errorf("expect %s in info: %s", field, info)
+		}
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,241 @@
+package database
+
+import (
+	"myredis/datastruct/bitmap"
+	"myredis/interface/database"
+	"myredis/interface/myredis"
+	"myredis/lib/utils"
+	"myredis/protocol"
+	"strconv"
+	"strings"
+)
+
+// 位图的最大长度为 512MB，与字符串的长度上限相同
+const maxBitOffset = 512 * 1024 * 1024 * 8
+
+var bitOperations = map[string]bitmap.Operation{
+	"and":   bitmap.OpAnd,
+	"or":    bitmap.OpOr,
+	"xor":   bitmap.OpXor,
+	"not":   bitmap.OpNot,
+	"diff":  bitmap.OpDiff,
+	"diff1": bitmap.OpDiff1,
+	"andor": bitmap.OpAndOr,
+	"one":   bitmap.OpOne,
+}
+
+func undoBitOp(db *DB, args [][]byte) []CmdLine {
+	return rollbackGivenKeys(db, string(args[1]))
+}
+
+// 对多个字符串做按位运算并把结果保存到 destkey，返回结果的字节长度
+func execBitOp(db *DB, args [][]byte) myredis.Reply {
+	opName := strings.ToLower(string(args[0]))
+	op, ok := bitOperations[opName]
+	if !ok {
+		return protocol.MakeSyntaxErrReply()
+	}
+	dest := string(args[1])
+	keys := args[2:]
+	switch op {
+	case bitmap.OpNot:
+		if len(keys) != 1 {
+			return protocol.MakeErrReply("ERR BITOP NOT must be called with a single source key.")
+		}
+	case bitmap.OpDiff, bitmap.OpDiff1, bitmap.OpAndOr:
+		if len(keys) < 2 {
+			return protocol.MakeErrReply("ERR BITOP " + strings.ToUpper(opName) +
+				" must be called with at least two source keys.")
+		}
+	}
+	srcs := make([][]byte, len(keys))
+	for i, key := range keys {
+		bytes, errReply := db.getAsString(string(key))
+		if errReply != nil {
+			return errReply
+		}
+		srcs[i] = bytes
+	}
+	result := bitmap.Compute(op, srcs...)
+	db.addAof(utils.ToCmdLine3("bitop", args...))
+	// 结果为空时删除目标键
+	if len(result) == 0 {
+		db.Remove(dest)
+		return protocol.MakeIntReply(0)
+	}
+	db.PutEntity(dest, &database.DataEntity{Data: result})
+	db.Persist(dest)
+	return protocol.MakeIntReply(int64(len(result)))
+}
+
+const (
+	bitFieldGet = iota
+	bitFieldSet
+	bitFieldIncrBy
+)
+
+// bitFieldOp BITFIELD 的一个子命令
+type bitFieldOp struct {
+	kind     int
+	typ      bitmap.FieldType
+	offset   int64
+	value    int64 // SET 的新值或 INCRBY 的增量
+	overflow bitmap.Overflow
+}
+
+// 解析字段偏移量，#N 表示第 N 个该类型的字段
+func parseBitFieldOffset(arg string, typ bitmap.FieldType) (int64, protocol.ErrorReply) {
+	errReply := protocol.MakeErrReply("ERR bit offset is not an integer or out of range")
+	multiply := false
+	if strings.HasPrefix(arg, "#") {
+		multiply = true
+		arg = arg[1:]
+	}
+	offset, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, errReply
+	}
+	if multiply {
+		if offset > maxBitOffset/int64(typ.Width) {
+			return 0, errReply
+		}
+		offset *= int64(typ.Width)
+	}
+	if offset+int64(typ.Width) > maxBitOffset {
+		return 0, errReply
+	}
+	return offset, nil
+}
+
+// 解析 BITFIELD 的全部子命令，readOnly 时只允许 GET
+func parseBitFieldOps(args [][]byte, readOnly bool) ([]*bitFieldOp, protocol.ErrorReply) {
+	var ops []*bitFieldOp
+	overflow := bitmap.OverflowWrap
+	for i := 0; i < len(args); {
+		sub := strings.ToLower(string(args[i]))
+		if sub == "overflow" && !readOnly {
+			if i+1 >= len(args) {
+				return nil, protocol.MakeSyntaxErrReply()
+			}
+			switch strings.ToLower(string(args[i+1])) {
+			case "wrap":
+				overflow = bitmap.OverflowWrap
+			case "sat":
+				overflow = bitmap.OverflowSat
+			case "fail":
+				overflow = bitmap.OverflowFail
+			default:
+				return nil, protocol.MakeErrReply("ERR Invalid OVERFLOW type specified")
+			}
+			i += 2
+			continue
+		}
+		op := &bitFieldOp{overflow: overflow}
+		argc := 0
+		switch sub {
+		case "get":
+			op.kind, argc = bitFieldGet, 3
+		case "set":
+			op.kind, argc = bitFieldSet, 4
+		case "incrby":
+			op.kind, argc = bitFieldIncrBy, 4
+		default:
+			if readOnly {
+				return nil, protocol.MakeErrReply("ERR BITFIELD_RO only supports the GET subcommand")
+			}
+			return nil, protocol.MakeSyntaxErrReply()
+		}
+		if readOnly && op.kind != bitFieldGet {
+			return nil, protocol.MakeErrReply("ERR BITFIELD_RO only supports the GET subcommand")
+		}
+		if i+argc > len(args) {
+			return nil, protocol.MakeSyntaxErrReply()
+		}
+		typ, ok := bitmap.ParseFieldType(string(args[i+1]))
+		if !ok {
+			return nil, protocol.MakeErrReply("ERR Invalid bitfield type. Use something like i16 u8. " +
+				"Note that u64 is not supported but i64 is.")
+		}
+		op.typ = typ
+		offset, errReply := parseBitFieldOffset(string(args[i+2]), typ)
+		if errReply != nil {
+			return nil, errReply
+		}
+		op.offset = offset
+		if op.kind != bitFieldGet {
+			value, err := strconv.ParseInt(string(args[i+3]), 10, 64)
+			if err != nil {
+				return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			op.value = value
+		}
+		ops = append(ops, op)
+		i += argc
+	}
+	return ops, nil
+}
+
+// 按顺序执行子命令，每个子命令对应结果中的一项，溢出失败时为 nil
+func (db *DB) bitField(args [][]byte, readOnly bool) myredis.Reply {
+	key := string(args[0])
+	ops, errReply := parseBitFieldOps(args[1:], readOnly)
+	if errReply != nil {
+		return errReply
+	}
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	bitmaps := bitmap.FromBytes(bytes)
+	results := make([]myredis.Reply, len(ops))
+	modified := false
+	for i, op := range ops {
+		old := op.typ.Get(bitmaps, op.offset)
+		switch op.kind {
+		case bitFieldGet:
+			results[i] = protocol.MakeIntReply(old)
+		case bitFieldSet:
+			value, ok := op.typ.Fit(op.value, op.overflow)
+			if !ok {
+				results[i] = protocol.MakeNullBulkReply()
+				continue
+			}
+			op.typ.Set(bitmaps, op.offset, value)
+			modified = true
+			results[i] = protocol.MakeIntReply(old)
+		case bitFieldIncrBy:
+			value, ok := op.typ.Incr(old, op.value, op.overflow)
+			if !ok {
+				results[i] = protocol.MakeNullBulkReply()
+				continue
+			}
+			op.typ.Set(bitmaps, op.offset, value)
+			modified = true
+			results[i] = protocol.MakeIntReply(value)
+		}
+	}
+	if modified {
+		db.PutEntity(key, &database.DataEntity{Data: bitmaps.ToBytes()})
+		db.addAof(utils.ToCmdLine3("bitfield", args...))
+	}
+	return protocol.MakeMultiRawReply(results)
+}
+
+// BITFIELD key [GET type offset] [SET type offset value] [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL]
+func execBitField(db *DB, args [][]byte) myredis.Reply {
+	return db.bitField(args, false)
+}
+
+// BITFIELD_RO key [GET type offset ...]
+func execBitFieldRO(db *DB, args [][]byte) myredis.Reply {
+	return db.bitField(args, true)
+}
+
+func init() {
+	registerCommand("BitOp", execBitOp, undoBitOp, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 2, -1, 1)
+	registerCommand("BitField", execBitField, rollbackFirstKey, -2, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	registerCommand("BitField_RO", execBitFieldRO, nil, -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"myredis/interface/myredis"
+	"myredis/lib/utils"
+	"myredis/protocol"
+	"myredis/protocol/assert"
+	"testing"
+)
+
+// 检查 BITFIELD 的返回值，nil 表示溢出失败
+func assertBitFieldReply(t *testing.T, actual myredis.Reply, expected ...interface{}) {
+	t.Helper()
+	replies := make([]myredis.Reply, len(expected))
+	for i, v := range expected {
+		if v == nil {
+			replies[i] = protocol.MakeNullBulkReply()
+		} else {
+			replies[i] = protocol.MakeIntReply(int64(v.(int)))
+		}
+	}
+	if string(actual.ToBytes()) != string(protocol.MakeMultiRawReply(replies).ToBytes()) {
+		t.Errorf("expected %v, actually %q", expected, actual.ToBytes())
+	}
+}
+
+func TestBitOp(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("set", "a", "\xff\x0f\x01"))
+	testDB.Exec(nil, utils.ToCmdLine("set", "b", "\x0f\xff"))
+	testDB.Exec(nil, utils.ToCmdLine("set", "c", "\x01"))
+
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("bitop", "and", "dest", "a", "b")), 3)
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("get", "dest")), "\x0f\x0f\x00")
+	testDB.Exec(nil, utils.ToCmdLine("bitop", "or", "dest", "a", "b", "missing"))
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("get", "dest")), "\xff\xff\x01")
+	testDB.Exec(nil, utils.ToCmdLine("bitop", "xor", "dest", "a", "b"))
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("get", "dest")), "\xf0\xf0\x01")
+	testDB.Exec(nil, utils.ToCmdLine("bitop", "not", "dest", "b"))
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("get", "dest")), "\xf0\x00")
+	testDB.Exec(nil, utils.ToCmdLine("bitop", "diff", "dest", "a", "b", "c"))
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("get", "dest")), "\xf0\x00\x01")
+	testDB.Exec(nil, utils.ToCmdLine("bitop", "one", "dest", "a", "b", "c"))
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("get", "dest")), "\xf0\xf0\x01")
+
+	// 目标键的过期时间被清除，结果为空时删除目标键
+	testDB.Exec(nil, utils.ToCmdLine("expire", "dest", "100"))
+	testDB.Exec(nil, utils.ToCmdLine("bitop", "and", "dest", "a", "c"))
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("ttl", "dest")), -1)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("bitop", "or", "dest", "missing")), 0)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("exists", "dest")), 0)
+
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("bitop", "not", "dest", "a", "b")),
+		"ERR BITOP NOT must be called with a single source key.")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("bitop", "diff", "dest", "a")),
+		"ERR BITOP DIFF must be called with at least two source keys.")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("bitop", "nand", "dest", "a")), "Err syntax error")
+	testDB.Exec(nil, utils.ToCmdLine("rpush", "list", "a"))
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("bitop", "or", "dest", "a", "list")),
+		"WRONGTYPE Operation against a key holding the wrong kind of value")
+}
+
+func TestBitField(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	result := testDB.Exec(nil, utils.ToCmdLine("bitfield", key, "get", "u8", "0"))
+	assertBitFieldReply(t, result, 0)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("exists", key)), 0)
+
+	result = testDB.Exec(nil, utils.ToCmdLine("bitfield", key, "set", "i8", "#1", "-100", "get", "i8", "8", "get", "u8", "#1"))
+	assertBitFieldReply(t, result, 0, -100, 156)
+	result = testDB.Exec(nil, utils.ToCmdLine("bitfield", key, "incrby", "u4", "0", "7", "incrby", "u4", "0", "10"))
+	assertBitFieldReply(t, result, 7, 1)
+	// 与 Redis 相同，字段从字节的最高位开始编号："1" 即 0x31
+	testDB.Exec(nil, utils.ToCmdLine("set", "r", "1"))
+	assertBitFieldReply(t, testDB.Exec(nil, utils.ToCmdLine("bitfield", "r", "get", "u4", "0", "incrby", "u8", "0", "1")), 3, 50)
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("get", "r")), "2")
+
+	// OVERFLOW 影响之后的子命令
+	result = testDB.Exec(nil, utils.ToCmdLine("bitfield", key, "set", "u8", "16", "250",
+		"overflow", "sat", "incrby", "u8", "16", "10",
+		"overflow", "fail", "incrby", "u8", "16", "1", "set", "i8", "24", "200", "get", "u8", "16"))
+	assertBitFieldReply(t, result, 0, 255, nil, nil, 255)
+
+	result = testDB.Exec(nil, utils.ToCmdLine("bitfield_ro", key, "get", "i8", "8"))
+	assertBitFieldReply(t, result, -100)
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("bitfield_ro", key, "set", "i8", "8", "1")),
+		"ERR BITFIELD_RO only supports the GET subcommand")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("bitfield", key, "get", "u64", "0")),
+		"ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("bitfield", key, "get", "u8", "-1")),
+		"ERR bit offset is not an integer or out of range")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("bitfield", key, "overflow", "none", "get", "u8", "0")),
+		"ERR Invalid OVERFLOW type specified")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("bitfield", key, "set", "u8", "0")), "Err syntax error")
+}
+
+func TestBitFieldUndo(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("bitfield", key, "set", "u8", "0", "42"))
+	undoCmdLines := rollbackFirstKey(testDB, utils.ToCmdLine(key, "incrby", "u8", "0", "1"))
+	testDB.Exec(nil, utils.ToCmdLine("bitfield", key, "incrby", "u8", "0", "1"))
+	for _, cmdLine := range undoCmdLines {
+		testDB.Exec(nil, cmdLine)
+	}
+	result := testDB.Exec(nil, utils.ToCmdLine("bitfield", key, "get", "u8", "0"))
+	assertBitFieldReply(t, result, 42)
+
+	// BITOP 回滚目标键
+	undoCmdLines = undoBitOp(testDB, utils.ToCmdLine("not", key, key))
+	testDB.Exec(nil, utils.ToCmdLine("bitop", "not", key, key))
+	for _, cmdLine := range undoCmdLines {
+		testDB.Exec(nil, cmdLine)
+	}
+	assertBitFieldReply(t, testDB.Exec(nil, utils.ToCmdLine("bitfield", key, "get", "u8", "0")), 42)
+}
+
+// 所有位图命令使用相同的位编号：第 n 位是第 n/8 个字节从最高位数起的第 n%8 位
+func TestBitNumbering(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("setbit", "b", "0", "1"))
+	assertBitFieldReply(t, testDB.Exec(nil, utils.ToCmdLine("bitfield", "b", "get", "u8", "0")), 128)
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("get", "b")), "\x80")
+
+	testDB.Exec(nil, utils.ToCmdLine("bitfield", "c", "set", "u8", "0", "128"))
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("getbit", "c", "0")), 1)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("getbit", "c", "7")), 0)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("bitpos", "c", "1")), 0)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("bitpos", "c", "0")), 1)
+
+	// "1" 即 0x31
+	testDB.Exec(nil, utils.ToCmdLine("set", "r", "1"))
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("getbit", "r", "2")), 1)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("getbit", "r", "0")), 0)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("bitpos", "r", "1")), 2)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("bitcount", "r", "0", "3", "bit")), 2)
+	testDB.Exec(nil, utils.ToCmdLine("setbit", "r", "7", "0"))
+	assertBitFieldReply(t, testDB.Exec(nil, utils.ToCmdLine("bitfield", "r", "get", "u8", "0")), 0x30)
+}
+
+func TestBitPos(t *testing.T) {
+	testDB.Flush()
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("bitpos", "none", "0")), 0)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("bitpos", "none", "1")), -1)
+
+	testDB.Exec(nil, utils.ToCmdLine("set", "a", "\xff\xf0\x00"))
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("bitpos", "a", "0")), 12)
+	testDB.Exec(nil, utils.ToCmdLine("set", "a", "\x00\xff\xf0"))
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("bitpos", "a", "1", "0")), 8)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("bitpos", "a", "1", "2")), 16)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("bitpos", "a", "1", "2", "-1", "byte")), 16)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("bitpos", "a", "1", "7", "15", "bit")), 8)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("bitpos", "a", "1", "7", "-3", "bit")), 8)
+
+	// 查找 0 时只有未指定 end 才把字符串右侧视为 0
+	testDB.Exec(nil, utils.ToCmdLine("set", "f", "\xff\xff"))
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("bitpos", "f", "0")), 16)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("bitpos", "f", "0", "0", "-1")), -1)
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("bitpos", "f", "2")), "ERR The bit argument must be 1 or 0.")
+}
+
+func TestBitCount(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("set", "a", "foobar"))
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("bitcount", "a")), 26)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("bitcount", "a", "0", "0")), 4)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("bitcount", "a", "1", "1", "byte")), 6)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("bitcount", "a", "5", "30", "bit")), 17)
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("bitcount", "a", "0")), "Err syntax error")
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"math"
+	"myredis/interface/myredis"
+	"myredis/lib/keyspec"
+	"myredis/protocol"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// blockingArgs 从阻塞命令的参数（不含命令名）中取出超时时间参数以及等待的键
+type blockingArgs func(args [][]byte) (timeout []byte, keys []string)
+
+// 阻塞命令及其参数格式
+//
+// 命令表中注册的执行函数不会阻塞，没有数据时返回空数组；在事务中执行时直接使用该结果
+var blockingCommands = map[string]blockingArgs{
+	"bzpopmin": lastTimeoutArgs,
+	"bzpopmax": lastTimeoutArgs,
+	"blmpop":   firstTimeoutArgs,
+}
+
+// 最后一个参数为超时时间（秒），其余参数都是键，例如 BZPOPMIN key [key ...] timeout
+func lastTimeoutArgs(args [][]byte) ([]byte, []string) {
+	keys, _ := keyspec.BlockingPop(args)
+	return args[len(args)-1], keys
+}
+
+// 第一个参数为超时时间，之后是 numkeys 与键，例如 BLMPOP timeout numkeys key [key ...] LEFT|RIGHT
+func firstTimeoutArgs(args [][]byte) ([]byte, []string) {
+	return args[0], keyspec.NumKeys(args[1:])
+}
+
+// 解析阻塞命令的超时时间，0 表示一直等待
+func parseBlockingTimeout(arg []byte) (time.Duration, protocol.ErrorReply) {
+	seconds, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, protocol.MakeErrReply("ERR timeout is not a float or out of range")
+	}
+	if seconds < 0 {
+		return 0, protocol.MakeErrReply("ERR timeout is negative")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// 在 key 上等待写入的通知
+func (db *DB) addKeyWaiter(keys []string, ch chan struct{}) {
+	db.waitersMu.Lock()
+	defer db.waitersMu.Unlock()
+	if db.waiters == nil {
+		db.waiters = make(map[string]map[chan struct{}]struct{})
+	}
+	for _, key := range keys {
+		waiters, ok := db.waiters[key]
+		if !ok {
+			waiters = make(map[chan struct{}]struct{})
+			db.waiters[key] = waiters
+		}
+		waiters[ch] = struct{}{}
+	}
+	db.waiterCount.Add(1)
+}
+
+func (db *DB) removeKeyWaiter(keys []string, ch chan struct{}) {
+	db.waitersMu.Lock()
+	defer db.waitersMu.Unlock()
+	for _, key := range keys {
+		delete(db.waiters[key], ch)
+		if len(db.waiters[key]) == 0 {
+			delete(db.waiters, key)
+		}
+	}
+	db.waiterCount.Add(-1)
+}
+
+// 写命令执行后通知等待这些键的阻塞命令重试
+func (db *DB) signalKeys(keys ...string) {
+	if db.waiterCount.Load() == 0 {
+		return
+	}
+	db.waitersMu.Lock()
+	defer db.waitersMu.Unlock()
+	for _, key := range keys {
+		for ch := range db.waiters[key] {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// 执行阻塞命令：没有数据时等待键被写入后重试，直到成功或超时
+func (db *DB) execBlocking(cmdLine [][]byte) myredis.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if !validateArity(cmdTable[cmdName].arity, cmdLine) {
+		return protocol.MakeArgNumErrReply(cmdName)
+	}
+	timeoutArg, keys := blockingCommands[cmdName](cmdLine[1:])
+	timeout, errReply := parseBlockingTimeout(timeoutArg)
+	if errReply != nil {
+		return errReply
+	}
+	// 先登记再尝试执行，避免错过两者之间的写入
+	ch := make(chan struct{}, 1)
+	db.addKeyWaiter(keys, ch)
+	defer db.removeKeyWaiter(keys, ch)
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		reply := db.execNormalCommand(cmdLine)
+		if _, ok := reply.(*protocol.NullMultiBulkReply); !ok {
+			return reply
+		}
+		select {
+		case <-ch:
+		case <-deadline:
+			return reply
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"myredis/config"
 	"myredis/datastruct/dict"
 	"myredis/interface/database"
 	"myredis/interface/myredis"
@@ -8,6 +9,8 @@ import (
 	"myredis/lib/timewheel"
 	"myredis/protocol"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -27,11 +30,24 @@ type DB struct {
 
 	versionMap *dict.ConcurrentDict
 
+	// 键的访问信息，key -> *keyAccess
+	accessMap *dict.ConcurrentDict
+
 	addAof func(CmdLine)
 
 	deleteCallback database.KeyEventCallback
 
 	insertCallback database.KeyEventCallback
+
+	// 写命令执行期间持有读锁，生成快照时持有写锁以获得一致的切点
+	writeGate sync.RWMutex
+	// 正在进行的快照，为 nil 时写入无需保存副本
+	snapshot atomic.Pointer[dbSnapshot]
+
+	// 阻塞命令等待的键，key -> 通知通道
+	waitersMu   sync.Mutex
+	waiters     map[string]map[chan struct{}]struct{}
+	waiterCount atomic.Int32
 }
 
 // 执行命令的接口
@@ -50,6 +66,7 @@ func makeDB() *DB {
 		data:       dict.MakeConcurrent(dataDictSize),
 		ttlMap:     dict.MakeConcurrent(ttlDictSize),
 		versionMap: dict.MakeConcurrent(dataDictSize),
+		accessMap:  dict.MakeConcurrent(dataDictSize),
 		addAof:     func(line CmdLine) {},
 	}
 	return db
@@ -84,6 +101,9 @@ func (db *DB) Exec(c myredis.Connection, cmdLine [][]byte) myredis.Reply {
 	if c != nil && c.InMultiState() {
 		return EnqueueCmd(c, cmdLine)
 	}
+	if _, ok := blockingCommands[cmdName]; ok {
+		return db.execBlocking(cmdLine)
+	}
 
 	return db.execNormalCommand(cmdLine)
 }
@@ -100,13 +120,18 @@ func (db *DB) execNormalCommand(cmdLine [][]byte) myredis.Reply {
 
 	prepare := cmd.prepare
 	write, read := prepare(cmdLine[1:])
+	db.writeGate.RLock()
+	defer db.writeGate.RUnlock()
 	// 写键需要版本信息
 	db.addVersion(write...)
 	db.RWLocks(write, read)
 	defer db.RWUnLocks(write, read)
+	db.preserve(write...)
 	exfun := cmd.executor
 	// 使用命令执行函数执行命令
-	return exfun(db, cmdLine[1:])
+	reply := exfun(db, cmdLine[1:])
+	db.signalKeys(write...)
+	return reply
 }
 
 func (db *DB) execWithLock(cmdLine [][]byte) myredis.Reply {
@@ -120,8 +145,13 @@ func (db *DB) execWithLock(cmdLine [][]byte) myredis.Reply {
 	if !validateArity(cmd.arity, cmdLine) {
 		return protocol.MakeArgNumErrReply(cmdName)
 	}
+	// 调用者已持有写锁
+	write, _ := cmd.prepare(cmdLine[1:])
+	db.preserve(write...)
 	exfun := cmd.executor
-	return exfun(db, cmdLine[1:])
+	reply := exfun(db, cmdLine[1:])
+	db.signalKeys(write...)
+	return reply
 }
 
 func validateArity(arity int, cmdArgs [][]byte) bool {
@@ -155,13 +185,18 @@ func (db *DB) GetEntity(key string) (*database.DataEntity, bool) {
 	if db.IsExpired(key) {
 		return nil, false
 	}
+	db.touchKey(key)
 	entity, _ := raw.(*database.DataEntity)
 	return entity, true
 }
 
 // 写入数据实体
 func (db *DB) PutEntity(key string, entity *database.DataEntity) int {
+	db.preserve(key)
 	res := db.data.PutWithLock(key, entity)
+	if res > 0 {
+		db.accessMap.Put(key, makeKeyAccess(time.Now()))
+	}
 	// 如果有插入的回调函数，执行该函数
 	if callback := db.insertCallback; callback != nil && res > 0 {
 		callback(db.index, key, entity)
@@ -169,22 +204,61 @@ func (db *DB) PutEntity(key string, entity *database.DataEntity) int {
 	return res
 }
 
+// key 不存在时写入数据实体，返回写入的数量
+func (db *DB) PutIfAbsent(key string, entity *database.DataEntity) int {
+	db.preserve(key)
+	res := db.data.PutIfAbsentWithLock(key, entity)
+	if res > 0 {
+		db.accessMap.Put(key, makeKeyAccess(time.Now()))
+	}
+	if callback := db.insertCallback; callback != nil && res > 0 {
+		callback(db.index, key, entity)
+	}
+	return res
+}
+
+// key 存在时覆盖数据实体，返回写入的数量
+func (db *DB) PutIfExists(key string, entity *database.DataEntity) int {
+	db.preserve(key)
+	return db.data.PutIfExistsWithLock(key, entity)
+}
+
 // 从内存数据库移除 key (需要同时移除时间轮中的定时清理任务)
 func (db *DB) Remove(key string) {
+	db.detach(key)
+}
+
+// 移除 key 并返回被移除的值，key 不存在时返回 nil
+func (db *DB) detach(key string) *database.DataEntity {
+	db.preserve(key)
 	raw, deleted := db.data.RemoveWithLock(key)
 	db.ttlMap.Remove(key)
+	db.accessMap.Remove(key)
 	// 定时的清理任务的键
 	taskKey := genExpireTask(key)
 	// 定时清理任务取消
 	timewheel.Cancel(taskKey)
+	var entity *database.DataEntity
+	if deleted > 0 {
+		entity = raw.(*database.DataEntity)
+	}
 	// 如果有删除的回调函数，那么就执行对应的回调函数
-	if cb := db.deleteCallback; cb != nil {
-		var entity *database.DataEntity
-		if deleted > 0 {
-			entity = raw.(*database.DataEntity)
-		}
+	if cb := db.deleteCallback; cb != nil && deleted > 0 {
 		cb(db.index, key, entity)
 	}
+	return entity
+}
+
+// 移除 key 并释放它的值，async 为 true 时较大的值在后台释放，返回 key 是否存在
+//
+// 只能用于值不会再被使用的场景，例如 DEL 与过期，RENAME 等转移值的场景应使用 Remove
+func (db *DB) removeAndFree(key string, async bool) bool {
+	entity := db.detach(key)
+	if entity == nil {
+		return false
+	}
+	freeEntity(entity, async)
+	return true
 }
 
 // 批量移除 key，返回移除的 key 数量
@@ -203,8 +277,28 @@ func (db *DB) Removes(keys ...string) (deleted int) {
 
 // 清空整个数据库
 func (db *DB) Flush() {
-	db.data.Clear()
-	db.ttlMap.Clear()
+	db.flush(false)
+}
+
+// 清空整个数据库，被删除的值在后台释放
+func (db *DB) FlushAsync() {
+	db.flush(true)
+}
+
+func (db *DB) flush(async bool) {
+	db.preserveAll()
+	// 逐个通知被删除的 key，保证外部维护的索引（如槽位索引）一致
+	if cb := db.deleteCallback; cb != nil {
+		db.data.ForEach(func(key string, val interface{}) bool {
+			entity, _ := val.(*database.DataEntity)
+			cb(db.index, key, entity)
+			return true
+		})
+	}
+	detached := db.data.Detach()
+	db.ttlMap.Detach()
+	db.accessMap.Detach()
+	freeDetached(detached, async)
 }
 
 // ******************** TTL Functions ********************
@@ -216,11 +310,14 @@ func genExpireTask(key string) string {
 
 // 对 key 设置 ttl，将过期任务加入时间轮
 func (db *DB) Expire(key string, expireTime time.Time) {
+	db.preserve(key)
 	db.ttlMap.Put(key, expireTime)
 	taskKey := genExpireTask(key)
 	// 将过期任务加入时间轮
 	timewheel.At(expireTime, taskKey, func() {
 		keys := []string{key}
+		db.writeGate.RLock()
+		defer db.writeGate.RUnlock()
 		db.RWLocks(keys, nil)
 		defer db.RWUnLocks(keys, nil)
 
@@ -234,13 +331,14 @@ func (db *DB) Expire(key string, expireTime time.Time) {
 		expireTime, _ := rawExpiredTime.(time.Time)
 		expired := time.Now().After(expireTime)
 		if expired {
-			db.Remove(key)
+			db.removeExpired(key)
 		}
 	})
 }
 
 // 移除过期时间
 func (db *DB) Persist(key string) {
+	db.preserve(key)
 	db.ttlMap.Remove(key)
 	taskKey := genExpireTask(key)
 	// 时间轮中移除对应的过期 key 操作
@@ -257,11 +355,18 @@ func (db *DB) IsExpired(key string) bool {
 	expireTime, _ := rawExpireTime.(time.Time)
 	expired := time.Now().After(expireTime)
 	if expired {
-		db.Remove(key)
+		db.removeExpired(key)
 	}
 	return expired
 }
 
+// 删除过期的 key，开启 lazyfree-lazy-expire 时较大的值在后台释放
+func (db *DB) removeExpired(key string) {
+	db.removeAndFree(key, lazyfreeEnabled(func(props *config.ServerProperties) bool {
+		return props.LazyfreeLazyExpire
+	}))
+}
+
 // ******************** Add Version Info ********************
 
 // 获取版本信息
@@ -273,11 +378,11 @@ func (db *DB) GetVersion(key string) uint32 {
 	return entity.(uint32)
 }
 
-// 对 key 加入版本信息
+// 对 key 加入版本信息，每次写入版本号加一
 func (db *DB) addVersion(keys ...string) {
 	for _, key := range keys {
 		versionCode := db.GetVersion(key)
-		db.versionMap.Put(key, versionCode)
+		db.versionMap.Put(key, versionCode+1)
 	}
 }
 
@@ -0,0 +1,289 @@
+package database
+
+import (
+	"myredis/aof"
+	"myredis/interface/database"
+	"myredis/interface/myredis"
+	"myredis/lib/utils"
+	"myredis/myredis/client"
+	"myredis/protocol"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// execDump: 将键的值序列化为与 Redis 兼容的 RDB 格式。
+// 返回值: 序列化后的数据，键不存在时返回 nil。
+// 格式: DUMP [KEY]
+func execDump(db *DB, args [][]byte) myredis.Reply {
+	key := string(args[0])
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return protocol.MakeNullBulkReply()
+	}
+	payload, err := aof.DumpEntity(entity)
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
+	return protocol.MakeBulkReply(payload)
+}
+
+// execRestore: 使用 DUMP 得到的数据创建键。
+// TTL 为 0 表示不过期，指定 ABSTTL 时 TTL 为毫秒时间戳；IDLETIME 与 FREQ 用于设置键的访问信息。
+// 返回值: OK，键已存在且未指定 REPLACE 时返回 BUSYKEY 错误。
+// 格式: RESTORE [KEY] [TTL] [SERIALIZED-VALUE] [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]
+func execRestore(db *DB, args [][]byte) myredis.Reply {
+	key := string(args[0])
+	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if ttl < 0 {
+		return protocol.MakeErrReply("ERR Invalid TTL value, must be >= 0")
+	}
+	replace, absTTL := false, false
+	idleTime, freq := int64(-1), int64(-1)
+	for i := 3; i < len(args); i++ {
+		arg := strings.ToLower(string(args[i]))
+		switch {
+		case arg == "replace":
+			replace = true
+		case arg == "absttl":
+			absTTL = true
+		case arg == "idletime" && i+1 < len(args):
+			idleTime, err = strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if idleTime < 0 {
+				return protocol.MakeErrReply("ERR Invalid IDLETIME value, must be >= 0")
+			}
+			i++
+		case arg == "freq" && i+1 < len(args):
+			freq, err = strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if freq < 0 || freq > 255 {
+				return protocol.MakeErrReply("ERR Invalid FREQ value, must be >= 0 and <= 255")
+			}
+			i++
+		default:
+			return protocol.MakeSyntaxErrReply()
+		}
+	}
+
+	_, exists := db.GetEntity(key)
+	if exists && !replace {
+		return protocol.MakeErrReply("BUSYKEY Target key name already exists.")
+	}
+	entity, err := aof.RestoreEntity(args[2])
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
+	var expireAt time.Time
+	if ttl > 0 {
+		if absTTL {
+			expireAt = time.UnixMilli(ttl)
+		} else {
+			expireAt = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+		}
+	}
+	if exists {
+		db.Remove(key)
+		db.addAof(utils.ToCmdLine("DEL", key))
+	}
+	// 已经过期的键以及字段全部过期的哈希无需创建
+	if (ttl > 0 && !expireAt.After(time.Now())) || !purgeRestoredFields(entity) {
+		return protocol.MakeOkReply()
+	}
+
+	db.PutEntity(key, entity)
+	db.addAof(aof.EntityToCmd(key, entity).Args)
+	if ttl > 0 {
+		db.Expire(key, expireAt)
+		db.addAof(aof.MakeExpiredCmd(key, expireAt).Args)
+	}
+	db.restoreFieldExpires(key, entity)
+	access := db.getAccess(key)
+	if idleTime >= 0 {
+		access.lastAccess.Store(time.Now().UnixMilli() - idleTime*1000)
+	}
+	if freq >= 0 {
+		access.counter.Store(uint32(freq))
+	}
+	return protocol.MakeOkReply()
+}
+
+// TIMEOUT 不大于 0 时使用的超时，单位为毫秒
+const defaultMigrateTimeout = 1000
+
+// 迁移选项
+type migrateOptions struct {
+	host     string
+	port     string
+	keys     []string
+	dbIndex  int
+	timeout  time.Duration
+	copy     bool
+	replace  bool
+	username string
+	password string
+}
+
+// 解析 MIGRATE 的参数，args 不含命令名
+func parseMigrateArgs(args [][]byte) (*migrateOptions, myredis.Reply) {
+	opts := &migrateOptions{
+		host: string(args[0]),
+		port: string(args[1]),
+	}
+	dbIndex, err := strconv.Atoi(string(args[3]))
+	if err != nil || dbIndex < 0 {
+		return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	opts.dbIndex = dbIndex
+	timeout, err := strconv.ParseInt(string(args[4]), 10, 64)
+	if err != nil {
+		return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if timeout <= 0 {
+		timeout = defaultMigrateTimeout
+	}
+	opts.timeout = time.Duration(timeout) * time.Millisecond
+	for i := 5; i < len(args); i++ {
+		arg := strings.ToLower(string(args[i]))
+		switch {
+		case arg == "copy":
+			opts.copy = true
+		case arg == "replace":
+			opts.replace = true
+		case arg == "auth" && i+1 < len(args):
+			opts.password = string(args[i+1])
+			i++
+		case arg == "auth2" && i+2 < len(args):
+			opts.username = string(args[i+1])
+			opts.password = string(args[i+2])
+			i += 2
+		case arg == "keys":
+			if len(args[2]) != 0 {
+				return nil, protocol.MakeErrReply("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			for _, key := range args[i+1:] {
+				opts.keys = append(opts.keys, string(key))
+			}
+			i = len(args)
+		default:
+			return nil, protocol.MakeSyntaxErrReply()
+		}
+	}
+	if len(args[2]) != 0 {
+		opts.keys = []string{string(args[2])}
+	}
+	return opts, nil
+}
+
+func undoMigrate(db *DB, args [][]byte) []CmdLine {
+	opts, errReply := parseMigrateArgs(args)
+	if errReply != nil {
+		return nil
+	}
+	return rollbackGivenKeys(db, opts.keys...)
+}
+
+// 目标实例返回错误时转换为 MIGRATE 的错误
+func makeMigrateErr(reply myredis.Reply) myredis.Reply {
+	msg := strings.TrimSpace(string(reply.ToBytes()))
+	msg = strings.TrimPrefix(msg, "-")
+	return protocol.MakeErrReply("ERR Target instance replied with error: " + msg)
+}
+
+// execMigrate: 将一个或多个键通过 DUMP/RESTORE 迁移到另一个 MyRedis 或 Redis 实例，TIMEOUT 单位为毫秒。
+// 迁移成功后删除本地的键，指定 COPY 时保留。
+// 返回值: OK，没有键需要迁移时返回 NOKEY。
+// 格式: MIGRATE [HOST] [PORT] [KEY|""] [DESTINATION-DB] [TIMEOUT] [COPY] [REPLACE] [AUTH password] [AUTH2 username password] [KEYS key ...]
+func execMigrate(db *DB, args [][]byte) myredis.Reply {
+	opts, errReply := parseMigrateArgs(args)
+	if errReply != nil {
+		return errReply
+	}
+	keys := make([]string, 0, len(opts.keys))
+	entities := make([]*database.DataEntity, 0, len(opts.keys))
+	for _, key := range opts.keys {
+		entity, exists := db.GetEntity(key)
+		if exists {
+			keys = append(keys, key)
+			entities = append(entities, entity)
+		}
+	}
+	if len(keys) == 0 {
+		return protocol.MakeStatusReply("NOKEY")
+	}
+
+	// 建立连接与等待每个回复都受 TIMEOUT 限制
+	target, err := client.NewClientWithTimeout(net.JoinHostPort(opts.host, opts.port), opts.timeout)
+	if err != nil {
+		return protocol.MakeErrReply("IOERR error or timeout connecting to the client")
+	}
+	target.Start()
+	defer target.Close()
+	// 依次发送命令，连接出错时返回 IOERR，目标实例返回错误时原样转告
+	request := func(cmdLine [][]byte) myredis.Reply {
+		reply := target.Send(cmdLine)
+		if client.IsConnErr(reply) {
+			return protocol.MakeErrReply("IOERR error or timeout reading to target instance")
+		}
+		if protocol.IsErrorReply(reply) {
+			return makeMigrateErr(reply)
+		}
+		return nil
+	}
+
+	if opts.password != "" {
+		authCmd := utils.ToCmdLine("AUTH", opts.password)
+		if opts.username != "" {
+			authCmd = utils.ToCmdLine("AUTH", opts.username, opts.password)
+		}
+		if errReply := request(authCmd); errReply != nil {
+			return errReply
+		}
+	}
+	if errReply := request(utils.ToCmdLine("SELECT", strconv.Itoa(opts.dbIndex))); errReply != nil {
+		return errReply
+	}
+	for i, key := range keys {
+		payload, err := aof.DumpEntity(entities[i])
+		if err != nil {
+			return protocol.MakeErrReply("ERR " + err.Error())
+		}
+		ttl := int64(0)
+		if raw, ok := db.ttlMap.Get(key); ok {
+			ttl = time.Until(raw.(time.Time)).Milliseconds()
+			if ttl < 1 {
+				ttl = 1
+			}
+		}
+		restoreCmd := [][]byte{[]byte("RESTORE"), []byte(key), []byte(strconv.FormatInt(ttl, 10)), payload}
+		if opts.replace {
+			restoreCmd = append(restoreCmd, []byte("REPLACE"))
+		}
+		if errReply := request(restoreCmd); errReply != nil {
+			return errReply
+		}
+	}
+
+	if !opts.copy {
+		db.Removes(keys...)
+		db.addAof(utils.ToCmdLine2("DEL", keys...))
+	}
+	return protocol.MakeOkReply()
+}
+
+func init() {
+	registerCommand("Dump", execDump, nil, 2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom}, 1, 1, 1)
+	registerCommand("Restore", execRestore, rollbackFirstKey, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	registerCommand("Migrate", execMigrate, undoMigrate, -6, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagRandom, redisFlagMovableKeys}, 3, 3, 1)
+}
@@ -0,0 +1,257 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"myredis/interface/myredis"
+	"myredis/lib/utils"
+	"myredis/myredis/parser"
+	"myredis/protocol"
+	"myredis/protocol/assert"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/rdb/crc64jones"
+)
+
+func dumpKey(t *testing.T, db *DB, key string) []byte {
+	ret := db.Exec(nil, utils.ToCmdLine("DUMP", key))
+	bulkReply, ok := ret.(*protocol.BulkReply)
+	if !ok {
+		t.Fatalf("expected bulk reply, actually %s", ret.ToBytes())
+	}
+	return bulkReply.Arg
+}
+
+func TestDumpRestore(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("SET", "str", "hello"))
+	testDB.Exec(nil, utils.ToCmdLine("RPUSH", "list", "a", "b", "c"))
+	testDB.Exec(nil, utils.ToCmdLine("HSET", "hash", "f1", "v1"))
+	testDB.Exec(nil, utils.ToCmdLine("HSET", "hash", "f2", "v2"))
+	testDB.Exec(nil, utils.ToCmdLine("SADD", "set", "a", "b"))
+	testDB.Exec(nil, utils.ToCmdLine("ZADD", "zset", "1", "a", "2.5", "b"))
+	payloads := make(map[string][]byte)
+	for _, key := range []string{"str", "list", "hash", "set", "zset"} {
+		payloads[key] = dumpKey(t, testDB, key)
+	}
+	if ret := testDB.Exec(nil, utils.ToCmdLine("DUMP", "none")); !utils.BytesEquals(ret.ToBytes(), protocol.MakeNullBulkReply().ToBytes()) {
+		t.Errorf("expected nil, actually %s", ret.ToBytes())
+	}
+
+	// 与 Redis 相同的格式：类型、值、RDB 版本与校验和
+	str := payloads["str"]
+	if !bytes.Equal(str[:7], []byte("\x00\x05hello")) || str[7] != 11 || str[8] != 0 || len(str) != 17 {
+		t.Errorf("wrong dump payload %q", str)
+	}
+
+	for key, payload := range payloads {
+		ret := testDB.Exec(nil, [][]byte{[]byte("RESTORE"), []byte(key), []byte("0"), payload})
+		assert.AssertErrReply(t, ret, "BUSYKEY Target key name already exists.")
+		ret = testDB.Exec(nil, [][]byte{[]byte("RESTORE"), []byte(key + "2"), []byte("0"), payload})
+		assert.AssertStatusReply(t, ret, "OK")
+	}
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("GET", "str2")), "hello")
+	assert.AssertMultiBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("LRANGE", "list2", "0", "-1")), []string{"a", "b", "c"})
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("HGET", "hash2", "f2")), "v2")
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("SISMEMBER", "set2", "b")), 1)
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("ZSCORE", "zset2", "b")), "2.5")
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("TTL", "str2")), -1)
+
+	// REPLACE 覆盖已有的键，并替换原有的过期时间
+	testDB.Exec(nil, utils.ToCmdLine("EXPIRE", "list", "100"))
+	ret := testDB.Exec(nil, [][]byte{[]byte("RESTORE"), []byte("list"), []byte("0"), payloads["str"], []byte("REPLACE")})
+	assert.AssertStatusReply(t, ret, "OK")
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("GET", "list")), "hello")
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("TTL", "list")), -1)
+
+	// 相对与绝对的过期时间
+	ret = testDB.Exec(nil, [][]byte{[]byte("RESTORE"), []byte("ttl"), []byte("100000"), payloads["str"]})
+	assert.AssertStatusReply(t, ret, "OK")
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("TTL", "ttl")), 100)
+	expireAt := time.Now().Add(time.Hour).UnixMilli()
+	ret = testDB.Exec(nil, [][]byte{[]byte("RESTORE"), []byte("absttl"), []byte(strconv.FormatInt(expireAt, 10)), payloads["str"], []byte("ABSTTL")})
+	assert.AssertStatusReply(t, ret, "OK")
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("PEXPIRETIME", "absttl")), int(expireAt))
+	ret = testDB.Exec(nil, [][]byte{[]byte("RESTORE"), []byte("expired"), []byte("1"), payloads["str"], []byte("ABSTTL")})
+	assert.AssertStatusReply(t, ret, "OK")
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("EXISTS", "expired")), 0)
+
+	// 损坏的数据
+	bad := append([]byte{}, payloads["hash"]...)
+	bad[3] ^= 0xFF
+	ret = testDB.Exec(nil, [][]byte{[]byte("RESTORE"), []byte("bad"), []byte("0"), bad})
+	assert.AssertErrReply(t, ret, "ERR DUMP payload version or checksum are wrong")
+	ret = testDB.Exec(nil, [][]byte{[]byte("RESTORE"), []byte("bad"), []byte("0"), []byte("xx")})
+	assert.AssertErrReply(t, ret, "ERR DUMP payload version or checksum are wrong")
+	ret = testDB.Exec(nil, [][]byte{[]byte("RESTORE"), []byte("bad"), []byte("-1"), payloads["str"]})
+	assert.AssertErrReply(t, ret, "ERR Invalid TTL value, must be >= 0")
+	ret = testDB.Exec(nil, [][]byte{[]byte("RESTORE"), []byte("bad"), []byte("0"), payloads["str"], []byte("FREQ"), []byte("256")})
+	assert.AssertErrReply(t, ret, "ERR Invalid FREQ value, must be >= 0 and <= 255")
+	ret = testDB.Exec(nil, [][]byte{[]byte("RESTORE"), []byte("bad"), []byte("0"), payloads["str"], []byte("XX")})
+	assert.AssertErrReply(t, ret, "Err syntax error")
+}
+
+func TestDumpHashFieldExpires(t *testing.T) {
+	testDB.Flush()
+	at := time.Now().Add(time.Hour).UnixMilli()
+	testDB.Exec(nil, utils.ToCmdLine("HSET", "hash", "a", "1"))
+	testDB.Exec(nil, utils.ToCmdLine("HSET", "hash", "b", "2"))
+	testDB.Exec(nil, utils.ToCmdLine("HPEXPIREAT", "hash", strconv.FormatInt(at, 10), "FIELDS", "1", "a"))
+	payload := dumpKey(t, testDB, "hash")
+
+	// Redis 7.4 的 RDB_TYPE_HASH_METADATA：类型 24、最早过期时间、字段数，之后每个字段的 ttl、字段与值
+	if payload[0] != 24 || int64(binary.LittleEndian.Uint64(payload[1:9])) != at || payload[9] != 2 {
+		t.Fatalf("wrong dump payload %q", payload)
+	}
+	version := payload[len(payload)-10 : len(payload)-8]
+	if version[0] != 12 || version[1] != 0 {
+		t.Errorf("wrong rdb version %v", version)
+	}
+	for _, entry := range []string{"\x01\x01a\x011", "\x00\x01b\x012"} {
+		if !bytes.Contains(payload[10:len(payload)-10], []byte(entry)) {
+			t.Errorf("missing entry %q in %q", entry, payload)
+		}
+	}
+
+	ret := testDB.Exec(nil, [][]byte{[]byte("RESTORE"), []byte("hash2"), []byte("0"), payload})
+	assert.AssertStatusReply(t, ret, "OK")
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("HGET", "hash2", "b")), "2")
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("HPEXPIRETIME", "hash2", "FIELDS", "2", "a", "b")), at, -1)
+
+	// Redis 生成的数据中字段可能是整数编码
+	body := []byte{24}
+	body = binary.LittleEndian.AppendUint64(body, uint64(at))
+	body = append(body, 2, 11, 0xC0, 7, 1, 'x', 0, 0xC1, 0x39, 0x30, 1, 'y')
+	body = binary.LittleEndian.AppendUint16(body, 12)
+	hash := crc64jones.New()
+	_, _ = hash.Write(body)
+	body = binary.LittleEndian.AppendUint64(body, hash.Sum64())
+	ret = testDB.Exec(nil, [][]byte{[]byte("RESTORE"), []byte("hash3"), []byte("0"), body})
+	assert.AssertStatusReply(t, ret, "OK")
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("HGET", "hash3", "12345")), "y")
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("HPEXPIRETIME", "hash3", "FIELDS", "2", "7", "12345")), at+10, -1)
+
+	// 版本低于 12 的数据不能包含这一类型
+	body = append(body[:len(body)-10:len(body)-10], 11, 0)
+	hash = crc64jones.New()
+	_, _ = hash.Write(body)
+	body = binary.LittleEndian.AppendUint64(body, hash.Sum64())
+	ret = testDB.Exec(nil, [][]byte{[]byte("RESTORE"), []byte("hash4"), []byte("0"), body})
+	assert.AssertErrReply(t, ret, "ERR Bad data format")
+}
+
+func TestRestoreAccessInfo(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("SET", "a", "1"))
+	payload := dumpKey(t, testDB, "a")
+	ret := testDB.Exec(nil, [][]byte{[]byte("RESTORE"), []byte("idle"), []byte("0"), payload, []byte("IDLETIME"), []byte("1000")})
+	assert.AssertStatusReply(t, ret, "OK")
+	ret = testDB.Exec(nil, utils.ToCmdLine("OBJECT", "IDLETIME", "idle"))
+	intReply, ok := ret.(*protocol.IntReply)
+	if !ok || intReply.Code < 1000 || intReply.Code > 1001 {
+		t.Errorf("wrong idle time %s", ret.ToBytes())
+	}
+	ret = testDB.Exec(nil, [][]byte{[]byte("RESTORE"), []byte("freq"), []byte("0"), payload, []byte("FREQ"), []byte("100")})
+	assert.AssertStatusReply(t, ret, "OK")
+	setMaxmemoryPolicy(t, "volatile-lfu")
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("OBJECT", "FREQ", "freq")), 100)
+}
+
+// 在本地启动一个简单的服务器，用 db 执行收到的命令
+func startMigrateTarget(t *testing.T, db *DB) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for payload := range parser.ParseStream(conn) {
+					if payload.Err != nil {
+						return
+					}
+					args := payload.Data.(*protocol.MultiBulkReply).Args
+					var reply myredis.Reply
+					switch string(bytes.ToLower(args[0])) {
+					case "select", "auth":
+						reply = protocol.MakeOkReply()
+					default:
+						reply = db.Exec(nil, args)
+					}
+					_, _ = conn.Write(reply.ToBytes())
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestMigrate(t *testing.T) {
+	testDB.Flush()
+	target := makeTestDB()
+	host, port, _ := net.SplitHostPort(startMigrateTarget(t, target))
+
+	testDB.Exec(nil, utils.ToCmdLine("SET", "a", "1", "EX", "100"))
+	testDB.Exec(nil, utils.ToCmdLine("RPUSH", "b", "x", "y"))
+	testDB.Exec(nil, utils.ToCmdLine("SET", "c", "3"))
+
+	ret := testDB.Exec(nil, utils.ToCmdLine("MIGRATE", host, port, "a", "0", "1000"))
+	assert.AssertStatusReply(t, ret, "OK")
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("EXISTS", "a")), 0)
+	assert.AssertBulkReply(t, target.Exec(nil, utils.ToCmdLine("GET", "a")), "1")
+	ttlReply, _ := target.Exec(nil, utils.ToCmdLine("TTL", "a")).(*protocol.IntReply)
+	if ttlReply == nil || ttlReply.Code < 99 || ttlReply.Code > 100 {
+		t.Errorf("wrong ttl after migrate")
+	}
+
+	// COPY 保留本地的键，目标已存在时需要 REPLACE
+	ret = testDB.Exec(nil, utils.ToCmdLine("MIGRATE", host, port, "", "0", "1000", "COPY", "KEYS", "b", "c", "none"))
+	assert.AssertStatusReply(t, ret, "OK")
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("EXISTS", "b", "c")), 2)
+	assert.AssertMultiBulkReply(t, target.Exec(nil, utils.ToCmdLine("LRANGE", "b", "0", "-1")), []string{"x", "y"})
+	testDB.Exec(nil, utils.ToCmdLine("SET", "c", "4"))
+	ret = testDB.Exec(nil, utils.ToCmdLine("MIGRATE", host, port, "c", "0", "1000"))
+	assert.AssertErrReply(t, ret, "ERR Target instance replied with error: BUSYKEY Target key name already exists.")
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("EXISTS", "c")), 1)
+	ret = testDB.Exec(nil, utils.ToCmdLine("MIGRATE", host, port, "c", "0", "1000", "REPLACE"))
+	assert.AssertStatusReply(t, ret, "OK")
+	assert.AssertBulkReply(t, target.Exec(nil, utils.ToCmdLine("GET", "c")), "4")
+
+	ret = testDB.Exec(nil, utils.ToCmdLine("MIGRATE", host, port, "none", "0", "1000"))
+	assert.AssertStatusReply(t, ret, "NOKEY")
+	ret = testDB.Exec(nil, utils.ToCmdLine("MIGRATE", host, port, "b", "0", "1000", "KEYS", "c"))
+	assert.AssertErrReply(t, ret, "ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+
+	// 目标实例不回复时在 TIMEOUT 之后返回 IOERR，键保留在本地
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	host, port, _ = net.SplitHostPort(silent.Addr().String())
+	start := time.Now()
+	ret = testDB.Exec(nil, utils.ToCmdLine("MIGRATE", host, port, "b", "0", "100"))
+	assert.AssertErrReply(t, ret, "IOERR error or timeout reading to target instance")
+	if time.Since(start) > time.Second {
+		t.Errorf("migrate should time out after 100ms, actually %v", time.Since(start))
+	}
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("EXISTS", "b")), 1)
+}
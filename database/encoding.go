@@ -0,0 +1,33 @@
+package database
+
+import (
+	"myredis/config"
+	"myredis/datastruct/dict"
+	"myredis/datastruct/list"
+	"myredis/datastruct/set"
+	"myredis/datastruct/sortedset"
+)
+
+// 将配置中的紧凑编码阈值应用到各数据结构，未配置（为 0）的项保持默认值
+func applyEncodingConfig() {
+	props := config.Properties
+	if props == nil {
+		return
+	}
+	setIfPositive(&dict.ListpackMaxEntries, props.HashMaxListpackEntries)
+	setIfPositive(&dict.ListpackMaxValue, props.HashMaxListpackValue)
+	setIfPositive(&set.IntsetMaxEntries, props.SetMaxIntsetEntries)
+	setIfPositive(&set.ListpackMaxEntries, props.SetMaxListpackEntries)
+	setIfPositive(&set.ListpackMaxValue, props.SetMaxListpackValue)
+	setIfPositive(&sortedset.ListpackMaxEntries, props.ZSetMaxListpackEntries)
+	setIfPositive(&sortedset.ListpackMaxValue, props.ZSetMaxListpackValue)
+	if props.ListMaxListpackSize != 0 {
+		list.ListpackMaxSize = props.ListMaxListpackSize
+	}
+}
+
+func setIfPositive(target *int, value int) {
+	if value > 0 {
+		*target = value
+	}
+}
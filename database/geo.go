@@ -5,9 +5,10 @@ import (
 	"myredis/datastruct/sortedset"
 	"myredis/interface/myredis"
 	"myredis/lib/geohash"
+	"myredis/lib/keyspec"
+	"myredis/lib/utils"
 	"myredis/protocol"
 	"strconv"
-	"strings"
 )
 
 // execGeoAdd: 将一个或多个地理位置（经度、纬度、成员名）添加到指定的 SortedSet 中。
@@ -55,6 +56,7 @@ func execGeoAdd(db *DB, args [][]byte) myredis.Reply {
 			i++
 		}
 	}
+	db.addAof(utils.ToCmdLine3("geoadd", args...))
 	return protocol.MakeIntReply(int64(i))
 }
 
@@ -109,7 +111,7 @@ func execGeoPos(db *DB, args [][]byte) myredis.Reply {
 	return protocol.MakeMultiRawReply(positions)
 }
 
-// execGeoDist: 计算两个成员之间的地理距离，默认单位为米（m），支持千米（km）。
+// execGeoDist: 计算两个成员之间的地理距离，默认单位为米（m），支持 km、mi、ft。
 //
 // 两个成员都必须存在于同一 SortedSet 中。
 //
@@ -139,20 +141,15 @@ func execGeoDist(db *DB, args [][]byte) myredis.Reply {
 		lat, lng := geohash.Decode(uint64(element.Score))
 		positions[i-1] = []float64{lat, lng}
 	}
-	distUnit := "m"
+	unit := 1.0
 	if len(args) == 4 {
-		distUnit = strings.ToLower(string(args[3]))
+		unit, errReply = parseGeoUnit(args[3])
+		if errReply != nil {
+			return errReply
+		}
 	}
 	dist := geohash.Distance(positions[0][0], positions[0][1], positions[1][0], positions[1][1])
-	var distStr string
-	if distUnit == "m" {
-		distStr = strconv.FormatFloat(dist, 'f', -1, 64)
-		return protocol.MakeBulkReply([]byte(distStr))
-	} else if distUnit == "km" {
-		distStr = strconv.FormatFloat(dist/1000, 'f', -1, 64)
-		return protocol.MakeBulkReply([]byte(distStr))
-	}
-	return protocol.MakeErrReply("ERR unsupported unit provided. please use m, km")
+	return protocol.MakeBulkReply([]byte(strconv.FormatFloat(dist/unit, 'f', 4, 64)))
 }
 
 // execGeoHash: 返回成员对应的原始 GeoHash 编码字符串（base32 格式）。
@@ -190,126 +187,75 @@ func execGeoHash(db *DB, args [][]byte) myredis.Reply {
 
 // execGeoRadius: 以给定经纬度为中心，返回指定半径内的所有成员。
 //
-// 半径单位支持 m（米）和 km（千米）。
+// 半径单位支持 m、km、mi、ft，可选参数与 GEOSEARCH 相同，并支持 STORE/STOREDIST 保存结果。
 //
-// 调用示例: GEORADIUS locations 15 37 200 km
+// 调用示例: GEORADIUS locations 15 37 200 km WITHDIST ASC COUNT 3
 //
-// 返回值: 匹配的成员名称列表（数组）。
+// 返回值: 匹配的成员列表；指定 STORE/STOREDIST 时返回保存的成员数量。
 func execGeoRadius(db *DB, args [][]byte) myredis.Reply {
-	if len(args) < 5 {
-		return protocol.MakeErrReply("ERR wrong number of arguments for 'georadius' command")
-	}
-	key := string(args[0])
-	sortedSet, errReply := db.getAsSortedSet(key)
+	opts := &geoSearchOptions{hasFromLonLat: true}
+	lng, lat, errReply := parseGeoLonLat(args[1], args[2])
 	if errReply != nil {
 		return errReply
 	}
-	if sortedSet == nil {
-		return protocol.MakeNullBulkReply()
-	}
-
-	lng, err := strconv.ParseFloat(string(args[1]), 64)
-	if err != nil {
-		return protocol.MakeErrReply("ERR value is not a valid float")
-	}
-	lat, err := strconv.ParseFloat(string(args[2]), 64)
-	if err != nil {
-		return protocol.MakeErrReply("ERR value is not a valid float")
-	}
-	radius, err := strconv.ParseFloat(string(args[3]), 64)
-	if err != nil {
-		return protocol.MakeErrReply("ERR value is not a valid float")
-	}
-
-	distUnit := strings.ToLower(string(args[4]))
-	if distUnit == "km" {
-		radius *= 1000
-	} else if distUnit != "m" {
-		return protocol.MakeErrReply("ERR unsupported unit provided. please use m, km")
+	opts.lng, opts.lat = lng, lat
+	if errReply := opts.setRadius(args[3], args[4]); errReply != nil {
+		return errReply
 	}
-
-	return geoRadius(sortedSet, lat, lng, radius)
+	return db.geoRadius("georadius", opts, args, 5)
 }
 
-// execGeoRadiusByMember: 以指定成员的位置为中心，返回指定半径内的所有其他成员。
+// execGeoRadiusByMember: 以指定成员的位置为中心，返回指定半径内的所有成员。
 //
-// 半径单位支持 m（米）和 km（千米）。
+// 半径单位支持 m、km、mi、ft，可选参数与 GEORADIUS 相同；该成员不存在时返回错误。
 //
-// 如果该成员不存在，则返回空结果。
+// 调用示例: GEORADIUSBYMEMBER locations Palermo 200 km WITHCOORD
 //
-// 调用示例: GEORADIUSBYMEMBER locations Palermo 200 km
-//
-// 返回值: 匹配的成员名称列表（数组）。
+// 返回值: 匹配的成员列表；指定 STORE/STOREDIST 时返回保存的成员数量。
 func execGeoRadiusByMember(db *DB, args [][]byte) myredis.Reply {
-	if len(args) < 3 {
-		return protocol.MakeErrReply("ERR wrong number of arguments for 'georadiusbymember' command")
-	}
-	key := string(args[0])
-	sortedSet, errReply := db.getAsSortedSet(key)
-	if errReply != nil {
+	opts := &geoSearchOptions{hasFromMember: true, fromMember: string(args[1])}
+	if errReply := opts.setRadius(args[2], args[3]); errReply != nil {
 		return errReply
 	}
-	if sortedSet == nil {
-		return protocol.MakeNullBulkReply()
-	}
+	return db.geoRadius("georadiusbymember", opts, args, 4)
+}
 
-	member := string(args[1])
-	element, ok := sortedSet.Get(member)
-	if !ok {
-		return protocol.MakeNullBulkReply()
+// geoRadius: GEORADIUS 与 GEORADIUSBYMEMBER 的公共逻辑，optStart 为可选参数的起始下标
+func (db *DB) geoRadius(cmdName string, opts *geoSearchOptions, args [][]byte, optStart int) myredis.Reply {
+	if errReply := parseGeoSearchOptions(cmdName, opts, args[optStart:], geoRadiusMode); errReply != nil {
+		return errReply
 	}
-	lat, lng := geohash.Decode(uint64(element.Score))
-	radius, err := strconv.ParseFloat(string(args[2]), 64)
-	if err != nil {
-		return protocol.MakeErrReply("ERR value is not a valid float")
+	points, errReply := db.runGeoSearch(string(args[0]), opts)
+	if errReply != nil {
+		return errReply
 	}
-
-	if len(args) > 3 {
-		distUnit := strings.ToLower(string(args[3]))
-		if distUnit == "km" {
-			radius *= 1000
-		} else if distUnit != "m" {
-			return protocol.MakeErrReply("ERR unsupported unit provided. please use m, km")
-		}
+	if opts.storeKey != "" {
+		return db.storeGeoPoints(cmdName, opts.storeKey, points, opts, args)
 	}
-	return geoRadius(sortedSet, lat, lng, radius)
+	return geoSearchReply(points, opts)
 }
 
-// geoRadius: 内部函数，实现基于经纬度和半径的地理范围查询。
-//
-// 利用 GeoHash 邻近区域算法确定候选范围，在 SortedSet 中进行 Score 范围查找。
-//
-// 注意：结果可能包含少量误报（未做精确距离过滤）。
-//
-// 返回值: 所有在候选区域内的成员名称列表。
-func geoRadius(sortedSet *sortedset.SortedSet, lat float64, lng float64, radius float64) myredis.Reply {
-	areas := geohash.GetNeighbours(lat, lng, radius)
-	members := make([][]byte, 0)
-	for _, area := range areas {
-		// 获取边界
-		lower := &sortedset.ScoreBorder{Value: float64(area[0])}
-		upper := &sortedset.ScoreBorder{Value: float64(area[1])}
-		// 查询成员
-		elements := sortedSet.Range(lower, upper, 0, -1, true)
-		for _, elem := range elements {
-			members = append(members, []byte(elem.Member))
-			// print(string(elem.Member), "\n")
+// undoGeoRadius 回滚 STORE/STOREDIST 写入的目标 key
+func undoGeoRadius(optStart int) UndoFunc {
+	return func(db *DB, args [][]byte) []CmdLine {
+		if storeKey := keyspec.GeoRadiusStoreKey(args, optStart); storeKey != "" {
+			return rollbackGivenKeys(db, storeKey)
 		}
+		return nil
 	}
-	return protocol.MakeMultiBulkReply(members)
 }
 
 func init() {
-	registerCommand("GeoAdd", execGeoAdd, writeFirstKey, undoGeoAdd, -5, flagWrite).
+	registerCommand("GeoAdd", execGeoAdd, undoGeoAdd, -5, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
-	registerCommand("GeoPos", execGeoPos, readFirstKey, nil, -2, flagReadOnly).
+	registerCommand("GeoPos", execGeoPos, nil, -2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
-	registerCommand("GeoDist", execGeoDist, readFirstKey, nil, -4, flagReadOnly).
+	registerCommand("GeoDist", execGeoDist, nil, -4, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
-	registerCommand("GeoHash", execGeoHash, readFirstKey, nil, -2, flagReadOnly).
+	registerCommand("GeoHash", execGeoHash, nil, -2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
-	registerCommand("GeoRadius", execGeoRadius, readFirstKey, nil, -6, flagReadOnly).
+	registerCommand("GeoRadius", execGeoRadius, undoGeoRadius(5), -6, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagMovableKeys}, 1, 1, 1)
-	registerCommand("GeoRadiusByMember", execGeoRadiusByMember, readFirstKey, nil, -5, flagReadOnly).
+	registerCommand("GeoRadiusByMember", execGeoRadiusByMember, undoGeoRadius(4), -5, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagMovableKeys}, 1, 1, 1)
 }
@@ -0,0 +1,408 @@
+package database
+
+import (
+	"fmt"
+	SortedSet "myredis/datastruct/sortedset"
+	"myredis/interface/myredis"
+	"myredis/lib/geohash"
+	"myredis/protocol"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 地理搜索命令的参数形式
+const (
+	geoSearchMode      = iota // GEOSEARCH
+	geoSearchStoreMode        // GEOSEARCHSTORE
+	geoRadiusMode             // GEORADIUS / GEORADIUSBYMEMBER
+)
+
+const (
+	geoSortNone = iota
+	geoSortAsc
+	geoSortDesc
+)
+
+// geoUnits 距离单位到米的换算系数
+var geoUnits = map[string]float64{
+	"m":  1,
+	"km": 1000,
+	"mi": 1609.34,
+	"ft": 0.3048,
+}
+
+// parseGeoUnit 解析距离单位，返回换算为米的系数
+func parseGeoUnit(unit []byte) (float64, protocol.ErrorReply) {
+	conversion, ok := geoUnits[strings.ToLower(string(unit))]
+	if !ok {
+		return 0, protocol.MakeErrReply("ERR unsupported unit provided. please use M, KM, FT, MI")
+	}
+	return conversion, nil
+}
+
+// geoSearchOptions 地理搜索参数，距离均已换算为米
+type geoSearchOptions struct {
+	fromMember    string
+	hasFromMember bool
+	hasFromLonLat bool
+	lat, lng      float64
+
+	byRadius bool
+	byBox    bool
+	radius   float64
+	width    float64
+	height   float64
+	unit     float64 // 结果距离的单位换算系数
+
+	sort      int
+	count     int
+	any       bool
+	withCoord bool
+	withDist  bool
+	withHash  bool
+
+	storeKey  string // GEORADIUS 的 STORE / STOREDIST 目标
+	storeDist bool
+}
+
+func (opts *geoSearchOptions) withAny() bool {
+	return opts.withCoord || opts.withDist || opts.withHash
+}
+
+func parseGeoFloat(arg []byte) (float64, protocol.ErrorReply) {
+	val, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil {
+		return 0, protocol.MakeErrReply("ERR value is not a valid float")
+	}
+	return val, nil
+}
+
+func parseGeoLonLat(lngArg, latArg []byte) (float64, float64, protocol.ErrorReply) {
+	lng, errReply := parseGeoFloat(lngArg)
+	if errReply != nil {
+		return 0, 0, errReply
+	}
+	lat, errReply := parseGeoFloat(latArg)
+	if errReply != nil {
+		return 0, 0, errReply
+	}
+	if lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return 0, 0, protocol.MakeErrReply(fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", lng, lat))
+	}
+	return lng, lat, nil
+}
+
+func (opts *geoSearchOptions) setRadius(radiusArg, unitArg []byte) protocol.ErrorReply {
+	radius, errReply := parseGeoFloat(radiusArg)
+	if errReply != nil {
+		return errReply
+	}
+	if radius < 0 {
+		return protocol.MakeErrReply("ERR radius cannot be negative")
+	}
+	unit, errReply := parseGeoUnit(unitArg)
+	if errReply != nil {
+		return errReply
+	}
+	opts.byRadius = true
+	opts.radius = radius * unit
+	opts.unit = unit
+	return nil
+}
+
+func (opts *geoSearchOptions) setBox(widthArg, heightArg, unitArg []byte) protocol.ErrorReply {
+	width, errReply := parseGeoFloat(widthArg)
+	if errReply != nil {
+		return errReply
+	}
+	height, errReply := parseGeoFloat(heightArg)
+	if errReply != nil {
+		return errReply
+	}
+	if width < 0 || height < 0 {
+		return protocol.MakeErrReply("ERR height or width cannot be negative")
+	}
+	unit, errReply := parseGeoUnit(unitArg)
+	if errReply != nil {
+		return errReply
+	}
+	opts.byBox = true
+	opts.width = width * unit
+	opts.height = height * unit
+	opts.unit = unit
+	return nil
+}
+
+// parseGeoSearchOptions 解析地理搜索的可选参数，opts 中已有的中心与形状会被保留
+//
+// GEOSEARCH 与 GEOSEARCHSTORE 通过 FROMMEMBER/FROMLONLAT 与 BYRADIUS/BYBOX 指定中心和形状；
+// GEORADIUS 额外支持 STORE/STOREDIST key；GEOSEARCHSTORE 不支持 WITH* 选项但支持 STOREDIST
+func parseGeoSearchOptions(cmdName string, opts *geoSearchOptions, args [][]byte, mode int) protocol.ErrorReply {
+	hasCount := false
+	for i := 0; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch arg := strings.ToUpper(string(args[i])); {
+		case arg == "FROMMEMBER" && mode != geoRadiusMode && remaining >= 1:
+			if opts.hasFromLonLat {
+				return protocol.MakeErrReply("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for " + cmdName)
+			}
+			opts.hasFromMember = true
+			opts.fromMember = string(args[i+1])
+			i++
+		case arg == "FROMLONLAT" && mode != geoRadiusMode && remaining >= 2:
+			if opts.hasFromMember {
+				return protocol.MakeErrReply("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for " + cmdName)
+			}
+			lng, lat, errReply := parseGeoLonLat(args[i+1], args[i+2])
+			if errReply != nil {
+				return errReply
+			}
+			opts.hasFromLonLat = true
+			opts.lng, opts.lat = lng, lat
+			i += 2
+		case arg == "BYRADIUS" && mode != geoRadiusMode && remaining >= 2:
+			if opts.byBox {
+				return protocol.MakeErrReply("ERR exactly one of BYRADIUS and BYBOX can be specified for " + cmdName)
+			}
+			if errReply := opts.setRadius(args[i+1], args[i+2]); errReply != nil {
+				return errReply
+			}
+			i += 2
+		case arg == "BYBOX" && mode != geoRadiusMode && remaining >= 3:
+			if opts.byRadius {
+				return protocol.MakeErrReply("ERR exactly one of BYRADIUS and BYBOX can be specified for " + cmdName)
+			}
+			if errReply := opts.setBox(args[i+1], args[i+2], args[i+3]); errReply != nil {
+				return errReply
+			}
+			i += 3
+		case arg == "ASC":
+			opts.sort = geoSortAsc
+		case arg == "DESC":
+			opts.sort = geoSortDesc
+		case arg == "COUNT" && remaining >= 1:
+			count, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if count <= 0 {
+				return protocol.MakeErrReply("ERR COUNT must be > 0")
+			}
+			hasCount = true
+			opts.count = int(count)
+			i++
+			if i+1 < len(args) && strings.ToUpper(string(args[i+1])) == "ANY" {
+				opts.any = true
+				i++
+			}
+		case arg == "WITHCOORD" && mode != geoSearchStoreMode:
+			opts.withCoord = true
+		case arg == "WITHDIST" && mode != geoSearchStoreMode:
+			opts.withDist = true
+		case arg == "WITHHASH" && mode != geoSearchStoreMode:
+			opts.withHash = true
+		case arg == "STOREDIST" && mode == geoSearchStoreMode:
+			opts.storeDist = true
+		case (arg == "STORE" || arg == "STOREDIST") && mode == geoRadiusMode && remaining >= 1:
+			opts.storeKey = string(args[i+1])
+			opts.storeDist = arg == "STOREDIST"
+			i++
+		default:
+			return protocol.MakeSyntaxErrReply()
+		}
+	}
+
+	if opts.hasFromMember == opts.hasFromLonLat {
+		return protocol.MakeErrReply("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for " + cmdName)
+	}
+	if opts.byRadius == opts.byBox {
+		return protocol.MakeErrReply("ERR exactly one of BYRADIUS and BYBOX can be specified for " + cmdName)
+	}
+	if opts.any && !hasCount {
+		return protocol.MakeErrReply("ERR the ANY argument requires COUNT argument")
+	}
+	if opts.storeKey != "" && opts.withAny() {
+		return protocol.MakeErrReply("ERR STORE option in " + strings.ToUpper(cmdName) +
+			" is not compatible with WITHDIST, WITHHASH and WITHCOORD options")
+	}
+	// 指定 COUNT 而未指定排序时，按距离升序取最近的成员
+	if hasCount && !opts.any && opts.sort == geoSortNone {
+		opts.sort = geoSortAsc
+	}
+	return nil
+}
+
+// geoPoint 地理搜索命中的成员
+type geoPoint struct {
+	member string
+	hash   uint64
+	lat    float64
+	lng    float64
+	dist   float64 // 与中心的距离（米）
+}
+
+// geoSearch 在 sortedSet 中查找落在搜索形状内的成员，并按选项排序与截断
+//
+// 先通过 GeoHash 邻接区块确定候选范围，再对候选成员做精确的距离或矩形过滤
+func geoSearch(sortedSet *SortedSet.SortedSet, opts *geoSearchOptions) []*geoPoint {
+	var areas [][2]uint64
+	if opts.byBox {
+		areas = geohash.GetNeighboursInBox(opts.lat, opts.lng, opts.width, opts.height)
+	} else {
+		areas = geohash.GetNeighbours(opts.lat, opts.lng, opts.radius)
+	}
+
+	points := make([]*geoPoint, 0)
+	seen := make(map[string]struct{})
+	for _, area := range areas {
+		lower := &SortedSet.ScoreBorder{Value: float64(area[0])}
+		upper := &SortedSet.ScoreBorder{Value: float64(area[1])}
+		for _, elem := range sortedSet.Range(lower, upper, 0, -1, false) {
+			// 区块边界上的成员可能被相邻区块重复命中
+			if _, ok := seen[elem.Member]; ok {
+				continue
+			}
+			seen[elem.Member] = struct{}{}
+			hash := uint64(elem.Score)
+			lat, lng := geohash.Decode(hash)
+			var dist float64
+			if opts.byBox {
+				var ok bool
+				if dist, ok = geohash.DistanceIfInBox(opts.lat, opts.lng, opts.width, opts.height, lat, lng); !ok {
+					continue
+				}
+			} else {
+				if dist = geohash.Distance(opts.lat, opts.lng, lat, lng); dist > opts.radius {
+					continue
+				}
+			}
+			points = append(points, &geoPoint{member: elem.Member, hash: hash, lat: lat, lng: lng, dist: dist})
+			// ANY 找到足够数量后立即停止
+			if opts.any && len(points) >= opts.count {
+				break
+			}
+		}
+		if opts.any && len(points) >= opts.count {
+			break
+		}
+	}
+
+	switch opts.sort {
+	case geoSortAsc:
+		sort.SliceStable(points, func(i, j int) bool { return points[i].dist < points[j].dist })
+	case geoSortDesc:
+		sort.SliceStable(points, func(i, j int) bool { return points[i].dist > points[j].dist })
+	}
+	if opts.count > 0 && len(points) > opts.count {
+		points = points[:opts.count]
+	}
+	return points
+}
+
+// geoSearchReply 生成搜索结果，指定 WITH* 选项时每个成员为 [member, dist, hash, [lng, lat]] 形式的数组
+func geoSearchReply(points []*geoPoint, opts *geoSearchOptions) myredis.Reply {
+	if !opts.withAny() {
+		members := make([][]byte, len(points))
+		for i, point := range points {
+			members[i] = []byte(point.member)
+		}
+		return protocol.MakeMultiBulkReply(members)
+	}
+	items := make([]myredis.Reply, len(points))
+	for i, point := range points {
+		item := []myredis.Reply{protocol.MakeBulkReply([]byte(point.member))}
+		if opts.withDist {
+			item = append(item, protocol.MakeBulkReply([]byte(strconv.FormatFloat(point.dist/opts.unit, 'f', 4, 64))))
+		}
+		if opts.withHash {
+			// 与 Redis 一致返回 52 位整数形式的 GeoHash，避免 64 位编码溢出有符号整数
+			item = append(item, protocol.MakeIntReply(int64(point.hash>>12)))
+		}
+		if opts.withCoord {
+			item = append(item, protocol.MakeMultiBulkReply([][]byte{
+				[]byte(strconv.FormatFloat(point.lng, 'f', -1, 64)),
+				[]byte(strconv.FormatFloat(point.lat, 'f', -1, 64)),
+			}))
+		}
+		items[i] = protocol.MakeMultiRawReply(item)
+	}
+	return protocol.MakeMultiRawReply(items)
+}
+
+// storeGeoPoints 将搜索结果写入 dest，默认保留 GeoHash 分数，storeDist 时以距离（按单位换算）为分数
+func (db *DB) storeGeoPoints(cmdName string, dest string, points []*geoPoint, opts *geoSearchOptions, args [][]byte) myredis.Reply {
+	result := SortedSet.Make()
+	for _, point := range points {
+		if opts.storeDist {
+			result.Add(point.member, point.dist/opts.unit)
+		} else {
+			result.Add(point.member, float64(point.hash))
+		}
+	}
+	return db.storeZSetResult(cmdName, dest, result, args)
+}
+
+// runGeoSearch 定位搜索中心并执行搜索，FROMMEMBER 的成员不存在时返回错误
+func (db *DB) runGeoSearch(key string, opts *geoSearchOptions) ([]*geoPoint, myredis.Reply) {
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if opts.hasFromMember {
+		if sortedSet == nil {
+			return nil, protocol.MakeErrReply("ERR could not decode requested zset member")
+		}
+		element, ok := sortedSet.Get(opts.fromMember)
+		if !ok {
+			return nil, protocol.MakeErrReply("ERR could not decode requested zset member")
+		}
+		opts.lat, opts.lng = geohash.Decode(uint64(element.Score))
+	}
+	if sortedSet == nil {
+		return nil, nil
+	}
+	return geoSearch(sortedSet, opts), nil
+}
+
+// execGeoSearch: 在指定中心的圆形或矩形范围内搜索成员。
+//
+// 调用示例: GEOSEARCH key FROMMEMBER member|FROMLONLAT lng lat BYRADIUS radius unit|BYBOX width height unit
+// [ASC|DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+//
+// 返回值: 匹配的成员列表，指定 WITH* 选项时每项为包含附加信息的数组。
+func execGeoSearch(db *DB, args [][]byte) myredis.Reply {
+	opts := &geoSearchOptions{}
+	if errReply := parseGeoSearchOptions("geosearch", opts, args[1:], geoSearchMode); errReply != nil {
+		return errReply
+	}
+	points, errReply := db.runGeoSearch(string(args[0]), opts)
+	if errReply != nil {
+		return errReply
+	}
+	return geoSearchReply(points, opts)
+}
+
+// execGeoSearchStore: 与 GEOSEARCH 相同，但将结果保存到 destination。
+//
+// 调用示例: GEOSEARCHSTORE destination source FROMLONLAT 15 37 BYBOX 400 400 km ASC COUNT 3 STOREDIST
+//
+// 返回值: destination 中的成员数量。
+func execGeoSearchStore(db *DB, args [][]byte) myredis.Reply {
+	opts := &geoSearchOptions{}
+	if errReply := parseGeoSearchOptions("geosearchstore", opts, args[2:], geoSearchStoreMode); errReply != nil {
+		return errReply
+	}
+	points, errReply := db.runGeoSearch(string(args[1]), opts)
+	if errReply != nil {
+		return errReply
+	}
+	return db.storeGeoPoints("geosearchstore", string(args[0]), points, opts, args)
+}
+
+func init() {
+	registerCommand("GeoSearch", execGeoSearch, nil, -7, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
+	registerCommand("GeoSearchStore", execGeoSearchStore, rollbackFirstKey, -8, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 2, 1)
+}
@@ -1,13 +1,19 @@
 package database
 
 import (
+	"fmt"
+	"myredis/lib/geohash"
+	"myredis/lib/keyspec"
 	"myredis/lib/utils"
+	"myredis/protocol"
 	"myredis/protocol/assert"
+	"strconv"
+	"strings"
 	"testing"
 )
 
 func TestGeoHash(t *testing.T) {
-	execFlushDB(testDB, utils.ToCmdLine())
+	testDB.Flush()
 	key := utils.RandString(10)
 	pos := utils.RandString(10)
 	result := execGeoAdd(testDB, utils.ToCmdLine(key, "13.361389", "38.115556", pos))
@@ -17,7 +23,7 @@ func TestGeoHash(t *testing.T) {
 }
 
 func TestGeoRadius(t *testing.T) {
-	execFlushDB(testDB, utils.ToCmdLine())
+	testDB.Flush()
 	key := utils.RandString(10)
 	pos1 := utils.RandString(10)
 	pos2 := utils.RandString(10)
@@ -30,7 +36,7 @@ func TestGeoRadius(t *testing.T) {
 }
 
 func TestGeoRadiusByMember(t *testing.T) {
-	execFlushDB(testDB, utils.ToCmdLine())
+	testDB.Flush()
 	key := utils.RandString(10)
 	pos1 := utils.RandString(10)
 	pos2 := utils.RandString(10)
@@ -45,7 +51,7 @@ func TestGeoRadiusByMember(t *testing.T) {
 }
 
 func TestGeoPos(t *testing.T) {
-	execFlushDB(testDB, utils.ToCmdLine())
+	testDB.Flush()
 	key := utils.RandString(10)
 	pos1 := utils.RandString(10)
 	pos2 := utils.RandString(10)
@@ -58,3 +64,152 @@ func TestGeoPos(t *testing.T) {
 		t.Error("test failed")
 	}
 }
+
+func TestGeoSearch(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	execGeoAdd(testDB, utils.ToCmdLine(key,
+		"13.361389", "38.115556", "Palermo",
+		"15.087269", "37.502669", "Catania",
+		"12.758489", "38.788135", "edge1",
+		"17.241510", "38.788135", "edge2",
+	))
+
+	result := execGeoSearch(testDB, utils.ToCmdLine(key, "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC"))
+	assert.AssertMultiBulkReply(t, result, []string{"Catania", "Palermo"})
+	result = execGeoSearch(testDB, utils.ToCmdLine(key, "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "DESC"))
+	assert.AssertMultiBulkReply(t, result, []string{"Palermo", "Catania"})
+	result = execGeoSearch(testDB, utils.ToCmdLine(key, "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "ASC"))
+	assert.AssertMultiBulkReply(t, result, []string{"Catania", "Palermo", "edge2", "edge1"})
+	result = execGeoSearch(testDB, utils.ToCmdLine(key, "FROMMEMBER", "Palermo", "BYRADIUS", "200", "km", "COUNT", "1"))
+	assert.AssertMultiBulkReply(t, result, []string{"Palermo"})
+	result = execGeoSearch(testDB, utils.ToCmdLine(key, "FROMLONLAT", "15", "37", "BYRADIUS", "124.3", "mi", "ASC"))
+	assert.AssertMultiBulkReply(t, result, []string{"Catania", "Palermo"})
+
+	result = execGeoSearch(testDB, utils.ToCmdLine(key, "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km",
+		"ASC", "WITHDIST", "WITHHASH", "WITHCOORD"))
+	expected := "*2\r\n" +
+		"*4\r\n$7\r\nCatania\r\n$7\r\n56.4412\r\n:3476216502357864\r\n" +
+		"*2\r\n$17\r\n15.08726750034839\r\n$18\r\n37.502667924854904\r\n"
+	if !strings.HasPrefix(string(result.ToBytes()), expected) {
+		t.Errorf("unexpected reply %q", result.ToBytes())
+	}
+
+	// 错误处理
+	result = execGeoSearch(testDB, utils.ToCmdLine(key, "FROMLONLAT", "15", "37", "BYRADIUS", "200", "yd"))
+	assert.AssertErrReply(t, result, "ERR unsupported unit provided. please use M, KM, FT, MI")
+	result = execGeoSearch(testDB, utils.ToCmdLine(key, "FROMMEMBER", "nx", "BYRADIUS", "200", "km"))
+	assert.AssertErrReply(t, result, "ERR could not decode requested zset member")
+	result = execGeoSearch(testDB, utils.ToCmdLine(key, "FROMMEMBER", "Palermo", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km"))
+	assert.AssertErrReply(t, result, "ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for geosearch")
+	result = execGeoSearch(testDB, utils.ToCmdLine(key, "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "BYBOX", "1", "1", "km"))
+	assert.AssertErrReply(t, result, "ERR exactly one of BYRADIUS and BYBOX can be specified for geosearch")
+	result = execGeoSearch(testDB, utils.ToCmdLine(key, "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ANY"))
+	assert.AssertErrReply(t, result, "Err syntax error")
+	result = execGeoSearch(testDB, utils.ToCmdLine(key, "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "COUNT", "0"))
+	assert.AssertErrReply(t, result, "ERR COUNT must be > 0")
+
+	// key 不存在
+	result = execGeoSearch(testDB, utils.ToCmdLine(utils.RandString(10), "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km"))
+	assert.AssertMultiBulkReplySize(t, result, 0)
+}
+
+func TestGeoSearchStore(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	dest := utils.RandString(10)
+	execGeoAdd(testDB, utils.ToCmdLine(key,
+		"13.361389", "38.115556", "Palermo",
+		"15.087269", "37.502669", "Catania",
+	))
+	result := execGeoSearchStore(testDB, utils.ToCmdLine(dest, key, "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km"))
+	assert.AssertIntReply(t, result, 2)
+	result = execGeoDist(testDB, utils.ToCmdLine(dest, "Palermo", "Catania", "km"))
+	assert.AssertBulkReply(t, result, "166.2743")
+
+	result = execGeoSearchStore(testDB, utils.ToCmdLine(dest, key, "FROMLONLAT", "15", "37",
+		"BYRADIUS", "200", "km", "COUNT", "1", "STOREDIST"))
+	assert.AssertIntReply(t, result, 1)
+	result = execZScore(testDB, utils.ToCmdLine(dest, "Catania"))
+	assert.AssertBulkReply(t, result, "56.44120345978601")
+
+	result = execGeoSearchStore(testDB, utils.ToCmdLine(dest, key, "FROMLONLAT", "15", "37",
+		"BYRADIUS", "200", "km", "WITHDIST"))
+	assert.AssertErrReply(t, result, "Err syntax error")
+
+	// 结果为空时删除 destination
+	result = execGeoSearchStore(testDB, utils.ToCmdLine(dest, key, "FROMLONLAT", "0", "0", "BYRADIUS", "1", "km"))
+	assert.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("exists", dest))
+	assert.AssertIntReply(t, result, 0)
+}
+
+func TestGeoRadiusOptions(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	dest := utils.RandString(10)
+	execGeoAdd(testDB, utils.ToCmdLine(key,
+		"13.361389", "38.115556", "Palermo",
+		"15.087269", "37.502669", "Catania",
+	))
+	result := execGeoRadius(testDB, utils.ToCmdLine(key, "15", "37", "200", "km", "WITHDIST", "ASC"))
+	expected := "*2\r\n*2\r\n$7\r\nCatania\r\n$7\r\n56.4412\r\n*2\r\n$7\r\nPalermo\r\n$8\r\n190.4425\r\n"
+	if string(result.ToBytes()) != expected {
+		t.Errorf("unexpected reply %q", result.ToBytes())
+	}
+	result = execGeoRadiusByMember(testDB, utils.ToCmdLine(key, "Palermo", "100", "mi", "STORE", dest))
+	assert.AssertIntReply(t, result, 1)
+	result = execGeoRadius(testDB, utils.ToCmdLine(key, "15", "37", "200", "km", "WITHDIST", "STORE", dest))
+	assert.AssertErrReply(t, result,
+		"ERR STORE option in GEORADIUS is not compatible with WITHDIST, WITHHASH and WITHCOORD options")
+	write, read := keyspec.GeoRadius(5)(utils.ToCmdLine(key, "15", "37", "200", "km", "COUNT", "1", "STOREDIST", dest))
+	if len(write) != 1 || write[0] != dest || len(read) != 1 || read[0] != key {
+		t.Errorf("unexpected keys %v %v", write, read)
+	}
+}
+
+// 大半径与高纬度的搜索结果应与逐个成员计算距离的结果一致
+func TestGeoSearchLargeArea(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	args := []string{key}
+	for lng := -175; lng <= 175; lng += 25 {
+		for _, lat := range []int{-85, -60, -20, 0, 20, 60, 85} {
+			args = append(args, strconv.Itoa(lng), strconv.Itoa(lat), fmt.Sprintf("%d,%d", lng, lat))
+		}
+	}
+	execGeoAdd(testDB, utils.ToCmdLine(args...))
+	sortedSet, _ := testDB.getAsSortedSet(key)
+	members := sortedSet.RangeByRank(0, sortedSet.Len(), false)
+
+	centers := [][2]float64{{0, 85}, {100, 85}, {-170, -85}, {0, 0}, {150, 0}}
+	for _, center := range centers {
+		for _, radius := range []float64{500, 1000, 1500, 5000, 15000} {
+			expect := 0
+			for _, elem := range members {
+				lat, lng := geohash.Decode(uint64(elem.Score))
+				if geohash.Distance(center[1], center[0], lat, lng) <= radius*1000 {
+					expect++
+				}
+			}
+			lng, lat := strconv.FormatFloat(center[0], 'f', -1, 64), strconv.FormatFloat(center[1], 'f', -1, 64)
+			r := strconv.FormatFloat(radius, 'f', -1, 64)
+			result := execGeoSearch(testDB, utils.ToCmdLine(key, "FROMLONLAT", lng, lat, "BYRADIUS", r, "km"))
+			if n := len(result.(*protocol.MultiBulkReply).Args); n != expect {
+				t.Errorf("radius %s km from (%s, %s): expect %d members, actually %d", r, lng, lat, expect, n)
+			}
+
+			expect = 0
+			for _, elem := range members {
+				lat, lng := geohash.Decode(uint64(elem.Score))
+				if _, ok := geohash.DistanceIfInBox(center[1], center[0], radius*1000, radius*1000, lat, lng); ok {
+					expect++
+				}
+			}
+			result = execGeoSearch(testDB, utils.ToCmdLine(key, "FROMLONLAT", lng, lat, "BYBOX", r, r, "km"))
+			if n := len(result.(*protocol.MultiBulkReply).Args); n != expect {
+				t.Errorf("box %s km from (%s, %s): expect %d members, actually %d", r, lng, lat, expect, n)
+			}
+		}
+	}
+}
@@ -19,6 +19,10 @@ func (db *DB) getAsDict(key string) (Dict.Dict, protocol.ErrorReply) {
 	if !ok {
 		return nil, &protocol.WrongTypeErrReply{}
 	}
+	// 惰性删除过期的字段
+	if hash, ok := dict.(*Dict.ExpireDict); ok && db.expireFields(key, hash) {
+		return nil, nil
+	}
 	return dict, nil
 }
 
@@ -29,7 +33,7 @@ func (db *DB) getOrInitDict(key string) (dict Dict.Dict, inited bool, errReply p
 	}
 	inited = false
 	if dict == nil {
-		dict = Dict.MakeSimple()
+		dict = Dict.MakeCompact()
 		db.PutEntity(key, &database.DataEntity{
 			Data: dict,
 		})
@@ -478,91 +482,61 @@ func execHRandMember(db *DB, args [][]byte) myredis.Reply {
 
 // 处理 HScan 命令，增量式迭代哈希表中的字段。示例：HSCAN myhash 0 MATCH field* COUNT 10
 func execHScan(db *DB, args [][]byte) myredis.Reply {
-	var count int = 10
-	var pattern string = "*"
-	if len(args) > 2 {
-		for i := 2; i < len(args); i++ {
-			arg := strings.ToLower(string(args[i]))
-			if arg == "count" {
-				tempCount, err := strconv.Atoi(string(args[i+1]))
-				if err != nil {
-					return protocol.MakeSyntaxErrReply()
-				}
-				count = tempCount
-				i++
-			} else if arg == "match" {
-				pattern = string(args[i+1])
-				i++
-			} else {
-				return protocol.MakeSyntaxErrReply()
-			}
-		}
-	}
-	if len(args) < 2 {
-		return protocol.MakeSyntaxErrReply()
-	}
-
-	key := string(args[0])
-	dict, errReply := db.getAsDict(key)
+	opts, errReply := parseScanArgs(args[1], args[2:], false)
 	if errReply != nil {
 		return errReply
 	}
-	if dict == nil {
-		return protocol.MakeNullBulkReply()
+	hash, errReply := db.getAsDict(string(args[0]))
+	if errReply != nil {
+		return errReply
 	}
-
-	cursor, err := strconv.Atoi(string(args[1]))
-	if err != nil {
-		return protocol.MakeErrReply("ERR invalid cursor")
+	// 键不存在时视为空集合
+	if hash == nil {
+		return makeScanReply(0, nil)
 	}
-
-	replys, nextCursor := dict.DictScan(cursor, count, pattern)
+	elements, nextCursor := hash.DictScan(opts.cursor, opts.count, opts.pattern)
 	if nextCursor < 0 {
-		return protocol.MakeErrReply("Invalid argument")
+		return protocol.MakeErrReply("ERR illegal wildcard")
 	}
-
-	result := make([]myredis.Reply, 2)
-	result[0] = protocol.MakeBulkReply([]byte(strconv.FormatInt(int64(nextCursor), 10)))
-	result[1] = protocol.MakeMultiBulkReply(replys)
-	return protocol.MakeMultiRawReply(result)
+	return makeScanReply(nextCursor, elements)
 }
 
 func init() {
-	registerCommand("HSet", execHSet, writeFirstKey, undoHSet, 4, flagWrite).
+	registerCommand("HSet", execHSet, undoHSet, 4, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
-	registerCommand("HSetNX", execHSetNX, writeFirstKey, undoHSet, 4, flagWrite).
+	registerCommand("HSetNX", execHSetNX, undoHSet, 4, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
-	registerCommand("HGet", execHGet, readFirstKey, nil, 3, flagReadOnly).
+	registerCommand("HGet", execHGet, nil, 3, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("HGetAll", execHGetAll, readFirstKey, nil, 2, flagReadOnly).
+	registerCommand("HGetAll", execHGetAll, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom}, 1, 1, 1)
-	registerCommand("HExists", execHExists, readFirstKey, nil, 3, flagReadOnly).
+	registerCommand("HExists", execHExists, nil, 3, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("HKeys", execHKeys, readFirstKey, nil, 2, flagReadOnly).
+	registerCommand("HKeys", execHKeys, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagSortForScript}, 1, 1, 1)
-	registerCommand("HVals", execHVals, readFirstKey, nil, 2, flagReadOnly).
+	registerCommand("HVals", execHVals, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagSortForScript}, 1, 1, 1)
 
-	registerCommand("HDel", execHDel, writeFirstKey, undoHDel, -3, flagWrite).
+	registerCommand("HDel", execHDel, undoHDel, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
 
-	registerCommand("HLen", execHLen, readFirstKey, nil, 2, flagReadOnly).
+	registerCommand("HLen", execHLen, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("HStrlen", execHStrlen, readFirstKey, nil, 3, flagReadOnly).
+	registerCommand("HStrlen", execHStrlen, nil, 3, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
 
-	registerCommand("HMSet", execHMSet, writeFirstKey, undoHMSet, -4, flagWrite).
+	registerCommand("HMSet", execHMSet, undoHMSet, -4, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
-	registerCommand("HMGet", execHMGet, readFirstKey, nil, -3, flagReadOnly).
+	registerCommand("HMGet", execHMGet, nil, -3, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
 
-	registerCommand("HIncrBy", execHIncrBy, writeFirstKey, undoHIncr, 4, flagWrite).
+	registerCommand("HIncrBy", execHIncrBy, undoHIncr, 4, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
-	registerCommand("HIncrByFloat", execHIncrByFloat, writeFirstKey, undoHIncr, 4, flagWrite).
+	registerCommand("HIncrByFloat", execHIncrByFloat, undoHIncr, 4, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
 
-	registerCommand("HRandField", execHRandMember, readFirstKey, nil, -2, flagReadOnly).
+	registerCommand("HRandField", execHRandMember, nil, -2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagRandom, redisFlagReadonly}, 1, 1, 1)
-	registerCommand("HScan", execHScan, readFirstKey, nil, -2, flagReadOnly).
+	registerCommand("HScan", execHScan, nil, -3, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagSortForScript}, 1, 1, 1)
 }
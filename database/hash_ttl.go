@@ -0,0 +1,614 @@
+package database
+
+import (
+	"myredis/aof"
+	Dict "myredis/datastruct/dict"
+	"myredis/interface/database"
+	"myredis/interface/myredis"
+	"myredis/lib/timewheel"
+	"myredis/lib/utils"
+	"myredis/protocol"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 字段过期时间的上限（毫秒），与 Redis 的 EB_EXPIRE_TIME_MAX 相同
+const fieldExpireTimeMax = int64(1)<<48 - 1
+
+// HEXPIRE 等命令对单个字段的返回值
+const (
+	fieldNotExists    = -2 // 字段不存在
+	fieldNoTTL        = -1 // 字段没有过期时间
+	fieldNotUpdated   = 0  // 条件不满足，未设置过期时间
+	fieldTTLUpdated   = 1  // 已设置过期时间
+	fieldTTLDeleted   = 2  // 过期时间已过，字段被删除
+	fieldTTLPersisted = 1  // 已移除过期时间
+)
+
+// ******************** 字段过期 ********************
+
+// 删除哈希中已经过期的字段，哈希因此变为空时删除整个键并返回 true
+func (db *DB) expireFields(key string, hash *Dict.ExpireDict) bool {
+	now := time.Now().UnixMilli()
+	if next, ok := hash.NextExpire(); !ok || next > now {
+		return false
+	}
+	db.preserve(key)
+	hash.RemoveExpired(now)
+	if hash.Len() == 0 {
+		db.Remove(key)
+		return true
+	}
+	return false
+}
+
+func genFieldExpireTask(key string) string {
+	return "hexpire:" + key
+}
+
+// 按哈希中最早的字段过期时间安排定时清理任务，实现字段的主动过期
+func (db *DB) scheduleFieldExpire(key string, hash *Dict.ExpireDict) {
+	taskKey := genFieldExpireTask(key)
+	next, ok := hash.NextExpire()
+	if !ok {
+		timewheel.Cancel(taskKey)
+		return
+	}
+	timewheel.At(time.UnixMilli(next), taskKey, func() {
+		keys := []string{key}
+		db.writeGate.RLock()
+		defer db.writeGate.RUnlock()
+		db.RWLocks(keys, nil)
+		defer db.RWUnLocks(keys, nil)
+
+		entity, ok := db.peekEntity(key)
+		if !ok {
+			return
+		}
+		// key 已经被删除或覆盖时不再是同一个哈希，无需处理
+		hash, ok := entity.Data.(*Dict.ExpireDict)
+		if !ok || db.expireFields(key, hash) {
+			return
+		}
+		db.scheduleFieldExpire(key, hash)
+	})
+}
+
+// 将哈希转换为可以记录字段过期时间的字典
+func (db *DB) getAsExpireDict(key string, hash Dict.Dict) *Dict.ExpireDict {
+	if expireDict, ok := hash.(*Dict.ExpireDict); ok {
+		return expireDict
+	}
+	expireDict := Dict.MakeExpireDict(hash)
+	db.PutEntity(key, &database.DataEntity{Data: expireDict})
+	return expireDict
+}
+
+// ******************** 参数解析 ********************
+
+// 解析 FIELDS numfields field [field ...]，pairs 为 true 时每个字段后跟一个值
+func parseFieldsArg(args [][]byte, pairs bool) ([][]byte, protocol.ErrorReply) {
+	if len(args) < 2 || strings.ToUpper(string(args[0])) != "FIELDS" {
+		return nil, protocol.MakeErrReply("ERR Mandatory argument FIELDS is missing or not at the right position")
+	}
+	numFields, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil || numFields <= 0 {
+		return nil, protocol.MakeErrReply("ERR Parameter `numFields` should be greater than 0")
+	}
+	width := int64(1)
+	if pairs {
+		width = 2
+	}
+	if numFields*width != int64(len(args)-2) {
+		return nil, protocol.MakeErrReply("ERR The `numfields` parameter must match the number of arguments")
+	}
+	return args[2:], nil
+}
+
+// 将过期时间参数转换为 Unix 毫秒时间戳，unit 为每单位的毫秒数，absolute 表示参数本身是时间戳
+func parseFieldExpireTime(cmdName string, arg []byte, unit int64, absolute bool) (int64, protocol.ErrorReply) {
+	expire, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if expire < 0 {
+		return 0, protocol.MakeErrReply("ERR invalid expire time, must be >= 0")
+	}
+	if expire > fieldExpireTimeMax/unit {
+		return 0, protocol.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+	}
+	expire *= unit
+	if !absolute {
+		expire += time.Now().UnixMilli()
+	}
+	if expire > fieldExpireTimeMax {
+		return 0, protocol.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+	}
+	return expire, nil
+}
+
+// 每个字段一个整数的返回值
+func fieldCodesReply(codes []int64) myredis.Reply {
+	replies := make([]myredis.Reply, len(codes))
+	for i, code := range codes {
+		replies[i] = protocol.MakeIntReply(code)
+	}
+	return protocol.MakeMultiRawReply(replies)
+}
+
+// 字段均不存在时的返回值
+func fieldsNotExistReply(fields [][]byte) myredis.Reply {
+	codes := make([]int64, len(fields))
+	for i := range codes {
+		codes[i] = fieldNotExists
+	}
+	return fieldCodesReply(codes)
+}
+
+// 删除字段并在哈希为空时删除键，写入 HDEL 命令到 AOF
+func (db *DB) removeFields(key string, hash Dict.Dict, fields []string) {
+	if len(fields) == 0 {
+		return
+	}
+	for _, field := range fields {
+		hash.Remove(field)
+	}
+	if hash.Len() == 0 {
+		db.Remove(key)
+	}
+	db.addAof(utils.ToCmdLine2("hdel", append([]string{key}, fields...)...))
+}
+
+// 为字段设置相同的过期时间并写入 HPEXPIREAT 命令到 AOF
+func (db *DB) setFieldsExpire(key string, hash *Dict.ExpireDict, fields []string, expireAt int64) {
+	if len(fields) == 0 {
+		return
+	}
+	for _, field := range fields {
+		hash.SetExpire(field, expireAt)
+	}
+	db.scheduleFieldExpire(key, hash)
+	db.addAof(makeFieldsCmd("hpexpireat", key, strconv.FormatInt(expireAt, 10), fields))
+}
+
+// 生成 <cmd> key [arg] FIELDS numfields field [field ...] 形式的命令行
+func makeFieldsCmd(cmdName string, key string, arg string, fields []string) CmdLine {
+	cmdLine := utils.ToCmdLine(cmdName, key)
+	if arg != "" {
+		cmdLine = append(cmdLine, []byte(arg))
+	}
+	cmdLine = append(cmdLine, []byte("FIELDS"), []byte(strconv.Itoa(len(fields))))
+	for _, field := range fields {
+		cmdLine = append(cmdLine, []byte(field))
+	}
+	return cmdLine
+}
+
+// ******************** HEXPIRE ********************
+
+// 设置字段过期时间的公共逻辑，unit 为每单位的毫秒数，absolute 表示参数为时间戳
+//
+// 格式: <cmd> key time [NX|XX|GT|LT] FIELDS numfields field [field ...]
+func execFieldExpire(cmdName string, unit int64, absolute bool) ExecFunc {
+	return func(db *DB, args [][]byte) myredis.Reply {
+		key := string(args[0])
+		expireAt, errReply := parseFieldExpireTime(cmdName, args[1], unit, absolute)
+		if errReply != nil {
+			return errReply
+		}
+		condition := ""
+		rest := args[2:]
+		if len(rest) > 0 {
+			switch flag := strings.ToUpper(string(rest[0])); flag {
+			case "NX", "XX", "GT", "LT":
+				condition = flag
+				rest = rest[1:]
+			}
+		}
+		fields, errReply := parseFieldsArg(rest, false)
+		if errReply != nil {
+			return errReply
+		}
+
+		hash, errReply := db.getAsDict(key)
+		if errReply != nil {
+			return errReply
+		}
+		if hash == nil {
+			return fieldsNotExistReply(fields)
+		}
+		expireDict := db.getAsExpireDict(key, hash)
+		expired := expireAt <= time.Now().UnixMilli()
+		codes := make([]int64, len(fields))
+		var updated, deleted []string
+		for i, arg := range fields {
+			field := string(arg)
+			if _, exists := expireDict.Get(field); !exists {
+				codes[i] = fieldNotExists
+				continue
+			}
+			current, hasTTL := expireDict.GetExpire(field)
+			// 没有过期时间的字段视为永不过期
+			if (condition == "NX" && hasTTL) || (condition == "XX" && !hasTTL) ||
+				(condition == "GT" && (!hasTTL || expireAt <= current)) ||
+				(condition == "LT" && hasTTL && expireAt >= current) {
+				codes[i] = fieldNotUpdated
+				continue
+			}
+			if expired {
+				codes[i] = fieldTTLDeleted
+				deleted = append(deleted, field)
+			} else {
+				codes[i] = fieldTTLUpdated
+				updated = append(updated, field)
+			}
+		}
+		db.setFieldsExpire(key, expireDict, updated, expireAt)
+		db.removeFields(key, expireDict, deleted)
+		return fieldCodesReply(codes)
+	}
+}
+
+// ******************** HTTL ********************
+
+// 查询字段过期时间的公共逻辑，convert 将过期毫秒时间戳转换为返回值
+//
+// 格式: <cmd> key FIELDS numfields field [field ...]
+func execFieldTTL(convert func(expireAt int64, now int64) int64) ExecFunc {
+	return func(db *DB, args [][]byte) myredis.Reply {
+		key := string(args[0])
+		fields, errReply := parseFieldsArg(args[1:], false)
+		if errReply != nil {
+			return errReply
+		}
+		hash, errReply := db.getAsDict(key)
+		if errReply != nil {
+			return errReply
+		}
+		if hash == nil {
+			return fieldsNotExistReply(fields)
+		}
+		expireDict, _ := hash.(*Dict.ExpireDict)
+		now := time.Now().UnixMilli()
+		codes := make([]int64, len(fields))
+		for i, arg := range fields {
+			field := string(arg)
+			if _, exists := hash.Get(field); !exists {
+				codes[i] = fieldNotExists
+				continue
+			}
+			codes[i] = fieldNoTTL
+			if expireDict != nil {
+				if expireAt, ok := expireDict.GetExpire(field); ok {
+					codes[i] = convert(expireAt, now)
+				}
+			}
+		}
+		return fieldCodesReply(codes)
+	}
+}
+
+// execHPersist: 移除字段的过期时间。示例：HPERSIST myhash FIELDS 2 field1 field2
+func execHPersist(db *DB, args [][]byte) myredis.Reply {
+	key := string(args[0])
+	fields, errReply := parseFieldsArg(args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+	hash, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if hash == nil {
+		return fieldsNotExistReply(fields)
+	}
+	expireDict, _ := hash.(*Dict.ExpireDict)
+	codes := make([]int64, len(fields))
+	var persisted []string
+	for i, arg := range fields {
+		field := string(arg)
+		if _, exists := hash.Get(field); !exists {
+			codes[i] = fieldNotExists
+			continue
+		}
+		codes[i] = fieldNoTTL
+		if expireDict != nil && expireDict.Persist(field) {
+			codes[i] = fieldTTLPersisted
+			persisted = append(persisted, field)
+		}
+	}
+	if len(persisted) > 0 {
+		db.scheduleFieldExpire(key, expireDict)
+		db.addAof(makeFieldsCmd("hpersist", key, "", persisted))
+	}
+	return fieldCodesReply(codes)
+}
+
+// ******************** HGETEX / HSETEX / HGETDEL ********************
+
+// 取出字段的值，字段不存在时为 nil
+func getFieldValues(hash Dict.Dict, fields [][]byte) [][]byte {
+	values := make([][]byte, len(fields))
+	if hash == nil {
+		return values
+	}
+	for i, field := range fields {
+		if val, ok := hash.Get(string(field)); ok {
+			values[i], _ = val.([]byte)
+		}
+	}
+	return values
+}
+
+// 解析 EX/PX/EXAT/PXAT 选项的过期时间
+func parseFieldExpireOption(cmdName string, option string, arg []byte) (int64, protocol.ErrorReply) {
+	switch option {
+	case "EX":
+		return parseFieldExpireTime(cmdName, arg, 1000, false)
+	case "PX":
+		return parseFieldExpireTime(cmdName, arg, 1, false)
+	case "EXAT":
+		return parseFieldExpireTime(cmdName, arg, 1000, true)
+	}
+	return parseFieldExpireTime(cmdName, arg, 1, true)
+}
+
+// execHGetEx: 获取字段的值，并可以设置或移除它们的过期时间。
+//
+// 格式: HGETEX key [EX seconds|PX milliseconds|EXAT unix-time-seconds|PXAT unix-time-milliseconds|PERSIST]
+// FIELDS numfields field [field ...]
+func execHGetEx(db *DB, args [][]byte) myredis.Reply {
+	key := string(args[0])
+	option := ""
+	var expireAt int64
+	rest := args[1:]
+	for len(rest) > 0 {
+		arg := strings.ToUpper(string(rest[0]))
+		if arg == "FIELDS" {
+			break
+		}
+		switch {
+		case arg == "PERSIST" || ((arg == "EX" || arg == "PX" || arg == "EXAT" || arg == "PXAT") && len(rest) > 1):
+			if option != "" {
+				return protocol.MakeErrReply("ERR Only one of EX, PX, EXAT, PXAT or PERSIST arguments can be specified")
+			}
+			option = arg
+			if arg != "PERSIST" {
+				var errReply protocol.ErrorReply
+				if expireAt, errReply = parseFieldExpireOption("hgetex", arg, rest[1]); errReply != nil {
+					return errReply
+				}
+				rest = rest[1:]
+			}
+			rest = rest[1:]
+		default:
+			return protocol.MakeSyntaxErrReply()
+		}
+	}
+	fields, errReply := parseFieldsArg(rest, false)
+	if errReply != nil {
+		return errReply
+	}
+
+	hash, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	values := getFieldValues(hash, fields)
+	if hash == nil || option == "" {
+		return protocol.MakeMultiBulkReply(values)
+	}
+	var existing []string
+	for i, field := range fields {
+		if values[i] != nil {
+			existing = append(existing, string(field))
+		}
+	}
+	if len(existing) == 0 {
+		return protocol.MakeMultiBulkReply(values)
+	}
+	switch {
+	case option == "PERSIST":
+		if expireDict, ok := hash.(*Dict.ExpireDict); ok {
+			var persisted []string
+			for _, field := range existing {
+				if expireDict.Persist(field) {
+					persisted = append(persisted, field)
+				}
+			}
+			if len(persisted) > 0 {
+				db.scheduleFieldExpire(key, expireDict)
+				db.addAof(makeFieldsCmd("hpersist", key, "", persisted))
+			}
+		}
+	case expireAt <= time.Now().UnixMilli():
+		db.removeFields(key, hash, existing)
+	default:
+		db.setFieldsExpire(key, db.getAsExpireDict(key, hash), existing, expireAt)
+	}
+	return protocol.MakeMultiBulkReply(values)
+}
+
+// execHSetEx: 设置字段的值，并可以同时设置它们的过期时间。
+//
+// 格式: HSETEX key [FNX|FXX] [EX seconds|PX milliseconds|EXAT unix-time-seconds|PXAT unix-time-milliseconds|KEEPTTL]
+// FIELDS numfields field value [field value ...]
+//
+// FNX 仅当所有字段都不存在时设置，FXX 仅当所有字段都存在时设置；不指定 KEEPTTL 时字段原有的过期时间被移除。
+// 返回值: 设置成功返回 1，条件不满足返回 0。
+func execHSetEx(db *DB, args [][]byte) myredis.Reply {
+	key := string(args[0])
+	condition := ""
+	option := ""
+	var expireAt int64
+	rest := args[1:]
+	for len(rest) > 0 {
+		arg := strings.ToUpper(string(rest[0]))
+		if arg == "FIELDS" {
+			break
+		}
+		switch {
+		case arg == "FNX" || arg == "FXX":
+			if condition != "" {
+				return protocol.MakeErrReply("ERR Only one of FXX or FNX arguments can be specified")
+			}
+			condition = arg
+			rest = rest[1:]
+		case arg == "KEEPTTL" || ((arg == "EX" || arg == "PX" || arg == "EXAT" || arg == "PXAT") && len(rest) > 1):
+			if option != "" {
+				return protocol.MakeErrReply("ERR Only one of EX, PX, EXAT, PXAT or KEEPTTL arguments can be specified")
+			}
+			option = arg
+			if arg != "KEEPTTL" {
+				var errReply protocol.ErrorReply
+				if expireAt, errReply = parseFieldExpireOption("hsetex", arg, rest[1]); errReply != nil {
+					return errReply
+				}
+				rest = rest[1:]
+			}
+			rest = rest[1:]
+		default:
+			return protocol.MakeSyntaxErrReply()
+		}
+	}
+	pairs, errReply := parseFieldsArg(rest, true)
+	if errReply != nil {
+		return errReply
+	}
+
+	hash, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if condition != "" {
+		for i := 0; i < len(pairs); i += 2 {
+			exists := false
+			if hash != nil {
+				_, exists = hash.Get(string(pairs[i]))
+			}
+			if exists == (condition == "FNX") {
+				return protocol.MakeIntReply(0)
+			}
+		}
+	}
+	if hash == nil {
+		hash, _, errReply = db.getOrInitDict(key)
+		if errReply != nil {
+			return errReply
+		}
+	}
+
+	fields := make([]string, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		field := string(pairs[i])
+		fields = append(fields, field)
+		// KEEPTTL 更新已有字段时保留其过期时间
+		if option != "KEEPTTL" || hash.PutIfExists(field, pairs[i+1]) == 0 {
+			hash.Put(field, pairs[i+1])
+		}
+	}
+	switch option {
+	case "", "KEEPTTL":
+		cmdLine := utils.ToCmdLine("hsetex", key)
+		if option == "KEEPTTL" {
+			cmdLine = append(cmdLine, []byte("KEEPTTL"))
+		}
+		db.addAof(append(cmdLine, rest...))
+	default:
+		if expireAt <= time.Now().UnixMilli() {
+			db.removeFields(key, hash, fields)
+			break
+		}
+		db.addAof(append(utils.ToCmdLine("hsetex", key, "PXAT", strconv.FormatInt(expireAt, 10)), rest...))
+		expireDict := db.getAsExpireDict(key, hash)
+		for _, field := range fields {
+			expireDict.SetExpire(field, expireAt)
+		}
+		db.scheduleFieldExpire(key, expireDict)
+	}
+	return protocol.MakeIntReply(1)
+}
+
+// execHGetDel: 获取字段的值并删除这些字段，哈希为空时删除键。示例：HGETDEL myhash FIELDS 2 field1 field2
+func execHGetDel(db *DB, args [][]byte) myredis.Reply {
+	key := string(args[0])
+	fields, errReply := parseFieldsArg(args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+	hash, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	values := getFieldValues(hash, fields)
+	var existing []string
+	for i, field := range fields {
+		if values[i] != nil {
+			existing = append(existing, string(field))
+		}
+	}
+	db.removeFields(key, hash, existing)
+	return protocol.MakeMultiBulkReply(values)
+}
+
+// ******************** 还原 ********************
+
+// 删除从 RDB 文件或 DUMP 数据还原的哈希中已经过期的字段，哈希因此变为空时返回 false
+func purgeRestoredFields(entity *database.DataEntity) bool {
+	hash, ok := entity.Data.(*Dict.ExpireDict)
+	if !ok {
+		return true
+	}
+	hash.RemoveExpired(time.Now().UnixMilli())
+	return hash.Len() > 0
+}
+
+// 为还原的哈希写入字段过期时间到 AOF，并安排字段的主动过期
+func (db *DB) restoreFieldExpires(key string, entity *database.DataEntity) {
+	hash, ok := entity.Data.(*Dict.ExpireDict)
+	if !ok {
+		return
+	}
+	for _, cmd := range aof.FieldExpireCmds(key, entity) {
+		db.addAof(cmd.Args)
+	}
+	db.scheduleFieldExpire(key, hash)
+}
+
+func init() {
+	registerCommand("HExpire", execFieldExpire("hexpire", 1000, false), rollbackFirstKey, -6, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
+	registerCommand("HPExpire", execFieldExpire("hpexpire", 1, false), rollbackFirstKey, -6, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
+	registerCommand("HExpireAt", execFieldExpire("hexpireat", 1000, true), rollbackFirstKey, -6, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
+	registerCommand("HPExpireAt", execFieldExpire("hpexpireat", 1, true), rollbackFirstKey, -6, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
+
+	registerCommand("HTTL", execFieldTTL(func(expireAt, now int64) int64 {
+		return (expireAt - now + 999) / 1000
+	}), nil, -5, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom, redisFlagFast}, 1, 1, 1)
+	registerCommand("HPTTL", execFieldTTL(func(expireAt, now int64) int64 {
+		return expireAt - now
+	}), nil, -5, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom, redisFlagFast}, 1, 1, 1)
+	registerCommand("HExpireTime", execFieldTTL(func(expireAt, now int64) int64 {
+		return (expireAt + 999) / 1000
+	}), nil, -5, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
+	registerCommand("HPExpireTime", execFieldTTL(func(expireAt, now int64) int64 {
+		return expireAt
+	}), nil, -5, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
+
+	registerCommand("HPersist", execHPersist, rollbackFirstKey, -5, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
+	registerCommand("HGetEx", execHGetEx, rollbackFirstKey, -5, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
+	registerCommand("HSetEx", execHSetEx, rollbackFirstKey, -6, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
+	registerCommand("HGetDel", execHGetDel, rollbackFirstKey, -5, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
+}
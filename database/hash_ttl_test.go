@@ -0,0 +1,235 @@
+package database
+
+import (
+	Dict "myredis/datastruct/dict"
+	"myredis/interface/database"
+	"myredis/interface/myredis"
+	"myredis/lib/utils"
+	"myredis/protocol"
+	"myredis/protocol/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// 检查每个字段一个整数的返回值
+func assertFieldCodes(t *testing.T, actual myredis.Reply, expected ...int64) {
+	t.Helper()
+	replies := make([]myredis.Reply, len(expected))
+	for i, code := range expected {
+		replies[i] = protocol.MakeIntReply(code)
+	}
+	if string(actual.ToBytes()) != string(protocol.MakeMultiRawReply(replies).ToBytes()) {
+		t.Errorf("expected %v, actually %q", expected, actual.ToBytes())
+	}
+}
+
+func TestHExpire(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("hexpire", key, "100", "FIELDS", "1", "a")), -2)
+	testDB.Exec(nil, utils.ToCmdLine("hset", key, "a", "1"))
+	testDB.Exec(nil, utils.ToCmdLine("hset", key, "b", "2"))
+	testDB.Exec(nil, utils.ToCmdLine("hset", key, "c", "3"))
+
+	result := testDB.Exec(nil, utils.ToCmdLine("hexpire", key, "100", "FIELDS", "2", "a", "x"))
+	assertFieldCodes(t, result, 1, -2)
+	// 条件选项
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("hexpire", key, "200", "NX", "FIELDS", "2", "a", "b")), 0, 1)
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("hexpire", key, "300", "XX", "FIELDS", "2", "a", "c")), 1, 0)
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("hexpire", key, "250", "GT", "FIELDS", "3", "a", "b", "c")), 0, 1, 0)
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("hexpire", key, "260", "LT", "FIELDS", "3", "a", "b", "c")), 1, 0, 1)
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("httl", key, "FIELDS", "4", "a", "b", "c", "x")), 260, 250, 260, -2)
+
+	// 毫秒与时间戳形式
+	at := time.Now().Add(time.Hour).UnixMilli()
+	testDB.Exec(nil, utils.ToCmdLine("hpexpireat", key, strconv.FormatInt(at, 10), "FIELDS", "1", "a"))
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("hpexpiretime", key, "FIELDS", "1", "a")), at)
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("hexpiretime", key, "FIELDS", "1", "a")), (at+999)/1000)
+	pttl := testDB.Exec(nil, utils.ToCmdLine("hpttl", key, "FIELDS", "1", "a")).(*protocol.MultiRawReply)
+	if code := pttl.Replies[0].(*protocol.IntReply).Code; code <= 3590*1000 || code > 3600*1000 {
+		t.Errorf("wrong pttl %d", code)
+	}
+
+	// 过去的时间删除字段，全部删除时删除键
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("hexpire", key, "0", "FIELDS", "2", "a", "b")), 2, 2)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("hlen", key)), 1)
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("hexpireat", key, "1", "FIELDS", "1", "c")), 2)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("exists", key)), 0)
+
+	// 参数错误
+	testDB.Exec(nil, utils.ToCmdLine("hset", key, "a", "1"))
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("hexpire", key, "100", "FIELDS", "2", "a")),
+		"ERR The `numfields` parameter must match the number of arguments")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("hexpire", key, "100", "FIELDS", "0", "a")),
+		"ERR Parameter `numFields` should be greater than 0")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("hexpire", key, "100", "NX", "XX", "FIELDS", "1", "a")),
+		"ERR Mandatory argument FIELDS is missing or not at the right position")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("hexpire", key, "-1", "FIELDS", "1", "a")),
+		"ERR invalid expire time, must be >= 0")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("hpexpireat", key, "281474976710656", "FIELDS", "1", "a")),
+		"ERR invalid expire time in 'hpexpireat' command")
+}
+
+func TestHPersist(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("hset", key, "a", "1"))
+	testDB.Exec(nil, utils.ToCmdLine("hset", key, "b", "2"))
+	testDB.Exec(nil, utils.ToCmdLine("hexpire", key, "100", "FIELDS", "1", "a"))
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("hpersist", key, "FIELDS", "3", "a", "b", "x")), 1, -1, -2)
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("httl", key, "FIELDS", "1", "a")), -1)
+
+	// HSET 覆盖字段时清除过期时间
+	testDB.Exec(nil, utils.ToCmdLine("hexpire", key, "100", "FIELDS", "1", "a"))
+	testDB.Exec(nil, utils.ToCmdLine("hset", key, "a", "2"))
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("httl", key, "FIELDS", "1", "a")), -1)
+}
+
+func TestHashFieldLazyExpire(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("hset", key, "a", "1"))
+	testDB.Exec(nil, utils.ToCmdLine("hset", key, "b", "2"))
+	testDB.Exec(nil, utils.ToCmdLine("hpexpire", key, "50", "FIELDS", "1", "a"))
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("hlen", key)), 2)
+	time.Sleep(100 * time.Millisecond)
+	assert.AssertNullBulk(t, testDB.Exec(nil, utils.ToCmdLine("hget", key, "a")))
+	assert.AssertMultiBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("hgetall", key)), []string{"b", "2"})
+
+	testDB.Exec(nil, utils.ToCmdLine("hpexpire", key, "50", "FIELDS", "1", "b"))
+	time.Sleep(100 * time.Millisecond)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("hlen", key)), 0)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("exists", key)), 0)
+}
+
+func TestHashFieldActiveExpire(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("hset", key, "a", "1"))
+	testDB.Exec(nil, utils.ToCmdLine("hset", key, "b", "2"))
+	testDB.Exec(nil, utils.ToCmdLine("hpexpire", key, "100", "FIELDS", "1", "a"))
+	// 不经过命令访问，检查字段是否被定时任务删除
+	waitFor(t, func() bool {
+		keys := []string{key}
+		testDB.RWLocks(nil, keys)
+		defer testDB.RWUnLocks(nil, keys)
+		raw, _ := testDB.data.GetWithLock(key)
+		hash := raw.(*database.DataEntity).Data.(*Dict.ExpireDict)
+		_, exists := hash.Dict.Get("a")
+		return !exists
+	})
+}
+
+func TestHGetEx(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("hset", key, "a", "1"))
+	testDB.Exec(nil, utils.ToCmdLine("hset", key, "b", "2"))
+
+	result := testDB.Exec(nil, utils.ToCmdLine("hgetex", key, "EX", "100", "FIELDS", "2", "a", "x"))
+	assert.AssertMultiBulkReply(t, result, []string{"1", ""})
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("httl", key, "FIELDS", "2", "a", "b")), 100, -1)
+	testDB.Exec(nil, utils.ToCmdLine("hgetex", key, "PERSIST", "FIELDS", "1", "a"))
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("httl", key, "FIELDS", "1", "a")), -1)
+
+	// 过去的时间删除字段
+	result = testDB.Exec(nil, utils.ToCmdLine("hgetex", key, "PXAT", "1", "FIELDS", "1", "b"))
+	assert.AssertMultiBulkReply(t, result, []string{"2"})
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("hexists", key, "b")), 0)
+
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("hgetex", key, "EX", "1", "PERSIST", "FIELDS", "1", "a")),
+		"ERR Only one of EX, PX, EXAT, PXAT or PERSIST arguments can be specified")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("hgetex", key, "KEEPTTL", "FIELDS", "1", "a")),
+		"Err syntax error")
+}
+
+func TestHSetEx(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	result := testDB.Exec(nil, utils.ToCmdLine("hsetex", key, "FNX", "EX", "100", "FIELDS", "2", "a", "1", "b", "2"))
+	assert.AssertIntReply(t, result, 1)
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("httl", key, "FIELDS", "2", "a", "b")), 100, 100)
+	// FNX 要求所有字段都不存在，FXX 要求所有字段都存在
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("hsetex", key, "FNX", "FIELDS", "2", "a", "3", "c", "3")), 0)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("hsetex", key, "FXX", "FIELDS", "2", "a", "3", "c", "3")), 0)
+
+	// KEEPTTL 保留过期时间，否则清除
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("hsetex", key, "FXX", "KEEPTTL", "FIELDS", "1", "a", "4")), 1)
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("hget", key, "a")), "4")
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("httl", key, "FIELDS", "1", "a")), 100)
+	testDB.Exec(nil, utils.ToCmdLine("hsetex", key, "FIELDS", "1", "a", "5"))
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("httl", key, "FIELDS", "1", "a")), -1)
+
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("hsetex", key, "FNX", "FXX", "FIELDS", "1", "a", "1")),
+		"ERR Only one of FXX or FNX arguments can be specified")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("hsetex", key, "EX", "1", "KEEPTTL", "FIELDS", "1", "a", "1")),
+		"ERR Only one of EX, PX, EXAT, PXAT or KEEPTTL arguments can be specified")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("hsetex", key, "FIELDS", "2", "a", "1")),
+		"ERR The `numfields` parameter must match the number of arguments")
+}
+
+func TestHGetDel(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("hset", key, "a", "1"))
+	testDB.Exec(nil, utils.ToCmdLine("hset", key, "b", "2"))
+	result := testDB.Exec(nil, utils.ToCmdLine("hgetdel", key, "FIELDS", "2", "a", "x"))
+	assert.AssertMultiBulkReply(t, result, []string{"1", ""})
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("hlen", key)), 1)
+	testDB.Exec(nil, utils.ToCmdLine("hgetdel", key, "FIELDS", "1", "b"))
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("exists", key)), 0)
+}
+
+func TestHashFieldExpireUndo(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("hset", key, "a", "1"))
+	testDB.Exec(nil, utils.ToCmdLine("hexpire", key, "100", "FIELDS", "1", "a"))
+	undoCmdLines := undoHSet(testDB, utils.ToCmdLine(key, "a", "2"))
+	testDB.Exec(nil, utils.ToCmdLine("hset", key, "a", "2"))
+	for _, cmdLine := range undoCmdLines {
+		testDB.Exec(nil, cmdLine)
+	}
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("hget", key, "a")), "1")
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("httl", key, "FIELDS", "1", "a")), 100)
+
+	undoCmdLines = rollbackFirstKey(testDB, utils.ToCmdLine(key))
+	testDB.Exec(nil, utils.ToCmdLine("hpersist", key, "FIELDS", "1", "a"))
+	for _, cmdLine := range undoCmdLines {
+		testDB.Exec(nil, cmdLine)
+	}
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("httl", key, "FIELDS", "1", "a")), 100)
+}
+
+func TestHashFieldExpireDumpRestore(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	dest := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("hset", key, "a", "1"))
+	testDB.Exec(nil, utils.ToCmdLine("hset", key, "b", "2"))
+	testDB.Exec(nil, utils.ToCmdLine("hexpire", key, "100", "FIELDS", "1", "a"))
+	dumped, ok := testDB.Exec(nil, utils.ToCmdLine("dump", key)).(*protocol.BulkReply)
+	if !ok {
+		t.Fatal("dump failed")
+	}
+	result := testDB.Exec(nil, utils.ToCmdLine("restore", dest, "0", string(dumped.Arg)))
+	assert.AssertStatusReply(t, result, "OK")
+	assert.AssertMultiBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("hmget", dest, "a", "b")), []string{"1", "2"})
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("httl", dest, "FIELDS", "2", "a", "b")), 100, -1)
+
+	// 字段过期时间不保存在哈希中，任意字段名都可以正常还原
+	field := "\x00myredis-hfe"
+	testDB.Exec(nil, utils.ToCmdLine("hset", key, field, "junk"))
+	for _, withTTL := range []bool{true, false} {
+		if !withTTL {
+			testDB.Exec(nil, utils.ToCmdLine("hpersist", key, "FIELDS", "1", "a"))
+		}
+		dumped = testDB.Exec(nil, utils.ToCmdLine("dump", key)).(*protocol.BulkReply)
+		result = testDB.Exec(nil, utils.ToCmdLine("restore", dest, "0", string(dumped.Arg), "REPLACE"))
+		assert.AssertStatusReply(t, result, "OK")
+		assert.AssertMultiBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("hmget", dest, "a", field)), []string{"1", "junk"})
+		assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("hlen", dest)), 3)
+	}
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("httl", dest, "FIELDS", "2", "a", field)), -1, -1)
+}
@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"math"
 	"myredis/aof"
+	"myredis/config"
 	"myredis/datastruct/dict"
 	"myredis/datastruct/list"
 	"myredis/datastruct/set"
 	"myredis/datastruct/sortedset"
+	"myredis/interface/database"
 	"myredis/interface/myredis"
 	"myredis/lib/utils"
 	"myredis/lib/wildcard"
@@ -27,13 +29,43 @@ func execDel(db *DB, args [][]byte) myredis.Reply {
 		keys[i] = string(value)
 	}
 
-	deleted := db.Removes(keys...)
+	// 开启 lazyfree-lazy-user-del 时 DEL 与 UNLINK 相同
+	async := lazyfreeEnabled(func(props *config.ServerProperties) bool {
+		return props.LazyfreeLazyUserDel
+	})
+	deleted := db.removeAndFreeKeys(keys, async)
 	if deleted > 0 {
 		db.addAof(utils.ToCmdLine3("del", args...))
 	}
 	return protocol.MakeIntReply(int64(deleted))
 }
 
+// execUnlink: 删除指定的一个或多个键，较大的值在后台释放。
+// 返回值: 成功删除的键的数量。
+// 格式: UNLINK [KEY1] [KEY2] ...
+func execUnlink(db *DB, args [][]byte) myredis.Reply {
+	keys := make([]string, len(args))
+	for i, value := range args {
+		keys[i] = string(value)
+	}
+	deleted := db.removeAndFreeKeys(keys, true)
+	if deleted > 0 {
+		db.addAof(utils.ToCmdLine3("unlink", args...))
+	}
+	return protocol.MakeIntReply(int64(deleted))
+}
+
+// 删除 keys 并释放它们的值，返回删除的 key 数量
+func (db *DB) removeAndFreeKeys(keys []string, async bool) int {
+	deleted := 0
+	for _, key := range keys {
+		if db.removeAndFree(key, async) {
+			deleted++
+		}
+	}
+	return deleted
+}
+
 // undoDel: 为 DEL 命令生成回滚操作
 func undoDel(db *DB, args [][]byte) []CmdLine {
 	keys := make([]string, len(args))
@@ -58,12 +90,18 @@ func execExists(db *DB, args [][]byte) myredis.Reply {
 	return protocol.MakeIntReply(result)
 }
 
-// 清空当前数据库中的所有键。
-// 返回值: OK
-func execFlushDB(db *DB, args [][]byte) myredis.Reply {
-	db.Flush()
-	db.addAof(utils.ToCmdLine3("flushdb", args...))
-	return &protocol.OkReply{}
+// execTouch: 更新一个或多个键的最近访问时间。
+// 返回值: 存在的键的数量。
+// 格式: TOUCH [KEY1] [KEY2] ...
+func execTouch(db *DB, args [][]byte) myredis.Reply {
+	result := int64(0)
+	for _, arg := range args {
+		// GetEntity 会记录一次访问
+		if _, exists := db.GetEntity(string(arg)); exists {
+			result++
+		}
+	}
+	return protocol.MakeIntReply(result)
 }
 
 // 获取指定键的值类型
@@ -72,8 +110,13 @@ func getType(db *DB, key string) string {
 	if !exists {
 		return "none"
 	}
+	return getEntityType(entity)
+}
+
+// 返回值的类型名称
+func getEntityType(entity *database.DataEntity) string {
 	switch entity.Data.(type) {
-	case []byte:
+	case []byte, int64:
 		return "string"
 	case list.List:
 		return "list"
@@ -100,12 +143,6 @@ func execType(db *DB, args [][]byte) myredis.Reply {
 	}
 }
 
-func prepareRename(args [][]byte) ([]string, []string) {
-	src := string(args[0])
-	dest := string(args[1])
-	return []string{src}, []string{dest}
-}
-
 // execRename: 将键从 [KEY] 重命名为 [NEWKEY]。如果 [NEWKEY] 已存在，会覆盖。
 // 返回值: OK
 // 格式: RENAME [KEY] [NEWKEY]
@@ -123,6 +160,10 @@ func execRename(db *DB, args [][]byte) myredis.Reply {
 	rawTTL, hasTTL := db.ttlMap.Get(src)
 	db.PutEntity(des, entity)
 	db.Remove(src)
+	// 字段过期的定时任务按键名安排，需要为新键重新安排
+	if hash, ok := entity.Data.(*dict.ExpireDict); ok {
+		db.scheduleFieldExpire(des, hash)
+	}
 	if hasTTL {
 		// 清除原有的可能的 TTL
 		db.Persist(src)
@@ -163,6 +204,10 @@ func execRenameNx(db *DB, args [][]byte) myredis.Reply {
 	rawTTL, hasTTL := db.ttlMap.Get(src)
 	db.PutEntity(des, entity)
 	db.Remove(src)
+	// 字段过期的定时任务按键名安排，需要为新键重新安排
+	if hash, ok := entity.Data.(*dict.ExpireDict); ok {
+		db.scheduleFieldExpire(des, hash)
+	}
 	if hasTTL {
 		db.Persist(src)
 		db.Persist(des)
@@ -173,32 +218,80 @@ func execRenameNx(db *DB, args [][]byte) myredis.Reply {
 	return protocol.MakeIntReply(1)
 }
 
-// execExpire: 为键 [KEY] 设置过期时间，单位为秒。
-// 返回值: 1 (成功), 0 (键不存在)
-// 格式: EXPIRE [KEY] [SECONDS]
-func execExpire(db *DB, args [][]byte) myredis.Reply {
-	key := string(args[0])
+// EXPIRE 系列命令的条件选项
+const (
+	expireNX = 1 << iota // 仅当 key 没有过期时间
+	expireXX             // 仅当 key 已有过期时间
+	expireGT             // 仅当新的过期时间更晚，没有过期时间视为永不过期
+	expireLT             // 仅当新的过期时间更早
+)
 
-	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
-		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+// 解析 EXPIRE 系列命令时间参数之后的 NX、XX、GT、LT 选项
+func parseExpireCondition(args [][]byte) (int, protocol.ErrorReply) {
+	condition := 0
+	for _, arg := range args {
+		switch strings.ToUpper(string(arg)) {
+		case "NX":
+			condition |= expireNX
+		case "XX":
+			condition |= expireXX
+		case "GT":
+			condition |= expireGT
+		case "LT":
+			condition |= expireLT
+		default:
+			return 0, protocol.MakeErrReply("ERR Unsupported option " + string(arg))
+		}
 	}
-	ttlSec := time.Duration(ttl) * time.Second
+	if condition&expireNX != 0 && condition != expireNX {
+		return 0, protocol.MakeErrReply("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if condition&expireGT != 0 && condition&expireLT != 0 {
+		return 0, protocol.MakeErrReply("ERR GT and LT options at the same time are not compatible")
+	}
+	return condition, nil
+}
 
+// 满足条件时为 key 设置过期时间，返回 1 表示设置成功，0 表示 key 不存在或条件不满足
+func (db *DB) expireWithCondition(key string, expireAt time.Time, condition int) myredis.Reply {
 	_, exists := db.GetEntity(key)
 	if !exists {
 		return protocol.MakeIntReply(0)
 	}
-
-	expireAt := time.Now().Add(ttlSec)
+	raw, hasTTL := db.ttlMap.Get(key)
+	current, _ := raw.(time.Time)
+	if (condition&expireNX != 0 && hasTTL) ||
+		(condition&expireXX != 0 && !hasTTL) ||
+		(condition&expireGT != 0 && (!hasTTL || !expireAt.After(current))) ||
+		(condition&expireLT != 0 && hasTTL && !expireAt.Before(current)) {
+		return protocol.MakeIntReply(0)
+	}
 	db.Expire(key, expireAt)
 	db.addAof(aof.MakeExpiredCmd(key, expireAt).Args)
 	return protocol.MakeIntReply(1)
 }
 
+// execExpire: 为键 [KEY] 设置过期时间，单位为秒。
+// 返回值: 1 (成功), 0 (键不存在或条件不满足)
+// 格式: EXPIRE [KEY] [SECONDS] [NX|XX|GT|LT]
+func execExpire(db *DB, args [][]byte) myredis.Reply {
+	key := string(args[0])
+
+	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	condition, errReply := parseExpireCondition(args[2:])
+	if errReply != nil {
+		return errReply
+	}
+	ttlSec := time.Duration(ttl) * time.Second
+	return db.expireWithCondition(key, time.Now().Add(ttlSec), condition)
+}
+
 // execExpiredAt: 为键 [KEY] 设置一个绝对的过期时间点（Unix 时间戳，秒）。
-// 返回值: 1 (成功), 0 (键不存在)
-// 格式: EXPIREAT [KEY] [TIMESTAMP]
+// 返回值: 1 (成功), 0 (键不存在或条件不满足)
+// 格式: EXPIREAT [KEY] [TIMESTAMP] [NX|XX|GT|LT]
 func execExpiredAt(db *DB, args [][]byte) myredis.Reply {
 	key := string(args[0])
 	// 获取过期时间
@@ -206,16 +299,11 @@ func execExpiredAt(db *DB, args [][]byte) myredis.Reply {
 	if err != nil {
 		return protocol.MakeErrReply("ERR value is not an integer or out of range")
 	}
-	expireAt := time.Unix(raw, 0)
-
-	_, exists := db.GetEntity(key)
-	if !exists {
-		return protocol.MakeIntReply(0)
+	condition, errReply := parseExpireCondition(args[2:])
+	if errReply != nil {
+		return errReply
 	}
-
-	db.Expire(key, expireAt)
-	db.addAof(aof.MakeExpiredCmd(key, expireAt).Args)
-	return protocol.MakeIntReply(1)
+	return db.expireWithCondition(key, time.Unix(raw, 0), condition)
 }
 
 // execGetExpiredTime: 获取键 [KEY] 的过期时间点（Unix 时间戳，秒）。
@@ -239,8 +327,8 @@ func execGetExpiredTime(db *DB, args [][]byte) myredis.Reply {
 }
 
 // execPExpire: 为键 [KEY] 设置过期时间，单位为毫秒。
-// 返回值: 1 (成功), 0 (键不存在)
-// 格式: PEXPIRE [KEY] [MILLISECONDS]
+// 返回值: 1 (成功), 0 (键不存在或条件不满足)
+// 格式: PEXPIRE [KEY] [MILLISECONDS] [NX|XX|GT|LT]
 func execPExpire(db *DB, args [][]byte) myredis.Reply {
 	key := string(args[0])
 
@@ -248,22 +336,17 @@ func execPExpire(db *DB, args [][]byte) myredis.Reply {
 	if err != nil {
 		return protocol.MakeErrReply("ERR value is not an integer or out of range")
 	}
-	ttlMilSec := time.Duration(ttl) * time.Millisecond
-
-	_, exists := db.GetEntity(key)
-	if !exists {
-		return protocol.MakeIntReply(0)
+	condition, errReply := parseExpireCondition(args[2:])
+	if errReply != nil {
+		return errReply
 	}
-
-	expireAt := time.Now().Add(ttlMilSec)
-	db.Expire(key, expireAt)
-	db.addAof(aof.MakeExpiredCmd(key, expireAt).Args)
-	return protocol.MakeIntReply(1)
+	ttlMilSec := time.Duration(ttl) * time.Millisecond
+	return db.expireWithCondition(key, time.Now().Add(ttlMilSec), condition)
 }
 
 // execPExpiredAt: 为键 [KEY] 设置一个绝对的过期时间点（Unix 时间戳，毫秒）。
-// 返回值: 1 (成功), 0 (键不存在)
-// 格式: PEXPIREAT [KEY] [MILLISECONDS-TIMESTAMP]
+// 返回值: 1 (成功), 0 (键不存在或条件不满足)
+// 格式: PEXPIREAT [KEY] [MILLISECONDS-TIMESTAMP] [NX|XX|GT|LT]
 func execPExpiredAt(db *DB, args [][]byte) myredis.Reply {
 	key := string(args[0])
 	// 获取过期时间
@@ -271,16 +354,11 @@ func execPExpiredAt(db *DB, args [][]byte) myredis.Reply {
 	if err != nil {
 		return protocol.MakeErrReply("ERR value is not an integer or out of range")
 	}
-	expireAt := time.Unix(0, raw*int64(time.Millisecond))
-
-	_, exists := db.GetEntity(key)
-	if !exists {
-		return protocol.MakeIntReply(0)
+	condition, errReply := parseExpireCondition(args[2:])
+	if errReply != nil {
+		return errReply
 	}
-
-	db.Expire(key, expireAt)
-	db.addAof(aof.MakeExpiredCmd(key, expireAt).Args)
-	return protocol.MakeIntReply(1)
+	return db.expireWithCondition(key, time.UnixMilli(raw), condition)
 }
 
 // execGetPExpiredTime: 获取键 [KEY] 的过期时间点（Unix 时间戳，毫秒）。
@@ -407,92 +485,124 @@ func execGetKeys(db *DB, args [][]byte) myredis.Reply {
 	return protocol.MakeMultiBulkReply(result)
 }
 
-// execScan: 以游标方式增量迭代数据库中的键。
-// 支持 COUNT, MATCH, TYPE 选项。
-// 返回值: 新的游标和一批键。
-// 格式: SCAN [CURSOR] [MATCH pattern] [COUNT count] [TYPE type]
-func execScan(db *DB, args [][]byte) myredis.Reply {
-	var count int = 10
-	var pattern string = "*"
-	var scanType string = ""
-
-	if len(args) > 1 {
-		for i := 1; i < len(args); i++ {
-			arg := strings.ToLower(string(args[i]))
-			if arg == "count" {
-				rawCount, err := strconv.Atoi(string(args[i+1]))
-				if err != nil {
-					return protocol.MakeSyntaxErrReply()
-				}
-				count = rawCount
-				i++
-			} else if arg == "match" {
-				pattern = string(args[i+1])
-				i++
-			} else if arg == "type" {
-				scanType = strings.ToLower(string(args[i+1]))
-				i++
-			} else {
-				return protocol.MakeSyntaxErrReply()
-			}
-		}
-	}
-	cursor, err := strconv.Atoi(string(args[0]))
+// scanOptions SCAN 系列命令的参数
+type scanOptions struct {
+	cursor  int
+	count   int
+	pattern string
+	keyType string // 仅 SCAN 支持，为空时不过滤类型
+}
+
+// 解析 SCAN、HSCAN、SSCAN 与 ZSCAN 的游标和选项，allowType 为 true 时接受 TYPE 选项
+func parseScanArgs(cursorArg []byte, args [][]byte, allowType bool) (*scanOptions, protocol.ErrorReply) {
+	cursor, err := strconv.ParseUint(string(cursorArg), 10, 32)
 	if err != nil {
-		return protocol.MakeErrReply("ERR invalid cursor")
+		return nil, protocol.MakeErrReply("ERR invalid cursor")
 	}
-	keysReply, nextCursor := db.data.DictScan(cursor, count, pattern)
-	if nextCursor < 0 {
-		return protocol.MakeErrReply("Invalid argument")
+	opts := &scanOptions{
+		cursor:  int(cursor),
+		count:   10,
+		pattern: "*",
 	}
-
-	if len(scanType) != 0 {
-		for i := 0; i < len(keysReply); {
-			// 如果类型不符合，跳过该键
-			if getType(db, string(keysReply[i])) != scanType {
-				keysReply = append(keysReply[:i], keysReply[i+1:]...)
-			} else {
-				i++
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToLower(string(args[i]))
+		if i+1 >= len(args) {
+			return nil, protocol.MakeSyntaxErrReply()
+		}
+		switch {
+		case arg == "count":
+			count, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
 			}
+			if count < 1 {
+				return nil, protocol.MakeSyntaxErrReply()
+			}
+			opts.count = count
+		case arg == "match":
+			opts.pattern = string(args[i+1])
+		case arg == "type" && allowType:
+			opts.keyType = strings.ToLower(string(args[i+1]))
+		default:
+			return nil, protocol.MakeSyntaxErrReply()
 		}
+		i++
 	}
+	return opts, nil
+}
+
+// 生成 SCAN 系列命令的回复: 新的游标和一批元素
+func makeScanReply(nextCursor int, elements [][]byte) myredis.Reply {
 	result := make([]myredis.Reply, 2)
 	result[0] = protocol.MakeBulkReply([]byte(strconv.FormatInt(int64(nextCursor), 10)))
-	result[1] = protocol.MakeMultiBulkReply(keysReply)
+	result[1] = protocol.MakeMultiBulkReply(elements)
 	return protocol.MakeMultiRawReply(result)
 }
 
+// execScan: 以游标方式增量迭代数据库中的键。
+// 支持 COUNT, MATCH, TYPE 选项，扫描期间一直存在的键至少会被返回一次。
+// 返回值: 新的游标和一批键。
+// 格式: SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func execScan(db *DB, args [][]byte) myredis.Reply {
+	opts, errReply := parseScanArgs(args[0], args[1:], true)
+	if errReply != nil {
+		return errReply
+	}
+	keys, nextCursor := db.data.DictScan(opts.cursor, opts.count, opts.pattern)
+	if nextCursor < 0 {
+		return protocol.MakeErrReply("ERR illegal wildcard")
+	}
+
+	result := keys[:0]
+	for _, key := range keys {
+		// 跳过已过期的键以及类型不符合的键
+		entity, exists := db.GetEntity(string(key))
+		if !exists {
+			continue
+		}
+		if opts.keyType != "" && getEntityType(entity) != opts.keyType {
+			continue
+		}
+		result = append(result, key)
+	}
+	return makeScanReply(nextCursor, result)
+}
+
 func init() {
-	registerCommand("Del", execDel, writeAllKeys, undoDel, -2, flagWrite).
+	registerCommand("Del", execDel, undoDel, -2, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite}, 1, -1, 1)
-	registerCommand("Exists", execExists, readAllKeys, nil, -2, flagReadOnly).
+	registerCommand("Unlink", execUnlink, undoDel, -2, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, -1, 1)
+	registerCommand("Exists", execExists, nil, -2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("TTL", execTTL, readFirstKey, nil, 2, flagReadOnly).
+	registerCommand("Touch", execTouch, nil, -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, -1, 1)
+	registerCommand("TTL", execTTL, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom, redisFlagFast}, 1, 1, 1)
-	registerCommand("PTTL", execPTTL, readFirstKey, nil, 2, flagReadOnly).
+	registerCommand("PTTL", execPTTL, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom, redisFlagFast}, 1, 1, 1)
-	registerCommand("Type", execType, readFirstKey, nil, 2, flagReadOnly).
+	registerCommand("Type", execType, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("Rename", execRename, prepareRename, undoRename, 3, flagReadOnly).
+	registerCommand("Rename", execRename, undoRename, 3, flagReadOnly).
 		attachCommandExtra([]string{redisFlagWrite}, 1, 1, 1)
-	registerCommand("RenameNx", execRenameNx, prepareRename, undoRename, 3, flagReadOnly).
+	registerCommand("RenameNx", execRenameNx, undoRename, 3, flagReadOnly).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
-	registerCommand("Expire", execExpire, writeFirstKey, undoExpire, 3, flagWrite).
+	registerCommand("Expire", execExpire, undoExpire, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
-	registerCommand("ExpireAt", execExpiredAt, writeFirstKey, undoExpire, 3, flagWrite).
+	registerCommand("ExpireAt", execExpiredAt, undoExpire, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
-	registerCommand("ExpireTime", execGetExpiredTime, readFirstKey, nil, 2, flagReadOnly).
+	registerCommand("ExpireTime", execGetExpiredTime, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
-	registerCommand("PExpire", execPExpire, writeFirstKey, undoExpire, 3, flagWrite).
+	registerCommand("PExpire", execPExpire, undoExpire, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
-	registerCommand("PExpireAt", execPExpiredAt, writeFirstKey, undoExpire, 3, flagWrite).
+	registerCommand("PExpireAt", execPExpiredAt, undoExpire, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
-	registerCommand("PExpireTime", execGetPExpiredTime, readFirstKey, nil, 2, flagReadOnly).
+	registerCommand("PExpireTime", execGetPExpiredTime, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
-	registerCommand("Persist", execPersist, writeFirstKey, undoExpire, 2, flagWrite).
+	registerCommand("Persist", execPersist, undoExpire, 2, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
-	registerCommand("Keys", execGetKeys, noPrepare, nil, 2, flagReadOnly).
+	registerCommand("Keys", execGetKeys, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagSortForScript}, 1, 1, 1)
-	registerCommand("Scan", execScan, noPrepare, nil, -2, flagReadOnly).
+	registerCommand("Scan", execScan, nil, -2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagSortForScript}, 1, 1, 1)
 }
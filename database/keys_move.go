@@ -0,0 +1,242 @@
+// keys_move.go 实现了在数据库之间转移键的 MOVE 与 COPY：
+//
+//   - 普通命令只能访问连接选择的数据库，跨库命令由 Server 直接分发
+//   - 跨库命令按数据库编号从小到大加锁，与快照以及其他跨库命令的加锁顺序一致，避免死锁
+//   - COPY 的目标是当前数据库时与普通命令相同，可以在事务中使用
+package database
+
+import (
+	"myredis/datastruct/dict"
+	"myredis/interface/myredis"
+	"myredis/lib/utils"
+	"myredis/protocol"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 跨库命令在某个数据库上需要锁定的键
+type dbKeys struct {
+	db    *DB
+	write []string
+	read  []string
+}
+
+// 按数据库编号顺序锁定各个数据库上的键，返回解锁函数
+func lockAcrossDBs(groups ...*dbKeys) func() {
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].db.index < groups[j].db.index
+	})
+	for _, g := range groups {
+		g.db.writeGate.RLock()
+		g.db.addVersion(g.write...)
+		g.db.RWLocks(g.write, g.read)
+	}
+	return func() {
+		for i := len(groups) - 1; i >= 0; i-- {
+			g := groups[i]
+			g.db.RWUnLocks(g.write, g.read)
+			g.db.writeGate.RUnlock()
+		}
+	}
+}
+
+// 解析目标数据库编号
+func parseDBIndex(server *Server, arg []byte) (int, protocol.ErrorReply) {
+	index, err := strconv.Atoi(string(arg))
+	if err != nil {
+		return 0, protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if index < 0 || index >= len(server.dbSet) {
+		return 0, protocol.MakeErrReply("ERR DB index is out of range")
+	}
+	return index, nil
+}
+
+// 把 src 中的 srcKey 复制为 dst 中的 destKey，保留过期时间，调用者需要持有相关的锁
+//
+// srcKey 不存在，或 destKey 已存在且 replace 为 false 时返回 false
+func copyKey(src *DB, srcKey string, dst *DB, destKey string, replace bool) bool {
+	entity, ok := src.GetEntity(srcKey)
+	if !ok {
+		return false
+	}
+	if _, exists := dst.peekEntity(destKey); exists {
+		if !replace {
+			return false
+		}
+		dst.Remove(destKey)
+	}
+	clone := cloneEntity(entity)
+	dst.PutEntity(destKey, clone)
+	if rawTTL, ok := src.ttlMap.Get(srcKey); ok {
+		expireTime, _ := rawTTL.(time.Time)
+		dst.Expire(destKey, expireTime)
+	}
+	if hash, ok := clone.Data.(*dict.ExpireDict); ok {
+		dst.scheduleFieldExpire(destKey, hash)
+	}
+	return true
+}
+
+// copyOptions COPY 命令的参数
+type copyOptions struct {
+	src     string
+	dest    string
+	dbIndex int // 未指定 DB 时为 -1
+	replace bool
+}
+
+// COPY source destination [DB destination-db] [REPLACE]
+func parseCopyArgs(args [][]byte) (*copyOptions, protocol.ErrorReply) {
+	opts := &copyOptions{
+		src:     string(args[0]),
+		dest:    string(args[1]),
+		dbIndex: -1,
+	}
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "REPLACE":
+			opts.replace = true
+		case "DB":
+			if i+1 >= len(args) {
+				return nil, protocol.MakeSyntaxErrReply()
+			}
+			index, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if index < 0 {
+				return nil, protocol.MakeErrReply("ERR DB index is out of range")
+			}
+			opts.dbIndex = index
+			i++
+		default:
+			return nil, protocol.MakeSyntaxErrReply()
+		}
+	}
+	return opts, nil
+}
+
+func undoCopy(db *DB, args [][]byte) []CmdLine {
+	return rollbackGivenKeys(db, string(args[1]))
+}
+
+// execCopy: 在当前数据库中复制键，由事务或不指定其他数据库的 COPY 使用
+// 返回值: 1 (成功), 0 (源键不存在或目标键已存在)
+// 格式: COPY source destination [DB destination-db] [REPLACE]
+func execCopy(db *DB, args [][]byte) myredis.Reply {
+	opts, errReply := parseCopyArgs(args)
+	if errReply != nil {
+		return errReply
+	}
+	if opts.dbIndex >= 0 && opts.dbIndex != db.index {
+		return protocol.MakeErrReply("ERR COPY to another database is not allowed in MULTI")
+	}
+	if opts.src == opts.dest {
+		return protocol.MakeErrReply("ERR source and destination objects are the same")
+	}
+	if !copyKey(db, opts.src, db, opts.dest, opts.replace) {
+		return protocol.MakeIntReply(0)
+	}
+	db.addAof(utils.ToCmdLine3("copy", args...))
+	return protocol.MakeIntReply(1)
+}
+
+// 由 Server 分发的 COPY，目标为其他数据库时同时锁定两个数据库
+func execCopyAcrossDB(c myredis.Connection, server *Server, cmdLine [][]byte) myredis.Reply {
+	srcDB, errReply := server.selectDB(c.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	args := cmdLine[1:]
+	if c.InMultiState() || len(args) < 2 {
+		return srcDB.Exec(c, cmdLine)
+	}
+	opts, parseErr := parseCopyArgs(args)
+	if parseErr != nil {
+		return parseErr
+	}
+	if opts.dbIndex < 0 || opts.dbIndex == srcDB.index {
+		return srcDB.Exec(c, cmdLine)
+	}
+	destDB, errReply := server.selectDB(opts.dbIndex)
+	if errReply != nil {
+		return errReply
+	}
+
+	unlock := lockAcrossDBs(
+		&dbKeys{db: srcDB, read: []string{opts.src}},
+		&dbKeys{db: destDB, write: []string{opts.dest}},
+	)
+	defer unlock()
+	if !copyKey(srcDB, opts.src, destDB, opts.dest, opts.replace) {
+		return protocol.MakeIntReply(0)
+	}
+	// 重放时同样由 Server 分发，因此记录在源数据库中
+	srcDB.addAof(utils.ToCmdLine3("copy", args...))
+	destDB.signalKeys(opts.dest)
+	return protocol.MakeIntReply(1)
+}
+
+// execMove: 把当前数据库中的键移动到另一个数据库
+// 返回值: 1 (成功), 0 (键不存在或目标数据库中已存在同名键)
+// 格式: MOVE key db
+func execMove(c myredis.Connection, server *Server, cmdLine [][]byte) myredis.Reply {
+	srcDB, errReply := server.selectDB(c.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	// 交给数据库报告 MOVE 不能在事务中使用
+	if c.InMultiState() {
+		return srcDB.Exec(c, cmdLine)
+	}
+	if len(cmdLine) != 3 {
+		return protocol.MakeArgNumErrReply("move")
+	}
+	args := cmdLine[1:]
+	destIndex, parseErr := parseDBIndex(server, args[1])
+	if parseErr != nil {
+		return parseErr
+	}
+	if destIndex == srcDB.index {
+		return protocol.MakeErrReply("ERR source and destination objects are the same")
+	}
+	destDB := server.mustSelectDB(destIndex)
+	key := string(args[0])
+
+	unlock := lockAcrossDBs(
+		&dbKeys{db: srcDB, write: []string{key}},
+		&dbKeys{db: destDB, write: []string{key}},
+	)
+	defer unlock()
+	entity, ok := srcDB.GetEntity(key)
+	if !ok {
+		return protocol.MakeIntReply(0)
+	}
+	if _, exists := destDB.peekEntity(key); exists {
+		return protocol.MakeIntReply(0)
+	}
+	rawTTL, hasTTL := srcDB.ttlMap.Get(key)
+	// 先从源数据库移除，取消源数据库中的过期任务
+	srcDB.Remove(key)
+	destDB.PutEntity(key, entity)
+	if hasTTL {
+		expireTime, _ := rawTTL.(time.Time)
+		destDB.Expire(key, expireTime)
+	}
+	if hash, ok := entity.Data.(*dict.ExpireDict); ok {
+		destDB.scheduleFieldExpire(key, hash)
+	}
+	srcDB.addAof(utils.ToCmdLine3("move", args...))
+	destDB.signalKeys(key)
+	return protocol.MakeIntReply(1)
+}
+
+func init() {
+	registerCommand("Copy", execCopy, undoCopy, -3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 2, 1)
+	registerSpecialCommand("Move", 3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
+}
@@ -1,10 +1,15 @@
 package database
 
 import (
+	"fmt"
+	"myredis/config"
+	"myredis/interface/database"
 	"myredis/lib/utils"
+	"myredis/myredis/connection"
 	"myredis/protocol"
 	"myredis/protocol/assert"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -394,3 +399,270 @@ func TestScan(t *testing.T) {
 		return
 	}
 }
+
+func TestExpireCondition(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("set", key, "v"))
+
+	result := testDB.Exec(nil, utils.ToCmdLine("expire", key, "100", "XX"))
+	assert.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("expire", key, "100", "GT"))
+	assert.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("expire", key, "100", "NX"))
+	assert.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("expire", key, "200", "NX"))
+	assert.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("expire", key, "50", "GT"))
+	assert.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("pexpire", key, "200000", "XX", "GT"))
+	assert.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("expire", key, "300", "LT"))
+	assert.AssertIntReply(t, result, 0)
+	expireAt := time.Now().Add(time.Minute).Unix()
+	result = testDB.Exec(nil, utils.ToCmdLine("expireat", key, strconv.FormatInt(expireAt, 10), "LT"))
+	assert.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("ttl", key))
+	if ttl := result.(*protocol.IntReply).Code; ttl <= 0 || ttl > 60 {
+		t.Errorf("expected ttl within a minute, actually %d", ttl)
+	}
+
+	result = testDB.Exec(nil, utils.ToCmdLine("expire", key, "100", "NX", "XX"))
+	assert.AssertErrReply(t, result, "ERR NX and XX, GT or LT options at the same time are not compatible")
+	result = testDB.Exec(nil, utils.ToCmdLine("expire", key, "100", "GT", "LT"))
+	assert.AssertErrReply(t, result, "ERR GT and LT options at the same time are not compatible")
+	result = testDB.Exec(nil, utils.ToCmdLine("pexpireat", key, "100", "FOO"))
+	assert.AssertErrReply(t, result, "ERR Unsupported option FOO")
+}
+
+func TestTouch(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("set", key, "v"))
+	result := testDB.Exec(nil, utils.ToCmdLine("touch", key, utils.RandString(10), key))
+	assert.AssertIntReply(t, result, 2)
+}
+
+func TestCopy(t *testing.T) {
+	testDB.Flush()
+	src := utils.RandString(10)
+	dest := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("rpush", src, "a", "b"))
+	testDB.Exec(nil, utils.ToCmdLine("expire", src, "100"))
+
+	result := testDB.Exec(nil, utils.ToCmdLine("copy", src, dest))
+	assert.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("lrange", dest, "0", "-1"))
+	assert.AssertMultiBulkReply(t, result, []string{"a", "b"})
+	result = testDB.Exec(nil, utils.ToCmdLine("ttl", dest))
+	if ttl := result.(*protocol.IntReply).Code; ttl <= 0 {
+		t.Errorf("expected ttl copied, actually %d", ttl)
+	}
+	// 复制得到的是独立的值
+	testDB.Exec(nil, utils.ToCmdLine("rpush", dest, "c"))
+	result = testDB.Exec(nil, utils.ToCmdLine("llen", src))
+	assert.AssertIntReply(t, result, 2)
+
+	result = testDB.Exec(nil, utils.ToCmdLine("copy", src, dest))
+	assert.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("copy", src, dest, "REPLACE"))
+	assert.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("llen", dest))
+	assert.AssertIntReply(t, result, 2)
+	result = testDB.Exec(nil, utils.ToCmdLine("copy", src, src))
+	assert.AssertErrReply(t, result, "ERR source and destination objects are the same")
+	result = testDB.Exec(nil, utils.ToCmdLine("copy", utils.RandString(10), dest))
+	assert.AssertIntReply(t, result, 0)
+}
+
+func TestMoveAndCopyAcrossDB(t *testing.T) {
+	config.Properties = &config.ServerProperties{Databases: 4}
+	server := MakeAuxiliaryServer()
+	conn := connection.NewSimpleConn()
+	server.Exec(conn, utils.ToCmdLine("set", "a", "1"))
+	server.Exec(conn, utils.ToCmdLine("expire", "a", "100"))
+
+	result := server.Exec(conn, utils.ToCmdLine("move", "a", "1"))
+	assert.AssertIntReply(t, result, 1)
+	result = server.Exec(conn, utils.ToCmdLine("exists", "a"))
+	assert.AssertIntReply(t, result, 0)
+	result = server.Exec(conn, utils.ToCmdLine("move", "a", "1"))
+	assert.AssertIntReply(t, result, 0)
+	result = server.Exec(conn, utils.ToCmdLine("move", "a", "0"))
+	assert.AssertErrReply(t, result, "ERR source and destination objects are the same")
+	result = server.Exec(conn, utils.ToCmdLine("move", "a", "10"))
+	assert.AssertErrReply(t, result, "ERR DB index is out of range")
+
+	server.Exec(conn, utils.ToCmdLine("select", "1"))
+	assert.AssertBulkReply(t, server.Exec(conn, utils.ToCmdLine("get", "a")), "1")
+	result = server.Exec(conn, utils.ToCmdLine("ttl", "a"))
+	if ttl := result.(*protocol.IntReply).Code; ttl <= 0 {
+		t.Errorf("expected ttl moved, actually %d", ttl)
+	}
+	// 目标数据库中已存在同名键时不移动
+	server.Exec(conn, utils.ToCmdLine("select", "0"))
+	server.Exec(conn, utils.ToCmdLine("set", "a", "2"))
+	result = server.Exec(conn, utils.ToCmdLine("move", "a", "1"))
+	assert.AssertIntReply(t, result, 0)
+
+	result = server.Exec(conn, utils.ToCmdLine("copy", "a", "b", "DB", "2"))
+	assert.AssertIntReply(t, result, 1)
+	result = server.Exec(conn, utils.ToCmdLine("copy", "a", "a", "DB", "1"))
+	assert.AssertIntReply(t, result, 0)
+	result = server.Exec(conn, utils.ToCmdLine("copy", "a", "a", "DB", "1", "REPLACE"))
+	assert.AssertIntReply(t, result, 1)
+	server.Exec(conn, utils.ToCmdLine("select", "2"))
+	assert.AssertBulkReply(t, server.Exec(conn, utils.ToCmdLine("get", "b")), "2")
+	server.Exec(conn, utils.ToCmdLine("select", "1"))
+	assert.AssertBulkReply(t, server.Exec(conn, utils.ToCmdLine("get", "a")), "2")
+
+	// MOVE 不能在事务中使用，COPY 只能复制到当前数据库
+	server.Exec(conn, utils.ToCmdLine("multi"))
+	result = server.Exec(conn, utils.ToCmdLine("move", "a", "0"))
+	assert.AssertErrReply(t, result, "ERR command 'move' cannot be used in MULTI")
+	server.Exec(conn, utils.ToCmdLine("discard"))
+}
+
+// 用 cmd 扫描到结束，每一轮之前调用 mutate，返回每个元素被返回的次数
+func scanUntilDone(t *testing.T, cmd func(cursor string) []string, step int, mutate func(round int)) map[string]int {
+	t.Helper()
+	seen := make(map[string]int)
+	cursor := "0"
+	for round := 0; ; round++ {
+		if mutate != nil {
+			mutate(round)
+		}
+		result := testDB.Exec(nil, utils.ToCmdLine(cmd(cursor)...))
+		replies, ok := result.(*protocol.MultiRawReply)
+		if !ok {
+			t.Fatalf("unexpected scan reply: %s", result.ToBytes())
+		}
+		cursor = string(replies.Replies[0].(*protocol.BulkReply).Arg)
+		elements := replies.Replies[1].(*protocol.MultiBulkReply).Args
+		for i := 0; i < len(elements); i += step {
+			seen[string(elements[i])]++
+		}
+		if cursor == "0" {
+			return seen
+		}
+		if round > 10000 {
+			t.Fatalf("scan does not terminate")
+		}
+	}
+}
+
+func TestScanWhileWriting(t *testing.T) {
+	testDB.Flush()
+	for i := 0; i < 300; i++ {
+		testDB.Exec(nil, utils.ToCmdLine("set", "str"+strconv.Itoa(i), "v"))
+		testDB.Exec(nil, utils.ToCmdLine("sadd", "set"+strconv.Itoa(i), "m"))
+	}
+	for i := 0; i < 600; i++ {
+		testDB.Exec(nil, utils.ToCmdLine("set", "temp"+strconv.Itoa(i), "v"))
+	}
+	seen := scanUntilDone(t, func(cursor string) []string {
+		return []string{"scan", cursor, "count", "20", "type", "set"}
+	}, 1, func(round int) {
+		// 扫描期间删除部分键，同时写入新的键
+		for i := 0; i < 20; i++ {
+			testDB.Exec(nil, utils.ToCmdLine("del", "temp"+strconv.Itoa(round*20+i)))
+			testDB.Exec(nil, utils.ToCmdLine("set", "new"+strconv.Itoa(round*20+i), "v"))
+		}
+	})
+	if len(seen) != 300 {
+		t.Errorf("expect 300 set keys, actual %d", len(seen))
+	}
+	for key := range seen {
+		if key[:3] != "set" {
+			t.Errorf("expect type set, found %s", key)
+		}
+	}
+}
+
+func TestScanArgs(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("set", "a", "1"))
+	result := testDB.Exec(nil, utils.ToCmdLine("scan", "abc"))
+	assert.AssertErrReply(t, result, "ERR invalid cursor")
+	result = testDB.Exec(nil, utils.ToCmdLine("scan", "-1"))
+	assert.AssertErrReply(t, result, "ERR invalid cursor")
+	result = testDB.Exec(nil, utils.ToCmdLine("scan", "0", "count", "0"))
+	assert.AssertErrReply(t, result, "Err syntax error")
+	result = testDB.Exec(nil, utils.ToCmdLine("scan", "0", "count", "x"))
+	assert.AssertErrReply(t, result, "ERR value is not an integer or out of range")
+	result = testDB.Exec(nil, utils.ToCmdLine("scan", "0", "match"))
+	assert.AssertErrReply(t, result, "Err syntax error")
+	result = testDB.Exec(nil, utils.ToCmdLine("hscan", "a", "0", "type", "string"))
+	assert.AssertErrReply(t, result, "Err syntax error")
+	result = testDB.Exec(nil, utils.ToCmdLine("sscan", "a", "0"))
+	assert.AssertErrReply(t, result, "WRONGTYPE Operation against a key holding the wrong kind of value")
+
+	// 不存在的键视为空集合
+	for _, cmd := range []string{"hscan", "sscan", "zscan"} {
+		result = testDB.Exec(nil, utils.ToCmdLine(cmd, "missing", "0"))
+		replies := result.(*protocol.MultiRawReply).Replies
+		assert.AssertBulkReply(t, replies[0], "0")
+		assert.AssertMultiBulkReplySize(t, replies[1], 0)
+	}
+}
+
+func TestScanLargeCollections(t *testing.T) {
+	testDB.Flush()
+	for i := 0; i < 300; i++ {
+		member := "member" + strconv.Itoa(i)
+		testDB.Exec(nil, utils.ToCmdLine("hset", "hash", member, "v"))
+		testDB.Exec(nil, utils.ToCmdLine("sadd", "set", member))
+		testDB.Exec(nil, utils.ToCmdLine("zadd", "zset", strconv.Itoa(i), member))
+	}
+	cases := []struct {
+		cmd, key, add string
+		step          int
+	}{
+		{"hscan", "hash", "hset %s %s v", 2},
+		{"sscan", "set", "sadd %s %s", 1},
+		{"zscan", "zset", "zadd %s 0 %s", 2},
+	}
+	for _, c := range cases {
+		seen := scanUntilDone(t, func(cursor string) []string {
+			return []string{c.cmd, c.key, cursor, "count", "15"}
+		}, c.step, func(round int) {
+			for i := 0; i < 15; i++ {
+				line := strings.Fields(fmt.Sprintf(c.add, c.key, "extra"+strconv.Itoa(round*15+i)))
+				testDB.Exec(nil, utils.ToCmdLine(line...))
+			}
+		})
+		for i := 0; i < 300; i++ {
+			if seen["member"+strconv.Itoa(i)] == 0 {
+				t.Errorf("%s: missing member%d", c.cmd, i)
+			}
+		}
+	}
+}
+
+func TestKeyEventCallbacks(t *testing.T) {
+	config.Properties = &config.ServerProperties{Databases: 4}
+	server := MakeAuxiliaryServer()
+	var inserted, deleted []string
+	server.SetKeyInsertedCallback(func(dbIndex int, key string, entity *database.DataEntity) {
+		inserted = append(inserted, key)
+	})
+	server.SetKeyDeletedCallback(func(dbIndex int, key string, entity *database.DataEntity) {
+		deleted = append(deleted, key)
+	})
+	conn := connection.NewSimpleConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "1"))
+	server.Exec(conn, utils.ToCmdLine("SET", "n", "1", "NX"))
+	server.Exec(conn, utils.ToCmdLine("SET", "n", "2", "NX"))
+	server.Exec(conn, utils.ToCmdLine("SETNX", "m", "1"))
+	server.Exec(conn, utils.ToCmdLine("SETNX", "m", "2"))
+	server.Exec(conn, utils.ToCmdLine("SET", "n", "3", "XX"))
+	server.Exec(conn, utils.ToCmdLine("SET", "x", "1", "XX"))
+	if strings.Join(inserted, ",") != "a,n,m" {
+		t.Errorf("expect inserted a,n,m, actually %v", inserted)
+	}
+	server.Exec(conn, utils.ToCmdLine("DEL", "missing"))
+	server.Exec(conn, utils.ToCmdLine("UNLINK", "missing", "n"))
+	if strings.Join(deleted, ",") != "n" {
+		t.Errorf("expect deleted n, actually %v", deleted)
+	}
+}
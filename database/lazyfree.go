@@ -0,0 +1,124 @@
+// lazyfree.go 实现被删除的值的后台释放，用于 UNLINK、FLUSHDB ASYNC、FLUSHALL ASYNC 与 lazyfree-lazy-* 选项：
+//
+//   - 值先从数据库中摘除，之后不会再被命令访问
+//   - 元素个数超过 lazyfreeThreshold 的值交给后台 goroutine 拆除，较小的值直接释放
+//
+// 拆除指清空值的内部结构并解除引用，内存最终由 GC 回收
+package database
+
+import (
+	"myredis/config"
+	"myredis/datastruct/dict"
+	"myredis/datastruct/list"
+	"myredis/datastruct/set"
+	"myredis/datastruct/sortedset"
+	"myredis/interface/database"
+	"sync"
+	"sync/atomic"
+)
+
+// 超过该元素个数的值在后台释放，与 Redis 的 LAZYFREE_THRESHOLD 相同
+const lazyfreeThreshold = 64
+
+// 后台释放任务，objects 为任务释放的值的个数
+type lazyfreeJob struct {
+	objects int64
+	release func()
+}
+
+var (
+	lazyfreeJobs     = make(chan *lazyfreeJob, 1024)
+	lazyfreeOnce     sync.Once
+	lazyfreePending  atomic.Int64 // 等待释放的值的个数
+	lazyfreedObjects atomic.Int64 // 已在后台释放的值的个数
+)
+
+func lazyfreeWorker() {
+	for job := range lazyfreeJobs {
+		job.release()
+		lazyfreePending.Add(-job.objects)
+		lazyfreedObjects.Add(job.objects)
+	}
+}
+
+// 提交后台释放任务，队列已满时直接在当前 goroutine 中释放
+func submitLazyfree(job *lazyfreeJob) {
+	lazyfreeOnce.Do(func() {
+		go lazyfreeWorker()
+	})
+	lazyfreePending.Add(job.objects)
+	select {
+	case lazyfreeJobs <- job:
+	default:
+		job.release()
+		lazyfreePending.Add(-job.objects)
+	}
+}
+
+// 释放值需要处理的元素个数，与 Redis 的 lazyfreeGetFreeEffort 相同
+func lazyfreeEffort(entity *database.DataEntity) int {
+	switch val := entity.Data.(type) {
+	case list.List:
+		return val.Len()
+	case dict.Dict:
+		return val.Len()
+	case *set.Set:
+		return val.Len()
+	case *sortedset.SortedSet:
+		return int(val.Len())
+	}
+	return 1
+}
+
+// 拆除值的内部结构并解除引用，调用者需保证值已不可被访问
+func releaseEntity(entity *database.DataEntity) {
+	if hash, ok := entity.Data.(dict.Dict); ok {
+		hash.Clear()
+	}
+	entity.Data = nil
+}
+
+// 释放已从数据库中摘除的值，async 为 true 且值较大时在后台释放
+func freeEntity(entity *database.DataEntity, async bool) {
+	if !async || lazyfreeEffort(entity) <= lazyfreeThreshold {
+		releaseEntity(entity)
+		return
+	}
+	submitLazyfree(&lazyfreeJob{
+		objects: 1,
+		release: func() {
+			releaseEntity(entity)
+		},
+	})
+}
+
+// 释放 ConcurrentDict.Detach 返回的全部值
+func freeDetached(detached []map[string]interface{}, async bool) {
+	release := func() {
+		for _, m := range detached {
+			for key, val := range m {
+				if entity, ok := val.(*database.DataEntity); ok {
+					releaseEntity(entity)
+				}
+				delete(m, key)
+			}
+		}
+	}
+	if !async {
+		release()
+		return
+	}
+	objects := 0
+	for _, m := range detached {
+		objects += len(m)
+	}
+	submitLazyfree(&lazyfreeJob{
+		objects: int64(objects),
+		release: release,
+	})
+}
+
+// 读取 lazyfree-lazy-* 选项，未加载配置时均为关闭
+func lazyfreeEnabled(option func(props *config.ServerProperties) bool) bool {
+	return config.Properties != nil && option(config.Properties)
+}
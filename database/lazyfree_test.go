@@ -0,0 +1,71 @@
+package database
+
+import (
+	"myredis/config"
+	"myredis/lib/utils"
+	"myredis/myredis/connection"
+	"myredis/protocol/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// 等待后台释放任务全部完成
+func waitLazyfree(t *testing.T) {
+	deadline := time.Now().Add(time.Second)
+	for lazyfreePending.Load() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("lazyfree jobs not finished")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestUnlink(t *testing.T) {
+	testDB.Flush()
+	for i := 0; i <= lazyfreeThreshold; i++ {
+		testDB.Exec(nil, utils.ToCmdLine("ZADD", "big", strconv.Itoa(i), "m"+strconv.Itoa(i)))
+	}
+	testDB.Exec(nil, utils.ToCmdLine("SET", "small", "v"))
+	freed := lazyfreedObjects.Load()
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("UNLINK", "big", "small", "none")), 2)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("EXISTS", "big", "small")), 0)
+	waitLazyfree(t)
+	// 只有较大的值在后台释放
+	if n := lazyfreedObjects.Load() - freed; n != 1 {
+		t.Errorf("expect 1 object freed in background, actually %d", n)
+	}
+}
+
+func TestFlushAll(t *testing.T) {
+	config.Properties = &config.ServerProperties{Databases: 2}
+	server := MakeAuxiliaryServer()
+	conn := connection.NewSimpleConn()
+	for i := 0; i < 2; i++ {
+		conn.SelectDB(i)
+		for j := 0; j < 100; j++ {
+			server.Exec(conn, utils.ToCmdLine("SET", strconv.Itoa(j), "v"))
+		}
+	}
+	assert.AssertErrReply(t, server.Exec(conn, utils.ToCmdLine("FLUSHALL", "LATER")), "Err syntax error")
+	assert.AssertStatusReply(t, server.Exec(conn, utils.ToCmdLine("FLUSHDB", "SYNC")), "OK")
+	if keys, _ := server.GetDBSize(1); keys != 0 {
+		t.Errorf("expect db 1 flushed, actually %d keys", keys)
+	}
+	if keys, _ := server.GetDBSize(0); keys != 100 {
+		t.Errorf("expect db 0 untouched, actually %d keys", keys)
+	}
+
+	freed := lazyfreedObjects.Load()
+	assert.AssertStatusReply(t, server.Exec(conn, utils.ToCmdLine("FLUSHALL", "ASYNC")), "OK")
+	if keys, _ := server.GetDBSize(0); keys != 0 {
+		t.Errorf("expect db 0 flushed, actually %d keys", keys)
+	}
+	waitLazyfree(t)
+	if n := lazyfreedObjects.Load() - freed; n != 100 {
+		t.Errorf("expect 100 objects freed in background, actually %d", n)
+	}
+	// 清空后仍然可以正常写入
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "1"))
+	assert.AssertBulkReply(t, server.Exec(conn, utils.ToCmdLine("GET", "a")), "1")
+}
@@ -1,10 +1,13 @@
 package database
 
 import (
+	"bytes"
 	"fmt"
+	"math"
 	List "myredis/datastruct/list"
 	"myredis/interface/database"
 	"myredis/interface/myredis"
+	"myredis/lib/keyspec"
 	"myredis/lib/utils"
 	"myredis/protocol"
 	"strconv"
@@ -32,7 +35,7 @@ func (db *DB) getOrInitList(key string) (list List.List, isNew bool, errReply pr
 	}
 	isNew = false
 	if list == nil {
-		list = List.NewQuickList()
+		list = List.MakeCompact()
 		db.PutEntity(
 			key,
 			&database.DataEntity{
@@ -96,90 +99,116 @@ func execLLen(db *DB, args [][]byte) myredis.Reply {
 	return protocol.MakeIntReply(size)
 }
 
-// 从左侧开始移除 key 对应的 List 的成员  LPop key count
-func execLPop(db *DB, args [][]byte) myredis.Reply {
-	if len(args) < 1 {
-		return protocol.MakeErrReply("ERR wrong number of arguments for 'lpop' command")
-	}
-	key := string(args[0])
-
+// 从列表头部（left 为 true）或尾部弹出最多 count 个元素，列表为空后删除 key
+//
+// key 不存在时返回 nil，count 为 0 时返回空切片
+func (db *DB) listPop(key string, count int, left bool) ([][]byte, protocol.ErrorReply) {
 	list, errReply := db.getAsList(key)
 	if errReply != nil {
-		return errReply
+		return nil, errReply
 	}
 	if list == nil {
-		return protocol.MakeNullBulkReply()
+		return nil, nil
 	}
-	// 如果指定了 POP 的个数
-	if len(args) == 2 {
-		count64, err := strconv.ParseInt(string(args[1]), 10, 64)
-		if err != nil {
-			return protocol.MakeErrReply("ERR value is not an integer or out of range")
-		}
-		count := int(count64)
-		if count > list.Len() {
-			count = list.Len()
-		}
-		vals := make([][]byte, count)
-		for i := 0; i < count; i++ {
-			val := list.Remove(0).([]byte)
-			vals[i] = val
-		}
-		if list.Len() == 0 {
-			db.Remove(key)
+	if count > list.Len() {
+		count = list.Len()
+	}
+	vals := make([][]byte, count)
+	for i := range vals {
+		if left {
+			vals[i], _ = list.RemoveFirst().([]byte)
+		} else {
+			vals[i], _ = list.RemoveLast().([]byte)
 		}
-		return protocol.MakeMultiBulkReply(vals)
 	}
-	// 只移除一个
-	val, _ := list.Remove(0).([]byte)
 	if list.Len() == 0 {
 		db.Remove(key)
 	}
-	db.addAof(utils.ToCmdLine3("lpop", args...))
-	return protocol.MakeBulkReply(val)
+	if count > 0 {
+		cmdName := "rpop"
+		if left {
+			cmdName = "lpop"
+		}
+		db.addAof(utils.ToCmdLine(cmdName, key, strconv.Itoa(count)))
+	}
+	return vals, nil
 }
 
-var lPushCmd = []byte("LPUSH")
-
-// 在执行 LPOP 命令之前先记录即将被删除的元素，然后生成相应的撤销命令
-func undoLPop(db *DB, args [][]byte) []CmdLine {
+// LPOP/RPOP 的公共实现，不指定 count 时返回单个元素，指定 count 时总是返回数组
+func execListPop(db *DB, args [][]byte, left bool) myredis.Reply {
+	if len(args) > 2 {
+		return protocol.MakeSyntaxErrReply()
+	}
 	key := string(args[0])
-	list, errReply := db.getAsList(key)
+	if len(args) == 1 {
+		vals, errReply := db.listPop(key, 1, left)
+		if errReply != nil {
+			return errReply
+		}
+		if len(vals) == 0 {
+			return protocol.MakeNullBulkReply()
+		}
+		return protocol.MakeBulkReply(vals[0])
+	}
+	count, errReply := parsePopCount(args[1])
 	if errReply != nil {
-		return nil
+		return errReply
 	}
-	if list == nil || list.Len() == 0 {
+	vals, errReply := db.listPop(key, count, left)
+	if errReply != nil {
+		return errReply
+	}
+	if vals == nil {
+		return protocol.MakeNullMultiBulkReply()
+	}
+	return protocol.MakeMultiBulkReply(vals)
+}
+
+// 从左侧开始移除 key 对应的 List 的成员  LPop key [count]
+func execLPop(db *DB, args [][]byte) myredis.Reply {
+	return execListPop(db, args, true)
+}
+
+var lPushCmd = []byte("LPUSH")
+
+// 在执行 LPOP/RPOP 命令之前先记录即将被删除的元素，然后生成相应的撤销命令
+func undoListPop(db *DB, args [][]byte, left bool) []CmdLine {
+	list, errReply := db.getAsList(string(args[0]))
+	if errReply != nil || list == nil {
 		return nil
 	}
-	// 如果指定撤回数量
+	count := 1
 	if len(args) == 2 {
-		count64, err := strconv.ParseInt(string(args[1]), 10, 64)
-		if err != nil {
+		count, errReply = parsePopCount(args[1])
+		if errReply != nil {
 			return nil
 		}
-		count := int(count64)
-		if count > list.Len() {
-			count = list.Len()
-		}
-
-		elements := make([][]byte, count)
+	}
+	if count > list.Len() {
+		count = list.Len()
+	}
+	if count == 0 {
+		return nil
+	}
+	if left {
+		// LPUSH 逆序插入，恢复原来的顺序
 		vals := list.Range(0, count)
-		for i := 0; i < count; i++ {
-			elements[count-i-1] = vals[i].([]byte)
-		}
 		cmd := CmdLine{lPushCmd, args[0]}
-		cmd = append(cmd, elements...)
+		for i := count - 1; i >= 0; i-- {
+			cmd = append(cmd, vals[i].([]byte))
+		}
 		return []CmdLine{cmd}
 	}
-	// 撤回单个命令
-	element, _ := list.Get(0).([]byte)
-	return []CmdLine{
-		{
-			lPushCmd,
-			args[0],
-			element,
-		},
+	vals := list.Range(list.Len()-count, list.Len())
+	cmd := CmdLine{rPushCmd, args[0]}
+	for _, val := range vals {
+		cmd = append(cmd, val.([]byte))
 	}
+	return []CmdLine{cmd}
+}
+
+func undoLPop(db *DB, args [][]byte) []CmdLine {
+	return undoListPop(db, args, true)
 }
 
 // 向 key 对应的 list 中插入对应的值，返回 list 的长度
@@ -196,7 +225,7 @@ func execLPush(db *DB, args [][]byte) myredis.Reply {
 	}
 
 	for _, value := range values {
-		list.Insert(0, value)
+		list.AddFirst(value)
 	}
 	db.addAof(utils.ToCmdLine3("lpush", args...))
 	return protocol.MakeIntReply(int64(list.Len()))
@@ -233,7 +262,7 @@ func execLPushX(db *DB, args [][]byte) myredis.Reply {
 	}
 
 	for _, value := range values {
-		list.Insert(0, value)
+		list.AddFirst(value)
 	}
 	db.addAof(utils.ToCmdLine3("lpushx", args...))
 	return protocol.MakeIntReply(int64(list.Len()))
@@ -422,97 +451,15 @@ func undoLSet(db *DB, args [][]byte) []CmdLine {
 	}
 }
 
-// 从右侧开始移除 key 对应的 List 的成员  RPop key count
+// 从右侧开始移除 key 对应的 List 的成员  RPop key [count]
 func execRPop(db *DB, args [][]byte) myredis.Reply {
-	if len(args) < 1 {
-		return protocol.MakeErrReply("ERR wrong number of arguments for 'rpop' command")
-	}
-
-	key := string(args[0])
-
-	list, errReply := db.getAsList(key)
-	if errReply != nil {
-		return errReply
-	}
-	if list == nil {
-		return protocol.MakeNullBulkReply()
-	}
-
-	// 如果设置 POP 的数量
-	if len(args) == 2 {
-		count64, err := strconv.ParseInt(string(args[1]), 10, 64)
-		if err != nil {
-			return protocol.MakeErrReply("ERR value is not an integer or out of range")
-		}
-		count := int(count64)
-		if count > list.Len() {
-			count = list.Len()
-		}
-		vals := make([][]byte, count)
-		for i := 0; i < count; i++ {
-			val := list.RemoveLast().([]byte)
-			vals[i] = val
-		}
-		// 如果全删完了
-		if list.Len() == 0 {
-			db.Remove(key)
-		}
-		db.addAof(utils.ToCmdLine3("rpop", args...))
-		return protocol.MakeMultiBulkReply(vals)
-	}
-	// 未设置删除数量
-	val, _ := list.RemoveLast().([]byte)
-	if list.Len() == 0 {
-		db.Remove(key)
-	}
-	db.addAof(utils.ToCmdLine3("rpop", args...))
-	return protocol.MakeBulkReply(val)
+	return execListPop(db, args, false)
 }
 
 var rPushCmd = []byte("RPUSH")
 
 func undoRPop(db *DB, args [][]byte) []CmdLine {
-	key := string(args[0])
-	list, errReply := db.getAsList(key)
-	if errReply != nil {
-		return nil
-	}
-	if list == nil || list.Len() == 0 {
-		return nil
-	}
-	if len(args) == 2 {
-		count64, err := strconv.ParseInt(string(args[1]), 10, 64)
-		if err != nil {
-			return nil
-		}
-		count := int(count64)
-		if count > list.Len() {
-			count = list.Len()
-		}
-		elements := make([][]byte, count)
-		vals := list.Range(list.Len()-count, list.Len())
-		for i := 0; i < count; i++ {
-			elements[i] = vals[i].([]byte)
-		}
-		cmd := CmdLine{rPushCmd, args[0]}
-		cmd = append(cmd, elements...)
-		return []CmdLine{cmd}
-	}
-	element, _ := list.Get(list.Len() - 1).([]byte)
-	return []CmdLine{
-		{
-			rPushCmd,
-			args[0],
-			element,
-		},
-	}
-}
-
-func prepareRPopLPush(args [][]byte) ([]string, []string) {
-	return []string{
-		string(args[0]),
-		string(args[1]),
-	}, nil
+	return undoListPop(db, args, false)
 }
 
 // 移除 List A 的最后一个元素，插入 List B 的第一个位置
@@ -538,7 +485,7 @@ func execRPopLPush(db *DB, args [][]byte) myredis.Reply {
 
 	// RPop
 	val, _ := sourceList.RemoveLast().([]byte)
-	destList.Insert(0, val)
+	destList.AddFirst(val)
 
 	if sourceList.Len() == 0 {
 		db.Remove(sourceKey)
@@ -727,34 +674,265 @@ func execLInsert(db *DB, args [][]byte) myredis.Reply {
 	return protocol.MakeIntReply(int64(list.Len()))
 }
 
+// 解析 LEFT/RIGHT 方向参数，LEFT 表示列表头部
+func parseListDirection(arg []byte) (left bool, ok bool) {
+	switch strings.ToUpper(string(arg)) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+// 从 srcKey 的一端弹出一个元素写入 destKey 的一端，srcKey 不存在时返回 nil
+func (db *DB) listMove(srcKey, destKey string, srcLeft, destLeft bool) ([]byte, protocol.ErrorReply) {
+	srcList, errReply := db.getAsList(srcKey)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if srcList == nil {
+		return nil, nil
+	}
+	// 先检查目标键的类型，避免弹出元素后无法写入
+	if _, errReply = db.getAsList(destKey); errReply != nil {
+		return nil, errReply
+	}
+	var val []byte
+	if srcLeft {
+		val, _ = srcList.RemoveFirst().([]byte)
+	} else {
+		val, _ = srcList.RemoveLast().([]byte)
+	}
+	if srcList.Len() == 0 {
+		db.Remove(srcKey)
+	}
+	// 源键与目标键相同且只有一个元素时，源键已被删除，这里会重新创建
+	destList, _, _ := db.getOrInitList(destKey)
+	if destLeft {
+		destList.AddFirst(val)
+	} else {
+		destList.Add(val)
+	}
+	return val, nil
+}
+
+// LMOVE source destination LEFT|RIGHT LEFT|RIGHT，返回被移动的元素
+func execLMove(db *DB, args [][]byte) myredis.Reply {
+	srcLeft, ok := parseListDirection(args[2])
+	if !ok {
+		return protocol.MakeSyntaxErrReply()
+	}
+	destLeft, ok := parseListDirection(args[3])
+	if !ok {
+		return protocol.MakeSyntaxErrReply()
+	}
+	val, errReply := db.listMove(string(args[0]), string(args[1]), srcLeft, destLeft)
+	if errReply != nil {
+		return errReply
+	}
+	if val == nil {
+		return protocol.MakeNullBulkReply()
+	}
+	db.addAof(utils.ToCmdLine3("lmove", args...))
+	return protocol.MakeBulkReply(val)
+}
+
+func undoLMove(db *DB, args [][]byte) []CmdLine {
+	return rollbackGivenKeys(db, string(args[0]), string(args[1]))
+}
+
+// LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+//
+// RANK 为负数时从尾部开始查找，不指定 COUNT 时返回第一个匹配的下标，指定 COUNT 时返回数组，COUNT 为 0 表示返回全部
+func execLPos(db *DB, args [][]byte) myredis.Reply {
+	key := string(args[0])
+	element := args[1]
+	rank, count, maxLen := int64(1), int64(0), int64(0)
+	hasCount := false
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return protocol.MakeSyntaxErrReply()
+		}
+		val, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil {
+			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "RANK":
+			if val == 0 {
+				return protocol.MakeErrReply("ERR RANK can't be zero: use 1 to start from the first match, " +
+					"2 from the second ... or use negative to start from the end of the list")
+			}
+			if val == math.MinInt64 {
+				return protocol.MakeErrReply("ERR value is out of range, value must between " +
+					"-9223372036854775807 and 9223372036854775807")
+			}
+			rank = val
+		case "COUNT":
+			if val < 0 {
+				return protocol.MakeErrReply("ERR COUNT can't be negative")
+			}
+			count = val
+			hasCount = true
+		case "MAXLEN":
+			if val < 0 {
+				return protocol.MakeErrReply("ERR MAXLEN can't be negative")
+			}
+			maxLen = val
+		default:
+			return protocol.MakeSyntaxErrReply()
+		}
+	}
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	var positions []int64
+	if list != nil {
+		// 跳过前 |rank|-1 个匹配，最多比较 maxLen 个元素
+		skip := rank - 1
+		if rank < 0 {
+			skip = -rank - 1
+		}
+		var scanned int64
+		consumer := func(i int, v interface{}) bool {
+			if maxLen > 0 && scanned >= maxLen {
+				return false
+			}
+			scanned++
+			if val, _ := v.([]byte); !bytes.Equal(val, element) {
+				return true
+			}
+			if skip > 0 {
+				skip--
+				return true
+			}
+			positions = append(positions, int64(i))
+			return hasCount && (count == 0 || int64(len(positions)) < count)
+		}
+		if rank > 0 {
+			list.ForEach(consumer)
+		} else {
+			list.ReverseForEach(consumer)
+		}
+	}
+
+	if !hasCount {
+		if len(positions) == 0 {
+			return protocol.MakeNullBulkReply()
+		}
+		return protocol.MakeIntReply(positions[0])
+	}
+	replies := make([]myredis.Reply, len(positions))
+	for i, pos := range positions {
+		replies[i] = protocol.MakeIntReply(pos)
+	}
+	return protocol.MakeMultiRawReply(replies)
+}
+
+// LMPOP 与 BLMPOP 的公共实现，args 从 numkeys 开始
+//
+// 从第一个非空的列表中弹出最多 count 个元素，返回 [key, [element, ...]]，所有列表为空时返回空数组
+func (db *DB) listMPop(cmdName string, args [][]byte) myredis.Reply {
+	keys, rest, errReply := parseNumKeys(cmdName, args)
+	if errReply != nil {
+		return errReply
+	}
+	if len(rest) != 1 && len(rest) != 3 {
+		return protocol.MakeSyntaxErrReply()
+	}
+	left, ok := parseListDirection(rest[0])
+	if !ok {
+		return protocol.MakeSyntaxErrReply()
+	}
+	count := 1
+	if len(rest) == 3 {
+		if strings.ToUpper(string(rest[1])) != "COUNT" {
+			return protocol.MakeSyntaxErrReply()
+		}
+		var err error
+		count, err = strconv.Atoi(string(rest[2]))
+		if err != nil || count <= 0 {
+			return protocol.MakeErrReply("ERR count should be greater than 0")
+		}
+	}
+	for _, key := range keys {
+		vals, errReply := db.listPop(key, count, left)
+		if errReply != nil {
+			return errReply
+		}
+		if len(vals) == 0 {
+			continue
+		}
+		return protocol.MakeMultiRawReply([]myredis.Reply{
+			protocol.MakeBulkReply([]byte(key)),
+			protocol.MakeMultiBulkReply(vals),
+		})
+	}
+	return protocol.MakeNullMultiBulkReply()
+}
+
+// LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func execLMPop(db *DB, args [][]byte) myredis.Reply {
+	return db.listMPop("lmpop", args)
+}
+
+func undoLMPop(db *DB, args [][]byte) []CmdLine {
+	return rollbackGivenKeys(db, keyspec.NumKeys(args)...)
+}
+
+// BLMPOP timeout numkeys key [key ...] LEFT|RIGHT [COUNT count]
+//
+// 这里是不阻塞的版本，所有列表为空时返回空数组；在事务之外执行时由 execBlocking 负责等待
+func execBLMPop(db *DB, args [][]byte) myredis.Reply {
+	if _, errReply := parseBlockingTimeout(args[0]); errReply != nil {
+		return errReply
+	}
+	return db.listMPop("blmpop", args[1:])
+}
+
+func undoBLMPop(db *DB, args [][]byte) []CmdLine {
+	return rollbackGivenKeys(db, keyspec.NumKeys(args[1:])...)
+}
+
 func init() {
-	registerCommand("LIndex", execLIndex, readFirstKey, nil, 3, flagReadOnly).
+	registerCommand("LIndex", execLIndex, nil, 3, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
-	registerCommand("LLen", execLLen, readFirstKey, nil, 2, flagReadOnly).
+	registerCommand("LLen", execLLen, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("LPop", execLPop, writeFirstKey, undoLPop, -2, flagWrite).
+	registerCommand("LPop", execLPop, undoLPop, -2, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
-	registerCommand("LPush", execLPush, writeFirstKey, undoLPush, -3, flagWrite).
+	registerCommand("LPush", execLPush, undoLPush, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
-	registerCommand("LPushX", execLPushX, writeFirstKey, undoLPush, -3, flagWrite).
+	registerCommand("LPushX", execLPushX, undoLPush, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
-	registerCommand("LRange", execLRange, readFirstKey, nil, 4, flagReadOnly).
+	registerCommand("LRange", execLRange, nil, 4, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
-	registerCommand("LRem", execLRem, writeFirstKey, rollbackFirstKey, 4, flagWrite).
+	registerCommand("LRem", execLRem, rollbackFirstKey, 4, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite}, 1, 1, 1)
-	registerCommand("LSet", execLSet, writeFirstKey, undoLSet, 4, flagWrite).
+	registerCommand("LSet", execLSet, undoLSet, 4, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
-	registerCommand("RPop", execRPop, writeFirstKey, undoRPop, -2, flagWrite).
+	registerCommand("RPop", execRPop, undoRPop, -2, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
 	// 遵循 Redis 的约定，将源键作为主要键来标记
-	registerCommand("RPopLPush", execRPopLPush, prepareRPopLPush, undoRPopLPush, 3, flagWrite).
+	registerCommand("RPopLPush", execRPopLPush, undoRPopLPush, 3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
-	registerCommand("RPush", execRPush, writeFirstKey, undoRPush, -3, flagWrite).
+	registerCommand("RPush", execRPush, undoRPush, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
-	registerCommand("RPushX", execRPushX, writeFirstKey, undoRPush, -3, flagWrite).
+	registerCommand("RPushX", execRPushX, undoRPush, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
-	registerCommand("LTrim", execLTrim, writeFirstKey, rollbackFirstKey, 4, flagWrite).
+	registerCommand("LTrim", execLTrim, rollbackFirstKey, 4, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite}, 1, 1, 1)
-	registerCommand("LInsert", execLInsert, writeFirstKey, rollbackFirstKey, 5, flagWrite).
+	registerCommand("LInsert", execLInsert, rollbackFirstKey, 5, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	registerCommand("LMove", execLMove, undoLMove, 5, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 2, 1)
+	registerCommand("LPos", execLPos, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
+	registerCommand("LMPop", execLMPop, undoLMPop, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagMovableKeys}, 0, 0, 0)
+	registerCommand("BLMPop", execBLMPop, undoBLMPop, -5, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagMovableKeys}, 0, 0, 0)
 }
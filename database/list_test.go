@@ -1,11 +1,13 @@
 package database
 
 import (
+	"myredis/interface/myredis"
 	"myredis/lib/utils"
 	"myredis/protocol"
 	"myredis/protocol/assert"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestPush(t *testing.T) {
@@ -617,3 +619,141 @@ func TestUndoRPopLPush(t *testing.T) {
 	result = testDB.Exec(nil, utils.ToCmdLine("llen", key2))
 	assert.AssertIntReply(t, result, 0)
 }
+
+func TestListPopCount(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("rpush", key, "a", "b", "c", "d"))
+	assert.AssertMultiBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("lpop", key, "1")), []string{"a"})
+	assert.AssertMultiBulkReplySize(t, testDB.Exec(nil, utils.ToCmdLine("lpop", key, "0")), 0)
+	assert.AssertMultiBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("rpop", key, "2")), []string{"d", "c"})
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("lpop", key, "-1")),
+		"ERR value is out of range, must be positive")
+	assert.AssertNullMultiBulk(t, testDB.Exec(nil, utils.ToCmdLine("lpop", "missing", "2")))
+	assert.AssertNullBulk(t, testDB.Exec(nil, utils.ToCmdLine("rpop", "missing")))
+	if undoCmdLines := undoLPop(testDB, utils.ToCmdLine(key, "-1")); len(undoCmdLines) != 0 {
+		t.Error("expect no undo command for invalid count")
+	}
+}
+
+func TestLPos(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("rpush", key, "a", "b", "c", "1", "2", "3", "c", "c"))
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("lpos", key, "c")), 2)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("lpos", key, "c", "rank", "2")), 6)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("lpos", key, "c", "rank", "-1")), 7)
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("lpos", key, "c", "count", "2")), 2, 6)
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("lpos", key, "c", "count", "0")), 2, 6, 7)
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("lpos", key, "c", "rank", "-2", "count", "0")), 6, 2)
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("lpos", key, "c", "count", "0", "maxlen", "7")), 2, 6)
+	assert.AssertNullBulk(t, testDB.Exec(nil, utils.ToCmdLine("lpos", key, "x")))
+	assertFieldCodes(t, testDB.Exec(nil, utils.ToCmdLine("lpos", key, "x", "count", "1")))
+	assert.AssertNullBulk(t, testDB.Exec(nil, utils.ToCmdLine("lpos", "missing", "x")))
+
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("lpos", key, "c", "rank", "0")),
+		"ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... "+
+			"or use negative to start from the end of the list")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("lpos", key, "c", "count", "-1")),
+		"ERR COUNT can't be negative")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("lpos", key, "c", "maxlen", "-1")),
+		"ERR MAXLEN can't be negative")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("lpos", key, "c", "rank")), "Err syntax error")
+}
+
+func TestLMove(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("rpush", "src", "a", "b", "c"))
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("lmove", "src", "dest", "right", "left")), "c")
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("lmove", "src", "dest", "left", "right")), "a")
+	assert.AssertMultiBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("lrange", "dest", "0", "-1")), []string{"c", "a"})
+	// 同一个列表内旋转
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("lmove", "dest", "dest", "left", "right")), "c")
+	assert.AssertMultiBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("lrange", "dest", "0", "-1")), []string{"a", "c"})
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("lmove", "src", "src", "left", "left")), "b")
+	assert.AssertMultiBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("lrange", "src", "0", "-1")), []string{"b"})
+
+	assert.AssertNullBulk(t, testDB.Exec(nil, utils.ToCmdLine("lmove", "missing", "dest", "left", "left")))
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("lmove", "src", "dest", "up", "left")), "Err syntax error")
+	testDB.Exec(nil, utils.ToCmdLine("set", "str", "x"))
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("lmove", "src", "str", "left", "left")),
+		"WRONGTYPE Operation against a key holding the wrong kind of value")
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("llen", "src")), 1)
+
+	// 回滚
+	undoCmdLines := undoLMove(testDB, utils.ToCmdLine("dest", "src", "right", "left"))
+	testDB.Exec(nil, utils.ToCmdLine("lmove", "dest", "src", "right", "left"))
+	for _, cmdLine := range undoCmdLines {
+		testDB.Exec(nil, cmdLine)
+	}
+	assert.AssertMultiBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("lrange", "dest", "0", "-1")), []string{"a", "c"})
+	assert.AssertMultiBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("lrange", "src", "0", "-1")), []string{"b"})
+}
+
+// 生成 LMPOP 的期望返回值
+func lmpopReply(key string, vals ...string) []byte {
+	return protocol.MakeMultiRawReply([]myredis.Reply{
+		protocol.MakeBulkReply([]byte(key)),
+		protocol.MakeMultiBulkReply(utils.ToCmdLine(vals...)),
+	}).ToBytes()
+}
+
+func TestLMPop(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("rpush", "l2", "a", "b", "c"))
+	result := testDB.Exec(nil, utils.ToCmdLine("lmpop", "2", "l1", "l2", "left"))
+	if string(result.ToBytes()) != string(lmpopReply("l2", "a")) {
+		t.Errorf("unexpected reply %q", result.ToBytes())
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("lmpop", "2", "l1", "l2", "right", "count", "5"))
+	if string(result.ToBytes()) != string(lmpopReply("l2", "c", "b")) {
+		t.Errorf("unexpected reply %q", result.ToBytes())
+	}
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("exists", "l2")), 0)
+	assert.AssertNullMultiBulk(t, testDB.Exec(nil, utils.ToCmdLine("lmpop", "2", "l1", "l2", "left")))
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("lmpop", "1", "l1", "left", "count", "0")),
+		"ERR count should be greater than 0")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("lmpop", "1", "l1", "middle")), "Err syntax error")
+
+	// 回滚
+	testDB.Exec(nil, utils.ToCmdLine("rpush", "l1", "x", "y"))
+	undoCmdLines := undoLMPop(testDB, utils.ToCmdLine("1", "l1", "left", "count", "2"))
+	testDB.Exec(nil, utils.ToCmdLine("lmpop", "1", "l1", "left", "count", "2"))
+	for _, cmdLine := range undoCmdLines {
+		testDB.Exec(nil, cmdLine)
+	}
+	assert.AssertMultiBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("lrange", "l1", "0", "-1")), []string{"x", "y"})
+}
+
+func TestBLMPop(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("rpush", "l2", "a", "b"))
+	result := testDB.Exec(nil, utils.ToCmdLine("blmpop", "0", "2", "l1", "l2", "right"))
+	if string(result.ToBytes()) != string(lmpopReply("l2", "b")) {
+		t.Errorf("unexpected reply %q", result.ToBytes())
+	}
+
+	start := time.Now()
+	assert.AssertNullMultiBulk(t, testDB.Exec(nil, utils.ToCmdLine("blmpop", "0.1", "1", "l1", "left")))
+	if time.Since(start) < 100*time.Millisecond {
+		t.Error("blmpop should wait until timeout")
+	}
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("blmpop", "-1", "1", "l1", "left")),
+		"ERR timeout is negative")
+
+	// 写入后唤醒等待的命令
+	done := make(chan myredis.Reply)
+	go func() {
+		done <- testDB.Exec(nil, utils.ToCmdLine("blmpop", "0", "2", "l1", "l3", "left", "count", "2"))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	testDB.Exec(nil, utils.ToCmdLine("lpush", "l3", "x", "y", "z"))
+	select {
+	case result = <-done:
+		if string(result.ToBytes()) != string(lmpopReply("l3", "z", "y")) {
+			t.Errorf("unexpected reply %q", result.ToBytes())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blmpop is not woken up")
+	}
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"fmt"
+	"myredis/config"
+	"myredis/interface/myredis"
+	"myredis/lib/logger"
+	"os"
+	"strings"
+	"time"
+)
+
+// 加载数据期间仍然可以执行的命令，其余命令返回 -LOADING
+var loadingCommands = map[string]bool{
+	"auth":   true,
+	"info":   true,
+	"select": true,
+}
+
+// 加载 AOF 时交给持久化器使用的数据库，重放的命令不受 -LOADING 限制
+type loaderEngine struct {
+	*Server
+}
+
+func (engine loaderEngine) Exec(c myredis.Connection, cmdLine [][]byte) myredis.Reply {
+	return engine.exec(c, cmdLine, true)
+}
+
+// 持久化器加载 AOF 时通过以下两个方法报告进度
+func (engine loaderEngine) SetLoadingTotal(total int64) {
+	engine.loadingTotal.Store(total)
+}
+
+func (engine loaderEngine) SetLoadingLoaded(loaded int64) {
+	engine.loadingLoaded.Store(loaded)
+}
+
+// 创建单机服务器，并在后台加载数据
+//
+// 开启 appendonly 时从 AOF 恢复数据，否则从 RDB 文件恢复，加载完成前客户端命令返回 -LOADING
+func NewStandaloneServer() *Server {
+	server := MakeAuxiliaryServer()
+	// 创建 myredis 所需的临时目录
+	err := os.MkdirAll(config.GetTmpDir(), os.ModePerm)
+	if err != nil {
+		panic(fmt.Sprintf("create temp dir failed: %v", err))
+	}
+	server.startLoading()
+	go server.loadData()
+	return server
+}
+
+// 加载 AOF 或 RDB 文件并启动持久化任务，数据文件无法加载时退出进程
+func (server *Server) loadData() {
+	defer server.finishLoading()
+	if config.Properties.AppendOnly {
+		persister, err := NewPersister(loaderEngine{server}, config.Properties.AppendFilename, true, config.Properties.AppendFsync)
+		if err != nil {
+			logger.Fatal(fmt.Sprintf("load aof failed: %v", err))
+			os.Exit(1)
+		}
+		server.bindPersister(persister)
+		return
+	}
+	if fileExists(rdbFilename()) {
+		if err := server.loadRdbFile(); err != nil {
+			logger.Fatal(err.Error())
+			os.Exit(1)
+		}
+	}
+	// 加载过程中产生的修改已经持久化
+	server.dirty.Store(0)
+	server.startPersistenceCron()
+}
+
+func (server *Server) startLoading() {
+	server.loadingStart.Store(time.Now().Unix())
+	server.loadingTotal.Store(0)
+	server.loadingLoaded.Store(0)
+	server.loading.Store(true)
+}
+
+// 结束加载状态并通知加载完成的回调函数
+func (server *Server) finishLoading() {
+	server.loading.Store(false)
+	if callback := server.loadedCallback; callback != nil {
+		callback()
+	}
+}
+
+// INFO persistence 中的加载进度，总大小未知时不输出百分比与预计剩余时间
+func genLoadingInfo(server *Server, sb *strings.Builder) {
+	if !server.loading.Load() {
+		sb.WriteString("loading:0\r\n")
+		return
+	}
+	start := server.loadingStart.Load()
+	total := server.loadingTotal.Load()
+	loaded := server.loadingLoaded.Load()
+	sb.WriteString("loading:1\r\n")
+	fmt.Fprintf(sb, "loading_start_time:%d\r\n", start)
+	fmt.Fprintf(sb, "loading_total_bytes:%d\r\n", total)
+	fmt.Fprintf(sb, "loading_loaded_bytes:%d\r\n", loaded)
+	if total <= 0 {
+		return
+	}
+	eta := int64(1)
+	elapsed := time.Now().Unix() - start
+	if loaded > 0 && elapsed > 0 {
+		eta = (total - loaded) * elapsed / loaded
+	}
+	fmt.Fprintf(sb, "loading_loaded_perc:%.2f\r\n", float64(loaded)*100/float64(total))
+	fmt.Fprintf(sb, "loading_eta_seconds:%d\r\n", eta)
+}
@@ -0,0 +1,247 @@
+package database
+
+import (
+	"bytes"
+	"myredis/aof"
+	"myredis/config"
+	"myredis/lib/utils"
+	"myredis/myredis/connection"
+	"myredis/protocol"
+	"myredis/protocol/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/rdb/crc64jones"
+	rdb "github.com/hdt3213/rdb/encoder"
+)
+
+// 写入只包含一个字符串的 RDB 文件，tail 追加在 RDB 内容之后
+func writeTestRDB(t *testing.T, filename string, aux map[string]string, tail string) {
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	encoder := rdb.NewEncoder(file)
+	if err = encoder.WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range aux {
+		if err = encoder.WriteAux(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = encoder.WriteDBHeader(0, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = encoder.WriteStringObject("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err = encoder.WriteEnd(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = file.WriteString(tail); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadRDBExpiration(t *testing.T) {
+	dir := t.TempDir()
+	config.Properties = &config.ServerProperties{
+		Dir:         dir,
+		Databases:   4,
+		RDBFilename: filepath.Join(dir, "dump.rdb"),
+	}
+	server := MakeAuxiliaryServer()
+	conn := connection.NewSimpleConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "persist", "1"))
+	server.Exec(conn, utils.ToCmdLine("SET", "ttl", "1", "EX", "1000"))
+	server.Exec(conn, utils.ToCmdLine("SET", "expired", "1", "PX", "100"))
+	assert.AssertStatusReply(t, server.Exec(conn, utils.ToCmdLine("SAVE")), "OK")
+	time.Sleep(150 * time.Millisecond)
+
+	loaded := MakeAuxiliaryServer()
+	if err := loaded.loadRdbFile(); err != nil {
+		t.Fatal(err)
+	}
+	assert.AssertIntReply(t, loaded.Exec(conn, utils.ToCmdLine("TTL", "persist")), -1)
+	ttl, ok := loaded.Exec(conn, utils.ToCmdLine("TTL", "ttl")).(*protocol.IntReply)
+	if !ok || ttl.Code <= 990 || ttl.Code > 1000 {
+		t.Errorf("wrong ttl %v", ttl)
+	}
+	assert.AssertIntReply(t, loaded.Exec(conn, utils.ToCmdLine("EXISTS", "expired")), 0)
+	if keys, _ := loaded.GetDBSize(0); keys != 2 {
+		t.Errorf("expect 2 keys, actually %d", keys)
+	}
+}
+
+func TestLoadRDBAux(t *testing.T) {
+	dir := t.TempDir()
+	config.Properties = &config.ServerProperties{
+		Dir:         dir,
+		Databases:   4,
+		RDBFilename: filepath.Join(dir, "dump.rdb"),
+	}
+	conn := connection.NewSimpleConn()
+
+	// 更高版本写入的文件不能加载
+	writeTestRDB(t, config.Properties.RDBFilename, map[string]string{"myredis-version": "0.1.0"}, "")
+	if err := MakeAuxiliaryServer().loadRdbFile(); err == nil {
+		t.Error("expect error for newer myredis-version")
+	}
+	writeTestRDB(t, config.Properties.RDBFilename, map[string]string{"myredis-version": "x"}, "")
+	if err := MakeAuxiliaryServer().loadRdbFile(); err == nil {
+		t.Error("expect error for invalid myredis-version")
+	}
+
+	// aof-preamble 为 1 时继续重放 RDB 之后的命令
+	tail := string(protocol.MakeMultiBulkReply(utils.ToCmdLine("SET", "b", "2")).ToBytes())
+	writeTestRDB(t, config.Properties.RDBFilename, map[string]string{
+		"myredis-version": "0.0.1",
+		"aof-preamble":    "1",
+	}, tail)
+	server := MakeAuxiliaryServer()
+	if err := server.loadRdbFile(); err != nil {
+		t.Fatal(err)
+	}
+	assert.AssertBulkReply(t, server.Exec(conn, utils.ToCmdLine("GET", "a")), "1")
+	assert.AssertBulkReply(t, server.Exec(conn, utils.ToCmdLine("GET", "b")), "2")
+	// 重放 RDB 之后的命令同样计入加载进度
+	if stat, _ := os.Stat(config.Properties.RDBFilename); server.loadingLoaded.Load() != stat.Size() {
+		t.Errorf("expect %d bytes loaded, actually %d", stat.Size(), server.loadingLoaded.Load())
+	}
+}
+
+func TestLoadingAofProgress(t *testing.T) {
+	dir := t.TempDir()
+	aofFilename := filepath.Join(dir, "appendonly.aof")
+	config.Properties = &config.ServerProperties{
+		Dir:               dir,
+		Databases:         4,
+		AppendOnly:        true,
+		AppendFilename:    aofFilename,
+		AofUseRdbPreamble: true,
+		AppendFsync:       aof.FsyncAlways,
+	}
+	_ = os.MkdirAll(config.GetTmpDir(), os.ModePerm)
+	server := MakeAuxiliaryServer()
+	persister, err := NewPersister(server, aofFilename, true, aof.FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.bindPersister(persister)
+	conn := connection.NewSimpleConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "1"))
+	if err := persister.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	server.Exec(conn, utils.ToCmdLine("SET", "b", "2"))
+	server.Exec(conn, utils.ToCmdLine("SET", "c", "3"))
+	persister.Close()
+	var size int64
+	entries, _ := os.ReadDir(filepath.Join(dir, "appendonlydir"))
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".manifest") {
+			info, _ := entry.Info()
+			size += info.Size()
+		}
+	}
+
+	// 总大小来自清单中的 base 与 incr 文件，重放命令时更新已加载的字节数
+	loaded := MakeAuxiliaryServer()
+	loaded.startLoading()
+	persister2, err := NewPersister(loaderEngine{loaded}, aofFilename, true, aof.FsyncAlways)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer persister2.Close()
+	if loaded.loadingTotal.Load() != size || loaded.loadingLoaded.Load() != size {
+		t.Errorf("expect %d bytes, actually total %d loaded %d", size, loaded.loadingTotal.Load(), loaded.loadingLoaded.Load())
+	}
+	assert.AssertBulkReply(t, loaderEngine{loaded}.Exec(conn, utils.ToCmdLine("GET", "c")), "3")
+}
+
+func TestLoading(t *testing.T) {
+	dir := t.TempDir()
+	config.Properties = &config.ServerProperties{
+		Dir:         dir,
+		Databases:   4,
+		RDBFilename: filepath.Join(dir, "dump.rdb"),
+	}
+	config.EachTimeServerInfo = &config.ServerInfo{StartUpTime: time.Now()}
+	conn := connection.NewSimpleConn()
+	server := MakeAuxiliaryServer()
+	server.startLoading()
+	server.loadingTotal.Store(100)
+	server.loadingLoaded.Store(40)
+	assert.AssertErrReply(t, server.Exec(conn, utils.ToCmdLine("GET", "a")), "LOADING Redis is loading the dataset in memory")
+	assert.AssertStatusReply(t, server.Exec(conn, utils.ToCmdLine("SELECT", "1")), "OK")
+	info := string(server.Exec(conn, utils.ToCmdLine("INFO", "persistence")).ToBytes())
+	for _, field := range []string{"loading:1", "loading_total_bytes:100", "loading_loaded_bytes:40", "loading_loaded_perc:40.00", "loading_eta_seconds:"} {
+		if !strings.Contains(info, field) {
+			t.Errorf("expect %s in info: %s", field, info)
+		}
+	}
+	// 加载过程执行的命令不受限制
+	assert.AssertStatusReply(t, loaderEngine{server}.Exec(conn, utils.ToCmdLine("SET", "a", "1")), "OK")
+	server.loading.Store(false)
+	assert.AssertBulkReply(t, server.Exec(conn, utils.ToCmdLine("GET", "a")), "1")
+
+	writeTestRDB(t, config.Properties.RDBFilename, nil, "")
+	loaded := NewStandaloneServer()
+	waitFor(t, func() bool { return !loaded.loading.Load() })
+	defer loaded.Close()
+	conn = connection.NewSimpleConn()
+	assert.AssertBulkReply(t, loaded.Exec(conn, utils.ToCmdLine("GET", "a")), "1")
+	info = string(loaded.Exec(conn, utils.ToCmdLine("INFO", "persistence")).ToBytes())
+	if !strings.Contains(info, "loading:0") {
+		t.Errorf("expect loading:0 in info: %s", info)
+	}
+}
+
+func TestLoadRDBFieldExpire(t *testing.T) {
+	dir := t.TempDir()
+	config.Properties = &config.ServerProperties{
+		Dir:         dir,
+		Databases:   4,
+		RDBFilename: filepath.Join(dir, "dump.rdb"),
+	}
+	server := MakeAuxiliaryServer()
+	conn := connection.NewSimpleConn()
+	conn.SelectDB(1)
+	server.Exec(conn, utils.ToCmdLine("HSET", "hash", "a", "1"))
+	server.Exec(conn, utils.ToCmdLine("HSET", "hash", "b", "2"))
+	server.Exec(conn, utils.ToCmdLine("HSET", "hash", "c", "3"))
+	server.Exec(conn, utils.ToCmdLine("HSET", "hash", "\x00myredis-hfe", "junk"))
+	server.Exec(conn, utils.ToCmdLine("HEXPIRE", "hash", "1000", "FIELDS", "1", "a"))
+	server.Exec(conn, utils.ToCmdLine("HPEXPIRE", "hash", "100", "FIELDS", "1", "b"))
+	server.Exec(conn, utils.ToCmdLine("HSET", "expired", "a", "1"))
+	server.Exec(conn, utils.ToCmdLine("HPEXPIRE", "expired", "100", "FIELDS", "1", "a"))
+	assert.AssertStatusReply(t, server.Exec(conn, utils.ToCmdLine("SAVE")), "OK")
+	time.Sleep(150 * time.Millisecond)
+
+	// 字段过期时间以辅助字段写入，校验和需要覆盖它们
+	content, err := os.ReadFile(config.Properties.RDBFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crc := crc64jones.New()
+	_, _ = crc.Write(content[:len(content)-8])
+	if !bytes.Equal(crc.Sum(nil), content[len(content)-8:]) {
+		t.Error("wrong rdb checksum")
+	}
+
+	loaded := MakeAuxiliaryServer()
+	if err := loaded.loadRdbFile(); err != nil {
+		t.Fatal(err)
+	}
+	ttl := loaded.Exec(conn, utils.ToCmdLine("HTTL", "hash", "FIELDS", "3", "a", "b", "c"))
+	if string(ttl.ToBytes()) != "*3\r\n:1000\r\n:-2\r\n:-1\r\n" {
+		t.Errorf("wrong field ttl %q", ttl.ToBytes())
+	}
+	assert.AssertIntReply(t, loaded.Exec(conn, utils.ToCmdLine("EXISTS", "expired")), 0)
+	assert.AssertBulkReply(t, loaded.Exec(conn, utils.ToCmdLine("HGET", "hash", "\x00myredis-hfe")), "junk")
+}
@@ -0,0 +1,212 @@
+// object.go 记录键的访问信息，并实现 OBJECT 命令
+//
+// 每个键记录最近一次访问时间（用于 IDLETIME）与 LFU 对数计数器（用于 FREQ），
+// 计数器的递增与衰减规则与 Redis 相同
+package database
+
+import (
+	"math"
+	"math/rand"
+	"myredis/config"
+	"myredis/datastruct/dict"
+	"myredis/datastruct/list"
+	"myredis/datastruct/strobj"
+	"myredis/interface/database"
+	"myredis/interface/myredis"
+	"myredis/protocol"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 新建键的 LFU 计数器初始值
+	lfuInitVal = 5
+	// 计数器增长的对数因子，越大增长越慢
+	lfuLogFactor = 10
+	// 每经过多少分钟计数器减一
+	lfuDecayTime = 1
+	// 不超过该长度的字符串编码为 embstr
+	embstrSizeLimit = 44
+)
+
+// 键的访问信息
+type keyAccess struct {
+	lastAccess atomic.Int64  // 最近一次访问的毫秒时间戳
+	counter    atomic.Uint32 // LFU 对数计数器，取值 0-255
+}
+
+func makeKeyAccess(now time.Time) *keyAccess {
+	access := &keyAccess{}
+	access.lastAccess.Store(now.UnixMilli())
+	access.counter.Store(lfuInitVal)
+	return access
+}
+
+// 返回衰减后的访问频率
+func (access *keyAccess) freq(now time.Time) uint32 {
+	counter := access.counter.Load()
+	elapsed := now.UnixMilli() - access.lastAccess.Load()
+	periods := elapsed / int64(time.Minute/time.Millisecond) / lfuDecayTime
+	if periods <= 0 {
+		return counter
+	}
+	if periods > int64(counter) {
+		return 0
+	}
+	return counter - uint32(periods)
+}
+
+// 返回空闲的秒数
+func (access *keyAccess) idleTime(now time.Time) int64 {
+	idle := (now.UnixMilli() - access.lastAccess.Load()) / 1000
+	if idle < 0 {
+		return 0
+	}
+	return idle
+}
+
+// 记录一次访问：先衰减，再按对数概率递增计数器
+func (access *keyAccess) touch(now time.Time) {
+	counter := access.freq(now)
+	if counter < 255 {
+		base := float64(counter) - lfuInitVal
+		if base < 0 {
+			base = 0
+		}
+		if rand.Float64() < 1/(base*lfuLogFactor+1) {
+			counter++
+		}
+	}
+	access.counter.Store(counter)
+	access.lastAccess.Store(now.UnixMilli())
+}
+
+// 获取 key 的访问信息，不存在时新建
+func (db *DB) getAccess(key string) *keyAccess {
+	raw, ok := db.accessMap.Get(key)
+	if ok {
+		return raw.(*keyAccess)
+	}
+	access := makeKeyAccess(time.Now())
+	db.accessMap.PutIfAbsent(key, access)
+	raw, _ = db.accessMap.Get(key)
+	return raw.(*keyAccess)
+}
+
+// 记录一次对 key 的访问
+func (db *DB) touchKey(key string) {
+	db.getAccess(key).touch(time.Now())
+}
+
+// 获取数据实体但不记录访问，供 OBJECT 等只查看元信息的命令使用
+func (db *DB) peekEntity(key string) (*database.DataEntity, bool) {
+	raw, ok := db.data.GetWithLock(key)
+	if !ok {
+		return nil, false
+	}
+	if db.IsExpired(key) {
+		return nil, false
+	}
+	entity, _ := raw.(*database.DataEntity)
+	return entity, true
+}
+
+// 返回对象的内部编码名称
+func getEncoding(entity *database.DataEntity) string {
+	// 使用紧凑编码的数据结构自己报告当前的编码
+	if encoded, ok := entity.Data.(interface{ Encoding() string }); ok {
+		return encoded.Encoding()
+	}
+	switch val := entity.Data.(type) {
+	case int64:
+		return "int"
+	case []byte:
+		if len(val) <= embstrSizeLimit {
+			return "embstr"
+		}
+		return "raw"
+	case list.List:
+		return "quicklist"
+	case dict.Dict:
+		return "hashtable"
+	}
+	return "unknown"
+}
+
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value",
+	"    associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is",
+	"    proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of",
+	"    seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified",
+	"    <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+// 与 Redis 相同，只有 maxmemory-policy 为 LFU 策略时才提供 OBJECT FREQ
+func lfuPolicySelected() bool {
+	if config.Properties == nil {
+		return false
+	}
+	policy := strings.ToLower(config.Properties.MaxmemoryPolicy)
+	return policy == "allkeys-lfu" || policy == "volatile-lfu"
+}
+
+// execObject: 查看键的内部信息，查看时不计为一次访问。
+// 返回值: ENCODING 返回编码名称，IDLETIME 返回空闲秒数，FREQ 返回访问频率，REFCOUNT 返回引用计数，键不存在时返回 nil。
+// 格式: OBJECT ENCODING|IDLETIME|FREQ|REFCOUNT [KEY] 或 OBJECT HELP
+func execObject(db *DB, args [][]byte) myredis.Reply {
+	subCmd := strings.ToLower(string(args[0]))
+	if subCmd == "help" && len(args) == 1 {
+		lines := make([][]byte, len(objectHelp))
+		for i, line := range objectHelp {
+			lines[i] = []byte(line)
+		}
+		return protocol.MakeMultiBulkReply(lines)
+	}
+	switch subCmd {
+	case "encoding", "idletime", "freq", "refcount":
+	default:
+		return protocol.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try OBJECT HELP.")
+	}
+	if len(args) != 2 {
+		return protocol.MakeErrReply("ERR wrong number of arguments for 'object|" + subCmd + "' command")
+	}
+	key := string(args[1])
+	entity, exists := db.peekEntity(key)
+	if !exists {
+		return protocol.MakeNullBulkReply()
+	}
+	switch subCmd {
+	case "encoding":
+		return protocol.MakeBulkReply([]byte(getEncoding(entity)))
+	case "idletime":
+		return protocol.MakeIntReply(db.getAccess(key).idleTime(time.Now()))
+	case "freq":
+		if !lfuPolicySelected() {
+			return protocol.MakeErrReply("ERR An LFU maxmemory policy is not selected, access frequency not tracked. " +
+				"Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		}
+		return protocol.MakeIntReply(int64(db.getAccess(key).freq(time.Now())))
+	default:
+		// 只有共享的整数对象会被多个键引用，与 Redis 相同返回 INT_MAX
+		if strobj.IsShared(entity.Data) {
+			return protocol.MakeIntReply(math.MaxInt32)
+		}
+		return protocol.MakeIntReply(1)
+	}
+}
+
+func init() {
+	registerCommand("Object", execObject, nil, -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom}, 2, 2, 1)
+}
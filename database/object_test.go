@@ -0,0 +1,129 @@
+package database
+
+import (
+	"math"
+	"myredis/config"
+	"myredis/lib/utils"
+	"myredis/protocol"
+	"myredis/protocol/assert"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestObject(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("SET", "int", "123"))
+	testDB.Exec(nil, utils.ToCmdLine("SET", "embstr", "hello"))
+	testDB.Exec(nil, utils.ToCmdLine("SET", "raw", strings.Repeat("a", 45)))
+	testDB.Exec(nil, utils.ToCmdLine("RPUSH", "list", "a"))
+	testDB.Exec(nil, utils.ToCmdLine("HSET", "hash", "f", "v"))
+	testDB.Exec(nil, utils.ToCmdLine("SADD", "set", "a"))
+	testDB.Exec(nil, utils.ToCmdLine("SADD", "intset", "1", "2"))
+	testDB.Exec(nil, utils.ToCmdLine("ZADD", "zset", "1", "a"))
+	encodings := map[string]string{
+		"int":    "int",
+		"embstr": "embstr",
+		"raw":    "raw",
+		"list":   "listpack",
+		"hash":   "listpack",
+		"set":    "listpack",
+		"intset": "intset",
+		"zset":   "listpack",
+	}
+	for key, encoding := range encodings {
+		assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("OBJECT", "ENCODING", key)), encoding)
+	}
+	// 小整数使用共享对象
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("OBJECT", "REFCOUNT", "int")), math.MaxInt32)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("OBJECT", "REFCOUNT", "embstr")), 1)
+	ret := testDB.Exec(nil, utils.ToCmdLine("OBJECT", "ENCODING", "none"))
+	if !utils.BytesEquals(ret.ToBytes(), protocol.MakeNullBulkReply().ToBytes()) {
+		t.Errorf("expected nil, actually %s", ret.ToBytes())
+	}
+	ret = testDB.Exec(nil, utils.ToCmdLine("OBJECT", "NONE", "int"))
+	assert.AssertErrReply(t, ret, "ERR unknown subcommand 'NONE'. Try OBJECT HELP.")
+	ret = testDB.Exec(nil, utils.ToCmdLine("OBJECT", "ENCODING"))
+	assert.AssertErrReply(t, ret, "ERR wrong number of arguments for 'object|encoding' command")
+	if _, ok := testDB.Exec(nil, utils.ToCmdLine("OBJECT", "HELP")).(*protocol.MultiBulkReply); !ok {
+		t.Error("expected help text")
+	}
+}
+
+func TestObjectEncodingConversion(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("HSET", "hash", "f", "v"))
+	testDB.Exec(nil, utils.ToCmdLine("HSET", "hash", "big", strings.Repeat("a", 65)))
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("OBJECT", "ENCODING", "hash")), "hashtable")
+	// 转换后不再转回紧凑编码
+	testDB.Exec(nil, utils.ToCmdLine("HDEL", "hash", "big"))
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("OBJECT", "ENCODING", "hash")), "hashtable")
+
+	for i := 0; i < 129; i++ {
+		testDB.Exec(nil, utils.ToCmdLine("ZADD", "zset", strconv.Itoa(i), "m"+strconv.Itoa(i)))
+		testDB.Exec(nil, utils.ToCmdLine("SADD", "set", "m"+strconv.Itoa(i)))
+	}
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("OBJECT", "ENCODING", "zset")), "skiplist")
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("OBJECT", "ENCODING", "set")), "hashtable")
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("ZCARD", "zset")), 129)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("SCARD", "set")), 129)
+
+	testDB.Exec(nil, utils.ToCmdLine("SADD", "intset", "1"))
+	testDB.Exec(nil, utils.ToCmdLine("SADD", "intset", "a"))
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("OBJECT", "ENCODING", "intset")), "listpack")
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("SISMEMBER", "intset", "1")), 1)
+
+	testDB.Exec(nil, utils.ToCmdLine("RPUSH", "list", strings.Repeat("a", 9000)))
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("OBJECT", "ENCODING", "list")), "quicklist")
+}
+
+// 使用给定的 maxmemory-policy，测试结束后恢复原来的配置
+func setMaxmemoryPolicy(t *testing.T, policy string) {
+	old := config.Properties
+	props := &config.ServerProperties{}
+	if old != nil {
+		*props = *old
+	}
+	props.MaxmemoryPolicy = policy
+	config.Properties = props
+	t.Cleanup(func() {
+		config.Properties = old
+	})
+}
+
+func TestObjectAccess(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("SET", "a", "1"))
+	// 与 Redis 相同，未选择 LFU 策略时不提供访问频率
+	setMaxmemoryPolicy(t, "noeviction")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("OBJECT", "FREQ", "a")),
+		"ERR An LFU maxmemory policy is not selected, access frequency not tracked. "+
+			"Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+	setMaxmemoryPolicy(t, "allkeys-lfu")
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("OBJECT", "FREQ", "a")), lfuInitVal)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("OBJECT", "IDLETIME", "a")), 0)
+
+	// 访问越多计数器越大，OBJECT 本身不计为访问
+	for i := 0; i < 1000; i++ {
+		testDB.Exec(nil, utils.ToCmdLine("GET", "a"))
+	}
+	freq := testDB.Exec(nil, utils.ToCmdLine("OBJECT", "FREQ", "a")).(*protocol.IntReply).Code
+	if freq <= lfuInitVal || freq >= 255 {
+		t.Errorf("unexpected freq %d", freq)
+	}
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("OBJECT", "FREQ", "a")), int(freq))
+
+	// 计数器每分钟衰减一
+	access := testDB.getAccess("a")
+	access.lastAccess.Store(time.Now().Add(-3 * time.Minute).UnixMilli())
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("OBJECT", "FREQ", "a")), int(freq)-3)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("OBJECT", "IDLETIME", "a")), 180)
+	testDB.Exec(nil, utils.ToCmdLine("GET", "a"))
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("OBJECT", "IDLETIME", "a")), 0)
+
+	// 重新创建的键使用新的访问信息
+	testDB.Exec(nil, utils.ToCmdLine("DEL", "a"))
+	testDB.Exec(nil, utils.ToCmdLine("SET", "a", "1"))
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("OBJECT", "FREQ", "a")), lfuInitVal)
+}
@@ -2,106 +2,147 @@ package database
 
 import (
 	"fmt"
+	"io"
 	"myredis/aof"
 	"myredis/config"
-	"myredis/datastruct/dict"
-	List "myredis/datastruct/list"
-	HashSet "myredis/datastruct/set"
-	SortedSet "myredis/datastruct/sortedset"
 	"myredis/interface/database"
+	"myredis/lib/logger"
+	"myredis/myredis/connection"
+	"myredis/myredis/parser"
+	"myredis/protocol"
 	"os"
 	"sync/atomic"
+	"time"
 
 	"github.com/hdt3213/rdb/core"
 	rdb "github.com/hdt3213/rdb/parser"
 )
 
 // 加载 rdb 文件，将命令读入数据库中
+//
+// 文件的 aof-preamble 辅助字段为 1 时，它是以 RDB 格式开头的 AOF 文件，RDB 部分之后的命令也会被重放
 func (server *Server) loadRdbFile() error {
 	// 加载 RDB 文件
-	rdbFile, err := os.Open(config.Properties.RDBFilename)
+	rdbFile, err := os.Open(rdbFilename())
 	if err != nil {
 		return fmt.Errorf("open rdb file failed %v ", err.Error())
 	}
 	defer func() {
 		_ = rdbFile.Close()
 	}()
+	if stat, err := rdbFile.Stat(); err == nil {
+		server.loadingTotal.Store(stat.Size())
+	}
 
+	preamble := false
 	decoder := rdb.NewDecoder(rdbFile)
-	err = server.LoadRDB(decoder)
+	err = server.loadRDB(decoder, func(key, value string) {
+		if key == "aof-preamble" {
+			preamble = value == "1"
+		}
+	})
 	if err != nil {
 		return fmt.Errorf("load rdb file failed %v ", err.Error())
 	}
+	if preamble {
+		err = server.replayCommands(rdbFile, int64(decoder.GetReadCount()))
+		if err != nil {
+			return fmt.Errorf("load rdb file failed %v ", err.Error())
+		}
+	}
 	return nil
 }
 
-// 解析 RDB 文件流
+// 解析 RDB 文件流，恢复键值对与过期时间，已经过期的键不会被加载
 func (server *Server) LoadRDB(dec *core.Decoder) error {
+	return server.loadRDB(dec, nil)
+}
+
+// onAux 在读到每个辅助字段时调用，可以为 nil；
+// myredis-version 高于当前支持的 RDB 格式版本时停止加载并返回错误
+func (server *Server) loadRDB(dec *core.Decoder, onAux func(key, value string)) error {
+	var loadErr error
+	now := time.Now()
+	fieldExpires := aof.MakeFieldExpires()
 	// 解码器解析 RDB 文件流时，每解析出一个完整的 RedisObject，
 	// 调用提供的这个回调函数
-	err := dec.Parse(func(object rdb.RedisObject) bool {
-		db := server.mustSelectDB(object.GetDBIndex())
-		var entity *database.DataEntity
+	err := dec.WithSpecialOpCode().Parse(func(object rdb.RedisObject) bool {
+		server.loadingLoaded.Store(int64(dec.GetReadCount()))
 		switch object.GetType() {
-		case rdb.StringType:
-			str := object.(*rdb.StringObject)
-			entity = &database.DataEntity{
-				Data: str.Value,
+		case rdb.AuxType:
+			aux := object.(*rdb.AuxObject)
+			if aux.Key == "myredis-version" {
+				loadErr = aof.CheckRDBFormatVersion(aux.Value)
+				if loadErr != nil {
+					return false
+				}
 			}
-		case rdb.ListType:
-			listObj := object.(*rdb.ListObject)
-			list := List.NewQuickList()
-			for _, v := range listObj.Values {
-				list.Add(v)
+			if aux.Key == aof.FieldExpiresAux {
+				loadErr = fieldExpires.Add(aux.Value)
+				return loadErr == nil
 			}
-			entity = &database.DataEntity{
-				Data: list,
-			}
-		case rdb.HashType:
-			hashObj := object.(*rdb.HashObject)
-			hash := dict.MakeSimple()
-			for k, v := range hashObj.Hash {
-				hash.Put(k, v)
-			}
-			entity = &database.DataEntity{
-				Data: hash,
-			}
-		case rdb.SetType:
-			setObj := object.(*rdb.SetObject)
-			set := HashSet.Make()
-			for _, mem := range setObj.Members {
-				set.Add(string(mem))
-			}
-			entity = &database.DataEntity{
-				Data: set,
-			}
-		case rdb.ZSetType:
-			zsetObj := object.(*rdb.ZSetObject)
-			zSet := SortedSet.Make()
-			for _, e := range zsetObj.Entries {
-				zSet.Add(e.Member, e.Score)
-			}
-			entity = &database.DataEntity{
-				Data: zSet,
+			if onAux != nil {
+				onAux(aux.Key, aux.Value)
 			}
+			return true
+		case rdb.DBSizeType:
+			return true
+		}
+		expiration := object.GetExpiration()
+		if expiration != nil && !expiration.After(now) {
+			return true
 		}
+		db, errReply := server.selectDB(object.GetDBIndex())
+		if errReply != nil {
+			loadErr = fmt.Errorf("db index %d is out of range", object.GetDBIndex())
+			return false
+		}
+		entity := aof.RDBObjectToEntity(object)
 		if entity != nil {
+			entity = fieldExpires.Apply(object.GetDBIndex(), object.GetKey(), entity)
+		}
+		if entity != nil && purgeRestoredFields(entity) {
 			db.PutEntity(object.GetKey(), entity)
-			if object.GetExpiration() != nil {
-				db.Expire(object.GetKey(), *object.GetExpiration())
-			}
 			db.addAof(aof.EntityToCmd(object.GetKey(), entity).Args)
+			if expiration != nil {
+				db.Expire(object.GetKey(), *expiration)
+				db.addAof(aof.MakeExpiredCmd(object.GetKey(), *expiration).Args)
+			}
+			db.restoreFieldExpires(object.GetKey(), entity)
 		}
 		return true
 	})
+	if loadErr != nil {
+		return loadErr
+	}
 	return err
 }
 
+// 从 offset 开始重放文件中的命令，用于加载 RDB 前导之后的 AOF 部分
+func (server *Server) replayCommands(file *os.File, offset int64) error {
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	conn := connection.NewSimpleConn()
+	reader := parser.NewCommandReader(file, offset)
+	for {
+		cmdLine, err := reader.ReadCommand()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		res := server.exec(conn, cmdLine, true)
+		if protocol.IsErrorReply(res) {
+			logger.Error("exec err", string(res.ToBytes()))
+		}
+		server.loadingLoaded.Store(reader.Offset())
+	}
+}
+
 func NewPersister(db database.DBEngine, filename string, load bool, fsync string) (*aof.Persister, error) {
-	return aof.NewPersister(
-		db, filename, load, fsync, func() database.DBEngine {
-			return MakeAuxiliaryServer()
-		})
+	return aof.NewPersister(db, filename, load, fsync)
 }
 
 // AddAof 向 AOF 持久化器添加命令行记录，允许外部组件直接向 AOF 文件写入命令
@@ -125,21 +166,31 @@ func (server *Server) bindPersister(persister *aof.Persister) {
 	for _, db := range server.dbSet {
 		singleDB := db.Load().(*DB)
 		singleDB.addAof = func(line CmdLine) {
+			server.dirty.Add(1)
 			if config.Properties.AppendOnly {
 				server.persister.SaveCmdLine(singleDB.index, line)
 			}
 		}
 	}
-
+	// 加载过程中产生的修改已经持久化
+	server.dirty.Store(0)
+	server.startPersistenceCron()
 }
 
 func MakeAuxiliaryServer() *Server {
+	applyEncodingConfig()
 	simpleServer := &Server{}
 	simpleServer.dbSet = make([]*atomic.Value, config.Properties.Databases)
 	for i := range simpleServer.dbSet {
+		singleDB := makeDB()
+		singleDB.index = i
+		singleDB.addAof = func(line CmdLine) {
+			simpleServer.dirty.Add(1)
+		}
 		holder := &atomic.Value{}
-		holder.Store(makeDB())
+		holder.Store(singleDB)
 		simpleServer.dbSet[i] = holder
 	}
+	simpleServer.lastSave.Store(time.Now().Unix())
 	return simpleServer
 }
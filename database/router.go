@@ -2,6 +2,7 @@ package database
 
 import (
 	"myredis/interface/myredis"
+	"myredis/lib/keyspec"
 	"myredis/protocol"
 	"strings"
 )
@@ -57,7 +58,6 @@ const (
 // 参数：
 //   - name: 命令名称（大小写不敏感，内部转为小写）
 //   - executor: 命令执行函数，处理客户端请求
-//   - prepare: 准备函数，用于提前提取命令中涉及的 key（用于加锁、AOF 重写等）
 //   - rollback: 撤销函数，用于事务中生成回滚操作（如删除刚添加的 key）
 //   - arity: 参数个数限制（正数=精确，负数=最小个数）
 //   - flags: 命令标志（如 flagReadOnly）
@@ -65,12 +65,18 @@ const (
 // 返回值：
 //
 //	返回 *command 对象，可用于链式调用（如 attachCommandExtra）
-func registerCommand(name string, executor ExecFunc, prepare PreFunc, rollback UndoFunc, arity int, flags int) *command {
+//
+// 命令涉及的 key 由 keyspec 中的同名规格提取，集群客户端也使用同一张表
+func registerCommand(name string, executor ExecFunc, rollback UndoFunc, arity int, flags int) *command {
 	name = strings.ToLower(name)
+	prepare := keyspec.Lookup(name)
+	if prepare == nil {
+		panic("missing key spec for command " + name)
+	}
 	cmd := &command{
 		name:     name,
 		executor: executor,
-		prepare:  prepare,
+		prepare:  PreFunc(prepare),
 		undo:     rollback,
 		arity:    arity,
 		flags:    flags,
@@ -102,6 +108,17 @@ func isReadOnlyCommand(name string) bool {
 	return cmd.flags&flagReadOnly > 0
 }
 
+// GetRelatedKeys 返回命令涉及的写键和读键
+// 命令不存在或不能在事务中使用时返回 nil
+func GetRelatedKeys(cmdLine [][]byte) ([]string, []string) {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := cmdTable[cmdName]
+	if !ok || cmd.prepare == nil {
+		return nil, nil
+	}
+	return cmd.prepare(cmdLine[1:])
+}
+
 // toDescReply 将 command 对象转换为 Redis 的 COMMAND 命令返回格式。
 // 用于实现 `COMMAND`, `COMMAND INFO` 等功能。
 //
@@ -3,13 +3,17 @@ package database
 import (
 	"fmt"
 	"myredis/aof"
+	"myredis/config"
 	"myredis/interface/database"
 	"myredis/interface/myredis"
 	"myredis/lib/logger"
+	"myredis/lib/utils"
 	"myredis/protocol"
 	"os"
 	"runtime/debug"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -19,48 +23,37 @@ var mydisVersion string = "1.0.0"
 type Server struct {
 	dbSet     []*atomic.Value
 	persister *aof.Persister
+	// 同一时刻只允许存在一个快照
+	snapshotMu sync.Mutex
+
+	// 自动持久化相关状态
+	dirty            atomic.Int64  // 上次保存 RDB 之后的修改次数
+	lastSave         atomic.Int64  // 上次成功保存 RDB 的时间戳（秒）
+	lastBgsaveTry    atomic.Int64  // 上次尝试保存 RDB 的时间戳（秒）
+	bgsaveInProgress atomic.Bool   // 是否正在保存 RDB
+	lastBgsaveErr    atomic.Bool   // 上次保存 RDB 是否失败
+	cronStop         chan struct{} // 关闭持久化定时任务
+
+	// 启动时加载数据的状态
+	loading       atomic.Bool
+	loadingStart  atomic.Int64 // 开始加载的时间戳（秒）
+	loadingTotal  atomic.Int64 // 需要加载的字节数，未知时为 0
+	loadingLoaded atomic.Int64 // 已经加载的字节数
 
 	insertCallback database.KeyEventCallback
 	deleteCallback database.KeyEventCallback
+	loadedCallback func()
 }
 
-// func NewStandaloneServer() *Server {
-// 	server := &Server{}
-// 	if config.Properties.Databases == 0 {
-// 		config.Properties.Databases = 16
-// 	}
-// 	// 创建 myredis 所需的临时目录
-// 	err := os.MkdirAll(config.GetTmpDir(), os.ModePerm)
-// 	if err != nil {
-// 		panic(fmt.Sprintf("create temp dir failed: %v", err))
-// 	}
-
-// 	server.dbSet = make([]*atomic.Value, config.Properties.Databases)
-// 	for i := range server.dbSet {
-// 		singleDB := makeDB()
-// 		singleDB.index = i
-// 		holder := &atomic.Value{}
-// 		holder.Store(singleDB)
-// 		server.dbSet[i] = holder
-// 	}
-
-// 	// 如果开启了 AOF 持久化
-// 	vaildAof := false
-// 	if config.Properties.AppendOnly {
-// 		vaildAof = fileExists(config.Properties.AppendFilename)
-
-// 	}
-// }
-
 func fileExists(filename string) bool {
 	info, err := os.Stat(filename)
-	return err != nil && !info.IsDir()
+	return err == nil && !info.IsDir()
 }
 
 // selectDB 根据数据库索引安全地获取对应的数据库实例。
 // 如果索引超出范围，返回 nil 和一个错误回复。
 func (server *Server) selectDB(index int) (*DB, *protocol.StandardErrReply) {
-	if index > len(server.dbSet) || index < 0 {
+	if index >= len(server.dbSet) || index < 0 {
 		return nil, protocol.MakeErrReply("ERR DB index is out of range")
 	}
 	return server.dbSet[index].Load().(*DB), nil
@@ -76,7 +69,12 @@ func (server *Server) mustSelectDB(index int) *DB {
 	return selectDB
 }
 
-func (server *Server) Exec(c myredis.Connection, cmdLine [][]byte) (result myredis.Reply) {
+func (server *Server) Exec(c myredis.Connection, cmdLine [][]byte) myredis.Reply {
+	return server.exec(c, cmdLine, false)
+}
+
+// loader 为 true 表示命令由加载数据的过程执行，不受 -LOADING 限制
+func (server *Server) exec(c myredis.Connection, cmdLine [][]byte, loader bool) (result myredis.Reply) {
 	defer func() {
 		if err := recover(); err != nil {
 			logger.Warn(fmt.Sprintf("error occurs: %v\n%s", err, string(debug.Stack())))
@@ -85,6 +83,9 @@ func (server *Server) Exec(c myredis.Connection, cmdLine [][]byte) (result myred
 	}()
 
 	cmdName := strings.ToLower(string(cmdLine[0]))
+	if !loader && server.loading.Load() && !loadingCommands[cmdName] {
+		return protocol.MakeErrReply("LOADING Redis is loading the dataset in memory")
+	}
 	if cmdName == "ping" {
 		return Ping(c, cmdLine[1:])
 	}
@@ -100,7 +101,118 @@ func (server *Server) Exec(c myredis.Connection, cmdLine [][]byte) (result myred
 	if cmdName == "dbsize" {
 		return Dbsize(c, server)
 	}
-	return nil
+	if cmdName == "select" {
+		if len(cmdLine) != 2 {
+			return protocol.MakeArgNumErrReply(cmdName)
+		}
+		return execSelect(c, server, cmdLine[1:])
+	}
+	if cmdName == "save" {
+		return execSave(server, cmdLine[1:])
+	}
+	if cmdName == "bgsave" {
+		return execBgSave(server, cmdLine[1:])
+	}
+	if cmdName == "bgrewriteaof" {
+		return execBgRewriteAof(server, cmdLine[1:])
+	}
+	if cmdName == "lastsave" {
+		return execLastSave(server, cmdLine[1:])
+	}
+	if cmdName == "flushdb" {
+		return execFlushDB(c, server, cmdLine[1:])
+	}
+	if cmdName == "flushall" {
+		return execFlushAll(server, cmdLine[1:])
+	}
+	// 跨库命令需要同时访问多个数据库
+	if cmdName == "move" {
+		return execMove(c, server, cmdLine)
+	}
+	if cmdName == "copy" {
+		return execCopyAcrossDB(c, server, cmdLine)
+	}
+	// 普通命令交给连接当前选择的数据库执行
+	selectedDB, errReply := server.selectDB(c.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	return selectedDB.Exec(c, cmdLine)
+}
+
+// 切换连接使用的数据库
+// 格式: SELECT index
+func execSelect(c myredis.Connection, server *Server, args [][]byte) myredis.Reply {
+	dbIndex, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return protocol.MakeErrReply("ERR invalid DB index")
+	}
+	if dbIndex >= len(server.dbSet) || dbIndex < 0 {
+		return protocol.MakeErrReply("ERR DB index is out of range")
+	}
+	c.SelectDB(dbIndex)
+	return protocol.MakeOkReply()
+}
+
+// 解析 FLUSHDB/FLUSHALL 的 ASYNC|SYNC 参数，未指定时由 lazyfree-lazy-user-flush 决定
+func parseFlushMode(args [][]byte) (async bool, errReply myredis.Reply) {
+	if len(args) > 1 {
+		return false, protocol.MakeSyntaxErrReply()
+	}
+	if len(args) == 0 {
+		return lazyfreeEnabled(func(props *config.ServerProperties) bool {
+			return props.LazyfreeLazyUserFlush
+		}), nil
+	}
+	switch strings.ToUpper(string(args[0])) {
+	case "ASYNC":
+		return true, nil
+	case "SYNC":
+		return false, nil
+	}
+	return false, protocol.MakeSyntaxErrReply()
+}
+
+// 暂停数据库上的所有命令后清空数据库
+func flushDB(db *DB, async bool) {
+	db.writeGate.Lock()
+	defer db.writeGate.Unlock()
+	if async {
+		db.FlushAsync()
+	} else {
+		db.Flush()
+	}
+}
+
+// execFlushDB: 清空当前数据库，ASYNC 时被删除的值在后台释放
+// 格式: FLUSHDB [ASYNC|SYNC]
+func execFlushDB(c myredis.Connection, server *Server, args [][]byte) myredis.Reply {
+	async, errReply := parseFlushMode(args)
+	if errReply != nil {
+		return errReply
+	}
+	db, selectErr := server.selectDB(c.GetDBIndex())
+	if selectErr != nil {
+		return selectErr
+	}
+	flushDB(db, async)
+	db.addAof(utils.ToCmdLine3("flushdb", args...))
+	return protocol.MakeOkReply()
+}
+
+// execFlushAll: 清空所有数据库，ASYNC 时被删除的值在后台释放
+// 格式: FLUSHALL [ASYNC|SYNC]
+func execFlushAll(server *Server, args [][]byte) myredis.Reply {
+	async, errReply := parseFlushMode(args)
+	if errReply != nil {
+		return errReply
+	}
+	for i := range server.dbSet {
+		flushDB(server.mustSelectDB(i), async)
+	}
+	// 重放时 FLUSHALL 会清空所有数据库，只需记录一次
+	server.mustSelectDB(0).addAof(utils.ToCmdLine3("flushall", args...))
+	return protocol.MakeOkReply()
 }
 
 func (server *Server) AfterClientClose(c myredis.Connection) {
@@ -108,7 +220,10 @@ func (server *Server) AfterClientClose(c myredis.Connection) {
 }
 
 func (server *Server) Close() {
-
+	if server.cronStop != nil {
+		close(server.cronStop)
+		server.cronStop = nil
+	}
 }
 
 func (server *Server) ExecMulti(conn myredis.Connection, watching map[string]uint32, cmdLines []CmdLine) myredis.Reply {
@@ -125,6 +240,8 @@ func (server *Server) ExecWithLock(conn myredis.Connection, cmdLine [][]byte) my
 	if errReply != nil {
 		return errReply
 	}
+	selectDB.writeGate.RLock()
+	defer selectDB.writeGate.RUnlock()
 	return selectDB.execWithLock(cmdLine)
 }
 
@@ -166,6 +283,14 @@ func (server *Server) GetUndoLogs(dbIndex int, cmdLine [][]byte) []CmdLine {
 	return server.mustSelectDB(dbIndex).GetUndoLogs(cmdLine)
 }
 
+func (server *Server) GetVersion(dbIndex int, key string) uint32 {
+	return server.mustSelectDB(dbIndex).GetVersion(key)
+}
+
+func (server *Server) AddVersion(dbIndex int, keys ...string) {
+	server.mustSelectDB(dbIndex).addVersion(keys...)
+}
+
 func (server *Server) SetKeyDeletedCallback(callback database.KeyEventCallback) {
 	server.deleteCallback = callback
 	for i := range server.dbSet {
@@ -175,13 +300,18 @@ func (server *Server) SetKeyDeletedCallback(callback database.KeyEventCallback)
 }
 
 func (server *Server) SetKeyInsertedCallback(callback database.KeyEventCallback) {
-	server.deleteCallback = callback
+	server.insertCallback = callback
 	for i := range server.dbSet {
 		db := server.mustSelectDB(i)
 		db.insertCallback = callback
 	}
 }
 
+// 注册数据加载完成后的回调函数
+func (server *Server) SetLoadedCallback(callback func()) {
+	server.loadedCallback = callback
+}
+
 func (server *Server) GetAvgTTL(dbIndex, randomKeyCount int) int64 {
 	var ttlCount int64
 	db := server.mustSelectDB(dbIndex)
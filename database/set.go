@@ -72,7 +72,7 @@ func execSIsMember(db *DB, args [][]byte) myredis.Reply {
 	key := string(args[0])
 	member := string(args[1])
 
-	set, _, errReply := db.getOrInitSet(key)
+	set, errReply := db.getAsSet(key)
 	if errReply != nil {
 		return errReply
 	}
@@ -87,6 +87,26 @@ func execSIsMember(db *DB, args [][]byte) myredis.Reply {
 	return protocol.MakeIntReply(0)
 }
 
+// 检查多个成员是否在集合内，按参数顺序返回 1 或 0
+//
+// SMISMEMBER key member [member ...]
+func execSMIsMember(db *DB, args [][]byte) myredis.Reply {
+	key := string(args[0])
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]myredis.Reply, len(args)-1)
+	for i, member := range args[1:] {
+		if set.Has(string(member)) {
+			result[i] = protocol.MakeIntReply(1)
+		} else {
+			result[i] = protocol.MakeIntReply(0)
+		}
+	}
+	return protocol.MakeMultiRawReply(result)
+}
+
 // 从集合中移除成员，返回移除的数量
 func execSRem(db *DB, args [][]byte) myredis.Reply {
 	if len(args) < 2 {
@@ -118,47 +138,88 @@ func execSRem(db *DB, args [][]byte) myredis.Reply {
 	return protocol.MakeIntReply(int64(count))
 }
 
-// 从集合中移除成员，按照数量随机移除，返回被移除的 key
+// 随机移除并返回集合中的成员，不指定 count 时返回单个成员，指定时返回数组
+//
+// SPOP key [count]
 func execSPop(db *DB, args [][]byte) myredis.Reply {
-	if len(args) < 2 {
-		return protocol.MakeErrReply("ERR wrong number of arguments for 'spop' command")
+	if len(args) > 2 {
+		return protocol.MakeSyntaxErrReply()
 	}
-
 	key := string(args[0])
+	withCount := len(args) == 2
+	count64 := int64(1)
+	if withCount {
+		var err error
+		count64, err = strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || count64 < 0 {
+			return protocol.MakeErrReply("ERR value is out of range, must be positive")
+		}
+	}
 
 	set, errReply := db.getAsSet(key)
 	if errReply != nil {
 		return errReply
 	}
 	if set == nil {
-		return protocol.MakeIntReply(0)
+		if withCount {
+			return protocol.MakeEmptyMultiBulkReply()
+		}
+		return protocol.MakeNullBulkReply()
 	}
 
-	count := 0
-	if len(args) == 2 {
-		count64, err := strconv.ParseInt(string(args[1]), 10, 64)
-		if err != nil || count64 <= 0 {
-			return protocol.MakeErrReply("ERR value is out of range, must be positive")
-		}
+	count := set.Len()
+	if count64 < int64(count) {
 		count = int(count64)
 	}
-
-	if count > set.Len() {
-		count = set.Len()
+	members := set.Pop(count)
+	if set.Len() == 0 {
+		db.Remove(key)
+	}
+	// 弹出的成员是随机的，AOF 中记录为确定的 SREM
+	if len(members) > 0 {
+		db.addAof(utils.ToCmdLine2("srem", append([]string{key}, members...)...))
+	}
+	if !withCount {
+		return protocol.MakeBulkReply([]byte(members[0]))
 	}
-
-	members := set.RandomDistinctMembers(count)
 	result := make([][]byte, len(members))
 	for i, member := range members {
-		set.Remove(member)
 		result[i] = []byte(member)
 	}
-	if count > 0 {
-		db.addAof(utils.ToCmdLine3("spop", args...))
-	}
 	return protocol.MakeMultiBulkReply(result)
 }
 
+// 将成员从 source 移动到 destination，成员不在 source 中时返回 0
+func execSMove(db *DB, args [][]byte) myredis.Reply {
+	srcKey := string(args[0])
+	destKey := string(args[1])
+	member := string(args[2])
+
+	src, errReply := db.getAsSet(srcKey)
+	if errReply != nil {
+		return errReply
+	}
+	// 在修改 source 之前检查 destination 的类型
+	if _, errReply = db.getAsSet(destKey); errReply != nil {
+		return errReply
+	}
+	if !src.Has(member) {
+		return protocol.MakeIntReply(0)
+	}
+	if srcKey == destKey {
+		return protocol.MakeIntReply(1)
+	}
+
+	src.Remove(member)
+	if src.Len() == 0 {
+		db.Remove(srcKey)
+	}
+	dest, _, _ := db.getOrInitSet(destKey)
+	dest.Add(member)
+	db.addAof(utils.ToCmdLine3("smove", args...))
+	return protocol.MakeIntReply(1)
+}
+
 // 返回集合中的成员数量
 func execSCard(db *DB, args [][]byte) myredis.Reply {
 	if len(args) != 1 {
@@ -264,6 +325,42 @@ func execSInterStore(db *DB, args [][]byte) myredis.Reply {
 	return protocol.MakeIntReply(int64(result.Len()))
 }
 
+// 计算多个集合交集的成员数量，达到 LIMIT 后停止计算
+//
+// SINTERCARD numkeys key [key ...] [LIMIT limit]
+func execSInterCard(db *DB, args [][]byte) myredis.Reply {
+	// 与 ZINTERCARD 不同，Redis 对 SINTERCARD 的 numkeys 过大返回单独的错误
+	if numKeys, err := strconv.ParseInt(string(args[0]), 10, 64); err == nil && numKeys > int64(len(args)-1) {
+		return protocol.MakeErrReply("ERR Number of keys can't be greater than number of args")
+	}
+	keys, rest, errReply := parseNumKeys("sintercard", args)
+	if errReply != nil {
+		return errReply
+	}
+	var limit int64
+	if len(rest) == 2 && strings.ToUpper(string(rest[0])) == "LIMIT" {
+		var err error
+		limit, err = strconv.ParseInt(string(rest[1]), 10, 64)
+		if err != nil {
+			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if limit < 0 {
+			return protocol.MakeErrReply("ERR LIMIT can't be negative")
+		}
+	} else if len(rest) != 0 {
+		return protocol.MakeSyntaxErrReply()
+	}
+	sets := make([]*HashSet.Set, len(keys))
+	for i, key := range keys {
+		set, errReply := db.getAsSet(key)
+		if errReply != nil {
+			return errReply
+		}
+		sets[i] = set
+	}
+	return protocol.MakeIntReply(int64(HashSet.IntersectCard(int(limit), sets...)))
+}
+
 // 执行多个集合的并集操作
 func execSUnion(db *DB, args [][]byte) myredis.Reply {
 	sets := make([]*HashSet.Set, 0, len(args))
@@ -398,53 +495,23 @@ func execSRandMember(db *DB, args [][]byte) myredis.Reply {
 //
 // SSCAN key cursor [MATCH pattern] [COUNT count]
 func execSScan(db *DB, args [][]byte) myredis.Reply {
-	var count int = 10
-	var pattern string = "*"
-
-	if len(args) > 2 {
-		for i := 2; i < len(args); i++ {
-			arg := strings.ToLower(string(args[i]))
-			if arg == "count" {
-				// 下一个 arg 包含 count 数值
-				count64, err := strconv.ParseInt(string(args[i+1]), 10, 64)
-				if err != nil {
-					return protocol.MakeSyntaxErrReply()
-				}
-				count = int(count64)
-				i++ // 跳过下一个参数
-			} else if arg == "match" {
-				pattern = string(args[i+1])
-				i++ // 跳过下一个参数
-			} else {
-				return protocol.MakeSyntaxErrReply()
-			}
-		}
+	opts, errReply := parseScanArgs(args[1], args[2:], false)
+	if errReply != nil {
+		return errReply
 	}
-
-	key := string(args[0])
-	set, errReply := db.getAsSet(key)
+	set, errReply := db.getAsSet(string(args[0]))
 	if errReply != nil {
 		return errReply
 	}
+	// 键不存在时视为空集合
 	if set == nil {
-		return &protocol.EmptyMultiBulkReply{}
+		return makeScanReply(0, nil)
 	}
-	cursor64, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
-		return protocol.MakeErrReply("ERR value is not an integer or out of range")
-	}
-	cursor := int(cursor64)
-
-	keysReply, nextCursor := set.SetScan(cursor, count, pattern)
+	elements, nextCursor := set.SetScan(opts.cursor, opts.count, opts.pattern)
 	if nextCursor < 0 {
-		return protocol.MakeErrReply("Invalid pattern argument")
+		return protocol.MakeErrReply("ERR illegal wildcard")
 	}
-
-	result := make([]myredis.Reply, 2)
-	result[0] = protocol.MakeBulkReply([]byte(strconv.FormatInt(int64(nextCursor), 10)))
-	result[1] = protocol.MakeMultiBulkReply(keysReply)
-
-	return protocol.MakeMultiRawReply(result)
+	return makeScanReply(nextCursor, elements)
 }
 
 /* Set 的撤销函数 */
@@ -459,37 +526,59 @@ func undoSetChange(db *DB, args [][]byte) []CmdLine {
 	return rollbackSetMembers(db, key, members...)
 }
 
+// 弹出的成员无法预知，回滚时重新加入当前的全部成员
+func undoSPop(db *DB, args [][]byte) []CmdLine {
+	key := string(args[0])
+	set, errReply := db.getAsSet(key)
+	if errReply != nil || set == nil {
+		return nil
+	}
+	return rollbackSetMembers(db, key, set.ToSlice()...)
+}
+
+func undoSMove(db *DB, args [][]byte) []CmdLine {
+	member := string(args[2])
+	undoCmdLines := rollbackSetMembers(db, string(args[0]), member)
+	return append(undoCmdLines, rollbackSetMembers(db, string(args[1]), member)...)
+}
+
 func init() {
-	registerCommand("SAdd", execSAdd, writeFirstKey, undoSetChange, -3, flagWrite).
+	registerCommand("SAdd", execSAdd, undoSetChange, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
-	registerCommand("SIsMember", execSIsMember, readFirstKey, nil, 3, flagReadOnly).
+	registerCommand("SIsMember", execSIsMember, nil, 3, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("SRem", execSRem, writeFirstKey, undoSetChange, -3, flagWrite).
+	registerCommand("SRem", execSRem, undoSetChange, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
-	registerCommand("SPop", execSPop, writeFirstKey, undoSetChange, -2, flagWrite).
+	registerCommand("SMIsMember", execSMIsMember, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
+	registerCommand("SPop", execSPop, undoSPop, -2, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagRandom, redisFlagFast}, 1, 1, 1)
-	registerCommand("SCard", execSCard, readFirstKey, nil, 2, flagReadOnly).
+	registerCommand("SMove", execSMove, undoSMove, 4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 2, 1)
+	registerCommand("SCard", execSCard, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("SMembers", execSMembers, readFirstKey, nil, 2, flagReadOnly).
+	registerCommand("SMembers", execSMembers, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
 
-	registerCommand("SInter", execSInter, prepareSetCalculate, nil, -2, flagReadOnly).
+	registerCommand("SInter", execSInter, nil, -2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagSortForScript}, 1, -1, 1)
-	registerCommand("SInterStore", execSInterStore, prepareSetCalculateStore, rollbackFirstKey, -3, flagWrite).
+	registerCommand("SInterStore", execSInterStore, rollbackFirstKey, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, -1, 1)
+	registerCommand("SInterCard", execSInterCard, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagMovableKeys}, 0, 0, 0)
 
-	registerCommand("SUnion", execSUnion, prepareSetCalculate, nil, -2, flagReadOnly).
+	registerCommand("SUnion", execSUnion, nil, -2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagSortForScript}, 1, -1, 1)
-	registerCommand("SUnionStore", execSUnionStore, prepareSetCalculateStore, rollbackFirstKey, -3, flagWrite).
+	registerCommand("SUnionStore", execSUnionStore, rollbackFirstKey, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, -1, 1)
 
-	registerCommand("SDiff", execSDiff, prepareSetCalculate, nil, -2, flagReadOnly).
+	registerCommand("SDiff", execSDiff, nil, -2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagSortForScript}, 1, 1, 1)
-	registerCommand("SDiffStore", execSDiffStore, prepareSetCalculateStore, rollbackFirstKey, -3, flagWrite).
+	registerCommand("SDiffStore", execSDiffStore, rollbackFirstKey, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
 
-	registerCommand("SRandMember", execSRandMember, readFirstKey, nil, -2, flagReadOnly).
+	registerCommand("SRandMember", execSRandMember, nil, -2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom}, 1, 1, 1)
-	registerCommand("SScan", execSScan, readFirstKey, nil, -2, flagReadOnly).
+	registerCommand("SScan", execSScan, nil, -3, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagSortForScript}, 1, 1, 1)
 }
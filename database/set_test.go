@@ -249,3 +249,120 @@ func TestSScan(t *testing.T) {
 		}
 	}
 }
+
+func TestSPop(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("sadd", key, "a", "b", "c"))
+
+	result := testDB.Exec(nil, utils.ToCmdLine("spop", key))
+	if _, ok := result.(*protocol.BulkReply); !ok {
+		t.Errorf("expected bulk reply, actually %s", result.ToBytes())
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("spop", key, "10"))
+	multiBulk, ok := result.(*protocol.MultiBulkReply)
+	if !ok || len(multiBulk.Args) != 2 {
+		t.Errorf("expected 2 members, actually %s", result.ToBytes())
+	}
+	// 集合被清空后删除键
+	result = testDB.Exec(nil, utils.ToCmdLine("exists", key))
+	assert.AssertIntReply(t, result, 0)
+
+	result = testDB.Exec(nil, utils.ToCmdLine("spop", key))
+	assert.AssertNullBulk(t, result)
+	result = testDB.Exec(nil, utils.ToCmdLine("spop", key, "1"))
+	assert.AssertMultiBulkReplySize(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("spop", key, "-1"))
+	assert.AssertErrReply(t, result, "ERR value is out of range, must be positive")
+
+	// 回滚后恢复被弹出的成员
+	testDB.Exec(nil, utils.ToCmdLine("sadd", key, "a", "b", "c"))
+	undoCmdLines := undoSPop(testDB, utils.ToCmdLine(key, "2"))
+	testDB.Exec(nil, utils.ToCmdLine("spop", key, "2"))
+	for _, cmdLine := range undoCmdLines {
+		testDB.Exec(nil, cmdLine)
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("scard", key))
+	assert.AssertIntReply(t, result, 3)
+}
+
+func TestSMove(t *testing.T) {
+	testDB.Flush()
+	src := utils.RandString(10)
+	dest := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("sadd", src, "a", "b"))
+
+	result := testDB.Exec(nil, utils.ToCmdLine("smove", src, dest, "a"))
+	assert.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("smove", src, dest, "x"))
+	assert.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("sismember", dest, "a"))
+	assert.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("smove", src, src, "b"))
+	assert.AssertIntReply(t, result, 1)
+
+	// source 被移空后删除
+	result = testDB.Exec(nil, utils.ToCmdLine("smove", src, dest, "b"))
+	assert.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("exists", src))
+	assert.AssertIntReply(t, result, 0)
+
+	str := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("set", str, "v"))
+	result = testDB.Exec(nil, utils.ToCmdLine("smove", dest, str, "a"))
+	assert.AssertErrReply(t, result, "WRONGTYPE Operation against a key holding the wrong kind of value")
+	result = testDB.Exec(nil, utils.ToCmdLine("scard", dest))
+	assert.AssertIntReply(t, result, 2)
+}
+
+func TestUndoSMove(t *testing.T) {
+	testDB.Flush()
+	src := utils.RandString(10)
+	dest := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("sadd", src, "a"))
+	cmdLine := utils.ToCmdLine("smove", src, dest, "a")
+	undoCmdLines := undoSMove(testDB, cmdLine[1:])
+	testDB.Exec(nil, cmdLine)
+	for _, cmdLine := range undoCmdLines {
+		testDB.Exec(nil, cmdLine)
+	}
+	result := testDB.Exec(nil, utils.ToCmdLine("smembers", src))
+	assert.AssertMultiBulkReply(t, result, []string{"a"})
+	result = testDB.Exec(nil, utils.ToCmdLine("exists", dest))
+	assert.AssertIntReply(t, result, 0)
+}
+
+func TestSMIsMember(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("sadd", key, "a", "b"))
+	result := testDB.Exec(nil, utils.ToCmdLine("smismember", key, "a", "x", "b"))
+	assertFieldCodes(t, result, 1, 0, 1)
+
+	// 读取不存在的键不会创建集合
+	missing := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("sismember", missing, "a"))
+	result = testDB.Exec(nil, utils.ToCmdLine("exists", missing))
+	assert.AssertIntReply(t, result, 0)
+}
+
+func TestSInterCard(t *testing.T) {
+	testDB.Flush()
+	key1 := utils.RandString(10)
+	key2 := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("sadd", key1, "a", "b", "c", "d"))
+	testDB.Exec(nil, utils.ToCmdLine("sadd", key2, "b", "c", "d", "e"))
+
+	result := testDB.Exec(nil, utils.ToCmdLine("sintercard", "2", key1, key2))
+	assert.AssertIntReply(t, result, 3)
+	result = testDB.Exec(nil, utils.ToCmdLine("sintercard", "2", key1, key2, "LIMIT", "2"))
+	assert.AssertIntReply(t, result, 2)
+	result = testDB.Exec(nil, utils.ToCmdLine("sintercard", "2", key1, utils.RandString(10)))
+	assert.AssertIntReply(t, result, 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("sintercard", "2", key1, key2, "LIMIT", "-1"))
+	assert.AssertErrReply(t, result, "ERR LIMIT can't be negative")
+	result = testDB.Exec(nil, utils.ToCmdLine("sintercard", "3", key1, key2))
+	assert.AssertErrReply(t, result, "ERR Number of keys can't be greater than number of args")
+	result = testDB.Exec(nil, utils.ToCmdLine("sintercard", "2", key1, key2, "LIMIT"))
+	assert.AssertErrReply(t, result, "Err syntax error")
+}
@@ -0,0 +1,213 @@
+// snapshot.go 实现了对在线数据库的时间点快照，用于 BGSAVE 与 BGREWRITEAOF：
+//
+//   - 生成快照时短暂阻塞所有写命令，等待执行中的写命令结束，得到一致的切点
+//   - 切点之后，写命令在第一次修改某个 key 之前，先将其切点时刻的值复制保存（写时复制）
+//   - 遍历快照时按分片加读锁，逐个访问在线数据；已被修改的 key 使用保存的副本
+//
+// 快照的开销与数据集大小成正比，额外内存只与快照期间被修改的 key 数量有关
+package database
+
+import (
+	"myredis/datastruct/dict"
+	List "myredis/datastruct/list"
+	"myredis/datastruct/set"
+	"myredis/datastruct/sortedset"
+	"myredis/interface/database"
+	"sync/atomic"
+	"time"
+)
+
+// 某个 key 在快照切点时刻的状态
+type savedEntity struct {
+	entity     *database.DataEntity // 为 nil 表示切点时刻 key 不存在
+	expiration *time.Time
+}
+
+// 表示 key 已被快照遍历，之后的修改无需再保存副本
+var visitedEntity = &savedEntity{}
+
+// 单个数据库的快照
+type dbSnapshot struct {
+	db       *DB
+	saved    *dict.ConcurrentDict // key -> *savedEntity
+	keyCount int
+	ttlCount int
+}
+
+// Server 在某一时刻的只读视图，实现 database.Snapshot
+type serverSnapshot struct {
+	server   *Server
+	dbs      []*dbSnapshot
+	released int32
+}
+
+// 生成在线数据的时间点快照
+//
+// onCut 在所有写命令暂停时执行，调用者可以在其中记录与快照对应的 AOF 位置。
+// 同一时刻只能存在一个快照，后来者会阻塞直到前一个快照被释放
+func (server *Server) Snapshot(onCut func()) database.Snapshot {
+	server.snapshotMu.Lock()
+	dbs := make([]*DB, len(server.dbSet))
+	for i := range server.dbSet {
+		dbs[i] = server.mustSelectDB(i)
+		dbs[i].writeGate.Lock()
+	}
+	snapshot := &serverSnapshot{
+		server: server,
+		dbs:    make([]*dbSnapshot, len(dbs)),
+	}
+	for i, db := range dbs {
+		s := &dbSnapshot{
+			db:       db,
+			saved:    dict.MakeConcurrent(ttlDictSize),
+			keyCount: db.data.Len(),
+			ttlCount: db.ttlMap.Len(),
+		}
+		db.snapshot.Store(s)
+		snapshot.dbs[i] = s
+	}
+	if onCut != nil {
+		onCut()
+	}
+	for _, db := range dbs {
+		db.writeGate.Unlock()
+	}
+	return snapshot
+}
+
+// 返回切点时刻数据库的 key 数量与设置了过期时间的 key 数量
+func (snapshot *serverSnapshot) GetDBSize(dbIndex int) (int, int) {
+	s := snapshot.dbs[dbIndex]
+	return s.keyCount, s.ttlCount
+}
+
+// 遍历切点时刻的数据，每个数据库只能遍历一次
+func (snapshot *serverSnapshot) ForEach(dbIndex int, callback func(key string, data *database.DataEntity, expiration *time.Time) bool) {
+	s := snapshot.dbs[dbIndex]
+	db := s.db
+	// 遍历在线数据，持有分片读锁期间该分片内的 key 不会被修改
+	finished := true
+	db.data.ForEach(func(key string, val interface{}) bool {
+		if s.saved.PutIfAbsent(key, visitedEntity) == 0 {
+			// 已经保存过副本或切点之后才写入
+			return true
+		}
+		entity, _ := val.(*database.DataEntity)
+		var expiration *time.Time
+		if raw, ok := db.ttlMap.Get(key); ok {
+			expireTime, _ := raw.(time.Time)
+			expiration = &expireTime
+		}
+		finished = callback(key, entity, expiration)
+		return finished
+	})
+	if !finished {
+		return
+	}
+	// 遍历切点之后被修改或删除的 key 的副本
+	s.saved.ForEach(func(key string, val interface{}) bool {
+		saved, _ := val.(*savedEntity)
+		if saved == visitedEntity || saved.entity == nil {
+			return true
+		}
+		return callback(key, saved.entity, saved.expiration)
+	})
+}
+
+// 释放快照，之后的写命令不再保存副本
+func (snapshot *serverSnapshot) Release() {
+	if !atomic.CompareAndSwapInt32(&snapshot.released, 0, 1) {
+		return
+	}
+	for _, s := range snapshot.dbs {
+		s.db.snapshot.CompareAndSwap(s, nil)
+	}
+	snapshot.server.snapshotMu.Unlock()
+}
+
+// 在修改 key 之前调用，存在快照时保存 key 在切点时刻的状态
+// 调用者需要持有 key 的写锁
+func (db *DB) preserve(keys ...string) {
+	s := db.snapshot.Load()
+	if s == nil {
+		return
+	}
+	for _, key := range keys {
+		if _, ok := s.saved.Get(key); ok {
+			continue
+		}
+		raw, exists := db.data.GetWithLock(key)
+		s.save(key, raw, exists)
+	}
+}
+
+// 在清空数据库之前调用，存在快照时保存所有 key
+func (db *DB) preserveAll() {
+	s := db.snapshot.Load()
+	if s == nil {
+		return
+	}
+	db.data.ForEach(func(key string, val interface{}) bool {
+		if _, ok := s.saved.Get(key); !ok {
+			s.save(key, val, true)
+		}
+		return true
+	})
+}
+
+func (s *dbSnapshot) save(key string, raw interface{}, exists bool) {
+	saved := &savedEntity{}
+	if exists {
+		entity, _ := raw.(*database.DataEntity)
+		saved.entity = cloneEntity(entity)
+		if rawTTL, ok := s.db.ttlMap.Get(key); ok {
+			expireTime, _ := rawTTL.(time.Time)
+			saved.expiration = &expireTime
+		}
+	}
+	s.saved.PutIfAbsent(key, saved)
+}
+
+// 深拷贝数据实体
+func cloneEntity(entity *database.DataEntity) *database.DataEntity {
+	if entity == nil {
+		return nil
+	}
+	switch object := entity.Data.(type) {
+	case []byte:
+		return &database.DataEntity{Data: append([]byte(nil), object...)}
+	case List.List:
+		list := List.MakeCompact()
+		object.ForEach(func(i int, val interface{}) bool {
+			bytes, _ := val.([]byte)
+			list.Add(append([]byte(nil), bytes...))
+			return true
+		})
+		return &database.DataEntity{Data: list}
+	case *dict.ExpireDict:
+		hash := dict.MakeExpireDict(cloneEntity(&database.DataEntity{Data: object.Dict}).Data.(dict.Dict))
+		object.ForEachExpire(func(field string, expireAt int64) bool {
+			hash.SetExpire(field, expireAt)
+			return true
+		})
+		return &database.DataEntity{Data: hash}
+	case dict.Dict:
+		hash := dict.MakeCompact()
+		object.ForEach(func(key string, val interface{}) bool {
+			bytes, _ := val.([]byte)
+			hash.Put(key, append([]byte(nil), bytes...))
+			return true
+		})
+		return &database.DataEntity{Data: hash}
+	case *set.Set:
+		return &database.DataEntity{Data: object.ShallowCopy()}
+	case *sortedset.SortedSet:
+		zset := sortedset.Make()
+		object.ForEachByRank(0, object.Len(), false, func(element *sortedset.Element) bool {
+			zset.Add(element.Member, element.Score)
+			return true
+		})
+		return &database.DataEntity{Data: zset}
+	}
+	return &database.DataEntity{Data: entity.Data}
+}
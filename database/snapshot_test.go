@@ -0,0 +1,136 @@
+package database
+
+import (
+	"myredis/aof"
+	"myredis/config"
+	"myredis/datastruct/strobj"
+	"myredis/interface/database"
+	"myredis/lib/utils"
+	"myredis/myredis/connection"
+	"myredis/protocol/assert"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func collectSnapshot(snapshot database.Snapshot, dbIndex int) map[string]*database.DataEntity {
+	result := make(map[string]*database.DataEntity)
+	snapshot.ForEach(dbIndex, func(key string, data *database.DataEntity, expiration *time.Time) bool {
+		result[key] = data
+		return true
+	})
+	return result
+}
+
+func TestSnapshotCopyOnWrite(t *testing.T) {
+	config.Properties = &config.ServerProperties{Databases: 4}
+	server := MakeAuxiliaryServer()
+	db := server.mustSelectDB(0)
+	db.Exec(nil, utils.ToCmdLine("SET", "a", "1"))
+	db.Exec(nil, utils.ToCmdLine("SET", "b", "1", "EX", "1000"))
+	db.Exec(nil, utils.ToCmdLine("RPUSH", "list", "x"))
+
+	snapshot := server.Snapshot(nil)
+	if keys, ttls := snapshot.GetDBSize(0); keys != 3 || ttls != 1 {
+		t.Errorf("wrong db size %d %d", keys, ttls)
+	}
+	// 快照生成后的修改不影响快照
+	db.Exec(nil, utils.ToCmdLine("SET", "a", "2"))
+	db.Exec(nil, utils.ToCmdLine("DEL", "b"))
+	db.Exec(nil, utils.ToCmdLine("RPUSH", "list", "y"))
+	db.Exec(nil, utils.ToCmdLine("SET", "c", "1"))
+
+	data := collectSnapshot(snapshot, 0)
+	snapshot.Release()
+	if len(data) != 3 {
+		t.Fatalf("expect 3 keys, actually %d", len(data))
+	}
+	if value, _ := strobj.Bytes(data["a"].Data); string(value) != "1" {
+		t.Errorf("expect a=1 in snapshot")
+	}
+	if data["b"] == nil {
+		t.Errorf("deleted key should remain in snapshot")
+	}
+	if _, ok := data["c"]; ok {
+		t.Errorf("key written after snapshot should not appear")
+	}
+	assert.AssertMultiBulkReply(t, db.Exec(nil, utils.ToCmdLine("LRANGE", "list", "0", "-1")), []string{"x", "y"})
+	if db.snapshot.Load() != nil {
+		t.Error("snapshot should be detached after release")
+	}
+}
+
+func TestSnapshotFlush(t *testing.T) {
+	config.Properties = &config.ServerProperties{Databases: 4}
+	server := MakeAuxiliaryServer()
+	db := server.mustSelectDB(1)
+	for i := 0; i < 10; i++ {
+		db.Exec(nil, utils.ToCmdLine("SET", strconv.Itoa(i), "1"))
+	}
+	snapshot := server.Snapshot(nil)
+	defer snapshot.Release()
+	conn := connection.NewSimpleConn()
+	conn.SelectDB(1)
+	assert.AssertStatusReply(t, server.Exec(conn, utils.ToCmdLine("FLUSHDB", "ASYNC")), "OK")
+	if n := db.data.Len(); n != 0 {
+		t.Errorf("expect empty db after flush, actually %d", n)
+	}
+	if n := len(collectSnapshot(snapshot, 1)); n != 10 {
+		t.Errorf("expect 10 keys, actually %d", n)
+	}
+}
+
+func TestRewriteFromSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	config.Properties = &config.ServerProperties{
+		Dir:            dir,
+		Databases:      4,
+		AppendOnly:     true,
+		AppendFilename: filepath.Join(dir, "appendonly.aof"),
+		AppendFsync:    aof.FsyncEverySec,
+	}
+	if err := os.MkdirAll(config.GetTmpDir(), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	server := MakeAuxiliaryServer()
+	persister, err := NewPersister(server, config.Properties.AppendFilename, false, config.Properties.AppendFsync)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.bindPersister(persister)
+	db := server.mustSelectDB(0)
+	db.Exec(nil, utils.ToCmdLine("RPUSH", "list", "a", "b"))
+
+	// 重写期间持续写入，重写后的文件需要恰好包含每一次写入
+	workers, times := 4, 200
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < times; j++ {
+				db.Exec(nil, utils.ToCmdLine("INCR", "counter"))
+			}
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		if err := persister.Rewrite(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	persister.Close()
+
+	loaded := MakeAuxiliaryServer()
+	persister2, err := NewPersister(loaded, config.Properties.AppendFilename, true, config.Properties.AppendFsync)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer persister2.Close()
+	loadedDB := loaded.mustSelectDB(0)
+	assert.AssertBulkReply(t, loadedDB.Exec(nil, utils.ToCmdLine("GET", "counter")), strconv.Itoa(workers*times))
+	assert.AssertMultiBulkReply(t, loadedDB.Exec(nil, utils.ToCmdLine("LRANGE", "list", "0", "-1")), []string{"a", "b"})
+}
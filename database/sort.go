@@ -0,0 +1,270 @@
+package database
+
+import (
+	"bytes"
+	"myredis/datastruct/list"
+	"myredis/datastruct/set"
+	"myredis/datastruct/sortedset"
+	"myredis/interface/database"
+	"myredis/interface/myredis"
+	"myredis/lib/keyspec"
+	"myredis/lib/utils"
+	"myredis/protocol"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sortOptions SORT 与 SORT_RO 的参数
+type sortOptions struct {
+	by     string   // 为空时按元素本身排序
+	noSort bool     // BY 的模式中不包含 *，保持元素原来的顺序
+	gets   []string // GET 模式，# 表示元素本身
+	offset int64
+	count  int64 // 小于 0 表示不限制数量
+	desc   bool
+	alpha  bool
+	store  string // 为空时不保存结果
+}
+
+// 解析 SORT 的选项，readOnly 时不接受 STORE
+func parseSortArgs(args [][]byte, readOnly bool) (*sortOptions, protocol.ErrorReply) {
+	opts := &sortOptions{count: -1}
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		switch {
+		case arg == "ASC":
+			opts.desc = false
+		case arg == "DESC":
+			opts.desc = true
+		case arg == "ALPHA":
+			opts.alpha = true
+		case arg == "LIMIT" && i+2 < len(args):
+			offset, err1 := strconv.ParseInt(string(args[i+1]), 10, 64)
+			count, err2 := strconv.ParseInt(string(args[i+2]), 10, 64)
+			if err1 != nil || err2 != nil {
+				return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			opts.offset, opts.count = offset, count
+			i += 2
+		case arg == "BY" && i+1 < len(args):
+			opts.by = string(args[i+1])
+			opts.noSort = !strings.Contains(opts.by, "*")
+			i++
+		case arg == "GET" && i+1 < len(args):
+			opts.gets = append(opts.gets, string(args[i+1]))
+			i++
+		case arg == "STORE" && i+1 < len(args) && !readOnly:
+			opts.store = string(args[i+1])
+			i++
+		default:
+			return nil, protocol.MakeSyntaxErrReply()
+		}
+	}
+	return opts, nil
+}
+
+func undoSort(db *DB, args [][]byte) []CmdLine {
+	write, _ := keyspec.Sort(args)
+	if len(write) == 0 {
+		return nil
+	}
+	return rollbackGivenKeys(db, write...)
+}
+
+// 按模式查找外部键的值：第一个 * 替换为元素，* 之后的 -> 表示读取哈希字段，# 表示元素本身
+//
+// 键不存在、类型不符或字段不存在时返回 nil
+func (db *DB) sortLookup(pattern string, member []byte) []byte {
+	if pattern == "#" {
+		return member
+	}
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return nil
+	}
+	keyPattern, field := pattern, ""
+	if arrow := strings.Index(pattern[star+1:], "->"); arrow >= 0 {
+		arrow += star + 1
+		if arrow+2 < len(pattern) {
+			keyPattern, field = pattern[:arrow], pattern[arrow+2:]
+		}
+	}
+	key := keyPattern[:star] + string(member) + keyPattern[star+1:]
+	if field == "" {
+		value, errReply := db.getAsString(key)
+		if errReply != nil {
+			return nil
+		}
+		return value
+	}
+	hash, errReply := db.getAsDict(key)
+	if errReply != nil || hash == nil {
+		return nil
+	}
+	raw, ok := hash.Get(field)
+	if !ok {
+		return nil
+	}
+	value, _ := raw.([]byte)
+	return value
+}
+
+// 读取列表、集合或有序集合中的全部元素
+func (db *DB) sortSource(key string) ([][]byte, protocol.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	var members [][]byte
+	switch object := entity.Data.(type) {
+	case list.List:
+		object.ForEach(func(i int, val interface{}) bool {
+			members = append(members, val.([]byte))
+			return true
+		})
+	case *set.Set:
+		object.ForEach(func(member string) bool {
+			members = append(members, []byte(member))
+			return true
+		})
+	case *sortedset.SortedSet:
+		object.ForEachByRank(0, object.Len(), false, func(element *sortedset.Element) bool {
+			members = append(members, []byte(element.Member))
+			return true
+		})
+	default:
+		return nil, &protocol.WrongTypeErrReply{}
+	}
+	return members, nil
+}
+
+// 待排序的元素及其权重
+type sortElement struct {
+	member []byte
+	weight []byte
+	score  float64
+}
+
+// 对元素排序，数值排序时权重无法转换为浮点数会返回错误
+func (db *DB) sortMembers(members [][]byte, opts *sortOptions) protocol.ErrorReply {
+	elements := make([]*sortElement, len(members))
+	for i, member := range members {
+		element := &sortElement{member: member, weight: member}
+		if opts.by != "" {
+			element.weight = db.sortLookup(opts.by, member)
+		}
+		// 数值排序时不存在的权重视为 0
+		if !opts.alpha && element.weight != nil {
+			score, err := strconv.ParseFloat(strings.TrimSpace(string(element.weight)), 64)
+			if err != nil {
+				return protocol.MakeErrReply("ERR One or more scores can't be converted into double")
+			}
+			element.score = score
+		}
+		elements[i] = element
+	}
+	sort.SliceStable(elements, func(i, j int) bool {
+		a, b := elements[i], elements[j]
+		cmp := 0
+		if opts.alpha {
+			cmp = bytes.Compare(a.weight, b.weight)
+		} else if a.score < b.score {
+			cmp = -1
+		} else if a.score > b.score {
+			cmp = 1
+		}
+		// 权重相同时按元素本身比较，保证结果确定
+		if cmp == 0 {
+			cmp = bytes.Compare(a.member, b.member)
+		}
+		if opts.desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+	for i, element := range elements {
+		members[i] = element.member
+	}
+	return nil
+}
+
+// 对列表、集合或有序集合中的元素排序，返回排序结果或保存到 STORE 指定的列表
+func (db *DB) sort(args [][]byte, readOnly bool) myredis.Reply {
+	opts, errReply := parseSortArgs(args[1:], readOnly)
+	if errReply != nil {
+		return errReply
+	}
+	members, errReply := db.sortSource(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if !opts.noSort {
+		if errReply = db.sortMembers(members, opts); errReply != nil {
+			return errReply
+		}
+	}
+
+	start := opts.offset
+	if start < 0 {
+		start = 0
+	}
+	if start > int64(len(members)) {
+		start = int64(len(members))
+	}
+	end := int64(len(members))
+	if opts.count >= 0 && start+opts.count < end {
+		end = start + opts.count
+	}
+	members = members[start:end]
+
+	result := members
+	if len(opts.gets) > 0 {
+		result = make([][]byte, 0, len(members)*len(opts.gets))
+		for _, member := range members {
+			for _, pattern := range opts.gets {
+				result = append(result, db.sortLookup(pattern, member))
+			}
+		}
+	}
+	if opts.store == "" {
+		return protocol.MakeMultiBulkReply(result)
+	}
+
+	// 覆盖目标键并清除其过期时间
+	db.Remove(opts.store)
+	if len(result) == 0 {
+		db.addAof(utils.ToCmdLine("del", opts.store))
+		return protocol.MakeIntReply(0)
+	}
+	stored := list.MakeCompact()
+	aofLine := utils.ToCmdLine("rpush", opts.store)
+	for _, value := range result {
+		// GET 找不到的值保存为空字符串
+		value = append([]byte{}, value...)
+		stored.Add(value)
+		aofLine = append(aofLine, value)
+	}
+	db.PutEntity(opts.store, &database.DataEntity{Data: stored})
+	// 排序依赖外部键，AOF 中记录排序的结果
+	db.addAof(utils.ToCmdLine("del", opts.store))
+	db.addAof(aofLine)
+	return protocol.MakeIntReply(int64(len(result)))
+}
+
+// SORT key [BY pattern] [LIMIT offset count] [GET pattern ...] [ASC|DESC] [ALPHA] [STORE destination]
+func execSort(db *DB, args [][]byte) myredis.Reply {
+	return db.sort(args, false)
+}
+
+// SORT_RO key [BY pattern] [LIMIT offset count] [GET pattern ...] [ASC|DESC] [ALPHA]
+func execSortRO(db *DB, args [][]byte) myredis.Reply {
+	return db.sort(args, true)
+}
+
+func init() {
+	registerCommand("Sort", execSort, undoSort, -2, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagMovableKeys}, 1, 1, 1)
+	registerCommand("Sort_RO", execSortRO, nil, -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagMovableKeys}, 1, 1, 1)
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"myredis/lib/utils"
+	"myredis/protocol/assert"
+	"testing"
+)
+
+func TestSort(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("rpush", key, "3", "1", "10", "2"))
+
+	result := testDB.Exec(nil, utils.ToCmdLine("sort", key))
+	assert.AssertMultiBulkReply(t, result, []string{"1", "2", "3", "10"})
+	result = testDB.Exec(nil, utils.ToCmdLine("sort", key, "DESC", "LIMIT", "1", "2"))
+	assert.AssertMultiBulkReply(t, result, []string{"3", "2"})
+	result = testDB.Exec(nil, utils.ToCmdLine("sort", key, "ALPHA"))
+	assert.AssertMultiBulkReply(t, result, []string{"1", "10", "2", "3"})
+	result = testDB.Exec(nil, utils.ToCmdLine("sort_ro", key, "LIMIT", "0", "-1"))
+	assert.AssertMultiBulkReply(t, result, []string{"1", "2", "3", "10"})
+
+	testDB.Exec(nil, utils.ToCmdLine("rpush", key, "x"))
+	result = testDB.Exec(nil, utils.ToCmdLine("sort", key))
+	assert.AssertErrReply(t, result, "ERR One or more scores can't be converted into double")
+
+	result = testDB.Exec(nil, utils.ToCmdLine("sort", utils.RandString(10)))
+	assert.AssertMultiBulkReplySize(t, result, 0)
+	str := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("set", str, "v"))
+	result = testDB.Exec(nil, utils.ToCmdLine("sort", str))
+	assert.AssertErrReply(t, result, "WRONGTYPE Operation against a key holding the wrong kind of value")
+	result = testDB.Exec(nil, utils.ToCmdLine("sort_ro", key, "STORE", str))
+	assert.AssertErrReply(t, result, "Err syntax error")
+}
+
+func TestSortByAndGet(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("sadd", "users", "alice", "bob", "carol"))
+	testDB.Exec(nil, utils.ToCmdLine("set", "age_alice", "30"))
+	testDB.Exec(nil, utils.ToCmdLine("set", "age_bob", "20"))
+	testDB.Exec(nil, utils.ToCmdLine("set", "age_carol", "25"))
+	testDB.Exec(nil, utils.ToCmdLine("hset", "info_alice", "city", "Paris"))
+	testDB.Exec(nil, utils.ToCmdLine("hset", "info_bob", "city", "Rome"))
+
+	result := testDB.Exec(nil, utils.ToCmdLine("sort", "users", "BY", "age_*"))
+	assert.AssertMultiBulkReply(t, result, []string{"bob", "carol", "alice"})
+	result = testDB.Exec(nil, utils.ToCmdLine("sort", "users", "BY", "info_*->city", "ALPHA", "GET", "#", "GET", "info_*->city"))
+	// carol 没有 city 字段，按空值排在最前面
+	assert.AssertMultiBulkReply(t, result, []string{"carol", "", "alice", "Paris", "bob", "Rome"})
+	result = testDB.Exec(nil, utils.ToCmdLine("sort", "users", "BY", "nosort", "GET", "age_*"))
+	assert.AssertMultiBulkReplySize(t, result, 3)
+
+	result = testDB.Exec(nil, utils.ToCmdLine("sort", "users", "BY", "age_*", "GET", "age_*", "STORE", "ages"))
+	assert.AssertIntReply(t, result, 3)
+	result = testDB.Exec(nil, utils.ToCmdLine("lrange", "ages", "0", "-1"))
+	assert.AssertMultiBulkReply(t, result, []string{"20", "25", "30"})
+
+	// 回滚后恢复 STORE 覆盖的键
+	testDB.Exec(nil, utils.ToCmdLine("set", "ages", "old"))
+	cmdLine := utils.ToCmdLine("sort", "users", "ALPHA", "STORE", "ages")
+	undoCmdLines := undoSort(testDB, cmdLine[1:])
+	testDB.Exec(nil, cmdLine)
+	for _, cmdLine := range undoCmdLines {
+		testDB.Exec(nil, cmdLine)
+	}
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("get", "ages")), "old")
+}
@@ -6,6 +6,7 @@ import (
 	SortedSet "myredis/datastruct/sortedset"
 	"myredis/interface/database"
 	"myredis/interface/myredis"
+	"myredis/lib/keyspec"
 	"myredis/lib/utils"
 	"myredis/protocol"
 	"strconv"
@@ -40,19 +41,71 @@ func (db *DB) getOrInitSortedSet(key string) (sortedSet *sortedset.SortedSet, in
 	return sortedSet, inited, nil
 }
 
-// 处理 ZADD 命令，向有序集合添加一个或多个成员及其分数。示例：ZADD myzset 100 "member1" 200 "member2"
-func execZAdd(db *DB, args [][]byte) myredis.Reply {
-	if len(args)%2 != 1 {
-		return protocol.MakeSyntaxErrReply()
+// ZADD 的选项
+type zaddOptions struct {
+	nx   bool // 只添加新成员
+	xx   bool // 只更新已有成员
+	gt   bool // 只在新分数更大时更新
+	lt   bool // 只在新分数更小时更新
+	ch   bool // 返回新增与分数变化的成员总数
+	incr bool // 与 ZINCRBY 相同，返回新的分数
+}
+
+// 解析 ZADD 的选项，返回选项与第一个 score 的下标
+func parseZAddOptions(args [][]byte) (*zaddOptions, int, protocol.ErrorReply) {
+	opts := &zaddOptions{}
+	i := 1
+loop:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "NX":
+			opts.nx = true
+		case "XX":
+			opts.xx = true
+		case "GT":
+			opts.gt = true
+		case "LT":
+			opts.lt = true
+		case "CH":
+			opts.ch = true
+		case "INCR":
+			opts.incr = true
+		default:
+			break loop
+		}
+	}
+	pairs := len(args) - i
+	if pairs == 0 || pairs%2 != 0 {
+		return nil, 0, protocol.MakeSyntaxErrReply()
+	}
+	if opts.nx && opts.xx {
+		return nil, 0, protocol.MakeErrReply("ERR XX and NX options at the same time are not compatible")
+	}
+	if (opts.gt && opts.lt) || (opts.nx && (opts.gt || opts.lt)) {
+		return nil, 0, protocol.MakeErrReply("ERR GT, LT, and/or NX options at the same time are not compatible")
 	}
+	if opts.incr && pairs != 2 {
+		return nil, 0, protocol.MakeErrReply("ERR INCR option supports a single increment-element pair")
+	}
+	return opts, i, nil
+}
+
+// 处理 ZADD 命令，向有序集合添加一个或多个成员及其分数。示例：ZADD myzset NX CH 100 "member1" 200 "member2"
+//
+// 返回新增的成员数量，CH 时包括分数发生变化的成员；INCR 时返回新的分数，因 NX/XX/GT/LT 未执行时返回 nil
+func execZAdd(db *DB, args [][]byte) myredis.Reply {
 	key := string(args[0])
-	size := (len(args) - 1) / 2
+	opts, start, errReply := parseZAddOptions(args)
+	if errReply != nil {
+		return errReply
+	}
+	size := (len(args) - start) / 2
 	elements := make([]*SortedSet.Element, size)
 	for i := 0; i < size; i++ {
-		rawScore := args[2*i+1]
-		member := string(args[2*i+2])
+		rawScore := args[start+2*i]
+		member := string(args[start+2*i+1])
 		score, err := strconv.ParseFloat(string(rawScore), 64)
-		if err != nil {
+		if err != nil || math.IsNaN(score) {
 			return protocol.MakeErrReply("ERR value is not a valid float")
 		}
 		elements[i] = &SortedSet.Element{
@@ -61,28 +114,81 @@ func execZAdd(db *DB, args [][]byte) myredis.Reply {
 		}
 	}
 
-	sortedset, _, errReply := db.getOrInitSortedSet(key)
+	var sortedSet *SortedSet.SortedSet
+	if opts.xx {
+		// XX 不会创建新的键
+		sortedSet, errReply = db.getAsSortedSet(key)
+	} else {
+		sortedSet, _, errReply = db.getOrInitSortedSet(key)
+	}
 	if errReply != nil {
 		return errReply
 	}
+	if sortedSet == nil {
+		if opts.incr {
+			return protocol.MakeNullBulkReply()
+		}
+		return protocol.MakeIntReply(0)
+	}
 
-	i := 0
+	var added, changed int64
+	var incrScore *float64
 	for _, element := range elements {
-		if sortedset.Add(element.Member, element.Score) {
-			i++
+		current, exists := sortedSet.Get(element.Member)
+		if (exists && opts.nx) || (!exists && opts.xx) {
+			continue
 		}
+		score := element.Score
+		if exists && opts.incr {
+			score += current.Score
+			if math.IsNaN(score) {
+				return protocol.MakeErrReply("ERR resulting score is not a number (NaN)")
+			}
+		}
+		if exists {
+			if (opts.gt && score <= current.Score) || (opts.lt && score >= current.Score) {
+				continue
+			}
+			if score != current.Score {
+				sortedSet.Add(element.Member, score)
+				changed++
+			}
+		} else {
+			sortedSet.Add(element.Member, score)
+			added++
+		}
+		incrScore = &score
+	}
+	if sortedSet.Len() == 0 {
+		// 因 NX/GT/LT 没有添加任何成员时不保留新建的空集合
+		db.Remove(key)
+	}
+	if added+changed > 0 {
+		db.addAof(utils.ToCmdLine3("zadd", args...))
 	}
-	db.addAof(utils.ToCmdLine3("zadd", args...))
-	return protocol.MakeIntReply(int64(i))
+	if opts.incr {
+		if incrScore == nil {
+			return protocol.MakeNullBulkReply()
+		}
+		return protocol.MakeBulkReply([]byte(strconv.FormatFloat(*incrScore, 'f', -1, 64)))
+	}
+	if opts.ch {
+		return protocol.MakeIntReply(added + changed)
+	}
+	return protocol.MakeIntReply(added)
 }
 
 // 为 ZADD 命令生成回滚操作
 func undoZAdd(db *DB, args [][]byte) []CmdLine {
 	key := string(args[0])
-	size := (len(args) - 1) / 2
+	_, start, errReply := parseZAddOptions(args)
+	if errReply != nil {
+		return nil
+	}
+	size := (len(args) - start) / 2
 	fields := make([]string, size)
 	for i := 0; i < size; i++ {
-		fields[i] = string(args[2*i+2])
+		fields[i] = string(args[start+2*i+1])
 	}
 	return rollbackZSetFields(db, key, fields...)
 }
@@ -166,6 +272,31 @@ func execZCard(db *DB, args [][]byte) myredis.Reply {
 	return protocol.MakeIntReply(sortedSet.Len())
 }
 
+// 将闭区间 [start, stop] 表示的排名范围（负数表示倒数）转换为 [start, end)，范围为空时 start 等于 end
+func normalizeRankRange(start int64, stop int64, size int64) (int64, int64) {
+	if start < -1*size {
+		start = 0
+	} else if start < 0 {
+		start = start + size
+	} else if start >= size {
+		return 0, 0
+	}
+
+	if stop < -1*size {
+		stop = 0
+	} else if stop < 0 {
+		stop = stop + size + 1
+	} else if stop < size {
+		stop = stop + 1
+	} else {
+		stop = size
+	}
+	if stop < start {
+		stop = start
+	}
+	return start, stop
+}
+
 func range0(db *DB, key string, start int64, end int64, withScores bool, desc bool) myredis.Reply {
 	sortedSet, errReply := db.getAsSortedSet(key)
 	if errReply != nil {
@@ -175,28 +306,11 @@ func range0(db *DB, key string, start int64, end int64, withScores bool, desc bo
 		return protocol.MakeEmptyMultiBulkReply()
 	}
 
-	size := sortedSet.Len()
-	if start < -1*size {
-		start = 0
-	} else if start < 0 {
-		start = start + size
-	} else if start >= size {
+	start, end = normalizeRankRange(start, end, sortedSet.Len())
+	if start == end {
 		return protocol.MakeEmptyMultiBulkReply()
 	}
 
-	if end < -1*size {
-		end = 0
-	} else if end < 0 {
-		end = end + size + 1
-	} else if end < size {
-		end = end + 1
-	} else {
-		end = size
-	}
-	if end < start {
-		end = start
-	}
-
 	slice := sortedSet.RangeByRank(start, end, desc)
 	if withScores {
 		result := make([][]byte, len(slice)*2)
@@ -219,28 +333,162 @@ func range0(db *DB, key string, start int64, end int64, withScores bool, desc bo
 	return protocol.MakeMultiBulkReply(result)
 }
 
-// 处理 ZRANGE 命令，通过索引范围获取有序集合的成员。示例：ZRANGE myzset 0 -1 WITHSCORES
-func execZRange(db *DB, args [][]byte) myredis.Reply {
-	if len(args) != 3 && len(args) != 4 {
-		return protocol.MakeErrReply("ERR wrong number of arguments for 'zrange' command")
+// ZRANGE 与 ZRANGESTORE 的范围类型
+const (
+	zrangeByRank = iota
+	zrangeByScore
+	zrangeByLex
+)
+
+// ZRANGE 与 ZRANGESTORE 的参数
+type zrangeOptions struct {
+	key        string
+	start      string
+	stop       string
+	by         int
+	rev        bool
+	offset     int64
+	limit      int64 // 小于 0 表示不限制
+	withScores bool
+}
+
+// 解析 key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]，store 为 true 时不接受 WITHSCORES
+func parseZRangeArgs(args [][]byte, store bool) (*zrangeOptions, protocol.ErrorReply) {
+	opts := &zrangeOptions{
+		key:   string(args[0]),
+		start: string(args[1]),
+		stop:  string(args[2]),
+		by:    zrangeByRank,
+		limit: -1,
+	}
+	limited := false
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "BYSCORE":
+			opts.by = zrangeByScore
+		case "BYLEX":
+			opts.by = zrangeByLex
+		case "REV":
+			opts.rev = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return nil, protocol.MakeSyntaxErrReply()
+			}
+			var err1, err2 error
+			opts.offset, err1 = strconv.ParseInt(string(args[i+1]), 10, 64)
+			opts.limit, err2 = strconv.ParseInt(string(args[i+2]), 10, 64)
+			if err1 != nil || err2 != nil {
+				return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			limited = true
+			i += 2
+		case "WITHSCORES":
+			if store {
+				return nil, protocol.MakeSyntaxErrReply()
+			}
+			opts.withScores = true
+		default:
+			return nil, protocol.MakeSyntaxErrReply()
+		}
 	}
-	withScores := false
-	if len(args) == 4 {
-		if strings.ToUpper(string(args[3])) != "WITHSCORES" {
-			return protocol.MakeErrReply("syntax error")
+	if limited && opts.by == zrangeByRank {
+		return nil, protocol.MakeErrReply("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if opts.withScores && opts.by == zrangeByLex {
+		return nil, protocol.MakeErrReply("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	return opts, nil
+}
+
+// 按 ZRANGE 的参数读取元素，REV 时 start 与 stop 分别是范围的上界与下界
+func (db *DB) zrangeElements(opts *zrangeOptions) ([]*SortedSet.Element, protocol.ErrorReply) {
+	var min, max SortedSet.Border
+	var start, stop int64
+	var err error
+	switch opts.by {
+	case zrangeByRank:
+		start, err = strconv.ParseInt(opts.start, 10, 64)
+		if err == nil {
+			stop, err = strconv.ParseInt(opts.stop, 10, 64)
+		}
+		if err != nil {
+			return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+	case zrangeByScore, zrangeByLex:
+		parse := SortedSet.ParseScoreBorder
+		if opts.by == zrangeByLex {
+			parse = SortedSet.ParseLexBorder
+		}
+		minArg, maxArg := opts.start, opts.stop
+		if opts.rev {
+			minArg, maxArg = maxArg, minArg
+		}
+		if min, err = parse(minArg); err == nil {
+			max, err = parse(maxArg)
+		}
+		if err != nil {
+			return nil, protocol.MakeErrReply(err.Error())
 		}
-		withScores = true
 	}
-	key := string(args[0])
-	start, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
-		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+
+	sortedSet, errReply := db.getAsSortedSet(opts.key)
+	if errReply != nil {
+		return nil, errReply
 	}
-	end, err := strconv.ParseInt(string(args[2]), 10, 64)
-	if err != nil {
-		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	if sortedSet == nil {
+		return nil, nil
+	}
+	if opts.by != zrangeByRank {
+		return sortedSet.Range(min, max, opts.offset, opts.limit, opts.rev), nil
+	}
+	start, end := normalizeRankRange(start, stop, sortedSet.Len())
+	if start == end {
+		return nil, nil
+	}
+	return sortedSet.RangeByRank(start, end, opts.rev), nil
+}
+
+// 处理 ZRANGE 命令，按排名、分数或字典序范围获取有序集合的成员。
+// 示例：ZRANGE myzset 0 -1 WITHSCORES、ZRANGE myzset (100 0 BYSCORE REV LIMIT 0 5
+func execZRange(db *DB, args [][]byte) myredis.Reply {
+	opts, errReply := parseZRangeArgs(args, false)
+	if errReply != nil {
+		return errReply
+	}
+	elements, errReply := db.zrangeElements(opts)
+	if errReply != nil {
+		return errReply
+	}
+	return elementsReply(elements, opts.withScores)
+}
+
+// 处理 ZRANGESTORE 命令，将 ZRANGE 的结果保存到 dst，返回结果的成员数量。示例：ZRANGESTORE dst src 0 9
+func execZRangeStore(db *DB, args [][]byte) myredis.Reply {
+	opts, errReply := parseZRangeArgs(args[1:], true)
+	if errReply != nil {
+		return errReply
+	}
+	elements, errReply := db.zrangeElements(opts)
+	if errReply != nil {
+		return errReply
+	}
+	result := SortedSet.Make()
+	for _, element := range elements {
+		result.Add(element.Member, element.Score)
+	}
+	return db.storeZSetResult("zrangestore", string(args[0]), result, args)
+}
+
+// 返回元素列表，withScores 时成员与分数交替出现
+func elementsReply(elements []*SortedSet.Element, withScores bool) myredis.Reply {
+	result := make([][]byte, 0, len(elements))
+	for _, element := range elements {
+		result = append(result, []byte(element.Member))
+		if withScores {
+			result = append(result, []byte(strconv.FormatFloat(element.Score, 'f', -1, 64)))
+		}
 	}
-	return range0(db, key, start, end, withScores, false)
+	return protocol.MakeMultiBulkReply(result)
 }
 
 // 处理 ZREVRANGE 命令，通过索引范围逆序获取有序集合的成员。示例：ZREVRANGE myzset 0 10
@@ -497,39 +745,214 @@ func execZRemRangeByRank(db *DB, args [][]byte) myredis.Reply {
 	return protocol.MakeIntReply(removed)
 }
 
+// 从 key 弹出最多 count 个分数最小（max 为 false）或最大的成员，集合为空后删除 key，并按 ZPOPMIN/ZPOPMAX 记录 AOF
+func (db *DB) zpop(key string, count int, max bool) ([]*SortedSet.Element, protocol.ErrorReply) {
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil || sortedSet == nil || count <= 0 {
+		return nil, errReply
+	}
+	var removed []*SortedSet.Element
+	cmdName := "zpopmin"
+	if max {
+		removed = sortedSet.PopMax(count)
+		cmdName = "zpopmax"
+	} else {
+		removed = sortedSet.PopMin(count)
+	}
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+	}
+	if len(removed) > 0 {
+		db.addAof(utils.ToCmdLine(cmdName, key, strconv.Itoa(count)))
+	}
+	return removed, nil
+}
+
+// 解析弹出数量，不能为负数
+func parsePopCount(arg []byte) (int, protocol.ErrorReply) {
+	count, err := strconv.Atoi(string(arg))
+	if err != nil {
+		return 0, protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if count < 0 {
+		return 0, protocol.MakeErrReply("ERR value is out of range, must be positive")
+	}
+	return count, nil
+}
+
+func execZPop(db *DB, args [][]byte, max bool) myredis.Reply {
+	if len(args) > 2 {
+		return protocol.MakeSyntaxErrReply()
+	}
+	count := 1
+	if len(args) == 2 {
+		var errReply protocol.ErrorReply
+		count, errReply = parsePopCount(args[1])
+		if errReply != nil {
+			return errReply
+		}
+	}
+	removed, errReply := db.zpop(string(args[0]), count, max)
+	if errReply != nil {
+		return errReply
+	}
+	return elementsReply(removed, true)
+}
+
 // 处理 ZPOPMIN 命令，移除并返回有序集合中分数最小的成员。示例：ZPOPMIN myzset 2
 func execZPopMin(db *DB, args [][]byte) myredis.Reply {
-	key := string(args[0])
+	return execZPop(db, args, false)
+}
+
+// 处理 ZPOPMAX 命令，移除并返回有序集合中分数最大的成员，按分数从高到低排列。示例：ZPOPMAX myzset 2
+func execZPopMax(db *DB, args [][]byte) myredis.Reply {
+	return execZPop(db, args, true)
+}
+
+// 处理 BZPOPMIN/BZPOPMAX 命令，从第一个非空的有序集合中弹出一个成员，返回 [key, member, score]
+//
+// 这里是不阻塞的版本，所有集合为空时返回空数组；在事务之外执行时由 execBlocking 负责等待
+func execBZPop(db *DB, args [][]byte, max bool) myredis.Reply {
+	if _, errReply := parseBlockingTimeout(args[len(args)-1]); errReply != nil {
+		return errReply
+	}
+	for _, arg := range args[:len(args)-1] {
+		key := string(arg)
+		removed, errReply := db.zpop(key, 1, max)
+		if errReply != nil {
+			return errReply
+		}
+		if len(removed) > 0 {
+			score := strconv.FormatFloat(removed[0].Score, 'f', -1, 64)
+			return protocol.MakeMultiBulkReply(utils.ToCmdLine(key, removed[0].Member, score))
+		}
+	}
+	return protocol.MakeNullMultiBulkReply()
+}
+
+// 处理 BZPOPMIN 命令。示例：BZPOPMIN zset1 zset2 0.5
+func execBZPopMin(db *DB, args [][]byte) myredis.Reply {
+	return execBZPop(db, args, false)
+}
+
+// 处理 BZPOPMAX 命令。示例：BZPOPMAX zset1 zset2 0
+func execBZPopMax(db *DB, args [][]byte) myredis.Reply {
+	return execBZPop(db, args, true)
+}
+
+func undoBlockingPop(db *DB, args [][]byte) []CmdLine {
+	keys, _ := keyspec.BlockingPop(args)
+	return rollbackGivenKeys(db, keys...)
+}
+
+// 处理 ZMPOP 命令，从第一个非空的有序集合中弹出最多 count 个成员，返回 [key, [[member, score], ...]]，
+// 所有集合为空时返回空数组。示例：ZMPOP 2 zset1 zset2 MIN COUNT 10
+func execZMPop(db *DB, args [][]byte) myredis.Reply {
+	keys, rest, errReply := parseNumKeys("zmpop", args)
+	if errReply != nil {
+		return errReply
+	}
+	if len(rest) != 1 && len(rest) != 3 {
+		return protocol.MakeSyntaxErrReply()
+	}
+	var max bool
+	switch strings.ToUpper(string(rest[0])) {
+	case "MIN":
+		max = false
+	case "MAX":
+		max = true
+	default:
+		return protocol.MakeSyntaxErrReply()
+	}
 	count := 1
-	if len(args) > 1 {
+	if len(rest) == 3 {
+		if strings.ToUpper(string(rest[1])) != "COUNT" {
+			return protocol.MakeSyntaxErrReply()
+		}
 		var err error
-		count, err = strconv.Atoi(string(args[1]))
-		if err != nil {
-			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		count, err = strconv.Atoi(string(rest[2]))
+		if err != nil || count <= 0 {
+			return protocol.MakeErrReply("ERR count should be greater than 0")
+		}
+	}
+	for _, key := range keys {
+		removed, errReply := db.zpop(key, count, max)
+		if errReply != nil {
+			return errReply
+		}
+		if len(removed) == 0 {
+			continue
+		}
+		elements := make([]myredis.Reply, len(removed))
+		for i, element := range removed {
+			score := strconv.FormatFloat(element.Score, 'f', -1, 64)
+			elements[i] = protocol.MakeMultiBulkReply(utils.ToCmdLine(element.Member, score))
 		}
+		return protocol.MakeMultiRawReply([]myredis.Reply{
+			protocol.MakeBulkReply([]byte(key)),
+			protocol.MakeMultiRawReply(elements),
+		})
 	}
+	return protocol.MakeNullMultiBulkReply()
+}
 
-	sortedset, errReply := db.getAsSortedSet(key)
+func undoZMPop(db *DB, args [][]byte) []CmdLine {
+	return rollbackGivenKeys(db, keyspec.NumKeys(args)...)
+}
+
+// 处理 ZRANDMEMBER 命令，随机返回成员。示例：ZRANDMEMBER myzset -5 WITHSCORES
+//
+// 不指定 count 时返回一个成员；count 为正数时返回互不相同的成员，为负数时允许重复
+func execZRandMember(db *DB, args [][]byte) myredis.Reply {
+	if len(args) > 3 || (len(args) == 3 && strings.ToUpper(string(args[2])) != "WITHSCORES") {
+		return protocol.MakeSyntaxErrReply()
+	}
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
 	if errReply != nil {
 		return errReply
 	}
-	if sortedset == nil {
+	if len(args) == 1 {
+		if sortedSet == nil {
+			return protocol.MakeNullBulkReply()
+		}
+		return protocol.MakeBulkReply([]byte(sortedSet.RandomElements(1, true)[0].Member))
+	}
+	count, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if count < -math.MaxInt32 || count > math.MaxInt32 {
+		return protocol.MakeErrReply("ERR value is out of range")
+	}
+	if sortedSet == nil || count == 0 {
 		return protocol.MakeEmptyMultiBulkReply()
 	}
-	removed := sortedset.PopMin(count)
-	result := make([][]byte, len(removed)*2)
-	i := 0
-	for _, element := range removed {
-		score := strconv.FormatFloat(element.Score, 'f', -1, 64)
-		result[i] = []byte(element.Member)
-		i++
-		result[i] = []byte(score)
-		i++
+	var elements []*SortedSet.Element
+	if count > 0 {
+		elements = sortedSet.RandomElements(int(count), true)
+	} else {
+		elements = sortedSet.RandomElements(int(-count), false)
 	}
-	if len(removed) > 0 {
-		db.addAof(utils.ToCmdLine3("zpopmin", args...))
+	return elementsReply(elements, len(args) == 3)
+}
+
+// 处理 ZMSCORE 命令，返回多个成员的分数，不存在的成员返回 nil。示例：ZMSCORE myzset m1 m2
+func execZMScore(db *DB, args [][]byte) myredis.Reply {
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
 	}
-	return protocol.MakeMultiBulkReply(result)
+	result := make([]myredis.Reply, len(args)-1)
+	for i, member := range args[1:] {
+		result[i] = protocol.MakeNullBulkReply()
+		if sortedSet == nil {
+			continue
+		}
+		if element, ok := sortedSet.Get(string(member)); ok {
+			result[i] = protocol.MakeBulkReply([]byte(strconv.FormatFloat(element.Score, 'f', -1, 64)))
+		}
+	}
+	return protocol.MakeMultiRawReply(result)
 }
 
 // 处理 ZREM 命令，从有序集合中移除一个或多个成员。示例：ZREM myzset "member1" "member2"
@@ -779,92 +1202,80 @@ func execZRemRangeByLex(db *DB, args [][]byte) myredis.Reply {
 
 // 处理 ZSCAN 命令，迭代有序集合中的成员。示例：ZSCAN myzset 0 MATCH user:* COUNT 100
 func execZScan(db *DB, args [][]byte) myredis.Reply {
-	var count int = 10
-	var pattern string = "*"
-	if len(args) > 2 {
-		for i := 2; i < len(args); i++ {
-			arg := strings.ToLower(string(args[i]))
-			if arg == "count" {
-				tempCount, err := strconv.Atoi(string(args[i+1]))
-				if err != nil {
-					return protocol.MakeSyntaxErrReply()
-				}
-				count = tempCount
-				i++
-			} else if arg == "match" {
-				pattern = string(args[i+1])
-				i++
-			} else {
-				return protocol.MakeSyntaxErrReply()
-			}
-		}
-	}
-	key := string(args[0])
-	sortedset, errReply := db.getAsSortedSet(key)
+	opts, errReply := parseScanArgs(args[1], args[2:], false)
 	if errReply != nil {
 		return errReply
 	}
-	if sortedset == nil {
-		return protocol.MakeEmptyMultiBulkReply()
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
 	}
-	cursor, err := strconv.Atoi(string(args[1]))
-	if err != nil {
-		return protocol.MakeErrReply("ERR invalid cursor")
+	// 键不存在时视为空集合
+	if sortedSet == nil {
+		return makeScanReply(0, nil)
 	}
-
-	keysReply, nextCursor := sortedset.ZSetScan(cursor, count, pattern)
+	elements, nextCursor := sortedSet.ZSetScan(opts.cursor, opts.count, opts.pattern)
 	if nextCursor < 0 {
-		return protocol.MakeErrReply("Invalid argument")
+		return protocol.MakeErrReply("ERR illegal wildcard")
 	}
-
-	result := make([]myredis.Reply, 2)
-	result[0] = protocol.MakeBulkReply([]byte(strconv.FormatInt(int64(nextCursor), 10)))
-	result[1] = protocol.MakeMultiBulkReply(keysReply)
-
-	return protocol.MakeMultiRawReply(result)
+	return makeScanReply(nextCursor, elements)
 }
 
 func init() {
-	registerCommand("ZAdd", execZAdd, writeFirstKey, undoZAdd, -4, flagWrite).
+	registerCommand("ZAdd", execZAdd, undoZAdd, -4, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
-	registerCommand("ZRem", execZRem, writeFirstKey, undoZRem, -3, flagWrite).
+	registerCommand("ZRem", execZRem, undoZRem, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
-	registerCommand("ZRemRangeByScore", execZRemRangeByScore, writeFirstKey, rollbackFirstKey, 4, flagWrite).
+	registerCommand("ZRemRangeByScore", execZRemRangeByScore, rollbackFirstKey, 4, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite}, 1, 1, 1)
-	registerCommand("ZRemRangeByRank", execZRemRangeByRank, writeFirstKey, rollbackFirstKey, 4, flagWrite).
+	registerCommand("ZRemRangeByRank", execZRemRangeByRank, rollbackFirstKey, 4, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite}, 1, 1, 1)
-	registerCommand("ZIncrBy", execZIncrBy, writeFirstKey, undoZIncr, -4, flagWrite).
+	registerCommand("ZIncrBy", execZIncrBy, undoZIncr, -4, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
-	registerCommand("ZPopMin", execZPopMin, writeFirstKey, rollbackFirstKey, -2, flagWrite).
+	registerCommand("ZPopMin", execZPopMin, rollbackFirstKey, -2, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
-
-	registerCommand("ZScore", execZScore, readFirstKey, nil, 3, flagReadOnly).
+	registerCommand("ZPopMax", execZPopMax, rollbackFirstKey, -2, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
+	registerCommand("BZPopMin", execBZPopMin, undoBlockingPop, -3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite}, 1, -2, 1)
+	registerCommand("BZPopMax", execBZPopMax, undoBlockingPop, -3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite}, 1, -2, 1)
+	registerCommand("ZMPop", execZMPop, undoZMPop, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagMovableKeys}, 0, 0, 0)
+	registerCommand("ZRangeStore", execZRangeStore, rollbackFirstKey, -5, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 2, 1)
+
+	registerCommand("ZScore", execZScore, nil, 3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
+	registerCommand("ZMScore", execZMScore, nil, -3, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("ZRank", execZRank, readFirstKey, nil, 3, flagWrite).
+	registerCommand("ZRandMember", execZRandMember, nil, -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom}, 1, 1, 1)
+	registerCommand("ZRank", execZRank, nil, 3, flagWrite).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("ZRevRank", execZRevRank, readFirstKey, nil, 3, flagReadOnly).
+	registerCommand("ZRevRank", execZRevRank, nil, 3, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("ZCount", execZCount, readFirstKey, nil, 4, flagReadOnly).
+	registerCommand("ZCount", execZCount, nil, 4, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("ZCard", execZCard, readFirstKey, nil, 2, flagReadOnly).
+	registerCommand("ZCard", execZCard, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("ZRange", execZRange, readFirstKey, nil, -4, flagReadOnly).
+	registerCommand("ZRange", execZRange, nil, -4, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
-	registerCommand("ZRevRange", execZRevRange, readFirstKey, nil, -4, flagReadOnly).
+	registerCommand("ZRevRange", execZRevRange, nil, -4, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
-	registerCommand("ZRangeByScore", execZRangeByScore, readFirstKey, nil, -4, flagReadOnly).
+	registerCommand("ZRangeByScore", execZRangeByScore, nil, -4, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
-	registerCommand("ZRevRangeByScore", execZRevRangeByScore, readFirstKey, nil, -4, flagReadOnly).
+	registerCommand("ZRevRangeByScore", execZRevRangeByScore, nil, -4, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
 
-	registerCommand("ZLexCount", execZLexCount, readFirstKey, nil, 4, flagReadOnly).
+	registerCommand("ZLexCount", execZLexCount, nil, 4, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
-	registerCommand("ZRangeByLex", execZRangeByLex, readFirstKey, nil, -4, flagReadOnly).
+	registerCommand("ZRangeByLex", execZRangeByLex, nil, -4, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
-	registerCommand("ZRemRangeByLex", execZRemRangeByLex, writeFirstKey, rollbackFirstKey, 4, flagWrite).
+	registerCommand("ZRemRangeByLex", execZRemRangeByLex, rollbackFirstKey, 4, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite}, 1, 1, 1)
-	registerCommand("ZRevRangeByLex", execZRevRangeByLex, readFirstKey, nil, -4, flagReadOnly).
+	registerCommand("ZRevRangeByLex", execZRevRangeByLex, nil, -4, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
-	registerCommand("ZScan", execZScan, readFirstKey, nil, -2, flagReadOnly).
+	registerCommand("ZScan", execZScan, nil, -3, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
 }
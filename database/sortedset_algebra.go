@@ -0,0 +1,384 @@
+package database
+
+import (
+	"math"
+	HashSet "myredis/datastruct/set"
+	SortedSet "myredis/datastruct/sortedset"
+	"myredis/interface/database"
+	"myredis/interface/myredis"
+	"myredis/lib/utils"
+	"myredis/protocol"
+	"strconv"
+	"strings"
+)
+
+// 有序集合运算的聚合方式
+const (
+	aggregateSum = iota
+	aggregateMin
+	aggregateMax
+)
+
+// 有序集合运算的一个输入，普通集合的成员分数视为 1
+type zsetOperand map[string]float64
+
+// 有序集合运算的参数
+type zsetCalcOptions struct {
+	keys       []string
+	weights    []float64
+	aggregate  int
+	withScores bool
+}
+
+// 读取 key 作为运算输入，key 不存在时返回空输入
+func (db *DB) getAsZSetOperand(key string) (zsetOperand, protocol.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return zsetOperand{}, nil
+	}
+	switch data := entity.Data.(type) {
+	case *SortedSet.SortedSet:
+		operand := make(zsetOperand, data.Len())
+		if data.Len() > 0 {
+			data.ForEachByRank(0, data.Len(), false, func(element *SortedSet.Element) bool {
+				operand[element.Member] = element.Score
+				return true
+			})
+		}
+		return operand, nil
+	case *HashSet.Set:
+		operand := make(zsetOperand, data.Len())
+		data.ForEach(func(member string) bool {
+			operand[member] = 1
+			return true
+		})
+		return operand, nil
+	}
+	return nil, &protocol.WrongTypeErrReply{}
+}
+
+// 从 args 中读取 numkeys 个键，返回键与剩余参数
+func parseNumKeys(cmdName string, args [][]byte) ([]string, [][]byte, protocol.ErrorReply) {
+	if len(args) == 0 {
+		return nil, nil, protocol.MakeArgNumErrReply(cmdName)
+	}
+	numKeys, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil {
+		return nil, nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if numKeys <= 0 {
+		return nil, nil, protocol.MakeErrReply("ERR at least 1 input key is needed for '" + cmdName + "' command")
+	}
+	if numKeys > int64(len(args)-1) {
+		return nil, nil, protocol.MakeSyntaxErrReply()
+	}
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = string(args[i+1])
+	}
+	return keys, args[numKeys+1:], nil
+}
+
+// 解析 ZUNION/ZINTER/ZDIFF 及其 STORE 形式的参数，args 从 numkeys 开始
+//
+// weighted 表示是否接受 WEIGHTS 与 AGGREGATE，store 为 true 时不接受 WITHSCORES
+func parseZSetCalcArgs(cmdName string, args [][]byte, weighted bool, store bool) (*zsetCalcOptions, protocol.ErrorReply) {
+	keys, rest, errReply := parseNumKeys(cmdName, args)
+	if errReply != nil {
+		return nil, errReply
+	}
+	opts := &zsetCalcOptions{
+		keys:      keys,
+		weights:   make([]float64, len(keys)),
+		aggregate: aggregateSum,
+	}
+	for i := range opts.weights {
+		opts.weights[i] = 1
+	}
+	for i := 0; i < len(rest); i++ {
+		arg := strings.ToUpper(string(rest[i]))
+		switch {
+		case arg == "WEIGHTS" && weighted && i+len(keys) < len(rest):
+			for j := range keys {
+				weight, err := strconv.ParseFloat(string(rest[i+1+j]), 64)
+				if err != nil || math.IsNaN(weight) {
+					return nil, protocol.MakeErrReply("ERR weight value is not a float")
+				}
+				opts.weights[j] = weight
+			}
+			i += len(keys)
+		case arg == "AGGREGATE" && weighted && i+1 < len(rest):
+			switch strings.ToUpper(string(rest[i+1])) {
+			case "SUM":
+				opts.aggregate = aggregateSum
+			case "MIN":
+				opts.aggregate = aggregateMin
+			case "MAX":
+				opts.aggregate = aggregateMax
+			default:
+				return nil, protocol.MakeSyntaxErrReply()
+			}
+			i++
+		case arg == "WITHSCORES" && !store:
+			opts.withScores = true
+		default:
+			return nil, protocol.MakeSyntaxErrReply()
+		}
+	}
+	return opts, nil
+}
+
+// 按聚合方式合并两个分数，+inf 与 -inf 相加得到的 NaN 视为 0
+func aggregateScore(aggregate int, a, b float64) float64 {
+	switch aggregate {
+	case aggregateMin:
+		return math.Min(a, b)
+	case aggregateMax:
+		return math.Max(a, b)
+	}
+	sum := a + b
+	if math.IsNaN(sum) {
+		return 0
+	}
+	return sum
+}
+
+// 带权重的分数，0 乘以 inf 得到的 NaN 视为 0
+func weightedScore(score, weight float64) float64 {
+	result := score * weight
+	if math.IsNaN(result) {
+		return 0
+	}
+	return result
+}
+
+func (db *DB) getZSetOperands(keys []string) ([]zsetOperand, protocol.ErrorReply) {
+	operands := make([]zsetOperand, len(keys))
+	for i, key := range keys {
+		operand, errReply := db.getAsZSetOperand(key)
+		if errReply != nil {
+			return nil, errReply
+		}
+		operands[i] = operand
+	}
+	return operands, nil
+}
+
+func zunion(operands []zsetOperand, opts *zsetCalcOptions) *SortedSet.SortedSet {
+	scores := make(map[string]float64)
+	for i, operand := range operands {
+		for member, score := range operand {
+			score = weightedScore(score, opts.weights[i])
+			if current, ok := scores[member]; ok {
+				scores[member] = aggregateScore(opts.aggregate, current, score)
+			} else {
+				scores[member] = score
+			}
+		}
+	}
+	return scoresToSortedSet(scores)
+}
+
+func zinter(operands []zsetOperand, opts *zsetCalcOptions) *SortedSet.SortedSet {
+	scores := make(map[string]float64)
+	for member, score := range operands[0] {
+		score = weightedScore(score, opts.weights[0])
+		found := true
+		for i := 1; i < len(operands); i++ {
+			other, ok := operands[i][member]
+			if !ok {
+				found = false
+				break
+			}
+			score = aggregateScore(opts.aggregate, score, weightedScore(other, opts.weights[i]))
+		}
+		if found {
+			scores[member] = score
+		}
+	}
+	return scoresToSortedSet(scores)
+}
+
+// 差集保留第一个输入中不属于其它输入的成员及其原有分数
+func zdiff(operands []zsetOperand) *SortedSet.SortedSet {
+	scores := make(map[string]float64)
+	for member, score := range operands[0] {
+		found := false
+		for i := 1; i < len(operands); i++ {
+			if _, found = operands[i][member]; found {
+				break
+			}
+		}
+		if !found {
+			scores[member] = score
+		}
+	}
+	return scoresToSortedSet(scores)
+}
+
+func scoresToSortedSet(scores map[string]float64) *SortedSet.SortedSet {
+	result := SortedSet.Make()
+	for member, score := range scores {
+		result.Add(member, score)
+	}
+	return result
+}
+
+// 按分数从低到高返回有序集合的全部成员
+func sortedSetReply(sortedSet *SortedSet.SortedSet, withScores bool) myredis.Reply {
+	if sortedSet.Len() == 0 {
+		return protocol.MakeEmptyMultiBulkReply()
+	}
+	return elementsReply(sortedSet.RangeByRank(0, sortedSet.Len(), false), withScores)
+}
+
+// 用运算结果覆盖 dest，结果为空时删除 dest
+func (db *DB) storeZSetResult(cmdName string, dest string, result *SortedSet.SortedSet, args [][]byte) myredis.Reply {
+	db.Remove(dest)
+	if result.Len() > 0 {
+		db.PutEntity(dest, &database.DataEntity{
+			Data: result,
+		})
+	}
+	db.addAof(utils.ToCmdLine3(cmdName, args...))
+	return protocol.MakeIntReply(result.Len())
+}
+
+// 执行 ZUNION/ZINTER/ZDIFF 运算，args 从 numkeys 开始
+func (db *DB) zsetCalc(cmdName string, args [][]byte, store bool) (*SortedSet.SortedSet, *zsetCalcOptions, myredis.Reply) {
+	isDiff := strings.HasPrefix(cmdName, "zdiff")
+	opts, errReply := parseZSetCalcArgs(cmdName, args, !isDiff, store)
+	if errReply != nil {
+		return nil, nil, errReply
+	}
+	operands, errReply := db.getZSetOperands(opts.keys)
+	if errReply != nil {
+		return nil, nil, errReply
+	}
+	switch {
+	case isDiff:
+		return zdiff(operands), opts, nil
+	case strings.HasPrefix(cmdName, "zinter"):
+		return zinter(operands, opts), opts, nil
+	}
+	return zunion(operands, opts), opts, nil
+}
+
+// execZUnion: 计算多个有序集合的并集，普通集合的成员分数视为 1
+// 返回值: 按分数排序的成员，WITHSCORES 时成员与分数交替返回
+// 格式: ZUNION numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func execZUnion(db *DB, args [][]byte) myredis.Reply {
+	result, opts, errReply := db.zsetCalc("zunion", args, false)
+	if errReply != nil {
+		return errReply
+	}
+	return sortedSetReply(result, opts.withScores)
+}
+
+// execZInter: 计算多个有序集合的交集
+// 格式: ZINTER numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func execZInter(db *DB, args [][]byte) myredis.Reply {
+	result, opts, errReply := db.zsetCalc("zinter", args, false)
+	if errReply != nil {
+		return errReply
+	}
+	return sortedSetReply(result, opts.withScores)
+}
+
+// execZDiff: 计算第一个有序集合与其它集合的差集
+// 格式: ZDIFF numkeys key [key ...] [WITHSCORES]
+func execZDiff(db *DB, args [][]byte) myredis.Reply {
+	result, opts, errReply := db.zsetCalc("zdiff", args, false)
+	if errReply != nil {
+		return errReply
+	}
+	return sortedSetReply(result, opts.withScores)
+}
+
+// execZUnionStore: 计算并集并保存到 destination
+// 返回值: 结果集合的成员数量
+// 格式: ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func execZUnionStore(db *DB, args [][]byte) myredis.Reply {
+	result, _, errReply := db.zsetCalc("zunionstore", args[1:], true)
+	if errReply != nil {
+		return errReply
+	}
+	return db.storeZSetResult("zunionstore", string(args[0]), result, args)
+}
+
+// execZInterStore: 计算交集并保存到 destination
+// 格式: ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func execZInterStore(db *DB, args [][]byte) myredis.Reply {
+	result, _, errReply := db.zsetCalc("zinterstore", args[1:], true)
+	if errReply != nil {
+		return errReply
+	}
+	return db.storeZSetResult("zinterstore", string(args[0]), result, args)
+}
+
+// execZDiffStore: 计算差集并保存到 destination
+// 格式: ZDIFFSTORE destination numkeys key [key ...]
+func execZDiffStore(db *DB, args [][]byte) myredis.Reply {
+	result, _, errReply := db.zsetCalc("zdiffstore", args[1:], true)
+	if errReply != nil {
+		return errReply
+	}
+	return db.storeZSetResult("zdiffstore", string(args[0]), result, args)
+}
+
+// execZInterCard: 计算交集的成员数量，达到 LIMIT 后停止计算
+// 格式: ZINTERCARD numkeys key [key ...] [LIMIT limit]
+func execZInterCard(db *DB, args [][]byte) myredis.Reply {
+	keys, rest, errReply := parseNumKeys("zintercard", args)
+	if errReply != nil {
+		return errReply
+	}
+	var limit int64
+	if len(rest) == 2 && strings.ToUpper(string(rest[0])) == "LIMIT" {
+		var err error
+		limit, err = strconv.ParseInt(string(rest[1]), 10, 64)
+		if err != nil {
+			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if limit < 0 {
+			return protocol.MakeErrReply("ERR LIMIT can't be negative")
+		}
+	} else if len(rest) != 0 {
+		return protocol.MakeSyntaxErrReply()
+	}
+	operands, errReply := db.getZSetOperands(keys)
+	if errReply != nil {
+		return errReply
+	}
+	var count int64
+	for member := range operands[0] {
+		found := true
+		for i := 1; i < len(operands) && found; i++ {
+			_, found = operands[i][member]
+		}
+		if found {
+			count++
+			if count == limit {
+				break
+			}
+		}
+	}
+	return protocol.MakeIntReply(count)
+}
+
+func init() {
+	registerCommand("ZUnion", execZUnion, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagMovableKeys}, 0, 0, 0)
+	registerCommand("ZInter", execZInter, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagMovableKeys}, 0, 0, 0)
+	registerCommand("ZDiff", execZDiff, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagMovableKeys}, 0, 0, 0)
+	registerCommand("ZInterCard", execZInterCard, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagMovableKeys}, 0, 0, 0)
+	registerCommand("ZUnionStore", execZUnionStore, rollbackFirstKey, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagMovableKeys}, 1, 1, 1)
+	registerCommand("ZInterStore", execZInterStore, rollbackFirstKey, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagMovableKeys}, 1, 1, 1)
+	registerCommand("ZDiffStore", execZDiffStore, rollbackFirstKey, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagMovableKeys}, 1, 1, 1)
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"myredis/lib/keyspec"
+	"myredis/lib/utils"
+	"myredis/protocol/assert"
+	"testing"
+)
+
+func TestZUnionInter(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("ZADD", "z1", "1", "a", "2", "b", "3", "c"))
+	testDB.Exec(nil, utils.ToCmdLine("ZADD", "z2", "10", "b", "20", "c", "30", "d"))
+	testDB.Exec(nil, utils.ToCmdLine("SADD", "s", "c", "d", "e"))
+
+	result := testDB.Exec(nil, utils.ToCmdLine("ZUNION", "2", "z1", "z2", "WITHSCORES"))
+	assert.AssertMultiBulkReply(t, result, []string{"a", "1", "b", "12", "c", "23", "d", "30"})
+	result = testDB.Exec(nil, utils.ToCmdLine("ZUNION", "3", "z1", "z2", "s", "WEIGHTS", "2", "1", "5", "AGGREGATE", "MAX", "WITHSCORES"))
+	assert.AssertMultiBulkReply(t, result, []string{"a", "2", "e", "5", "b", "10", "c", "20", "d", "30"})
+	result = testDB.Exec(nil, utils.ToCmdLine("ZINTER", "2", "z1", "z2", "AGGREGATE", "MIN", "WITHSCORES"))
+	assert.AssertMultiBulkReply(t, result, []string{"b", "2", "c", "3"})
+	result = testDB.Exec(nil, utils.ToCmdLine("ZINTER", "3", "z1", "z2", "s"))
+	assert.AssertMultiBulkReply(t, result, []string{"c"})
+	result = testDB.Exec(nil, utils.ToCmdLine("ZINTER", "2", "z1", "missing"))
+	assert.AssertMultiBulkReplySize(t, result, 0)
+
+	result = testDB.Exec(nil, utils.ToCmdLine("ZUNIONSTORE", "dest", "2", "z1", "s"))
+	assert.AssertIntReply(t, result, 5)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRANGE", "dest", "0", "-1", "WITHSCORES"))
+	assert.AssertMultiBulkReply(t, result, []string{"a", "1", "d", "1", "e", "1", "b", "2", "c", "4"})
+	result = testDB.Exec(nil, utils.ToCmdLine("ZINTERSTORE", "dest", "2", "z1", "z2", "WEIGHTS", "1", "0.5"))
+	assert.AssertIntReply(t, result, 2)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRANGE", "dest", "0", "-1", "WITHSCORES"))
+	assert.AssertMultiBulkReply(t, result, []string{"b", "7", "c", "13"})
+
+	// 结果为空时删除目标键
+	result = testDB.Exec(nil, utils.ToCmdLine("ZINTERSTORE", "dest", "2", "z1", "missing"))
+	assert.AssertIntReply(t, result, 0)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("EXISTS", "dest")), 0)
+
+	result = testDB.Exec(nil, utils.ToCmdLine("ZINTERCARD", "2", "z1", "z2"))
+	assert.AssertIntReply(t, result, 2)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZINTERCARD", "2", "z1", "z2", "LIMIT", "1"))
+	assert.AssertIntReply(t, result, 1)
+}
+
+func TestZDiff(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("ZADD", "z1", "1", "a", "2", "b", "3", "c"))
+	testDB.Exec(nil, utils.ToCmdLine("ZADD", "z2", "10", "b"))
+	testDB.Exec(nil, utils.ToCmdLine("SADD", "s", "c"))
+
+	result := testDB.Exec(nil, utils.ToCmdLine("ZDIFF", "2", "z1", "z2", "WITHSCORES"))
+	assert.AssertMultiBulkReply(t, result, []string{"a", "1", "c", "3"})
+	result = testDB.Exec(nil, utils.ToCmdLine("ZDIFFSTORE", "dest", "3", "z1", "z2", "s"))
+	assert.AssertIntReply(t, result, 1)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRANGE", "dest", "0", "-1", "WITHSCORES"))
+	assert.AssertMultiBulkReply(t, result, []string{"a", "1"})
+}
+
+func TestZSetCalcErrors(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("ZADD", "z1", "1", "a"))
+	testDB.Exec(nil, utils.ToCmdLine("SET", "str", "1"))
+
+	result := testDB.Exec(nil, utils.ToCmdLine("ZUNION", "0", "z1"))
+	assert.AssertErrReply(t, result, "ERR at least 1 input key is needed for 'zunion' command")
+	result = testDB.Exec(nil, utils.ToCmdLine("ZUNION", "3", "z1", "z2"))
+	assert.AssertErrReply(t, result, "Err syntax error")
+	result = testDB.Exec(nil, utils.ToCmdLine("ZINTER", "2", "z1", "str"))
+	assert.AssertErrReply(t, result, "WRONGTYPE Operation against a key holding the wrong kind of value")
+	result = testDB.Exec(nil, utils.ToCmdLine("ZUNION", "1", "z1", "WEIGHTS", "x"))
+	assert.AssertErrReply(t, result, "ERR weight value is not a float")
+	result = testDB.Exec(nil, utils.ToCmdLine("ZDIFF", "1", "z1", "AGGREGATE", "MIN"))
+	assert.AssertErrReply(t, result, "Err syntax error")
+	result = testDB.Exec(nil, utils.ToCmdLine("ZUNIONSTORE", "dest", "1", "z1", "WITHSCORES"))
+	assert.AssertErrReply(t, result, "Err syntax error")
+	result = testDB.Exec(nil, utils.ToCmdLine("ZINTERCARD", "1", "z1", "LIMIT", "-1"))
+	assert.AssertErrReply(t, result, "ERR LIMIT can't be negative")
+}
+
+func TestZUnionStoreUndo(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("ZADD", "z1", "1", "a"))
+	testDB.Exec(nil, utils.ToCmdLine("ZADD", "dest", "5", "x"))
+	undoCmdLines := undoZSetCalcStore(t, "ZUNIONSTORE", "dest", "1", "z1")
+	for _, cmdLine := range undoCmdLines {
+		testDB.Exec(nil, cmdLine)
+	}
+	result := testDB.Exec(nil, utils.ToCmdLine("ZRANGE", "dest", "0", "-1", "WITHSCORES"))
+	assert.AssertMultiBulkReply(t, result, []string{"x", "5"})
+}
+
+func undoZSetCalcStore(t *testing.T, args ...string) []CmdLine {
+	cmdLine := utils.ToCmdLine(args...)
+	write, read := keyspec.WriteFirstReadNumKeys(cmdLine[1:])
+	if len(write) != 1 || write[0] != "dest" || len(read) != len(cmdLine)-3 {
+		t.Errorf("wrong keys %v %v", write, read)
+	}
+	undoCmdLines := rollbackFirstKey(testDB, cmdLine[1:])
+	testDB.Exec(nil, cmdLine)
+	return undoCmdLines
+}
@@ -2,11 +2,13 @@ package database
 
 import (
 	"math/rand"
+	"myredis/interface/myredis"
 	"myredis/lib/utils"
 	"myredis/protocol"
 	"myredis/protocol/assert"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestZAdd(t *testing.T) {
@@ -807,3 +809,169 @@ func TestZScan(t *testing.T) {
 		}
 	}
 }
+
+func TestZAddOptions(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	result := testDB.Exec(nil, utils.ToCmdLine("ZAdd", key, "1", "a", "2", "b"))
+	assert.AssertIntReply(t, result, 2)
+
+	result = testDB.Exec(nil, utils.ToCmdLine("ZAdd", key, "NX", "10", "a", "3", "c"))
+	assert.AssertIntReply(t, result, 1)
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("ZScore", key, "a")), "1")
+	result = testDB.Exec(nil, utils.ToCmdLine("ZAdd", key, "XX", "CH", "10", "a", "4", "d"))
+	assert.AssertIntReply(t, result, 1)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("ZCard", key)), 3)
+
+	result = testDB.Exec(nil, utils.ToCmdLine("ZAdd", key, "GT", "CH", "5", "a", "5", "b"))
+	assert.AssertIntReply(t, result, 1)
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("ZScore", key, "a")), "10")
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("ZScore", key, "b")), "5")
+	result = testDB.Exec(nil, utils.ToCmdLine("ZAdd", key, "LT", "CH", "1", "a", "6", "b", "0", "e"))
+	assert.AssertIntReply(t, result, 2)
+
+	result = testDB.Exec(nil, utils.ToCmdLine("ZAdd", key, "INCR", "2.5", "a"))
+	assert.AssertBulkReply(t, result, "3.5")
+	result = testDB.Exec(nil, utils.ToCmdLine("ZAdd", key, "NX", "INCR", "1", "a"))
+	assert.AssertNullBulk(t, result)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZAdd", key+"new", "XX", "1", "a"))
+	assert.AssertIntReply(t, result, 0)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("Exists", key+"new")), 0)
+
+	result = testDB.Exec(nil, utils.ToCmdLine("ZAdd", key, "NX", "XX", "1", "a"))
+	assert.AssertErrReply(t, result, "ERR XX and NX options at the same time are not compatible")
+	result = testDB.Exec(nil, utils.ToCmdLine("ZAdd", key, "GT", "LT", "1", "a"))
+	assert.AssertErrReply(t, result, "ERR GT, LT, and/or NX options at the same time are not compatible")
+	result = testDB.Exec(nil, utils.ToCmdLine("ZAdd", key, "INCR", "1", "a", "2", "b"))
+	assert.AssertErrReply(t, result, "ERR INCR option supports a single increment-element pair")
+	result = testDB.Exec(nil, utils.ToCmdLine("ZAdd", key, "CH", "1"))
+	assert.AssertErrReply(t, result, "Err syntax error")
+}
+
+func TestZAddUndo(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("ZAdd", key, "1", "a"))
+	args := utils.ToCmdLine(key, "XX", "CH", "5", "a")
+	undoCmdLines := undoZAdd(testDB, args)
+	testDB.Exec(nil, utils.ToCmdLine3("ZAdd", args...))
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("ZScore", key, "a")), "5")
+	for _, cmdLine := range undoCmdLines {
+		testDB.Exec(nil, cmdLine)
+	}
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("ZScore", key, "a")), "1")
+}
+
+func TestZPopMax(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("ZAdd", key, "1", "a", "2", "b", "3", "c"))
+	result := testDB.Exec(nil, utils.ToCmdLine("ZPopMax", key, "2"))
+	assert.AssertMultiBulkReply(t, result, []string{"c", "3", "b", "2"})
+	result = testDB.Exec(nil, utils.ToCmdLine("ZPopMax", key, "-1"))
+	assert.AssertErrReply(t, result, "ERR value is out of range, must be positive")
+	result = testDB.Exec(nil, utils.ToCmdLine("ZPopMax", key))
+	assert.AssertMultiBulkReply(t, result, []string{"a", "1"})
+	// 集合为空后删除键
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("Exists", key)), 0)
+}
+
+func TestZMPop(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("ZAdd", "z2", "1", "a", "2", "b", "3", "c"))
+	result := testDB.Exec(nil, utils.ToCmdLine("ZMPop", "2", "z1", "z2", "MAX", "COUNT", "2"))
+	expected := "*2\r\n$2\r\nz2\r\n*2\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n"
+	if string(result.ToBytes()) != expected {
+		t.Errorf("wrong zmpop result %q", result.ToBytes())
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("ZMPop", "1", "z1", "MIN"))
+	assert.AssertNullMultiBulk(t, result)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZMPop", "1", "z2", "MIN", "COUNT", "0"))
+	assert.AssertErrReply(t, result, "ERR count should be greater than 0")
+	result = testDB.Exec(nil, utils.ToCmdLine("ZMPop", "1", "z2", "LEFT"))
+	assert.AssertErrReply(t, result, "Err syntax error")
+}
+
+func TestBZPop(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("ZAdd", "z2", "1", "a", "2", "b"))
+	result := testDB.Exec(nil, utils.ToCmdLine("BZPopMin", "z1", "z2", "0"))
+	assert.AssertMultiBulkReply(t, result, []string{"z2", "a", "1"})
+	result = testDB.Exec(nil, utils.ToCmdLine("BZPopMax", "z1", "z2", "0"))
+	assert.AssertMultiBulkReply(t, result, []string{"z2", "b", "2"})
+
+	// 超时后返回空数组
+	start := time.Now()
+	result = testDB.Exec(nil, utils.ToCmdLine("BZPopMin", "z1", "0.1"))
+	assert.AssertNullMultiBulk(t, result)
+	if time.Since(start) < 100*time.Millisecond {
+		t.Error("bzpopmin should wait until timeout")
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("BZPopMin", "z1", "-1"))
+	assert.AssertErrReply(t, result, "ERR timeout is negative")
+
+	// 写入后唤醒等待的命令
+	done := make(chan myredis.Reply)
+	go func() {
+		done <- testDB.Exec(nil, utils.ToCmdLine("BZPopMax", "z1", "z3", "0"))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	testDB.Exec(nil, utils.ToCmdLine("ZAdd", "z3", "5", "x"))
+	select {
+	case result = <-done:
+		assert.AssertMultiBulkReply(t, result, []string{"z3", "x", "5"})
+	case <-time.After(time.Second):
+		t.Fatal("bzpopmax is not woken up")
+	}
+}
+
+func TestZRandMemberAndMScore(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("ZAdd", key, "1", "a", "2", "b", "3", "c"))
+	result := testDB.Exec(nil, utils.ToCmdLine("ZRandMember", key))
+	assert.AssertNotError(t, result)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRandMember", key, "5", "WITHSCORES"))
+	assert.AssertMultiBulkReplySize(t, result, 6)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRandMember", key, "-5"))
+	assert.AssertMultiBulkReplySize(t, result, 5)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRandMember", key+"1", "2"))
+	assert.AssertMultiBulkReplySize(t, result, 0)
+	assert.AssertNullBulk(t, testDB.Exec(nil, utils.ToCmdLine("ZRandMember", key+"1")))
+
+	result = testDB.Exec(nil, utils.ToCmdLine("ZMScore", key, "a", "x", "c"))
+	if string(result.ToBytes()) != "*3\r\n$1\r\n1\r\n$-1\r\n$1\r\n3\r\n" {
+		t.Errorf("wrong zmscore result %q", result.ToBytes())
+	}
+}
+
+func TestZRangeOptions(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("ZAdd", key, "1", "a", "2", "b", "3", "c", "4", "d"))
+	result := testDB.Exec(nil, utils.ToCmdLine("ZRange", key, "0", "1", "REV", "WITHSCORES"))
+	assert.AssertMultiBulkReply(t, result, []string{"d", "4", "c", "3"})
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRange", key, "(1", "+inf", "BYSCORE", "LIMIT", "1", "2"))
+	assert.AssertMultiBulkReply(t, result, []string{"c", "d"})
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRange", key, "3", "-inf", "BYSCORE", "REV"))
+	assert.AssertMultiBulkReply(t, result, []string{"c", "b", "a"})
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRange", key, "[b", "(d", "BYLEX"))
+	assert.AssertMultiBulkReply(t, result, []string{"b", "c"})
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRange", key, "+", "-", "BYLEX", "REV", "LIMIT", "0", "1"))
+	assert.AssertMultiBulkReply(t, result, []string{"d"})
+
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRange", key, "0", "1", "LIMIT", "0", "1"))
+	assert.AssertErrReply(t, result, "ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRange", key, "-", "+", "BYLEX", "WITHSCORES"))
+	assert.AssertErrReply(t, result, "ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRangeStore", "dst", key, "2", "3", "BYSCORE"))
+	assert.AssertIntReply(t, result, 2)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRange", "dst", "0", "-1", "WITHSCORES"))
+	assert.AssertMultiBulkReply(t, result, []string{"b", "2", "c", "3"})
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRangeStore", "dst", key, "10", "20"))
+	assert.AssertIntReply(t, result, 0)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("Exists", "dst")), 0)
+	result = testDB.Exec(nil, utils.ToCmdLine("ZRangeStore", "dst", key, "0", "1", "WITHSCORES"))
+	assert.AssertErrReply(t, result, "Err syntax error")
+}
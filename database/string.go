@@ -1,11 +1,14 @@
 package database
 
 import (
+	"math"
 	"math/bits"
 	"myredis/aof"
 	"myredis/datastruct/bitmap"
+	"myredis/datastruct/strobj"
 	"myredis/interface/database"
 	"myredis/interface/myredis"
+	"myredis/lib/keyspec"
 	"myredis/lib/utils"
 	"myredis/protocol"
 	"strconv"
@@ -13,8 +16,6 @@ import (
 	"time"
 )
 
-const unlimitedTTL int64 = 0
-
 /*
 upsertPolicy:
 
@@ -43,7 +44,7 @@ func (db *DB) getAsString(key string) ([]byte, protocol.ErrorReply) {
 	if !ok {
 		return nil, nil
 	}
-	bytes, ok := entity.Data.([]byte)
+	bytes, ok := strobj.Bytes(entity.Data)
 	if !ok {
 		return nil, &protocol.WrongTypeErrReply{}
 	}
@@ -68,156 +69,172 @@ func execGet(db *DB, args [][]byte) myredis.Reply {
 	return protocol.MakeBulkReply(bytes)
 }
 
+// 字符串命令的过期时间选项
+const (
+	expireNone    = iota // 没有指定过期时间选项
+	expireAtTime         // EX / PX / EXAT / PXAT
+	expireKeepTTL        // KEEPTTL，保留原有的过期时间
+	expirePersist        // PERSIST，移除过期时间
+)
+
+type stringExpire struct {
+	kind     int
+	expireAt time.Time
+}
+
+// 解析 args[i] 处的过期时间选项，返回消耗的参数个数，args[i] 不是过期时间选项时返回 0
+//
+// 相对时间与绝对时间都会转换为绝对时间，多个过期时间选项同时出现时返回语法错误
+func parseStringExpire(cmdName string, args [][]byte, i int, exp *stringExpire, allowKeepTTL, allowPersist bool) (int, protocol.ErrorReply) {
+	opt := strings.ToUpper(string(args[i]))
+	switch opt {
+	case "KEEPTTL", "PERSIST":
+		if (opt == "KEEPTTL" && !allowKeepTTL) || (opt == "PERSIST" && !allowPersist) {
+			return 0, nil
+		}
+		if exp.kind != expireNone {
+			return 0, &protocol.SyntaxErrReply{}
+		}
+		exp.kind = expireKeepTTL
+		if opt == "PERSIST" {
+			exp.kind = expirePersist
+		}
+		return 1, nil
+	case "EX", "PX", "EXAT", "PXAT":
+	default:
+		return 0, nil
+	}
+	if exp.kind != expireNone || i+1 >= len(args) {
+		return 0, &protocol.SyntaxErrReply{}
+	}
+	ttlArg, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+	if err != nil {
+		return 0, &protocol.SyntaxErrReply{}
+	}
+	invalidErr := protocol.MakeErrReply("ERR invalid expire time in " + cmdName)
+	if ttlArg <= 0 {
+		return 0, invalidErr
+	}
+	// 统一换算为毫秒时间戳，并检查是否溢出
+	now := time.Now().UnixMilli()
+	var expireAt int64
+	switch opt {
+	case "EX":
+		if ttlArg > (math.MaxInt64-now)/1000 {
+			return 0, invalidErr
+		}
+		expireAt = now + ttlArg*1000
+	case "PX":
+		if ttlArg > math.MaxInt64-now {
+			return 0, invalidErr
+		}
+		expireAt = now + ttlArg
+	case "EXAT":
+		if ttlArg > math.MaxInt64/1000 {
+			return 0, invalidErr
+		}
+		expireAt = ttlArg * 1000
+	case "PXAT":
+		expireAt = ttlArg
+	}
+	exp.kind = expireAtTime
+	exp.expireAt = time.UnixMilli(expireAt)
+	return 2, nil
+}
+
+// 按过期时间选项更新 key 的过期时间，并记录 AOF，AOF 中总是使用绝对时间
+func (db *DB) applyStringExpire(key string, exp *stringExpire) {
+	switch exp.kind {
+	case expireAtTime:
+		db.Expire(key, exp.expireAt)
+		db.addAof(aof.MakeExpiredCmd(key, exp.expireAt).Args)
+	case expirePersist:
+		db.Persist(key)
+		db.addAof(utils.ToCmdLine("persist", key))
+	}
+}
+
 // GETEX 命令，获取 key 的字符串值，同时设置新的过期时间或移除过期时间 (PERSIST)
+// eg: GETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | PERSIST]
 func execGetEX(db *DB, args [][]byte) myredis.Reply {
 	key := string(args[0])
+	var exp stringExpire
+	for i := 1; i < len(args); {
+		n, errReply := parseStringExpire("getex", args, i, &exp, false, true)
+		if errReply != nil {
+			return errReply
+		}
+		if n == 0 {
+			return &protocol.SyntaxErrReply{}
+		}
+		i += n
+	}
 	bytes, err := db.getAsString(key)
-	ttl := unlimitedTTL
 	if err != nil {
 		return err
 	}
 	if bytes == nil {
 		return &protocol.NullBulkReply{}
 	}
-	for i := 1; i < len(args); i++ {
-		arg := string(args[i])
-		// 单位为 seconds
-		if arg == "EX" {
-			// 如果 ttl 已经被设置过
-			if ttl != unlimitedTTL {
-				return &protocol.SyntaxErrReply{}
-			}
-			// 参数数量不够
-			if i+1 >= len(args) {
-				return &protocol.SyntaxErrReply{}
-			}
-			// int64类型
-			ttlArg, err := strconv.ParseInt(string(args[i+1]), 10, 64)
-			if err != nil {
-				return &protocol.SyntaxErrReply{}
-			}
-			// ttl 不能小于 0
-			if ttlArg <= 0 {
-				return protocol.MakeErrReply("ERR invalid expire time in getex")
-			}
-			ttl = ttlArg * 1000
-			i++
-		} else if arg == "PX" { // 单位为 milliseconds
-			if ttl != unlimitedTTL {
-				return &protocol.SyntaxErrReply{}
-			}
-			if i+1 >= len(args) {
-				return &protocol.SyntaxErrReply{}
-			}
-			ttlArg, err := strconv.ParseInt(string(args[i+1]), 10, 64)
-			if err != nil {
-				return &protocol.SyntaxErrReply{}
-			}
-			if ttlArg <= 0 {
-				return protocol.MakeErrReply("ERR invalid expire time in getex")
-			}
-			ttl = ttlArg
-			i++
-		} else if arg == "PERSIST" { // 持久化，移除过期时间
-			if ttl != unlimitedTTL { // PERSIST Cannot be used with EX | PX
-				return &protocol.SyntaxErrReply{}
-			}
-			if i+1 > len(args) {
-				return &protocol.SyntaxErrReply{}
-			}
-			// 移除过期时间
-			db.Persist(key)
-		}
-	}
-
-	// 如果设置了过期时间，加入过期操作
-	if len(args) > 1 {
-		if ttl != unlimitedTTL {
-			expireTime := time.Now().Add(time.Duration(ttl) * time.Millisecond)
-			db.Expire(key, expireTime)
-			db.addAof(aof.MakeExpiredCmd(key, expireTime).Args)
-			// DONE: Aof 持久化
-		} else {
-			// 如果不设置过期时间，移除可能的过期时间
-			db.Persist(key)
-			// 记录对应执行的该命令
-			db.addAof(utils.ToCmdLine3("persist", args[0]))
-		}
-	}
+	db.applyStringExpire(key, &exp)
 	return protocol.MakeBulkReply(bytes)
 }
 
 // ******************** SET Functions ********************
 
 // SET 命令，设置 key 的 string 值
+// eg: SET key value [NX | XX] [GET] [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
 func execSet(db *DB, args [][]byte) myredis.Reply {
 	key := string(args[0])
 	value := args[1]
-	ttl := unlimitedTTL
 	policy := upsertPolicy
-
-	if len(args) > 2 {
-		for i := 2; i < len(args); i++ {
-			arg := strings.ToUpper(string(args[i]))
-			// 插入策略
-			if arg == "NX" {
-				if policy == updatePolicy {
-					return &protocol.SyntaxErrReply{}
-				}
-				policy = insertPolicy
-				// 更新策略
-			} else if arg == "XX" {
-				if policy == insertPolicy {
-					return &protocol.SyntaxErrReply{}
-				}
-				policy = updatePolicy
-				// 超时设置（秒）
-			} else if arg == "EX" {
-				// ttl 被更新了
-				if ttl != unlimitedTTL {
-					return &protocol.SyntaxErrReply{}
-				}
-				// 没设置超时时长
-				if i+1 >= len(args) {
-					return &protocol.SyntaxErrReply{}
-				}
-				ttlArg, err := strconv.ParseInt(string(args[i+1]), 10, 64)
-				if err != nil {
-					return &protocol.SyntaxErrReply{}
-				}
-				// 超时时长不能为负数
-				if ttlArg <= 0 {
-					return protocol.MakeErrReply("ERR invalid expire time in set")
-				}
-				ttl = ttlArg * 1000
-				i++
-				// 超时设置（毫秒）
-			} else if arg == "PX" {
-				if ttl != unlimitedTTL {
-					return &protocol.SyntaxErrReply{}
-				}
-				if i+1 >= len(args) {
-					return &protocol.SyntaxErrReply{}
-				}
-				ttlArg, err := strconv.ParseInt(string(args[i+1]), 10, 64)
-				if err != nil {
-					return &protocol.SyntaxErrReply{}
-				}
-				if ttlArg <= 0 {
-					return protocol.MakeErrReply("ERR invalid expire time in set")
-				}
-				ttl = ttlArg
-				i++
-				// 未知命令
-			} else {
+	returnOld := false
+	var exp stringExpire
+
+	for i := 2; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		// 插入策略
+		if arg == "NX" {
+			if policy == updatePolicy {
+				return &protocol.SyntaxErrReply{}
+			}
+			policy = insertPolicy
+			// 更新策略
+		} else if arg == "XX" {
+			if policy == insertPolicy {
+				return &protocol.SyntaxErrReply{}
+			}
+			policy = updatePolicy
+			// 返回旧值
+		} else if arg == "GET" {
+			returnOld = true
+		} else {
+			n, errReply := parseStringExpire("set", args, i, &exp, true, false)
+			if errReply != nil {
+				return errReply
+			}
+			// 未知选项
+			if n == 0 {
 				return &protocol.SyntaxErrReply{}
 			}
+			i += n - 1
 		}
 	}
 
+	// 先删除已经过期的 key，避免影响 NX/XX 的判断以及 KEEPTTL 保留过期时间
+	db.IsExpired(key)
+	var old []byte
+	if returnOld {
+		bytes, errReply := db.getAsString(key)
+		if errReply != nil {
+			return errReply
+		}
+		old = bytes
+	}
+
 	// 键值的实体存储
 	entity := &database.DataEntity{
-		Data: value,
+		Data: strobj.Make(value),
 	}
 
 	// 根据不同的更新策略更新内存数据库
@@ -227,22 +244,26 @@ func execSet(db *DB, args [][]byte) myredis.Reply {
 		db.PutEntity(key, entity)
 		res = 1
 	case insertPolicy:
-		res = db.data.PutIfAbsentWithLock(key, entity)
+		res = db.PutIfAbsent(key, entity)
 	case updatePolicy:
-		res = db.data.PutIfExistsWithLock(key, entity)
+		res = db.PutIfExists(key, entity)
 	}
 	if res > 0 {
-		// 设置了过时时间
-		if ttl != unlimitedTTL {
-			expireTime := time.Now().Add(time.Duration(ttl) * time.Millisecond)
-			db.Expire(key, expireTime)
-			db.addAof(utils.ToCmdLine3("set", args[0], args[1]))
-			db.addAof(aof.MakeExpiredCmd(key, expireTime).Args)
-			// TODO: 持久化操作
+		if exp.kind == expireKeepTTL {
+			db.addAof(utils.ToCmdLine3("set", args[0], args[1], []byte("KEEPTTL")))
 		} else {
+			db.addAof(utils.ToCmdLine3("set", args[0], args[1]))
+		}
+		if exp.kind == expireNone {
 			db.Persist(key)
-			db.addAof(utils.ToCmdLine3("set", args...))
 		}
+		db.applyStringExpire(key, &exp)
+	}
+	if returnOld {
+		if old == nil {
+			return &protocol.NullBulkReply{}
+		}
+		return protocol.MakeBulkReply(old)
 	}
 	// 操作成功
 	if res > 0 {
@@ -257,9 +278,9 @@ func execSetNX(db *DB, args [][]byte) myredis.Reply {
 	key := string(args[0])
 	value := args[1]
 	entity := &database.DataEntity{
-		Data: value,
+		Data: strobj.Make(value),
 	}
-	res := db.data.PutIfAbsentWithLock(key, entity)
+	res := db.PutIfAbsent(key, entity)
 	db.addAof(utils.ToCmdLine3("setnx", args...))
 	return protocol.MakeIntReply(int64(res))
 }
@@ -267,70 +288,42 @@ func execSetNX(db *DB, args [][]byte) myredis.Reply {
 // SETEX 命令，设置过时时间
 // eg : SETEX key seconds value
 func execSetEX(db *DB, args [][]byte) myredis.Reply {
-	key := string(args[0])
-	value := args[2]
-
-	ttlArg, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
-		return &protocol.SyntaxErrReply{}
-	}
-	if ttlArg <= 0 {
-		return protocol.MakeErrReply("ERR invalid expire time in setex")
-	}
-	ttl := ttlArg * 1000
-
-	entity := &database.DataEntity{
-		Data: value,
-	}
-
-	db.PutEntity(key, entity)
-	expireTime := time.Now().Add(time.Duration(ttl) * time.Millisecond)
-	db.Expire(key, expireTime)
-	db.addAof(utils.ToCmdLine3("setex", args...))
-	db.addAof(aof.MakeExpiredCmd(key, expireTime).Args)
-	return &protocol.OkReply{}
+	return db.setWithExpire("setex", args, "EX")
 }
 
 // 过期时间为 millisecond 单位
+// eg : PSETEX key milliseconds value
 func execPSetEX(db *DB, args [][]byte) myredis.Reply {
+	return db.setWithExpire("psetex", args, "PX")
+}
+
+// SETEX 与 PSETEX 的公共实现，unit 为对应的 SET 选项
+func (db *DB) setWithExpire(cmdName string, args [][]byte, unit string) myredis.Reply {
 	key := string(args[0])
 	value := args[2]
 
-	ttlArg, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
-		return &protocol.SyntaxErrReply{}
-	}
-	if ttlArg <= 0 {
-		return protocol.MakeErrReply("ERR invalid expire time in setex")
+	var exp stringExpire
+	_, errReply := parseStringExpire(cmdName, [][]byte{[]byte(unit), args[1]}, 0, &exp, false, false)
+	if errReply != nil {
+		return errReply
 	}
 
 	entity := &database.DataEntity{
-		Data: value,
+		Data: strobj.Make(value),
 	}
 
 	db.PutEntity(key, entity)
-	expireTime := time.Now().Add(time.Duration(ttlArg) * time.Millisecond)
-	db.addAof(utils.ToCmdLine3("setex", args...))
-	db.addAof(aof.MakeExpiredCmd(key, expireTime).Args)
+	db.addAof(utils.ToCmdLine3("set", args[0], value))
+	db.applyStringExpire(key, &exp)
 	return &protocol.OkReply{}
 }
 
 // ******************** MSET Functions ********************
 
-// 生成 MSET 所需的键值对
-func prepareMSet(args [][]byte) ([]string, []string) {
-	size := len(args) / 2
-	keys := make([]string, size)
-	for i := 0; i < size; i++ {
-		keys[i] = string(args[i*2])
-	}
-	return keys, nil
-}
-
 // 撤销 MSet 操作需要执行的命令
 func undoMSet(db *DB, args [][]byte) []CmdLine {
 	// 只需要撤回写键
-	writekeys, _ := prepareMSet(args)
+	writekeys, _ := keyspec.MSet(args)
 	return rollbackGivenKeys(db, writekeys...)
 }
 
@@ -352,21 +345,12 @@ func execMSet(db *DB, args [][]byte) myredis.Reply {
 
 	for i, key := range keys {
 		value := values[i]
-		db.PutEntity(key, &database.DataEntity{Data: value})
+		db.PutEntity(key, &database.DataEntity{Data: strobj.Make(value)})
 	}
 	db.addAof(utils.ToCmdLine3("mset", args...))
 	return &protocol.OkReply{}
 }
 
-// 准备 MGET 命令的 readKeys
-func prepareMGet(args [][]byte) ([]string, []string) {
-	key := make([]string, len(args))
-	for i, k := range args {
-		key[i] = string(k)
-	}
-	return nil, key
-}
-
 // 执行 MGET 命令
 func execMGet(db *DB, args [][]byte) myredis.Reply {
 	keys := make([]string, len(args))
@@ -416,12 +400,90 @@ func execMSetNX(db *DB, args [][]byte) myredis.Reply {
 
 	for i, key := range keys {
 		value := values[i]
-		db.PutEntity(key, &database.DataEntity{Data: value})
+		db.PutEntity(key, &database.DataEntity{Data: strobj.Make(value)})
 	}
 	db.addAof(utils.ToCmdLine3("msetnx", args...))
 	return protocol.MakeIntReply(1)
 }
 
+// 解析 MSETEX 的 numkeys，返回键值对的个数
+func parseMSetEXNumKeys(args [][]byte) (int, protocol.ErrorReply) {
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil || numKeys <= 0 {
+		return 0, protocol.MakeErrReply("ERR invalid numkeys value")
+	}
+	if 1+numKeys*2 > len(args) {
+		return 0, protocol.MakeErrReply("ERR wrong number of key-value pairs")
+	}
+	return numKeys, nil
+}
+
+func undoMSetEX(db *DB, args [][]byte) []CmdLine {
+	writeKeys, _ := keyspec.MSetEX(args)
+	return rollbackGivenKeys(db, writeKeys...)
+}
+
+// MSETEX 命令，原子地设置多个键值对并设置相同的过期时间，全部设置时返回 1，没有设置时返回 0
+// eg: MSETEX numkeys key value [key value ...] [NX | XX] [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
+func execMSetEX(db *DB, args [][]byte) myredis.Reply {
+	numKeys, errReply := parseMSetEXNumKeys(args)
+	if errReply != nil {
+		return errReply
+	}
+	policy := upsertPolicy
+	var exp stringExpire
+	for i := 1 + numKeys*2; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		if arg == "NX" {
+			if policy == updatePolicy {
+				return &protocol.SyntaxErrReply{}
+			}
+			policy = insertPolicy
+		} else if arg == "XX" {
+			if policy == insertPolicy {
+				return &protocol.SyntaxErrReply{}
+			}
+			policy = updatePolicy
+		} else {
+			n, errReply := parseStringExpire("msetex", args, i, &exp, true, false)
+			if errReply != nil {
+				return errReply
+			}
+			if n == 0 {
+				return &protocol.SyntaxErrReply{}
+			}
+			i += n - 1
+		}
+	}
+
+	// NX 要求所有 key 都不存在，XX 要求所有 key 都存在
+	if policy != upsertPolicy {
+		for i := 0; i < numKeys; i++ {
+			_, exists := db.GetEntity(string(args[1+i*2]))
+			if exists != (policy == updatePolicy) {
+				return protocol.MakeIntReply(0)
+			}
+		}
+	}
+	for i := 0; i < numKeys; i++ {
+		key := string(args[1+i*2])
+		value := args[2+i*2]
+		// KEEPTTL 时先删除已过期的 key，避免保留已经失效的过期时间
+		db.IsExpired(key)
+		db.PutEntity(key, &database.DataEntity{Data: strobj.Make(value)})
+		if exp.kind == expireKeepTTL {
+			db.addAof(utils.ToCmdLine3("set", args[1+i*2], value, []byte("KEEPTTL")))
+			continue
+		}
+		db.addAof(utils.ToCmdLine3("set", args[1+i*2], value))
+		if exp.kind == expireNone {
+			db.Persist(key)
+		}
+		db.applyStringExpire(key, &exp)
+	}
+	return protocol.MakeIntReply(1)
+}
+
 // 设置新值，并返回旧值
 func execGetSet(db *DB, args [][]byte) myredis.Reply {
 	key := string(args[0])
@@ -433,7 +495,7 @@ func execGetSet(db *DB, args [][]byte) myredis.Reply {
 		return err
 	}
 
-	db.PutEntity(key, &database.DataEntity{Data: value})
+	db.PutEntity(key, &database.DataEntity{Data: strobj.Make(value)})
 	db.Persist(key)
 	db.addAof(utils.ToCmdLine3("set", args...))
 	// 如果旧值不存在，返回 null 回复
@@ -461,59 +523,53 @@ func execGetDel(db *DB, args [][]byte) myredis.Reply {
 	return protocol.MakeBulkReply(old)
 }
 
+// 将 key 对应的整数增加 delta 并返回结果，key 不存在时视为 0
+func (db *DB) incrBy(key string, delta int64) (int64, protocol.ErrorReply) {
+	var val int64
+	if entity, exists := db.GetEntity(key); exists {
+		if i, ok := strobj.Int(entity.Data); ok {
+			// 整数编码，不需要解析
+			val = i
+		} else if bytes, ok := entity.Data.([]byte); ok {
+			parsed, err := strconv.ParseInt(string(bytes), 10, 64)
+			if err != nil {
+				return 0, protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			val = parsed
+		} else {
+			return 0, &protocol.WrongTypeErrReply{}
+		}
+	}
+	if (delta > 0 && val > math.MaxInt64-delta) || (delta < 0 && val < math.MinInt64-delta) {
+		return 0, protocol.MakeErrReply("ERR increment or decrement would overflow")
+	}
+	val += delta
+	db.PutEntity(key, &database.DataEntity{Data: strobj.MakeInt(val)})
+	return val, nil
+}
+
 // 将 key 对应的值自增
 func execIncr(db *DB, args [][]byte) myredis.Reply {
-	key := string(args[0])
-
-	// 先获取值，再自增
-	val_bytes, err := db.getAsString(key)
+	val, err := db.incrBy(string(args[0]), 1)
 	if err != nil {
 		return err
 	}
-	if val_bytes != nil {
-		val, err := strconv.ParseInt(string(val_bytes), 10, 64)
-		if err != nil {
-			return protocol.MakeErrReply("ERR value is not an integer or out of range")
-		}
-		db.PutEntity(key, &database.DataEntity{Data: []byte(strconv.FormatInt(val+1, 10))})
-		db.addAof(utils.ToCmdLine3("incr", args...))
-		return protocol.MakeIntReply(val + 1)
-	}
-	// 如果值不存在，那么设置为 1
-	db.PutEntity(key, &database.DataEntity{Data: []byte("1")})
 	db.addAof(utils.ToCmdLine3("incr", args...))
-	return protocol.MakeIntReply(1)
+	return protocol.MakeIntReply(val)
 }
 
 // 将 key 对应的值增加 value
 func execIncrBy(db *DB, args [][]byte) myredis.Reply {
-	key := string(args[0])
-	rawData := string(args[1])
-	data, err := strconv.ParseInt(rawData, 10, 64)
+	data, err := strconv.ParseInt(string(args[1]), 10, 64)
 	if err != nil {
 		return protocol.MakeErrReply("ERR value is not an integer or out of range")
 	}
-
-	val_bytes, errReply := db.getAsString(key)
+	val, errReply := db.incrBy(string(args[0]), data)
 	if errReply != nil {
 		return errReply
 	}
-	// 存在对应的值，按 value 自增
-	if val_bytes != nil {
-		val, err := strconv.ParseInt(string(val_bytes), 10, 64)
-		// 数字解析错误
-		if err != nil {
-			return protocol.MakeErrReply("ERR value is not an integer or out of range")
-		}
-		db.PutEntity(key, &database.DataEntity{Data: []byte(strconv.FormatInt(val+data, 10))})
-		db.addAof(utils.ToCmdLine3("incrby", args...))
-		return protocol.MakeIntReply(val + data)
-	}
-
-	// 如果值不存在，那么设置为 value
-	db.PutEntity(key, &database.DataEntity{Data: args[1]})
 	db.addAof(utils.ToCmdLine3("incrby", args...))
-	return protocol.MakeIntReply(data)
+	return protocol.MakeIntReply(val)
 }
 
 // 将 key 对应的值增加 value （Float 类型）
@@ -546,53 +602,30 @@ func execIncrByFloat(db *DB, args [][]byte) myredis.Reply {
 
 // 将 key 对应的值自减
 func execDecr(db *DB, args [][]byte) myredis.Reply {
-	key := string(args[0])
-	val_bytes, err := db.getAsString(key)
+	val, err := db.incrBy(string(args[0]), -1)
 	if err != nil {
 		return err
 	}
-	if val_bytes != nil {
-		val, err := strconv.ParseInt(string(val_bytes), 10, 64)
-		if err != nil {
-			return protocol.MakeErrReply("ERR value is not an integer or out of range")
-		}
-		db.PutEntity(key, &database.DataEntity{Data: []byte(strconv.FormatInt(val-1, 10))})
-		db.addAof(utils.ToCmdLine3("decr", args...))
-		return protocol.MakeIntReply(val - 1)
-	}
-	db.PutEntity(key, &database.DataEntity{Data: []byte("-1")})
 	db.addAof(utils.ToCmdLine3("decr", args...))
-	return protocol.MakeIntReply(-1)
+	return protocol.MakeIntReply(val)
 }
 
 // 将 key 对应的值自减少 value
 func execDecrBy(db *DB, args [][]byte) myredis.Reply {
-	key := string(args[0])
-	rawData := string(args[1])
-	data, err := strconv.ParseInt(rawData, 10, 64)
+	data, err := strconv.ParseInt(string(args[1]), 10, 64)
 	if err != nil {
 		return protocol.MakeErrReply("ERR value is not an integer or out of range")
 	}
-
-	val_bytes, errReply := db.getAsString(key)
+	// -data 会溢出
+	if data == math.MinInt64 {
+		return protocol.MakeErrReply("ERR decrement would overflow")
+	}
+	val, errReply := db.incrBy(string(args[0]), -data)
 	if errReply != nil {
 		return errReply
 	}
-	// 如果 key 的值存在
-	if val_bytes != nil {
-		val, err := strconv.ParseInt(string(val_bytes), 10, 64)
-		if err != nil {
-			return protocol.MakeErrReply("ERR value is not an integer or out of range")
-		}
-		db.PutEntity(key, &database.DataEntity{Data: []byte(strconv.FormatInt(val-data, 10))})
-		db.addAof(utils.ToCmdLine3("decrby", args...))
-		return protocol.MakeIntReply(val - data)
-	}
-	// key 的值不存在
-	valueStr := strconv.FormatInt(-data, 10)
-	db.PutEntity(key, &database.DataEntity{Data: []byte(valueStr)})
 	db.addAof(utils.ToCmdLine3("decrby", args...))
-	return protocol.MakeIntReply(-data)
+	return protocol.MakeIntReply(val)
 }
 
 // 返回 value 的字符串值的字节长度
@@ -741,10 +774,13 @@ func execGetBit(db *DB, args [][]byte) myredis.Reply {
 
 /*
 对某个 bitmap 类型键 的指定范围内的 1 bit 数量进行统计 ，并返回结果，和
-bit 模式：范围参数按字节索引计算
-byte 模式：范围参数按比特位索引计算
+byte 模式：范围参数按字节索引计算
+bit 模式：范围参数按比特位索引计算
 */
 func execBitCount(db *DB, args [][]byte) myredis.Reply {
+	if len(args) == 2 || len(args) > 4 {
+		return &protocol.SyntaxErrReply{}
+	}
 	key := string(args[0])
 	// 获取 value
 	bytes, errReply := db.getAsString(key)
@@ -776,17 +812,17 @@ func execBitCount(db *DB, args [][]byte) myredis.Reply {
 		size = int64(bitmaps.Bitsize())
 	}
 
-	// BITCOUNT key [start end]
+	// BITCOUNT key [start end [BIT|BYTE]]
 	var begin, end int
 	if len(args) > 1 {
 		var err2 error
 		var startIndex, endIndex int64
 		// 解析 start 和 end
-		startIndex, err2 = strconv.ParseInt(string(args[2]), 10, 64)
+		startIndex, err2 = strconv.ParseInt(string(args[1]), 10, 64)
 		if err2 != nil {
 			return protocol.MakeErrReply("ERR value is not an integer or out of range")
 		}
-		endIndex, err2 = strconv.ParseInt(string(args[3]), 10, 64)
+		endIndex, err2 = strconv.ParseInt(string(args[2]), 10, 64)
 		if err2 != nil {
 			return protocol.MakeErrReply("ERR value is not an integer or out of range")
 		}
@@ -816,25 +852,31 @@ func execBitCount(db *DB, args [][]byte) myredis.Reply {
 
 // 查找某个 bitmap 键中 ，指定范围内第一个出现 0 或 1 的 bit 位的位置索引
 func execBitPos(db *DB, args [][]byte) myredis.Reply {
-	// BITPOS key bit [start] [end] [BIT|BYTE]
-
+	// BITPOS key bit [start [end [BIT|BYTE]]]
+	if len(args) > 5 {
+		return &protocol.SyntaxErrReply{}
+	}
 	key := string(args[0])
+	// 待查找的 0 / 1
+	var v byte
+	switch string(args[1]) {
+	case "1":
+		v = 1
+	case "0":
+		v = 0
+	default:
+		return protocol.MakeErrReply("ERR The bit argument must be 1 or 0.")
+	}
 	bytes, errReply := db.getAsString(key)
 	if errReply != nil {
 		return errReply
 	}
+	// 不存在的 key 视为全 0 的字符串
 	if bytes == nil {
-		return protocol.MakeIntReply(-1)
-	}
-	valStr := string(args[2])
-	// 待查找的 0 / 1
-	var v byte
-	if valStr == "1" {
-		v = 1
-	} else if valStr == "0" {
-		v = 0
-	} else {
-		return protocol.MakeErrReply("ERR bit is not an integer ot out of range")
+		if v == 1 {
+			return protocol.MakeIntReply(-1)
+		}
+		return protocol.MakeIntReply(0)
 	}
 
 	byteMode := true
@@ -855,22 +897,23 @@ func execBitPos(db *DB, args [][]byte) myredis.Reply {
 	} else {
 		size = int64(bitmaps.Bitsize())
 	}
-	var begin, end int
+	begin, end := 0, int(size)
+	endGiven := len(args) > 3
 	if len(args) > 2 {
-		var err2 error
-		var startIndex, endIndex int64
-
-		startIndex, err2 = strconv.ParseInt(string(args[2]), 10, 64)
-		if err2 != nil {
+		startIndex, err := strconv.ParseInt(string(args[2]), 10, 64)
+		if err != nil {
 			return protocol.MakeErrReply("ERR value is not an integer or out of range")
 		}
-		endIndex, err2 = strconv.ParseInt(string(args[3]), 10, 64)
-		if err2 != nil {
-			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		endIndex := int64(-1)
+		if endGiven {
+			endIndex, err = strconv.ParseInt(string(args[3]), 10, 64)
+			if err != nil {
+				return protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
 		}
 		begin, end = utils.ConvertRange(startIndex, endIndex, size)
 		if begin < 0 {
-			return protocol.MakeIntReply(0)
+			return protocol.MakeIntReply(-1)
 		}
 	}
 	if byteMode {
@@ -886,6 +929,10 @@ func execBitPos(db *DB, args [][]byte) myredis.Reply {
 		}
 		return true
 	})
+	// 与 Redis 一致，查找 0 且没有指定 end 时，字符串右侧视为无限个 0
+	if offset < 0 && v == 0 && !endGiven {
+		offset = int64(end)
+	}
 	return protocol.MakeIntReply(offset)
 }
 
@@ -899,59 +946,63 @@ func getRandomKey(db *DB, args [][]byte) myredis.Reply {
 }
 
 func init() {
-	registerCommand("Set", execSet, writeFirstKey, rollbackFirstKey, -3, flagWrite).
+	registerCommand("Set", execSet, rollbackFirstKey, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
-	registerCommand("SetNX", execSetNX, writeFirstKey, rollbackFirstKey, 3, flagWrite).
+	registerCommand("SetNX", execSetNX, rollbackFirstKey, 3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
-	registerCommand("SetEX", execSetEX, writeFirstKey, rollbackFirstKey, 4, flagWrite).
+	registerCommand("SetEX", execSetEX, rollbackFirstKey, 4, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
-	registerCommand("PSetEX", execPSetEX, writeFirstKey, rollbackFirstKey, 4, flagWrite).
+	registerCommand("PSetEX", execPSetEX, rollbackFirstKey, 4, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
-	registerCommand("MSet", execMSet, prepareMSet, undoMSet, -3, flagWrite).
+	registerCommand("MSet", execMSet, undoMSet, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, -1, 2)
-	registerCommand("MSetNX", execMSetNX, prepareMSet, undoMSet, -3, flagWrite).
+	registerCommand("MSetNX", execMSetNX, undoMSet, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	registerCommand("MSetEX", execMSetEX, undoMSetEX, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagMovableKeys}, 0, 0, 0)
 
-	registerCommand("Get", execGet, readFirstKey, nil, 2, flagReadOnly).
-		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("MGet", execMGet, prepareMGet, nil, -2, flagReadOnly).
+	registerCommand("Get", execGet, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("GetEX", execGetEX, readFirstKey, nil, -2, flagReadOnly).
+	registerCommand("MGet", execMGet, nil, -2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("GetSet", execGetSet, writeFirstKey, rollbackFirstKey, 3, flagWrite).
+	registerCommand("GetEX", execGetEX, rollbackFirstKey, -2, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
+	registerCommand("GetSet", execGetSet, rollbackFirstKey, 3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
-	registerCommand("GetDel", execGetDel, writeFirstKey, rollbackFirstKey, 2, flagWrite).
+	registerCommand("GetDel", execGetDel, rollbackFirstKey, 2, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
 
-	registerCommand("Incr", execIncr, writeFirstKey, rollbackFirstKey, 2, flagWrite).
+	registerCommand("Incr", execIncr, rollbackFirstKey, 2, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
-	registerCommand("IncrBy", execIncrBy, writeFirstKey, rollbackFirstKey, 3, flagWrite).
+	registerCommand("IncrBy", execIncrBy, rollbackFirstKey, 3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
-	registerCommand("IncrByFloat", execIncrByFloat, writeFirstKey, rollbackFirstKey, 3, flagWrite).
+	registerCommand("IncrByFloat", execIncrByFloat, rollbackFirstKey, 3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
-	registerCommand("Decr", execDecr, writeFirstKey, rollbackFirstKey, 2, flagWrite).
+	registerCommand("Decr", execDecr, rollbackFirstKey, 2, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
-	registerCommand("DecrBy", execDecrBy, writeFirstKey, rollbackFirstKey, 3, flagWrite).
+	registerCommand("DecrBy", execDecrBy, rollbackFirstKey, 3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
 
-	registerCommand("StrLen", execStrLen, readFirstKey, nil, 2, flagReadOnly).
+	registerCommand("StrLen", execStrLen, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("Append", execAppend, writeFirstKey, rollbackFirstKey, 3, flagWrite).
+	registerCommand("Append", execAppend, rollbackFirstKey, 3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
-	registerCommand("SetRange", execSetRange, writeFirstKey, rollbackFirstKey, 4, flagWrite).
+	registerCommand("SetRange", execSetRange, rollbackFirstKey, 4, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
-	registerCommand("GetRange", execGetRange, readFirstKey, nil, 4, flagReadOnly).
+	registerCommand("GetRange", execGetRange, nil, 4, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
+	registerCommand("SubStr", execGetRange, nil, 4, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
 
-	registerCommand("GetBit", execGetBit, readFirstKey, nil, 3, flagReadOnly).
+	registerCommand("GetBit", execGetBit, nil, 3, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("SetBit", execSetBit, writeFirstKey, rollbackFirstKey, 4, flagWrite).
+	registerCommand("SetBit", execSetBit, rollbackFirstKey, 4, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
-	registerCommand("BitCount", execBitCount, readFirstKey, nil, -2, flagReadOnly).
+	registerCommand("BitCount", execBitCount, nil, -2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
-	registerCommand("BitPos", execBitPos, readFirstKey, nil, -3, flagReadOnly).
+	registerCommand("BitPos", execBitPos, nil, -3, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
 
-	registerCommand("Randomkey", getRandomKey, readAllKeys, nil, 1, flagReadOnly).
+	registerCommand("Randomkey", getRandomKey, nil, 1, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom}, 1, 1, 1)
 }
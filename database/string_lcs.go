@@ -0,0 +1,151 @@
+package database
+
+import (
+	"myredis/interface/myredis"
+	"myredis/protocol"
+	"strconv"
+	"strings"
+)
+
+// lcsRange 公共子序列中连续匹配的一段，两个字符串中的区间都是闭区间
+type lcsRange struct {
+	aStart, aEnd int
+	bStart, bEnd int
+}
+
+func (r *lcsRange) length() int {
+	return r.aEnd - r.aStart + 1
+}
+
+// 计算 a 与 b 的最长公共子序列，返回子序列以及从后向前回溯得到的连续匹配区间
+func longestCommonSubsequence(a, b []byte) ([]byte, []*lcsRange) {
+	// table[i*(len(b)+1)+j] 为 a[:i] 与 b[:j] 的最长公共子序列长度
+	width := len(b) + 1
+	table := make([]uint32, (len(a)+1)*width)
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				table[i*width+j] = table[(i-1)*width+j-1] + 1
+			} else if table[(i-1)*width+j] > table[i*width+j-1] {
+				table[i*width+j] = table[(i-1)*width+j]
+			} else {
+				table[i*width+j] = table[i*width+j-1]
+			}
+		}
+	}
+
+	lcs := make([]byte, table[len(table)-1])
+	idx := len(lcs)
+	var ranges []*lcsRange
+	var current *lcsRange
+	for i, j := len(a), len(b); i > 0 && j > 0; {
+		if a[i-1] == b[j-1] {
+			idx--
+			lcs[idx] = a[i-1]
+			i--
+			j--
+			// 回溯时 i、j 同时减小，与当前区间相邻的匹配可以向前扩展区间
+			if current != nil && current.aStart == i+1 && current.bStart == j+1 {
+				current.aStart, current.bStart = i, j
+			} else {
+				if current != nil {
+					ranges = append(ranges, current)
+				}
+				current = &lcsRange{aStart: i, aEnd: i, bStart: j, bEnd: j}
+			}
+			continue
+		}
+		if table[(i-1)*width+j] > table[i*width+j-1] {
+			i--
+		} else {
+			j--
+		}
+		if current != nil {
+			ranges = append(ranges, current)
+			current = nil
+		}
+	}
+	if current != nil {
+		ranges = append(ranges, current)
+	}
+	return lcs, ranges
+}
+
+// 返回两个字符串的最长公共子序列，LEN 时只返回长度，IDX 时返回各段匹配的位置
+func execLCS(db *DB, args [][]byte) myredis.Reply {
+	var getLen, getIdx, withMatchLen bool
+	minMatchLen := 0
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "LEN":
+			getLen = true
+		case "IDX":
+			getIdx = true
+		case "WITHMATCHLEN":
+			withMatchLen = true
+		case "MINMATCHLEN":
+			if i+1 >= len(args) {
+				return &protocol.SyntaxErrReply{}
+			}
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if n > 0 {
+				minMatchLen = int(n)
+			}
+			i++
+		default:
+			return &protocol.SyntaxErrReply{}
+		}
+	}
+	if getLen && getIdx {
+		return protocol.MakeErrReply("ERR If you want both the length and indexes, please just use IDX.")
+	}
+
+	values := make([][]byte, 2)
+	for i := range values {
+		bytes, errReply := db.getAsString(string(args[i]))
+		if errReply != nil {
+			return protocol.MakeErrReply("ERR The specified keys must contain string values")
+		}
+		values[i] = bytes
+	}
+	lcs, ranges := longestCommonSubsequence(values[0], values[1])
+	if getLen {
+		return protocol.MakeIntReply(int64(len(lcs)))
+	}
+	if !getIdx {
+		return protocol.MakeBulkReply(lcs)
+	}
+
+	matches := make([]myredis.Reply, 0, len(ranges))
+	for _, r := range ranges {
+		if r.length() < minMatchLen {
+			continue
+		}
+		match := []myredis.Reply{
+			protocol.MakeMultiRawReply([]myredis.Reply{
+				protocol.MakeIntReply(int64(r.aStart)), protocol.MakeIntReply(int64(r.aEnd)),
+			}),
+			protocol.MakeMultiRawReply([]myredis.Reply{
+				protocol.MakeIntReply(int64(r.bStart)), protocol.MakeIntReply(int64(r.bEnd)),
+			}),
+		}
+		if withMatchLen {
+			match = append(match, protocol.MakeIntReply(int64(r.length())))
+		}
+		matches = append(matches, protocol.MakeMultiRawReply(match))
+	}
+	return protocol.MakeMultiRawReply([]myredis.Reply{
+		protocol.MakeBulkReply([]byte("matches")),
+		protocol.MakeMultiRawReply(matches),
+		protocol.MakeBulkReply([]byte("len")),
+		protocol.MakeIntReply(int64(len(lcs))),
+	})
+}
+
+func init() {
+	registerCommand("LCS", execLCS, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly}, 1, 2, 1)
+}
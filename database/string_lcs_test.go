@@ -0,0 +1,50 @@
+package database
+
+import (
+	"myredis/interface/myredis"
+	"myredis/lib/utils"
+	"myredis/protocol"
+	"myredis/protocol/assert"
+	"testing"
+)
+
+func TestLCS(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("mset", "key1", "ohmytext", "key2", "mynewtext"))
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("lcs", "key1", "key2")), "mytext")
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("lcs", "key1", "key2", "len")), 6)
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("lcs", "key1", "missing")), "")
+
+	pair := func(start, end int64) myredis.Reply {
+		return protocol.MakeMultiRawReply([]myredis.Reply{protocol.MakeIntReply(start), protocol.MakeIntReply(end)})
+	}
+	idxReply := func(matches ...myredis.Reply) []byte {
+		return protocol.MakeMultiRawReply([]myredis.Reply{
+			protocol.MakeBulkReply([]byte("matches")),
+			protocol.MakeMultiRawReply(matches),
+			protocol.MakeBulkReply([]byte("len")),
+			protocol.MakeIntReply(6),
+		}).ToBytes()
+	}
+	result := testDB.Exec(nil, utils.ToCmdLine("lcs", "key1", "key2", "idx"))
+	expected := idxReply(
+		protocol.MakeMultiRawReply([]myredis.Reply{pair(4, 7), pair(5, 8)}),
+		protocol.MakeMultiRawReply([]myredis.Reply{pair(2, 3), pair(0, 1)}),
+	)
+	if string(result.ToBytes()) != string(expected) {
+		t.Errorf("expected %q, actually %q", expected, result.ToBytes())
+	}
+	result = testDB.Exec(nil, utils.ToCmdLine("lcs", "key1", "key2", "idx", "minmatchlen", "4", "withmatchlen"))
+	expected = idxReply(
+		protocol.MakeMultiRawReply([]myredis.Reply{pair(4, 7), pair(5, 8), protocol.MakeIntReply(4)}),
+	)
+	if string(result.ToBytes()) != string(expected) {
+		t.Errorf("expected %q, actually %q", expected, result.ToBytes())
+	}
+
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("lcs", "key1", "key2", "len", "idx")),
+		"ERR If you want both the length and indexes, please just use IDX.")
+	testDB.Exec(nil, utils.ToCmdLine("rpush", "list", "a"))
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("lcs", "key1", "list")),
+		"ERR The specified keys must contain string values")
+}
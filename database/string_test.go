@@ -7,11 +7,12 @@ import (
 	"myredis/protocol/assert"
 	"strconv"
 	"testing"
+	"time"
 )
 
 var testDB = makeTestDB()
 
-// var testServer = NewStandaloneServer()
+var testServer = makeTestServer()
 
 func TestSetEmpty(t *testing.T) {
 	key := utils.RandString(10)
@@ -238,6 +239,30 @@ func TestIncr(t *testing.T) {
 	}
 }
 
+func TestIncrEncoding(t *testing.T) {
+	testDB.Flush()
+	testDB.Exec(nil, utils.ToCmdLine("SET", "counter", "99"))
+	testDB.Exec(nil, utils.ToCmdLine("INCR", "counter"))
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("OBJECT", "ENCODING", "counter")), "int")
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("STRLEN", "counter")), 3)
+
+	// APPEND 之后不再是整数编码
+	testDB.Exec(nil, utils.ToCmdLine("APPEND", "counter", "0"))
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("GET", "counter")), "1000")
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("OBJECT", "ENCODING", "counter")), "embstr")
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("INCRBY", "counter", "5")), 1005)
+
+	// 非规范格式的整数保存为字节
+	testDB.Exec(nil, utils.ToCmdLine("SET", "padded", "007"))
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("GET", "padded")), "007")
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("OBJECT", "ENCODING", "padded")), "embstr")
+
+	testDB.Exec(nil, utils.ToCmdLine("SET", "max", strconv.FormatInt(math.MaxInt64, 10)))
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("INCR", "max")), "ERR increment or decrement would overflow")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("DECRBY", "max", strconv.FormatInt(math.MinInt64, 10))), "ERR decrement would overflow")
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("GET", "max")), strconv.FormatInt(math.MaxInt64, 10))
+}
+
 func TestDecr(t *testing.T) {
 	testDB.Flush()
 	size := 10
@@ -394,3 +419,78 @@ func TestStrLen_KeyNotExist(t *testing.T) {
 
 	assert.AssertIntReply(t, result, 0)
 }
+
+func TestSetOptions(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	// GET 返回旧值
+	assert.AssertNullBulk(t, testDB.Exec(nil, utils.ToCmdLine("set", key, "a", "get")))
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("set", key, "b", "get")), "a")
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("set", key, "c", "nx", "get")), "b")
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("get", key)), "b")
+
+	// KEEPTTL 保留过期时间，否则清除
+	testDB.Exec(nil, utils.ToCmdLine("expire", key, "100"))
+	testDB.Exec(nil, utils.ToCmdLine("set", key, "d", "keepttl"))
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("ttl", key)), 100)
+	testDB.Exec(nil, utils.ToCmdLine("set", key, "e"))
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("ttl", key)), -1)
+
+	// 绝对过期时间
+	at := time.Now().Add(time.Hour).Unix()
+	testDB.Exec(nil, utils.ToCmdLine("set", key, "f", "exat", strconv.FormatInt(at, 10)))
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("expiretime", key)), int(at))
+	testDB.Exec(nil, utils.ToCmdLine("set", key, "g", "pxat", strconv.FormatInt(at*1000+500, 10)))
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("pexpiretime", key)), int(at*1000+500))
+
+	testDB.Exec(nil, utils.ToCmdLine("rpush", "list", "a"))
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("set", "list", "a", "get")),
+		"WRONGTYPE Operation against a key holding the wrong kind of value")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("set", key, "a", "ex", "10", "keepttl")), "Err syntax error")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("set", key, "a", "exat", "0")),
+		"ERR invalid expire time in set")
+}
+
+func TestGetEXAbsolute(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("set", key, "a"))
+	at := time.Now().Add(time.Hour).UnixMilli()
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("getex", key, "pxat", strconv.FormatInt(at, 10))), "a")
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("pexpiretime", key)), int(at))
+	testDB.Exec(nil, utils.ToCmdLine("getex", key, "persist"))
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("ttl", key)), -1)
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("getex", key, "ex", "10", "persist")), "Err syntax error")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("getex", key, "keepttl")), "Err syntax error")
+}
+
+func TestMSetEX(t *testing.T) {
+	testDB.Flush()
+	result := testDB.Exec(nil, utils.ToCmdLine("msetex", "2", "k1", "v1", "k2", "v2", "ex", "100"))
+	assert.AssertIntReply(t, result, 1)
+	assert.AssertMultiBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("mget", "k1", "k2")), []string{"v1", "v2"})
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("ttl", "k2")), 100)
+
+	// NX 要求所有 key 都不存在，XX 要求所有 key 都存在
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("msetex", "2", "k1", "x", "k3", "x", "nx")), 0)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("msetex", "2", "k1", "x", "k3", "x", "xx")), 0)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("exists", "k3")), 0)
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("msetex", "2", "k1", "x", "k2", "y", "xx", "keepttl")), 1)
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("get", "k2")), "y")
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("ttl", "k2")), 100)
+	testDB.Exec(nil, utils.ToCmdLine("msetex", "1", "k2", "z"))
+	assert.AssertIntReply(t, testDB.Exec(nil, utils.ToCmdLine("ttl", "k2")), -1)
+
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("msetex", "0", "k1", "v1")), "ERR invalid numkeys value")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("msetex", "2", "k1", "v1", "k2")),
+		"ERR wrong number of key-value pairs")
+	assert.AssertErrReply(t, testDB.Exec(nil, utils.ToCmdLine("msetex", "1", "k1", "v1", "ex")), "Err syntax error")
+}
+
+func TestSubStr(t *testing.T) {
+	testDB.Flush()
+	key := utils.RandString(10)
+	testDB.Exec(nil, utils.ToCmdLine("set", key, "This is a string"))
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("substr", key, "0", "3")), "This")
+	assert.AssertBulkReply(t, testDB.Exec(nil, utils.ToCmdLine("substr", key, "-3", "-1")), "ing")
+}
@@ -53,7 +53,7 @@ func Dbsize(c myredis.Connection, db *Server) myredis.Reply {
 
 func Info(db *Server, args [][]byte) myredis.Reply {
 	if len(args) == 0 {
-		infoCommandList := [...]string{"server", "client", "cluster", "keyspace"}
+		infoCommandList := [...]string{"server", "client", "memory", "persistence", "cluster", "keyspace"}
 		var allSection []byte
 		for _, infoCommand := range infoCommandList {
 			allSection = append(allSection, GenMydisInfoString(infoCommand, db)...)
@@ -68,6 +68,12 @@ func Info(db *Server, args [][]byte) myredis.Reply {
 		case "client":
 			reply := GenMydisInfoString("client", db)
 			return protocol.MakeBulkReply(reply)
+		case "memory":
+			reply := GenMydisInfoString("memory", db)
+			return protocol.MakeBulkReply(reply)
+		case "persistence":
+			reply := GenMydisInfoString("persistence", db)
+			return protocol.MakeBulkReply(reply)
 		case "cluster":
 			reply := GenMydisInfoString("cluster", db)
 			return protocol.MakeBulkReply(reply)
@@ -113,6 +119,16 @@ func GenMydisInfoString(section string, db *Server) []byte {
 	case "client":
 		str := fmt.Sprintf("# Clients\r\n")
 		return []byte(str)
+	case "memory":
+		str := fmt.Sprintf("# Memory\r\n"+
+			"lazyfree_pending_objects:%d\r\n"+
+			"lazyfreed_objects:%d\r\n",
+			lazyfreePending.Load(),
+			lazyfreedObjects.Load(),
+		)
+		return []byte(str)
+	case "persistence":
+		return genPersistenceInfo(db)
 	case "cluster":
 		if getMydisRunningMode() == config.ClusterMode {
 			str := fmt.Sprintf("# Cluster\r\n"+
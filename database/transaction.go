@@ -58,8 +58,11 @@ func (db *DB) ExecMulti(conn myredis.Connection, watching map[string]uint32, cmd
 		watchingKeys = append(watchingKeys, key)
 	}
 	readKeys = append(readKeys, watchingKeys...)
+	// 整个事务位于快照切点的同一侧
+	db.writeGate.RLock()
+	defer db.writeGate.RUnlock()
 	db.RWLocks(writeKeys, readKeys)
-	defer db.RWUnLocks(watchingKeys, readKeys)
+	defer db.RWUnLocks(writeKeys, readKeys)
 
 	// 乐观锁 检查监视的键是否改变
 	if isWatchingChanged(db, watching) {
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"myredis/config"
 	"myredis/datastruct/dict"
 )
 
@@ -9,6 +10,14 @@ func makeTestDB() *DB {
 		data:       dict.MakeConcurrent(dataDictSize),
 		versionMap: dict.MakeConcurrent(dataDictSize),
 		ttlMap:     dict.MakeConcurrent(ttlDictSize),
+		accessMap:  dict.MakeConcurrent(dataDictSize),
 		addAof:     func(line CmdLine) {},
 	}
 }
+
+func makeTestServer() *Server {
+	if config.Properties == nil {
+		config.Properties = &config.ServerProperties{Databases: 16}
+	}
+	return MakeAuxiliaryServer()
+}
@@ -0,0 +1,181 @@
+package bitmap
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+)
+
+// Overflow 整数字段溢出时的处理方式
+type Overflow int
+
+const (
+	OverflowWrap Overflow = iota // 回绕，保留低位
+	OverflowSat                  // 饱和，取最大值或最小值
+	OverflowFail                 // 放弃本次操作
+)
+
+// FieldType 位图中整数字段的类型，有符号字段宽度为 1~64，无符号字段宽度为 1~63
+type FieldType struct {
+	Signed bool
+	Width  int
+}
+
+// ParseFieldType 解析形如 i8、u16 的字段类型
+func ParseFieldType(s string) (FieldType, bool) {
+	if len(s) < 2 {
+		return FieldType{}, false
+	}
+	var t FieldType
+	switch s[0] {
+	case 'i', 'I':
+		t.Signed = true
+	case 'u', 'U':
+	default:
+		return FieldType{}, false
+	}
+	width, err := strconv.Atoi(s[1:])
+	if err != nil || width < 1 || width > 64 || (!t.Signed && width == 64) {
+		return FieldType{}, false
+	}
+	t.Width = width
+	return t, true
+}
+
+func (t FieldType) mask() uint64 {
+	if t.Width == 64 {
+		return math.MaxUint64
+	}
+	return 1<<t.Width - 1
+}
+
+// 字段能表示的最大值与最小值
+func (t FieldType) bounds() (min, max int64) {
+	if !t.Signed {
+		return 0, int64(t.mask())
+	}
+	max = int64(t.mask() >> 1)
+	return -max - 1, max
+}
+
+// 把低 Width 位解释为字段的值，有符号字段做符号扩展
+func (t FieldType) decode(raw uint64) int64 {
+	raw &= t.mask()
+	if t.Signed && t.Width < 64 && raw>>(t.Width-1) == 1 {
+		raw |= ^t.mask()
+	}
+	return int64(raw)
+}
+
+// Get 读取从 offset 位开始的字段
+func (t FieldType) Get(b *Bitmap, offset int64) int64 {
+	return t.decode(b.GetBits(offset, t.Width))
+}
+
+// Set 把 value 的低 Width 位写入从 offset 位开始的字段
+func (t FieldType) Set(b *Bitmap, offset int64, value int64) {
+	b.SetBits(offset, t.Width, uint64(value))
+}
+
+// Fit 把 value 转换为字段能表示的值，溢出且处理方式为 OverflowFail 时返回 false
+func (t FieldType) Fit(value int64, overflow Overflow) (int64, bool) {
+	min, max := t.bounds()
+	// 无符号字段中负数按补码视为很大的正数
+	if value <= max && value >= min {
+		return value, true
+	}
+	switch overflow {
+	case OverflowSat:
+		if !t.Signed || value > max {
+			return max, true
+		}
+		return min, true
+	case OverflowFail:
+		return 0, false
+	}
+	return t.decode(uint64(value)), true
+}
+
+// Incr 计算 value + incr 的结果，溢出且处理方式为 OverflowFail 时返回 false
+func (t FieldType) Incr(value int64, incr int64, overflow Overflow) (int64, bool) {
+	min, max := t.bounds()
+	var up, down bool
+	if incr > 0 {
+		up = value > max-incr
+	} else if incr < 0 {
+		// 无符号字段 value >= 0，value+incr 不会溢出 int64
+		if t.Signed {
+			down = value < min-incr
+		} else {
+			down = value+incr < 0
+		}
+	}
+	if !up && !down {
+		return value + incr, true
+	}
+	switch overflow {
+	case OverflowSat:
+		if up {
+			return max, true
+		}
+		return min, true
+	case OverflowFail:
+		return 0, false
+	}
+	return t.decode(uint64(value) + uint64(incr)), true
+}
+
+// 以大端序读取从 i 开始的 8 个字节，超出 src 长度的部分视为 0
+func loadWordBE(src []byte, i int64) uint64 {
+	if i+wordSize <= int64(len(src)) {
+		return binary.BigEndian.Uint64(src[i:])
+	}
+	var buf [wordSize]byte
+	if i < int64(len(src)) {
+		copy(buf[:], src[i:])
+	}
+	return binary.BigEndian.Uint64(buf[:])
+}
+
+// 以大端序把 word 写入从 i 开始的 8 个字节，超出 dst 长度的部分丢弃
+func storeWordBE(dst []byte, i int64, word uint64) {
+	if i+wordSize <= int64(len(dst)) {
+		binary.BigEndian.PutUint64(dst[i:], word)
+		return
+	}
+	var buf [wordSize]byte
+	binary.BigEndian.PutUint64(buf[:], word)
+	copy(dst[i:], buf[:])
+}
+
+// GetBits 读取从 offset 开始的 width（1~64）位，超出位图的部分视为 0
+//
+// 位的编号与 GetBit/SetBit 相同，先读到的位作为结果的高位
+func (b *Bitmap) GetBits(offset int64, width int) uint64 {
+	byteIndex := offset / 8
+	shift := uint(offset % 8)
+	value := loadWordBE(*b, byteIndex) << shift
+	// 字段跨越第 9 个字节
+	if int(shift)+width > 64 && byteIndex+wordSize < int64(len(*b)) {
+		value |= uint64((*b)[byteIndex+wordSize]) >> (8 - shift)
+	}
+	return value >> (64 - width)
+}
+
+// SetBits 把 value 的低 width 位写入从 offset 开始的位置，位的编号与 GetBits 相同
+func (b *Bitmap) SetBits(offset int64, width int, value uint64) {
+	b.grow(offset + int64(width))
+	byteIndex := offset / 8
+	shift := uint(offset % 8)
+	// 字段在以 byteIndex 开始的 64 位字中从最高位数起占据 [shift, shift+width)
+	mask := ^uint64(0) << (64 - width) >> shift
+	word := loadWordBE(*b, byteIndex)
+	word = word&^mask | value<<(64-width)>>shift&mask
+	storeWordBE(*b, byteIndex, word)
+	// 放不下的低位写入第 9 个字节的高位
+	if spill := int(shift) + width - 64; spill > 0 {
+		last := &(*b)[byteIndex+wordSize]
+		lowMask := byte(0xff << (8 - spill))
+		*last = *last&^lowMask | byte(value<<(8-spill))&lowMask
+	}
+}
@@ -38,11 +38,12 @@ func (b *Bitmap) ToBytes() []byte {
 	return *b
 }
 
+// 与 Redis 一致，第 offset 位是第 offset/8 个字节从最高位数起的第 offset%8 位
 func (b *Bitmap) SetBit(offset int64, val byte) {
 	byteIndex := offset / 8
 	bitOffset := offset % 8
-	// 00000100 if bitOffset equals 3
-	mask := byte(1 << bitOffset)
+	// 00010000 if bitOffset equals 3
+	mask := byte(0x80 >> bitOffset)
 	b.grow(offset + 1)
 	if val > 0 {
 		// set bit
@@ -61,7 +62,7 @@ func (b *Bitmap) GetBit(offset int64) byte {
 		return 0
 	}
 	// 得到 bit 位
-	return ((*b)[byteIndex] >> bitOffset) & 0x01
+	return ((*b)[byteIndex] >> (7 - bitOffset)) & 0x01
 }
 
 // 是否继续遍历
@@ -78,7 +79,7 @@ func (b *Bitmap) ForEachBit(begin int64, end int64, cb Callback) {
 		b := (*b)[byteIndex]
 		for bitOffset < 8 {
 			// 由每个字节，获取每个bit位
-			bit := byte(b >> bitOffset & 0x01)
+			bit := byte(b >> (7 - bitOffset) & 0x01)
 			if !cb(offset, bit) {
 				return
 			}
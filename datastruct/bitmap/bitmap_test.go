@@ -2,6 +2,7 @@ package bitmap
 
 import (
 	"bytes"
+	"math"
 	"math/rand"
 	"testing"
 )
@@ -48,7 +49,7 @@ func TestFromBytes(t *testing.T) {
 	bs := []byte{0xff, 0xff}
 	bm := FromBytes(bs)
 	bm.SetBit(8, 0)
-	expect := []byte{0xff, 0xfe}
+	expect := []byte{0xff, 0x7f}
 	if !bytes.Equal(bs, expect) {
 		t.Error("wrong value")
 	}
@@ -123,7 +124,7 @@ func TestBitMap_ForEachByte(t *testing.T) {
 	}
 	bm.ForEachByte(0, 0, func(offset int64, val byte) bool {
 		if offset%2 == 0 {
-			if val != 1 {
+			if val != 0x80 {
 				t.Error("wrong value")
 			}
 		} else {
@@ -135,7 +136,7 @@ func TestBitMap_ForEachByte(t *testing.T) {
 	})
 	bm.ForEachByte(0, 2000, func(offset int64, val byte) bool {
 		if offset%2 == 0 {
-			if val != 1 {
+			if val != 0x80 {
 				t.Error("wrong value")
 			}
 		} else {
@@ -147,7 +148,7 @@ func TestBitMap_ForEachByte(t *testing.T) {
 	})
 	bm.ForEachByte(0, 500, func(offset int64, val byte) bool {
 		if offset%2 == 0 {
-			if val != 1 {
+			if val != 0x80 {
 				t.Error("wrong value")
 			}
 		} else {
@@ -166,3 +167,159 @@ func TestBitMap_ForEachByte(t *testing.T) {
 		t.Error("break failed")
 	}
 }
+
+func TestCompute(t *testing.T) {
+	a := []byte{0xff, 0x0f, 0x01, 0, 0, 0, 0, 0, 0xaa}
+	b := []byte{0x0f, 0xff}
+	c := []byte{0x01}
+	cases := []struct {
+		op     Operation
+		srcs   [][]byte
+		expect []byte
+	}{
+		{OpAnd, [][]byte{a, b}, []byte{0x0f, 0x0f, 0, 0, 0, 0, 0, 0, 0}},
+		{OpOr, [][]byte{a, b}, []byte{0xff, 0xff, 0x01, 0, 0, 0, 0, 0, 0xaa}},
+		{OpXor, [][]byte{a, b}, []byte{0xf0, 0xf0, 0x01, 0, 0, 0, 0, 0, 0xaa}},
+		{OpNot, [][]byte{b}, []byte{0xf0, 0x00}},
+		{OpDiff, [][]byte{a, b, c}, []byte{0xf0, 0x00, 0x01, 0, 0, 0, 0, 0, 0xaa}},
+		{OpDiff1, [][]byte{b, a}, []byte{0xf0, 0x00, 0x01, 0, 0, 0, 0, 0, 0xaa}},
+		{OpAndOr, [][]byte{a, b, c}, []byte{0x0f, 0x0f, 0, 0, 0, 0, 0, 0, 0}},
+		{OpOne, [][]byte{a, b, c}, []byte{0xf0, 0xf0, 0x01, 0, 0, 0, 0, 0, 0xaa}},
+	}
+	for i, c := range cases {
+		if actual := Compute(c.op, c.srcs...); !bytes.Equal(actual, c.expect) {
+			t.Errorf("case %d: expect %x, actual %x", i, c.expect, actual)
+		}
+	}
+	if Compute(OpOr, nil, []byte{}) != nil {
+		t.Error("expect nil")
+	}
+}
+
+func TestBits(t *testing.T) {
+	bm := New()
+	bm.SetBits(3, 12, 0xabc)
+	if v := bm.GetBits(3, 12); v != 0xabc {
+		t.Errorf("expect 0xabc, actual %x", v)
+	}
+	// 与 Redis 相同，从每个字节的最高位开始编号
+	if !bytes.Equal(bm.ToBytes(), []byte{0x15, 0x78}) {
+		t.Errorf("wrong bit order %x", bm.ToBytes())
+	}
+	// SetBit/GetBit 与 GetBits/SetBits 使用相同的位编号
+	bm2 := New()
+	bm2.SetBit(0, 1)
+	if v := bm2.GetBits(0, 8); v != 0x80 {
+		t.Errorf("expect 0x80, actual %x", v)
+	}
+	bm2.SetBits(8, 8, 0x80)
+	if bm2.GetBit(8) != 1 || bm2.GetBit(15) != 0 {
+		t.Errorf("wrong bit order %x", bm2.ToBytes())
+	}
+	if v := bm.GetBits(100, 64); v != 0 {
+		t.Error("expect 0")
+	}
+	bm.SetBits(64, 64, math.MaxUint64)
+	if v := bm.GetBits(64, 64); v != math.MaxUint64 {
+		t.Errorf("wrong value %x", v)
+	}
+
+	// 与逐位读写的结果比较，覆盖跨越 9 个字节的字段
+	getBit := func(b []byte, i int64) uint64 {
+		if i/8 >= int64(len(b)) {
+			return 0
+		}
+		return uint64(b[i/8] >> (7 - i%8) & 1)
+	}
+	data := make([]byte, 24)
+	for i := range data {
+		data[i] = byte(i*37 + 11)
+	}
+	for offset := int64(0); offset < 16; offset++ {
+		for width := 1; width <= 64; width++ {
+			var expect uint64
+			for i := int64(0); i < int64(width); i++ {
+				expect = expect<<1 | getBit(data, offset+i)
+			}
+			if v := FromBytes(data).GetBits(offset, width); v != expect {
+				t.Fatalf("get %d %d: expect %x, actual %x", offset, width, expect, v)
+			}
+			target := Bitmap(append([]byte(nil), data...))
+			target.SetBits(offset, width, ^expect)
+			for i := int64(0); i < int64(len(data))*8; i++ {
+				flipped := i >= offset && i < offset+int64(width)
+				if (getBit(target, i) != getBit(data, i)) != flipped {
+					t.Fatalf("set %d %d: wrong bit %d", offset, width, i)
+				}
+			}
+		}
+	}
+}
+
+func TestFieldType(t *testing.T) {
+	if _, ok := ParseFieldType("u64"); ok {
+		t.Error("u64 is not supported")
+	}
+	for _, s := range []string{"i0", "i65", "x8", "u", "i-1"} {
+		if _, ok := ParseFieldType(s); ok {
+			t.Errorf("expect %s to be invalid", s)
+		}
+	}
+	i8, _ := ParseFieldType("i8")
+	u8, _ := ParseFieldType("u8")
+	i64, _ := ParseFieldType("i64")
+	u63, _ := ParseFieldType("u63")
+
+	bm := New()
+	i8.Set(bm, 0, -1)
+	if v := i8.Get(bm, 0); v != -1 {
+		t.Errorf("expect -1, actual %d", v)
+	}
+	if v := u8.Get(bm, 0); v != 255 {
+		t.Errorf("expect 255, actual %d", v)
+	}
+
+	cases := []struct {
+		typ      FieldType
+		value    int64
+		incr     int64
+		overflow Overflow
+		expect   int64
+		ok       bool
+	}{
+		{u8, 250, 10, OverflowWrap, 4, true},
+		{u8, 250, 10, OverflowSat, 255, true},
+		{u8, 250, 10, OverflowFail, 0, false},
+		{u8, 5, -10, OverflowWrap, 251, true},
+		{u8, 5, -10, OverflowSat, 0, true},
+		{u8, 5, math.MinInt64, OverflowSat, 0, true},
+		{i8, 120, 10, OverflowWrap, -126, true},
+		{i8, 120, 10, OverflowSat, 127, true},
+		{i8, -120, -10, OverflowSat, -128, true},
+		{i8, -120, -10, OverflowFail, 0, false},
+		{i64, math.MaxInt64, 1, OverflowWrap, math.MinInt64, true},
+		{i64, math.MinInt64, math.MinInt64, OverflowSat, math.MinInt64, true},
+		{i64, -1, math.MinInt64, OverflowWrap, math.MaxInt64, true},
+		{u63, math.MaxInt64, 1, OverflowWrap, 0, true},
+		{i8, 3, -5, OverflowFail, -2, true},
+	}
+	for i, c := range cases {
+		actual, ok := c.typ.Incr(c.value, c.incr, c.overflow)
+		if actual != c.expect || ok != c.ok {
+			t.Errorf("case %d: expect %d %v, actual %d %v", i, c.expect, c.ok, actual, ok)
+		}
+	}
+
+	if v, _ := u8.Fit(-1, OverflowSat); v != 255 {
+		t.Errorf("expect 255, actual %d", v)
+	}
+	if v, _ := u8.Fit(300, OverflowWrap); v != 44 {
+		t.Errorf("expect 44, actual %d", v)
+	}
+	if v, _ := i8.Fit(-200, OverflowSat); v != -128 {
+		t.Errorf("expect -128, actual %d", v)
+	}
+	if _, ok := i8.Fit(128, OverflowFail); ok {
+		t.Error("expect fail")
+	}
+}
@@ -0,0 +1,96 @@
+package bitmap
+
+import "encoding/binary"
+
+// Operation 位图之间的按位运算
+type Operation int
+
+const (
+	OpAnd   Operation = iota // 所有位图按位与
+	OpOr                     // 所有位图按位或
+	OpXor                    // 所有位图按位异或
+	OpNot                    // 对唯一的位图取反
+	OpDiff                   // 第一个位图中存在、其余位图中都不存在的位
+	OpDiff1                  // 其余位图中存在、第一个位图中不存在的位
+	OpAndOr                  // 第一个位图中存在、且其余位图中至少有一个存在的位
+	OpOne                    // 恰好在一个位图中存在的位
+)
+
+const wordSize = 8
+
+// 读取从 i 开始的 8 个字节，超出 src 长度的部分视为 0
+func loadWord(src []byte, i int) uint64 {
+	if i+wordSize <= len(src) {
+		return binary.LittleEndian.Uint64(src[i:])
+	}
+	var buf [wordSize]byte
+	if i < len(src) {
+		copy(buf[:], src[i:])
+	}
+	return binary.LittleEndian.Uint64(buf[:])
+}
+
+// Compute 对 srcs 做按位运算并返回新的字节切片
+//
+// 较短的输入视为以 0 补齐，结果长度等于最长的输入。运算每次处理 8 个字节
+func Compute(op Operation, srcs ...[]byte) []byte {
+	maxLen := 0
+	for _, src := range srcs {
+		if len(src) > maxLen {
+			maxLen = len(src)
+		}
+	}
+	if maxLen == 0 {
+		return nil
+	}
+	// 按整字长分配，方便最后一个字直接写入
+	result := make([]byte, (maxLen+wordSize-1)/wordSize*wordSize)
+	for i := 0; i < maxLen; i += wordSize {
+		binary.LittleEndian.PutUint64(result[i:], computeWord(op, srcs, i))
+	}
+	return result[:maxLen]
+}
+
+// 计算结果中从 i 开始的一个字
+func computeWord(op Operation, srcs [][]byte, i int) uint64 {
+	first := loadWord(srcs[0], i)
+	switch op {
+	case OpNot:
+		return ^first
+	case OpDiff, OpDiff1, OpAndOr:
+		var rest uint64
+		for _, src := range srcs[1:] {
+			rest |= loadWord(src, i)
+		}
+		switch op {
+		case OpDiff:
+			return first &^ rest
+		case OpDiff1:
+			return rest &^ first
+		default:
+			return first & rest
+		}
+	case OpOne:
+		// once 记录出现过的位，more 记录出现过不止一次的位
+		once, more := first, uint64(0)
+		for _, src := range srcs[1:] {
+			w := loadWord(src, i)
+			more |= once & w
+			once |= w
+		}
+		return once &^ more
+	}
+	acc := first
+	for _, src := range srcs[1:] {
+		w := loadWord(src, i)
+		switch op {
+		case OpAnd:
+			acc &= w
+		case OpOr:
+			acc |= w
+		case OpXor:
+			acc ^= w
+		}
+	}
+	return acc
+}
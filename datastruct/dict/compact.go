@@ -0,0 +1,207 @@
+package dict
+
+import (
+	"math/rand"
+	"myredis/datastruct/listpack"
+	"myredis/lib/wildcard"
+)
+
+// 哈希类型使用 listpack 编码的阈值，对应 hash-max-listpack-entries 与 hash-max-listpack-value
+var (
+	ListpackMaxEntries = 128
+	ListpackMaxValue   = 64
+)
+
+// CompactDict 是哈希类型使用的字典，它不是线程安全的
+//
+// 元素较少时 field 与 value 交替保存在一个 listpack 中；元素个数超过 ListpackMaxEntries、
+// field 或 value 的长度超过 ListpackMaxValue，或者 value 不是 []byte 时，转换为 SimpleDict，之后不再转回
+type CompactDict struct {
+	lp   *listpack.ListPack
+	dict *SimpleDict
+}
+
+func MakeCompact() *CompactDict {
+	return &CompactDict{
+		lp: listpack.Make(),
+	}
+}
+
+// 返回内部编码，listpack 或 hashtable
+func (dict *CompactDict) Encoding() string {
+	if dict.lp != nil {
+		return "listpack"
+	}
+	return "hashtable"
+}
+
+// 判断写入 key, val 后是否仍可以使用 listpack 编码
+func (dict *CompactDict) fitsListpack(key string, val interface{}, added int) bool {
+	bytes, ok := val.([]byte)
+	return ok && len(key) <= ListpackMaxValue && len(bytes) <= ListpackMaxValue &&
+		dict.lp.Len()/2+added <= ListpackMaxEntries
+}
+
+// 转换为 SimpleDict
+func (dict *CompactDict) convert() {
+	simple := MakeSimple()
+	dict.lp.ForEach(func(i int, val []byte) bool {
+		if i%2 == 1 {
+			simple.Put(string(dict.lp.Get(i-1)), append([]byte{}, val...))
+		}
+		return true
+	})
+	dict.dict = simple
+	dict.lp = nil
+}
+
+func (dict *CompactDict) Get(key string) (val interface{}, exists bool) {
+	if dict.dict != nil {
+		return dict.dict.Get(key)
+	}
+	index := dict.lp.Find([]byte(key), 0, 2)
+	if index < 0 {
+		return nil, false
+	}
+	return dict.lp.Get(index + 1), true
+}
+
+func (dict *CompactDict) Len() int {
+	if dict.dict != nil {
+		return dict.dict.Len()
+	}
+	return dict.lp.Len() / 2
+}
+
+func (dict *CompactDict) Put(key string, val interface{}) (result int) {
+	if dict.dict != nil {
+		return dict.dict.Put(key, val)
+	}
+	index := dict.lp.Find([]byte(key), 0, 2)
+	added := 0
+	if index < 0 {
+		added = 1
+	}
+	if !dict.fitsListpack(key, val, added) {
+		dict.convert()
+		return dict.dict.Put(key, val)
+	}
+	if index >= 0 {
+		dict.lp.Replace(index+1, val.([]byte))
+		return 0
+	}
+	dict.lp.Append([]byte(key), val.([]byte))
+	return 1
+}
+
+func (dict *CompactDict) PutIfAbsent(key string, val interface{}) (result int) {
+	if _, exists := dict.Get(key); exists {
+		return 0
+	}
+	return dict.Put(key, val)
+}
+
+func (dict *CompactDict) PutIfExists(key string, val interface{}) (result int) {
+	if _, exists := dict.Get(key); !exists {
+		return 0
+	}
+	dict.Put(key, val)
+	return 1
+}
+
+func (dict *CompactDict) Remove(key string) (val interface{}, result int) {
+	if dict.dict != nil {
+		return dict.dict.Remove(key)
+	}
+	index := dict.lp.Find([]byte(key), 0, 2)
+	if index < 0 {
+		return nil, 0
+	}
+	val = dict.lp.Get(index + 1)
+	dict.lp.Delete(index, 2)
+	return val, 1
+}
+
+func (dict *CompactDict) Keys() []string {
+	if dict.dict != nil {
+		return dict.dict.Keys()
+	}
+	keys := make([]string, 0, dict.Len())
+	dict.lp.ForEach(func(i int, val []byte) bool {
+		if i%2 == 0 {
+			keys = append(keys, string(val))
+		}
+		return true
+	})
+	return keys
+}
+
+func (dict *CompactDict) ForEach(consumer Consumer) {
+	if dict.dict != nil {
+		dict.dict.ForEach(consumer)
+		return
+	}
+	// 先复制出全部元素，允许在 consumer 中修改字典
+	entries := make([][]byte, 0, dict.lp.Len())
+	dict.lp.ForEach(func(i int, val []byte) bool {
+		entries = append(entries, append([]byte{}, val...))
+		return true
+	})
+	for i := 0; i+1 < len(entries); i += 2 {
+		if !consumer(string(entries[i]), entries[i+1]) {
+			break
+		}
+	}
+}
+
+func (dict *CompactDict) RandomKeys(limit int) []string {
+	if dict.dict != nil {
+		return dict.dict.RandomKeys(limit)
+	}
+	keys := dict.Keys()
+	if len(keys) == 0 {
+		return make([]string, 0)
+	}
+	result := make([]string, limit)
+	for i := range result {
+		result[i] = keys[rand.Intn(len(keys))]
+	}
+	return result
+}
+
+func (dict *CompactDict) RandomDistinctKeys(limit int) []string {
+	if dict.dict != nil {
+		return dict.dict.RandomDistinctKeys(limit)
+	}
+	keys := dict.Keys()
+	rand.Shuffle(len(keys), func(i, j int) {
+		keys[i], keys[j] = keys[j], keys[i]
+	})
+	if limit < len(keys) {
+		keys = keys[:limit]
+	}
+	return keys
+}
+
+func (dict *CompactDict) Clear() {
+	*dict = *MakeCompact()
+}
+
+// listpack 编码时一次返回全部元素
+func (dict *CompactDict) DictScan(cursor int, count int, pattern string) ([][]byte, int) {
+	if dict.dict != nil {
+		return dict.dict.DictScan(cursor, count, pattern)
+	}
+	result := make([][]byte, 0)
+	matchKey, err := wildcard.CompilePattern(pattern)
+	if err != nil {
+		return result, -1
+	}
+	dict.ForEach(func(key string, val interface{}) bool {
+		if pattern == "*" || matchKey.IsMatch(key) {
+			result = append(result, []byte(key), val.([]byte))
+		}
+		return true
+	})
+	return result, 0
+}
@@ -0,0 +1,51 @@
+package dict
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompactDict(t *testing.T) {
+	d := MakeCompact()
+	for i := 0; i < 10; i++ {
+		if ret := d.Put("k"+strconv.Itoa(i), []byte("v"+strconv.Itoa(i))); ret != 1 {
+			t.Errorf("put new key should return 1, actually %d", ret)
+		}
+	}
+	if ret := d.Put("k0", []byte("new")); ret != 0 {
+		t.Errorf("put existing key should return 0, actually %d", ret)
+	}
+	if val, ok := d.Get("k0"); !ok || string(val.([]byte)) != "new" {
+		t.Error("wrong value of k0")
+	}
+	if _, ret := d.Remove("k1"); ret != 1 || d.Len() != 9 {
+		t.Error("remove failed")
+	}
+	if d.Encoding() != "listpack" {
+		t.Errorf("expect listpack, actually %s", d.Encoding())
+	}
+
+	// 值过长时转换为 hashtable，并保留已有的元素
+	d.Put("long", []byte(strings.Repeat("a", ListpackMaxValue+1)))
+	if d.Encoding() != "hashtable" || d.Len() != 10 {
+		t.Errorf("expect hashtable with 10 entries, actually %s with %d", d.Encoding(), d.Len())
+	}
+	if val, ok := d.Get("k9"); !ok || string(val.([]byte)) != "v9" {
+		t.Error("lost value after conversion")
+	}
+}
+
+func TestCompactDictMaxEntries(t *testing.T) {
+	d := MakeCompact()
+	for i := 0; i < ListpackMaxEntries; i++ {
+		d.Put(strconv.Itoa(i), []byte{})
+	}
+	if d.Encoding() != "listpack" {
+		t.Errorf("expect listpack, actually %s", d.Encoding())
+	}
+	d.Put(strconv.Itoa(ListpackMaxEntries), []byte{})
+	if d.Encoding() != "hashtable" || d.Len() != ListpackMaxEntries+1 {
+		t.Errorf("expect hashtable with %d entries", ListpackMaxEntries+1)
+	}
+}
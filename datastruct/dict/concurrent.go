@@ -381,6 +381,22 @@ func (dict *ConcurrentDict) Clear() {
 	*dict = *MakeConcurrent(dict.shardCount)
 }
 
+// 清空字典并返回原有的全部分片，逐个分片加锁后替换为空 map，耗时与键值对数量无关
+//
+// 调用者负责释放返回的内容
+func (dict *ConcurrentDict) Detach() []map[string]interface{} {
+	detached := make([]map[string]interface{}, 0, len(dict.table))
+	for _, s := range dict.table {
+		s.mutex.Lock()
+		m := s.m
+		s.m = make(map[string]interface{})
+		atomic.AddInt32(&dict.count, -int32(len(m)))
+		s.mutex.Unlock()
+		detached = append(detached, m)
+	}
+	return detached
+}
+
 // 提取 key 对应的所有 shard index
 func (dict *ConcurrentDict) toLockIndices(keys []string, reverse bool) []uint32 {
 	indexMap := make(map[uint32]struct{})
@@ -443,45 +459,39 @@ func (dict *ConcurrentDict) RWUnLocks(writeKeys []string, readKeys []string) {
 	}
 }
 
+// DictScan 使用反向二进制游标增量遍历，扫描期间一直存在的键至少会被返回一次，可能被返回多次
+//
+// 虚拟哈希表的大小随键的数量变化，但不超过分片数，每个虚拟桶对应低位相同的一组分片。
+// count 是每次至少访问的键的数量，模式在访问之后过滤
 func (dict *ConcurrentDict) DictScan(cursor int, count int, pattern string) ([][]byte, int) {
-	size := dict.Len()
 	result := make([][]byte, 0)
-
-	if pattern == "*" && count >= size {
-		return stringsToBytes(dict.Keys()), 0
-	}
-
 	matchKey, err := wildcard.CompilePattern(pattern)
 	if err != nil {
 		return result, -1
 	}
 
-	shardCount := len(dict.table)
-	shardIndex := cursor
-
-	for shardIndex < shardCount {
-		shard := dict.table[shardIndex]
-		shard.mutex.RLock()
-		if len(result)+len(shard.m) > count && shardIndex > cursor {
+	shardMask := uint32(len(dict.table) - 1)
+	mask := ScanMask(dict.Len())
+	if mask > shardMask {
+		mask = shardMask
+	}
+	visited := 0
+	bucket := uint32(cursor) & mask
+	for {
+		for index := bucket; index <= shardMask; index += mask + 1 {
+			shard := dict.table[index]
+			shard.mutex.RLock()
+			for key := range shard.m {
+				visited++
+				if pattern == "*" || matchKey.IsMatch(key) {
+					result = append(result, []byte(key))
+				}
+			}
 			shard.mutex.RUnlock()
-			return result, shardIndex
 		}
-
-		for key := range shard.m {
-			if pattern == "*" || matchKey.IsMatch(key) {
-				result = append(result, []byte(key))
-			}
+		bucket = NextScanCursor(bucket, mask)
+		if bucket == 0 || visited >= count {
+			return result, int(bucket)
 		}
-		shard.mutex.RUnlock()
-		shardIndex++
-	}
-	return result, 0
-}
-
-func stringsToBytes(strSlice []string) [][]byte {
-	byteSlice := make([][]byte, len(strSlice))
-	for i, str := range strSlice {
-		byteSlice[i] = []byte(str)
 	}
-	return byteSlice
 }
@@ -0,0 +1,113 @@
+package dict
+
+import "math"
+
+// ExpireDict 在 Dict 的基础上为字段附加可选的过期时间，用于哈希字段过期
+//
+// 过期时间以 Unix 毫秒保存；字段被 Put 覆盖或被删除时清除其过期时间，PutIfExists 更新值时保留过期时间。
+// 它只记录过期时间，过期字段需要调用者通过 RemoveExpired 删除
+type ExpireDict struct {
+	Dict
+	expires map[string]int64
+}
+
+// MakeExpireDict 包装一个字典，d 已经是 *ExpireDict 时直接返回
+func MakeExpireDict(d Dict) *ExpireDict {
+	if expireDict, ok := d.(*ExpireDict); ok {
+		return expireDict
+	}
+	return &ExpireDict{
+		Dict:    d,
+		expires: make(map[string]int64),
+	}
+}
+
+// 返回内部编码，listpack 编码且带有过期字段时为 listpackex
+func (dict *ExpireDict) Encoding() string {
+	encoding := "hashtable"
+	if encoded, ok := dict.Dict.(interface{ Encoding() string }); ok {
+		encoding = encoded.Encoding()
+	}
+	if encoding == "listpack" && len(dict.expires) > 0 {
+		return "listpackex"
+	}
+	return encoding
+}
+
+func (dict *ExpireDict) Put(key string, val interface{}) (result int) {
+	delete(dict.expires, key)
+	return dict.Dict.Put(key, val)
+}
+
+func (dict *ExpireDict) Remove(key string) (val interface{}, result int) {
+	delete(dict.expires, key)
+	return dict.Dict.Remove(key)
+}
+
+func (dict *ExpireDict) Clear() {
+	dict.expires = make(map[string]int64)
+	dict.Dict.Clear()
+}
+
+// SetExpire 设置字段的过期时间，字段不存在时返回 false
+func (dict *ExpireDict) SetExpire(key string, expireAt int64) bool {
+	if _, ok := dict.Dict.Get(key); !ok {
+		return false
+	}
+	dict.expires[key] = expireAt
+	return true
+}
+
+// GetExpire 返回字段的过期时间，字段没有过期时间时 ok 为 false
+func (dict *ExpireDict) GetExpire(key string) (expireAt int64, ok bool) {
+	expireAt, ok = dict.expires[key]
+	return
+}
+
+// Persist 移除字段的过期时间，字段原本有过期时间时返回 true
+func (dict *ExpireDict) Persist(key string) bool {
+	if _, ok := dict.expires[key]; !ok {
+		return false
+	}
+	delete(dict.expires, key)
+	return true
+}
+
+// ExpireLen 返回带有过期时间的字段个数
+func (dict *ExpireDict) ExpireLen() int {
+	return len(dict.expires)
+}
+
+// NextExpire 返回最早的过期时间，没有字段带过期时间时 ok 为 false
+func (dict *ExpireDict) NextExpire() (expireAt int64, ok bool) {
+	expireAt = math.MaxInt64
+	for _, at := range dict.expires {
+		if at < expireAt {
+			expireAt = at
+		}
+	}
+	return expireAt, len(dict.expires) > 0
+}
+
+// ForEachExpire 遍历带有过期时间的字段
+func (dict *ExpireDict) ForEachExpire(consumer func(key string, expireAt int64) bool) {
+	for key, at := range dict.expires {
+		if !consumer(key, at) {
+			return
+		}
+	}
+}
+
+// RemoveExpired 删除过期时间不晚于 now（Unix 毫秒）的字段，返回被删除的字段
+func (dict *ExpireDict) RemoveExpired(now int64) []string {
+	var removed []string
+	for key, at := range dict.expires {
+		if at <= now {
+			removed = append(removed, key)
+		}
+	}
+	for _, key := range removed {
+		dict.Remove(key)
+	}
+	return removed
+}
@@ -0,0 +1,59 @@
+package dict
+
+import "testing"
+
+func TestExpireDict(t *testing.T) {
+	d := MakeExpireDict(MakeCompact())
+	if MakeExpireDict(d) != d {
+		t.Error("wrapping an ExpireDict should return itself")
+	}
+	d.Put("a", []byte("1"))
+	d.Put("b", []byte("2"))
+	d.Put("c", []byte("3"))
+	if d.SetExpire("x", 100) {
+		t.Error("should not set expire on missing field")
+	}
+	d.SetExpire("a", 100)
+	d.SetExpire("b", 200)
+	d.SetExpire("c", 300)
+	if d.Encoding() != "listpackex" {
+		t.Errorf("expect listpackex, actually %s", d.Encoding())
+	}
+	if at, ok := d.NextExpire(); !ok || at != 100 {
+		t.Errorf("expect next expire 100, actually %d", at)
+	}
+
+	// 覆盖与删除清除过期时间，PutIfExists 保留过期时间
+	d.Put("a", []byte("new"))
+	if _, ok := d.GetExpire("a"); ok {
+		t.Error("put should clear expire")
+	}
+	d.PutIfExists("b", []byte("new"))
+	if at, ok := d.GetExpire("b"); !ok || at != 200 {
+		t.Error("PutIfExists should keep expire")
+	}
+	if !d.Persist("b") || d.Persist("b") {
+		t.Error("persist failed")
+	}
+	d.Remove("c")
+	if d.ExpireLen() != 0 {
+		t.Errorf("expect no expires, actually %d", d.ExpireLen())
+	}
+
+	d.SetExpire("a", 100)
+	d.SetExpire("b", 200)
+	removed := d.RemoveExpired(150)
+	if len(removed) != 1 || removed[0] != "a" || d.Len() != 1 {
+		t.Errorf("unexpected removed fields %v", removed)
+	}
+	if at, ok := d.NextExpire(); !ok || at != 200 {
+		t.Errorf("expect next expire 200, actually %d", at)
+	}
+	d.Clear()
+	if d.Len() != 0 || d.ExpireLen() != 0 {
+		t.Error("clear failed")
+	}
+	if _, ok := d.NextExpire(); ok {
+		t.Error("empty dict should have no next expire")
+	}
+}
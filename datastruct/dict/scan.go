@@ -0,0 +1,123 @@
+package dict
+
+import (
+	"math/bits"
+)
+
+// 增量扫描使用与 Redis 相同的反向二进制游标：
+//
+//   - 元素按 fnv32 哈希的低位分配到大小为 2 的幂的虚拟哈希表中，游标是桶的编号
+//   - 每次把游标按位反转后加一再反转回来，即从高位开始递增，因此桶按照编号反转后的顺序访问
+//   - 表的大小随元素数量变化时，扩容后的桶是原来某个桶的细分，缩容后的桶是原来若干个桶的合并，
+//     按反转顺序已访问过的桶在新表中仍然排在游标之前，因此扫描期间一直存在的元素至少会被返回一次
+//
+// 缩容时合并的桶中可能包含已经返回过的元素，所以同一个元素可能被返回多次
+
+// ScanMask 返回 size 个元素对应的虚拟哈希表掩码，表的大小为不小于 size 的最小的 2 的幂
+func ScanMask(size int) uint32 {
+	if size <= 1 {
+		return 0
+	}
+	return uint32(1)<<bits.Len32(uint32(size-1)) - 1
+}
+
+// NextScanCursor 返回 mask 对应的表中 cursor 之后的下一个桶，所有桶都访问过时返回 0
+func NextScanCursor(cursor uint32, mask uint32) uint32 {
+	cursor |= ^mask
+	cursor = bits.Reverse32(cursor)
+	cursor++
+	return bits.Reverse32(cursor)
+}
+
+// ScanIndex 把元素按 fnv32 哈希的低位分桶，供无法按桶访问的 map 增量扫描使用，不是线程安全的
+//
+// 每次扫描只访问返回的桶。元素数量超过桶数时扩容，不足桶数的 1/8 时缩容，
+// 调整大小的耗时均摊到每次 Add 与 Remove 上
+type ScanIndex struct {
+	buckets [][]string
+	size    int
+}
+
+// MakeScanIndex 使用 members 构建索引
+func MakeScanIndex(members []string) *ScanIndex {
+	index := &ScanIndex{}
+	index.resize(int(ScanMask(len(members))) + 1)
+	for _, member := range members {
+		index.insert(member)
+	}
+	return index
+}
+
+func (index *ScanIndex) insert(member string) {
+	bucket := fnv32(member) & uint32(len(index.buckets)-1)
+	index.buckets[bucket] = append(index.buckets[bucket], member)
+	index.size++
+}
+
+func (index *ScanIndex) resize(tableSize int) {
+	buckets := index.buckets
+	index.buckets = make([][]string, tableSize)
+	index.size = 0
+	for _, bucket := range buckets {
+		for _, member := range bucket {
+			index.insert(member)
+		}
+	}
+}
+
+// Add 添加一个不在索引中的元素
+func (index *ScanIndex) Add(member string) {
+	if len(index.buckets) == 0 {
+		index.resize(1)
+	}
+	index.insert(member)
+	if index.size > len(index.buckets) {
+		index.resize(len(index.buckets) * 2)
+	}
+}
+
+// Remove 删除元素，元素不存在时不做任何操作
+func (index *ScanIndex) Remove(member string) {
+	if len(index.buckets) == 0 {
+		return
+	}
+	b := fnv32(member) & uint32(len(index.buckets)-1)
+	bucket := index.buckets[b]
+	for i, m := range bucket {
+		if m == member {
+			last := len(bucket) - 1
+			bucket[i] = bucket[last]
+			bucket[last] = ""
+			index.buckets[b] = bucket[:last]
+			index.size--
+			break
+		}
+	}
+	if len(index.buckets) > 1 && index.size*8 < len(index.buckets) {
+		index.resize(int(ScanMask(index.size)) + 1)
+	}
+}
+
+// Len 返回索引中的元素数量
+func (index *ScanIndex) Len() int {
+	return index.size
+}
+
+// Scan 返回从 cursor 开始的若干个整桶中的元素以及下一个游标
+//
+// 返回的元素至少有 count 个，除非已经访问完全部的桶，此时下一个游标为 0
+func (index *ScanIndex) Scan(cursor int, count int) ([]string, int) {
+	if len(index.buckets) == 0 {
+		return nil, 0
+	}
+	mask := uint32(len(index.buckets) - 1)
+	result := make([]string, 0, count)
+	bucket := uint32(cursor) & mask
+	for {
+		result = append(result, index.buckets[bucket]...)
+		bucket = NextScanCursor(bucket, mask)
+		if bucket == 0 || len(result) >= count {
+			return result, int(bucket)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package dict
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestScanMask(t *testing.T) {
+	cases := map[int]uint32{0: 0, 1: 0, 2: 1, 3: 3, 4: 3, 5: 7, 1000: 1023, 1024: 1023}
+	for size, expected := range cases {
+		if mask := ScanMask(size); mask != expected {
+			t.Errorf("size %d: expect mask %d, actual %d", size, expected, mask)
+		}
+	}
+}
+
+func TestNextScanCursor(t *testing.T) {
+	// 大小为 8 的表的访问顺序
+	expected := []uint32{4, 2, 6, 1, 5, 3, 7, 0}
+	cursor := uint32(0)
+	for _, next := range expected {
+		cursor = NextScanCursor(cursor, 7)
+		if cursor != next {
+			t.Fatalf("expect cursor %d, actual %d", next, cursor)
+		}
+	}
+	if cursor := NextScanCursor(0, 0); cursor != 0 {
+		t.Errorf("expect cursor 0, actual %d", cursor)
+	}
+}
+
+// 扫描到结束，每一轮之前调用 mutate
+func scanAll(t *testing.T, scan func(cursor int) ([][]byte, int), mutate func(round int)) map[string]int {
+	seen := make(map[string]int)
+	cursor := 0
+	for round := 0; ; round++ {
+		if mutate != nil {
+			mutate(round)
+		}
+		keys, next := scan(cursor)
+		if next < 0 {
+			t.Fatalf("scan failed")
+		}
+		for _, key := range keys {
+			seen[string(key)]++
+		}
+		if next == 0 {
+			return seen
+		}
+		if round > 1<<16 {
+			t.Fatalf("scan does not terminate")
+		}
+		cursor = next
+	}
+}
+
+func TestConcurrentDictScan(t *testing.T) {
+	d := MakeConcurrent(16)
+	for i := 0; i < 1000; i++ {
+		d.Put("key"+strconv.Itoa(i), i)
+	}
+	seen := scanAll(t, func(cursor int) ([][]byte, int) {
+		return d.DictScan(cursor, 20, "*")
+	}, nil)
+	if len(seen) != 1000 {
+		t.Errorf("expect 1000 keys, actual %d", len(seen))
+	}
+	for key, times := range seen {
+		if times != 1 {
+			t.Errorf("key %s returned %d times", key, times)
+		}
+	}
+
+	seen = scanAll(t, func(cursor int) ([][]byte, int) {
+		return d.DictScan(cursor, 20, "key1*")
+	}, nil)
+	if len(seen) != 111 {
+		t.Errorf("expect 111 keys, actual %d", len(seen))
+	}
+
+	if keys, cursor := d.DictScan(0, 10, "[a"); cursor != -1 || len(keys) != 0 {
+		t.Errorf("expect illegal pattern")
+	}
+}
+
+func TestConcurrentDictScanWhileResizing(t *testing.T) {
+	for _, grow := range []bool{true, false} {
+		d := MakeConcurrent(1024)
+		for i := 0; i < 200; i++ {
+			d.Put("stable"+strconv.Itoa(i), i)
+		}
+		if !grow {
+			for i := 0; i < 600; i++ {
+				d.Put("temp"+strconv.Itoa(i), i)
+			}
+		}
+		seen := scanAll(t, func(cursor int) ([][]byte, int) {
+			return d.DictScan(cursor, 10, "*")
+		}, func(round int) {
+			// 扫描期间键的数量变化导致虚拟哈希表扩容或缩容
+			for i := 0; i < 30; i++ {
+				key := "temp" + strconv.Itoa(round*30+i)
+				if grow {
+					d.Put(key, i)
+				} else {
+					d.Remove(key)
+				}
+			}
+		})
+		for i := 0; i < 200; i++ {
+			if seen["stable"+strconv.Itoa(i)] == 0 {
+				t.Errorf("grow %v: missing key stable%d", grow, i)
+			}
+		}
+	}
+}
+
+func TestScanIndex(t *testing.T) {
+	index := MakeScanIndex(nil)
+	for i := 0; i < 1000; i++ {
+		index.Add("member" + strconv.Itoa(i))
+	}
+	if index.Len() != 1000 || len(index.buckets) != 1024 {
+		t.Errorf("expect 1000 members in 1024 buckets, actual %d in %d", index.Len(), len(index.buckets))
+	}
+	for i := 0; i < 990; i++ {
+		index.Remove("member" + strconv.Itoa(i))
+	}
+	index.Remove("missing")
+	if index.Len() != 10 || len(index.buckets) != 16 {
+		t.Errorf("expect 10 members in 16 buckets, actual %d in %d", index.Len(), len(index.buckets))
+	}
+	members, cursor := index.Scan(0, 100)
+	if len(members) != 10 || cursor != 0 {
+		t.Errorf("expect 10 members and cursor 0, actual %d and %d", len(members), cursor)
+	}
+}
+
+func TestSimpleDictScanWhileResizing(t *testing.T) {
+	for _, grow := range []bool{true, false} {
+		d := MakeSimple()
+		for i := 0; i < 100; i++ {
+			d.Put("stable"+strconv.Itoa(i), []byte("v"))
+		}
+		if !grow {
+			for i := 0; i < 400; i++ {
+				d.Put("temp"+strconv.Itoa(i), []byte("v"))
+			}
+		}
+		seen := scanAll(t, func(cursor int) ([][]byte, int) {
+			result, next := d.DictScan(cursor, 5, "*")
+			keys := make([][]byte, 0, len(result)/2)
+			for i := 0; i < len(result); i += 2 {
+				keys = append(keys, result[i])
+			}
+			return keys, next
+		}, func(round int) {
+			// 扫描期间成员数量变化导致索引扩容或缩容
+			for i := 0; i < 20; i++ {
+				key := "temp" + strconv.Itoa(round*20+i)
+				if grow {
+					d.Put(key, []byte("v"))
+				} else {
+					d.Remove(key)
+				}
+			}
+		})
+		for i := 0; i < 100; i++ {
+			if seen["stable"+strconv.Itoa(i)] == 0 {
+				t.Errorf("grow %v: missing member stable%d", grow, i)
+			}
+		}
+		if d.index.Len() != d.Len() {
+			t.Errorf("grow %v: index has %d members, dict has %d", grow, d.index.Len(), d.Len())
+		}
+	}
+}
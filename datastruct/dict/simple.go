@@ -7,6 +7,8 @@ import (
 // SimpleDict wraps a map, it is not thread safe
 type SimpleDict struct {
 	m map[string]interface{}
+	// 第一次增量扫描时创建，之后随键的增删维护
+	index *ScanIndex
 }
 
 // MakeSimple makes a new map
@@ -37,6 +39,9 @@ func (dict *SimpleDict) Put(key string, val interface{}) (result int) {
 	if existed {
 		return 0
 	}
+	if dict.index != nil {
+		dict.index.Add(key)
+	}
 	return 1
 }
 
@@ -47,6 +52,9 @@ func (dict *SimpleDict) PutIfAbsent(key string, val interface{}) (result int) {
 		return 0
 	}
 	dict.m[key] = val
+	if dict.index != nil {
+		dict.index.Add(key)
+	}
 	return 1
 }
 
@@ -65,6 +73,9 @@ func (dict *SimpleDict) Remove(key string) (val interface{}, result int) {
 	val, existed := dict.m[key]
 	delete(dict.m, key)
 	if existed {
+		if dict.index != nil {
+			dict.index.Remove(key)
+		}
 		return val, 1
 	}
 	return nil, 0
@@ -125,13 +136,23 @@ func (dict *SimpleDict) Clear() {
 	*dict = *MakeSimple()
 }
 
+// ScanKeys 返回从 cursor 开始的若干个桶中的键以及下一个游标
+func (dict *SimpleDict) ScanKeys(cursor int, count int) ([]string, int) {
+	if dict.index == nil {
+		dict.index = MakeScanIndex(dict.Keys())
+	}
+	return dict.index.Scan(cursor, count)
+}
+
+// DictScan 返回从 cursor 开始的若干个桶中的键值对，游标与 ConcurrentDict 的含义相同
 func (dict *SimpleDict) DictScan(cursor int, count int, pattern string) ([][]byte, int) {
 	result := make([][]byte, 0)
 	matchKey, err := wildcard.CompilePattern(pattern)
 	if err != nil {
 		return result, -1
 	}
-	for k := range dict.m {
+	keys, nextCursor := dict.ScanKeys(cursor, count)
+	for _, k := range keys {
 		if pattern == "*" || matchKey.IsMatch(k) {
 			raw, exists := dict.Get(k)
 			if !exists {
@@ -141,5 +162,5 @@ func (dict *SimpleDict) DictScan(cursor int, count int, pattern string) ([][]byt
 			result = append(result, raw.([]byte))
 		}
 	}
-	return result, 0
+	return result, nextCursor
 }
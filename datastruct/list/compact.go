@@ -0,0 +1,250 @@
+package list
+
+import (
+	"myredis/datastruct/listpack"
+)
+
+// 列表使用 listpack 编码的阈值，对应 list-max-listpack-size：
+// 正数表示最多的元素个数，-1 到 -5 表示编码后最多占用 4KB、8KB、16KB、32KB 或 64KB
+var ListpackMaxSize = -2
+
+// 判断元素个数为 entries、编码后大小为 size 的 listpack 是否在阈值之内
+func fitsListpack(entries int, size int) bool {
+	if ListpackMaxSize >= 0 {
+		return entries <= ListpackMaxSize
+	}
+	level := -ListpackMaxSize
+	if level > 5 {
+		level = 5
+	}
+	return size <= 4096<<(level-1)
+}
+
+// CompactList 是列表类型使用的实现
+//
+// 元素较少时保存在一个 listpack 中，超过 ListpackMaxSize 或写入的值不是 []byte 时转换为 QuickList，之后不再转回
+type CompactList struct {
+	lp *listpack.ListPack
+	ql *QuickList
+}
+
+func MakeCompact() *CompactList {
+	return &CompactList{
+		lp: listpack.Make(),
+	}
+}
+
+// 返回内部编码，listpack 或 quicklist
+func (list *CompactList) Encoding() string {
+	if list.lp != nil {
+		return "listpack"
+	}
+	return "quicklist"
+}
+
+// 写入 val 前调用，无法继续使用 listpack 编码时转换为 QuickList
+func (list *CompactList) prepareWrite(val interface{}, added int) {
+	if list.lp == nil {
+		return
+	}
+	bytes, ok := val.([]byte)
+	if ok && fitsListpack(list.lp.Len()+added, list.lp.Bytes()+len(bytes)+2) {
+		return
+	}
+	ql := NewQuickList()
+	list.lp.ForEach(func(i int, val []byte) bool {
+		ql.Add(append([]byte{}, val...))
+		return true
+	})
+	list.ql = ql
+	list.lp = nil
+}
+
+func (list *CompactList) Add(val interface{}) {
+	list.prepareWrite(val, 1)
+	if list.ql != nil {
+		list.ql.Add(val)
+		return
+	}
+	list.lp.Append(val.([]byte))
+}
+
+func (list *CompactList) AddFirst(val interface{}) {
+	list.prepareWrite(val, 1)
+	if list.ql != nil {
+		list.ql.AddFirst(val)
+		return
+	}
+	list.lp.Insert(0, val.([]byte))
+}
+
+func (list *CompactList) Get(index int) (val interface{}) {
+	if list.ql != nil {
+		return list.ql.Get(index)
+	}
+	return list.lp.Get(index)
+}
+
+func (list *CompactList) Set(index int, val interface{}) {
+	list.prepareWrite(val, 0)
+	if list.ql != nil {
+		list.ql.Set(index, val)
+		return
+	}
+	list.lp.Replace(index, val.([]byte))
+}
+
+func (list *CompactList) Insert(index int, val interface{}) {
+	list.prepareWrite(val, 1)
+	if list.ql != nil {
+		list.ql.Insert(index, val)
+		return
+	}
+	list.lp.Insert(index, val.([]byte))
+}
+
+func (list *CompactList) Remove(index int) (val interface{}) {
+	if list.ql != nil {
+		return list.ql.Remove(index)
+	}
+	val = list.lp.Get(index)
+	list.lp.Delete(index, 1)
+	return val
+}
+
+func (list *CompactList) RemoveFirst() (val interface{}) {
+	if list.ql != nil {
+		return list.ql.RemoveFirst()
+	}
+	if list.lp.Len() == 0 {
+		return nil
+	}
+	return list.Remove(0)
+}
+
+func (list *CompactList) RemoveLast() (val interface{}) {
+	if list.ql != nil {
+		return list.ql.RemoveLast()
+	}
+	if list.lp.Len() == 0 {
+		return nil
+	}
+	return list.Remove(list.lp.Len() - 1)
+}
+
+// 从前往后（reverse 为 false）或从后往前删除最多 count 个满足 expected 的元素，count 不大于 0 时删除全部
+func (list *CompactList) removeFromListpack(expected Expected, count int, reverse bool) int {
+	var indexes []int
+	list.lp.ForEach(func(i int, val []byte) bool {
+		if expected(val) {
+			indexes = append(indexes, i)
+		}
+		return true
+	})
+	if count > 0 && len(indexes) > count {
+		if reverse {
+			indexes = indexes[len(indexes)-count:]
+		} else {
+			indexes = indexes[:count]
+		}
+	}
+	// 从后往前删除，保证前面的下标不变
+	for i := len(indexes) - 1; i >= 0; i-- {
+		list.lp.Delete(indexes[i], 1)
+	}
+	return len(indexes)
+}
+
+func (list *CompactList) RemoveAllByVal(expected Expected) int {
+	if list.ql != nil {
+		return list.ql.RemoveAllByVal(expected)
+	}
+	return list.removeFromListpack(expected, 0, false)
+}
+
+func (list *CompactList) RemoveByVal(expected Expected, count int) int {
+	if list.ql != nil {
+		return list.ql.RemoveByVal(expected, count)
+	}
+	return list.removeFromListpack(expected, count, false)
+}
+
+func (list *CompactList) ReverseRemoveByVal(expected Expected, count int) int {
+	if list.ql != nil {
+		return list.ql.ReverseRemoveByVal(expected, count)
+	}
+	return list.removeFromListpack(expected, count, true)
+}
+
+func (list *CompactList) Len() int {
+	if list.ql != nil {
+		return list.ql.Len()
+	}
+	return list.lp.Len()
+}
+
+// listpack 编码时传给 consumer 的是元素的副本
+func (list *CompactList) ForEach(consumer Consumer) {
+	if list.ql != nil {
+		list.ql.ForEach(consumer)
+		return
+	}
+	list.lp.ForEach(func(i int, val []byte) bool {
+		return consumer(i, append([]byte{}, val...))
+	})
+}
+
+// listpack 只能从前往后解码，先记录所有元素再倒序遍历，传给 consumer 的是元素的副本
+func (list *CompactList) ReverseForEach(consumer Consumer) {
+	if list.ql != nil {
+		list.ql.ReverseForEach(consumer)
+		return
+	}
+	vals := make([][]byte, 0, list.lp.Len())
+	list.lp.ForEach(func(i int, val []byte) bool {
+		vals = append(vals, val)
+		return true
+	})
+	for i := len(vals) - 1; i >= 0; i-- {
+		if !consumer(i, append([]byte{}, vals[i]...)) {
+			return
+		}
+	}
+}
+
+func (list *CompactList) Contains(expected Expected) bool {
+	if list.ql != nil {
+		return list.ql.Contains(expected)
+	}
+	contains := false
+	list.lp.ForEach(func(i int, val []byte) bool {
+		contains = expected(val)
+		return !contains
+	})
+	return contains
+}
+
+func (list *CompactList) Range(start int, stop int) []interface{} {
+	if list.ql != nil {
+		return list.ql.Range(start, stop)
+	}
+	if start < 0 || start >= list.Len() {
+		panic("`start` out of range")
+	}
+	if stop < start || stop > list.Len() {
+		panic("`stop` out of range")
+	}
+	slice := make([]interface{}, 0, stop-start)
+	list.lp.ForEach(func(i int, val []byte) bool {
+		if i >= stop {
+			return false
+		}
+		if i >= start {
+			slice = append(slice, append([]byte{}, val...))
+		}
+		return true
+	})
+	return slice
+}
+
+var _ = (List)(&CompactList{})
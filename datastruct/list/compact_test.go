@@ -0,0 +1,85 @@
+package list
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompactList(t *testing.T) {
+	list := MakeCompact()
+	for i := 0; i < 10; i++ {
+		list.Add([]byte(strconv.Itoa(i)))
+	}
+	list.Insert(0, []byte("head"))
+	list.Set(1, []byte("zero"))
+	if string(list.Get(0).([]byte)) != "head" || string(list.Get(1).([]byte)) != "zero" {
+		t.Error("wrong value after insert and set")
+	}
+	if string(list.RemoveLast().([]byte)) != "9" || list.Len() != 10 {
+		t.Error("RemoveLast failed")
+	}
+	removed := list.RemoveAllByVal(func(a interface{}) bool {
+		return string(a.([]byte)) == "zero"
+	})
+	if removed != 1 || list.Len() != 9 {
+		t.Error("RemoveAllByVal failed")
+	}
+	if list.Encoding() != "listpack" {
+		t.Errorf("expect listpack, actually %s", list.Encoding())
+	}
+
+	// 超过 8KB 时转换为 quicklist，并保留已有的元素
+	list.Add([]byte(strings.Repeat("a", 8192)))
+	if list.Encoding() != "quicklist" || list.Len() != 10 {
+		t.Errorf("expect quicklist with 10 entries, actually %s with %d", list.Encoding(), list.Len())
+	}
+	values := list.Range(0, 3)
+	if string(values[0].([]byte)) != "head" || string(values[2].([]byte)) != "2" {
+		t.Error("lost values after conversion")
+	}
+}
+
+func TestCompactListRemoveByVal(t *testing.T) {
+	list := MakeCompact()
+	for _, val := range []string{"a", "b", "a", "c", "a"} {
+		list.Add([]byte(val))
+	}
+	isA := func(a interface{}) bool {
+		return string(a.([]byte)) == "a"
+	}
+	if list.ReverseRemoveByVal(isA, 1) != 1 || string(list.Get(3).([]byte)) != "c" {
+		t.Error("ReverseRemoveByVal should remove the last one")
+	}
+	if list.RemoveByVal(isA, 1) != 1 || string(list.Get(0).([]byte)) != "b" {
+		t.Error("RemoveByVal should remove the first one")
+	}
+	if !list.Contains(isA) || list.Len() != 3 {
+		t.Error("wrong content")
+	}
+}
+
+func TestCompactListHeadTail(t *testing.T) {
+	list := MakeCompact()
+	for i := 0; i < 5; i++ {
+		list.AddFirst([]byte(strconv.Itoa(i)))
+	}
+	var reversed []string
+	list.ReverseForEach(func(i int, v interface{}) bool {
+		reversed = append(reversed, strconv.Itoa(i)+":"+string(v.([]byte)))
+		return true
+	})
+	if strings.Join(reversed, ",") != "4:0,3:1,2:2,1:3,0:4" {
+		t.Errorf("wrong reverse order %v", reversed)
+	}
+	if string(list.RemoveFirst().([]byte)) != "4" || list.Len() != 4 {
+		t.Error("RemoveFirst failed")
+	}
+	list.AddFirst(1)
+	if list.Encoding() != "quicklist" || list.RemoveFirst() != 1 {
+		t.Error("AddFirst failed after conversion")
+	}
+	if string(list.RemoveFirst().([]byte)) != "3" {
+		t.Error("RemoveFirst failed after conversion")
+	}
+}
@@ -30,6 +30,23 @@ func (list *LinkedList) Add(val interface{}) {
 	list.size++
 }
 
+func (list *LinkedList) AddFirst(val interface{}) {
+	if list == nil {
+		panic("list is nil")
+	}
+	n := &node{
+		val:  val,
+		next: list.first,
+	}
+	if list.first == nil {
+		list.last = n
+	} else {
+		list.first.prev = n
+	}
+	list.first = n
+	list.size++
+}
+
 func (list *LinkedList) find(index int) (n *node) {
 	if index < list.size/2 {
 		n = list.first
@@ -125,6 +142,18 @@ func (list *LinkedList) Remove(index int) (val interface{}) {
 	return n.val
 }
 
+func (list *LinkedList) RemoveFirst() (val interface{}) {
+	if list == nil {
+		panic("list is nil")
+	}
+	if list.first == nil {
+		return nil
+	}
+	node := list.first
+	list.removeNode(node)
+	return node.val
+}
+
 func (list *LinkedList) RemoveLast() (val interface{}) {
 	if list == nil {
 		panic("list is nil")
@@ -221,6 +250,22 @@ func (list *LinkedList) ForEach(consumer Consumer) {
 	}
 }
 
+// 从尾部向头部遍历，consumer 收到的 i 仍是元素从头部开始的下标
+func (list *LinkedList) ReverseForEach(consumer Consumer) {
+	if list == nil {
+		panic("list is nil")
+	}
+	n := list.last
+	i := list.size - 1
+	for n != nil {
+		if !consumer(i, n.val) {
+			break
+		}
+		i--
+		n = n.prev
+	}
+}
+
 func (list *LinkedList) Contains(expected Expected) bool {
 	contains := false
 	list.ForEach(func(i int, v interface{}) bool {
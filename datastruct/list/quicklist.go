@@ -42,6 +42,27 @@ func (ql *QuickList) Add(val interface{}) {
 	backNode.Value = backPage
 }
 
+// 在头部插入元素，第一页已满时在前面新建一页
+func (ql *QuickList) AddFirst(val interface{}) {
+	if ql.data.Len() == 0 {
+		ql.Add(val)
+		return
+	}
+	ql.size++
+	frontNode := ql.data.Front()
+	frontPage := frontNode.Value.([]interface{})
+	if len(frontPage) >= pagesize {
+		page := make([]interface{}, 0, pagesize)
+		page = append(page, val)
+		ql.data.PushFront(page)
+		return
+	}
+	frontPage = append(frontPage, nil)
+	copy(frontPage[1:], frontPage)
+	frontPage[0] = val
+	frontNode.Value = frontPage
+}
+
 func (ql *QuickList) find(index int) *iterator {
 	if ql == nil {
 		panic("list is nil")
@@ -238,6 +259,25 @@ func (ql *QuickList) Len() int {
 	return ql.size
 }
 
+// 移除并返回第一个元素，列表为空时返回 nil
+func (ql *QuickList) RemoveFirst() interface{} {
+	if ql.Len() == 0 {
+		return nil
+	}
+	ql.size--
+	firstNode := ql.data.Front()
+	firstPage := firstNode.Value.([]interface{})
+	val := firstPage[0]
+	if len(firstPage) == 1 {
+		ql.data.Remove(firstNode)
+		return val
+	}
+	// 清除引用，避免底层数组继续持有被移除的元素
+	firstPage[0] = nil
+	firstNode.Value = firstPage[1:]
+	return val
+}
+
 func (ql *QuickList) RemoveLast() interface{} {
 	if ql.Len() == 0 {
 		return nil
@@ -335,6 +375,25 @@ func (ql *QuickList) ForEach(consumer Consumer) {
 	}
 }
 
+// 从尾部向头部遍历，consumer 收到的 i 仍是元素从头部开始的下标
+func (ql *QuickList) ReverseForEach(consumer Consumer) {
+	if ql == nil {
+		panic("list is nil")
+	}
+	if ql.Len() == 0 {
+		return
+	}
+	iter := ql.find(ql.size - 1)
+	for i := ql.size - 1; ; i-- {
+		if !consumer(i, iter.get()) {
+			break
+		}
+		if !iter.prev() {
+			break
+		}
+	}
+}
+
 func (ql *QuickList) Contains(expected Expected) bool {
 	if ql == nil {
 		panic("list is nil")
@@ -193,3 +193,59 @@ func TestQuickList_Contains(t *testing.T) {
 		t.Error("expect false actual true")
 	}
 }
+
+func TestQuickList_HeadTail(t *testing.T) {
+	// 跨越多个页，覆盖在头部新建页以及移除整页的情况
+	size := pagesize*3 + 10
+	list := NewQuickList()
+	expected := Make()
+	for i := 0; i < size; i++ {
+		if i%3 == 0 {
+			list.Add(i)
+			expected.Add(i)
+		} else {
+			list.AddFirst(i)
+			expected.AddFirst(i)
+		}
+	}
+	if list.Len() != size {
+		t.Errorf("wrong len %d", list.Len())
+	}
+	for i := 0; i < size; i += 97 {
+		if list.Get(i) != expected.Get(i) {
+			t.Errorf("wrong value at %d", i)
+		}
+	}
+	next := size - 1
+	list.ReverseForEach(func(i int, v interface{}) bool {
+		if i != next || v != expected.Get(i) {
+			t.Errorf("wrong value at %d", i)
+		}
+		next--
+		return true
+	})
+	if next != -1 {
+		t.Error("ReverseForEach stopped early")
+	}
+	for i := 0; i < size; i++ {
+		var actual interface{}
+		if i%2 == 0 {
+			actual = list.RemoveFirst()
+			if actual != expected.RemoveFirst() {
+				t.Errorf("wrong first value %v", actual)
+			}
+		} else {
+			actual = list.RemoveLast()
+			if actual != expected.RemoveLast() {
+				t.Errorf("wrong last value %v", actual)
+			}
+		}
+	}
+	if list.Len() != 0 || list.RemoveFirst() != nil {
+		t.Error("expect empty list")
+	}
+	list.AddFirst(1)
+	if list.Get(0) != 1 {
+		t.Error("AddFirst on empty list failed")
+	}
+}
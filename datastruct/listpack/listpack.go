@@ -0,0 +1,165 @@
+// Package listpack 实现了一种紧凑的字节串序列，用于元素较少的哈希、列表、集合与有序集合
+//
+// 所有元素依次保存在一段连续的内存中，每个元素编码为 <uvarint 长度><内容>，
+// 相比每个元素单独分配对象并由 map 或链表索引，内存开销小得多。
+// 按下标访问与查找需要从头扫描，因此只适合元素较少的场景
+package listpack
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+type ListPack struct {
+	buf  []byte
+	size int
+}
+
+func Make(vals ...[]byte) *ListPack {
+	lp := &ListPack{}
+	lp.Append(vals...)
+	return lp
+}
+
+// 元素个数
+func (lp *ListPack) Len() int {
+	return lp.size
+}
+
+// 编码后占用的字节数
+func (lp *ListPack) Bytes() int {
+	return len(lp.buf)
+}
+
+// 从 offset 处解码一个元素，返回元素内容与下一个元素的位置
+func (lp *ListPack) entryAt(offset int) ([]byte, int) {
+	n, width := binary.Uvarint(lp.buf[offset:])
+	start := offset + width
+	end := start + int(n)
+	return lp.buf[start:end:end], end
+}
+
+// 第 index 个元素的起始位置，index 等于 Len() 时返回末尾
+func (lp *ListPack) offset(index int) int {
+	if index < 0 || index > lp.size {
+		panic("listpack index out of range")
+	}
+	offset := 0
+	for i := 0; i < index; i++ {
+		_, offset = lp.entryAt(offset)
+	}
+	return offset
+}
+
+func encodeEntries(vals [][]byte) []byte {
+	size := 0
+	for _, val := range vals {
+		size += binary.MaxVarintLen64 + len(val)
+	}
+	buf := make([]byte, 0, size)
+	for _, val := range vals {
+		buf = binary.AppendUvarint(buf, uint64(len(val)))
+		buf = append(buf, val...)
+	}
+	return buf
+}
+
+// 返回第 index 个元素的副本
+func (lp *ListPack) Get(index int) []byte {
+	if index < 0 || index >= lp.size {
+		panic("listpack index out of range")
+	}
+	val, _ := lp.entryAt(lp.offset(index))
+	return append([]byte{}, val...)
+}
+
+// 依次遍历元素，val 直接引用内部内存，只在回调期间有效且不能修改
+func (lp *ListPack) ForEach(consumer func(i int, val []byte) bool) {
+	offset := 0
+	for i := 0; i < lp.size; i++ {
+		var val []byte
+		val, offset = lp.entryAt(offset)
+		if !consumer(i, val) {
+			return
+		}
+	}
+}
+
+// 从下标 start 开始，每隔 step 个元素比较一次，返回第一个等于 val 的元素下标，不存在时返回 -1
+//
+// 例如哈希类型按 field, value 交替保存，使用 Find(field, 0, 2) 查找 field
+func (lp *ListPack) Find(val []byte, start int, step int) int {
+	result := -1
+	lp.ForEach(func(i int, entry []byte) bool {
+		if i >= start && (i-start)%step == 0 && bytes.Equal(entry, val) {
+			result = i
+			return false
+		}
+		return true
+	})
+	return result
+}
+
+// 在末尾追加元素
+func (lp *ListPack) Append(vals ...[]byte) {
+	for _, val := range vals {
+		lp.buf = binary.AppendUvarint(lp.buf, uint64(len(val)))
+		lp.buf = append(lp.buf, val...)
+	}
+	lp.size += len(vals)
+}
+
+// 在第 index 个元素之前插入元素，index 等于 Len() 时追加到末尾
+func (lp *ListPack) Insert(index int, vals ...[]byte) {
+	offset := lp.offset(index)
+	if offset == len(lp.buf) {
+		lp.Append(vals...)
+		return
+	}
+	inserted := encodeEntries(vals)
+	lp.buf = append(lp.buf, inserted...)
+	copy(lp.buf[offset+len(inserted):], lp.buf[offset:])
+	copy(lp.buf[offset:], inserted)
+	lp.size += len(vals)
+}
+
+// 替换第 index 个元素
+func (lp *ListPack) Replace(index int, val []byte) {
+	if index < 0 || index >= lp.size {
+		panic("listpack index out of range")
+	}
+	start := lp.offset(index)
+	_, end := lp.entryAt(start)
+	replaced := encodeEntries([][]byte{val})
+	tail := lp.buf[end:]
+	buf := make([]byte, 0, start+len(replaced)+len(tail))
+	buf = append(buf, lp.buf[:start]...)
+	buf = append(buf, replaced...)
+	lp.buf = append(buf, tail...)
+}
+
+// 删除从 index 开始的 count 个元素
+func (lp *ListPack) Delete(index int, count int) {
+	if count <= 0 {
+		return
+	}
+	if index < 0 || index+count > lp.size {
+		panic("listpack index out of range")
+	}
+	start := lp.offset(index)
+	end := start
+	for i := 0; i < count; i++ {
+		_, end = lp.entryAt(end)
+	}
+	lp.buf = append(lp.buf[:start], lp.buf[end:]...)
+	lp.size -= count
+	if lp.size == 0 {
+		lp.buf = nil
+	}
+}
+
+// 清空所有元素
+func (lp *ListPack) Clear() {
+	lp.buf = nil
+	lp.size = 0
+}
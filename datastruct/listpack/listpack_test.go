@@ -0,0 +1,72 @@
+package listpack
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func toStrings(lp *ListPack) []string {
+	var result []string
+	lp.ForEach(func(i int, val []byte) bool {
+		result = append(result, string(val))
+		return true
+	})
+	return result
+}
+
+func TestListPack(t *testing.T) {
+	lp := Make([]byte("a"), []byte(""), []byte(strings.Repeat("x", 300)))
+	if lp.Len() != 3 || string(lp.Get(2)) != strings.Repeat("x", 300) || len(lp.Get(1)) != 0 {
+		t.Fatalf("wrong content %q", toStrings(lp))
+	}
+	lp.Insert(0, []byte("first"))
+	lp.Insert(2, []byte("b"), []byte("c"))
+	lp.Insert(lp.Len(), []byte("last"))
+	expected := []string{"first", "a", "b", "c", "", strings.Repeat("x", 300), "last"}
+	if strings.Join(toStrings(lp), ",") != strings.Join(expected, ",") {
+		t.Errorf("wrong content after insert %q", toStrings(lp))
+	}
+
+	lp.Replace(5, []byte("y"))
+	lp.Replace(0, []byte(strings.Repeat("z", 200)))
+	lp.Delete(1, 2)
+	expected = []string{strings.Repeat("z", 200), "c", "", "y", "last"}
+	if strings.Join(toStrings(lp), ",") != strings.Join(expected, ",") {
+		t.Errorf("wrong content after replace and delete %q", toStrings(lp))
+	}
+	if lp.Find([]byte("y"), 0, 1) != 3 || lp.Find([]byte("y"), 0, 2) != -1 || lp.Find([]byte("y"), 1, 2) != 3 {
+		t.Error("wrong find result")
+	}
+
+	// 返回的是副本
+	val := lp.Get(1)
+	val[0] = 'd'
+	if string(lp.Get(1)) != "c" {
+		t.Error("Get should return a copy")
+	}
+
+	lp.Delete(0, lp.Len())
+	if lp.Len() != 0 || lp.Bytes() != 0 {
+		t.Error("expect empty listpack")
+	}
+}
+
+func TestListPackRandom(t *testing.T) {
+	lp := Make()
+	var expected []string
+	for i := 0; i < 500; i++ {
+		val := strconv.Itoa(i * 7919 % 1000)
+		index := (i * 31) % (len(expected) + 1)
+		lp.Insert(index, []byte(val))
+		expected = append(expected[:index], append([]string{val}, expected[index:]...)...)
+		if i%3 == 0 {
+			remove := (i * 17) % len(expected)
+			lp.Delete(remove, 1)
+			expected = append(expected[:remove], expected[remove+1:]...)
+		}
+	}
+	if strings.Join(toStrings(lp), ",") != strings.Join(expected, ",") {
+		t.Error("wrong content after random operations")
+	}
+}
@@ -0,0 +1,124 @@
+package set
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+)
+
+// intSet 是只包含整数的有序数组，与 Redis 的 intset 相同：
+// 所有元素使用相同的宽度（2、4 或 8 字节）紧凑保存，插入更大的整数时整体升级宽度
+type intSet struct {
+	width    int
+	contents []byte
+}
+
+func makeIntSet() *intSet {
+	return &intSet{width: 2}
+}
+
+// 返回 member 对应的整数，只有规范格式的整数才能保存在 intset 中，例如 "01" 和 "+1" 不能
+func parseIntMember(member string) (int64, bool) {
+	if len(member) == 0 || len(member) > 20 {
+		return 0, false
+	}
+	value, err := strconv.ParseInt(member, 10, 64)
+	if err != nil || strconv.FormatInt(value, 10) != member {
+		return 0, false
+	}
+	return value, true
+}
+
+func widthOf(value int64) int {
+	if value >= math.MinInt16 && value <= math.MaxInt16 {
+		return 2
+	} else if value >= math.MinInt32 && value <= math.MaxInt32 {
+		return 4
+	}
+	return 8
+}
+
+func (is *intSet) len() int {
+	return len(is.contents) / is.width
+}
+
+func (is *intSet) get(index int) int64 {
+	buf := is.contents[index*is.width:]
+	switch is.width {
+	case 2:
+		return int64(int16(binary.LittleEndian.Uint16(buf)))
+	case 4:
+		return int64(int32(binary.LittleEndian.Uint32(buf)))
+	}
+	return int64(binary.LittleEndian.Uint64(buf))
+}
+
+func (is *intSet) set(index int, value int64) {
+	buf := is.contents[index*is.width:]
+	switch is.width {
+	case 2:
+		binary.LittleEndian.PutUint16(buf, uint16(value))
+	case 4:
+		binary.LittleEndian.PutUint32(buf, uint32(value))
+	default:
+		binary.LittleEndian.PutUint64(buf, uint64(value))
+	}
+}
+
+// 二分查找，返回 value 的位置或应当插入的位置
+func (is *intSet) search(value int64) (int, bool) {
+	low, high := 0, is.len()-1
+	for low <= high {
+		mid := (low + high) / 2
+		current := is.get(mid)
+		if current == value {
+			return mid, true
+		} else if current < value {
+			low = mid + 1
+		} else {
+			high = mid - 1
+		}
+	}
+	return low, false
+}
+
+// 将所有元素升级为更大的宽度
+func (is *intSet) upgrade(width int) {
+	upgraded := &intSet{
+		width:    width,
+		contents: make([]byte, is.len()*width),
+	}
+	for i := 0; i < is.len(); i++ {
+		upgraded.set(i, is.get(i))
+	}
+	*is = *upgraded
+}
+
+func (is *intSet) add(value int64) bool {
+	if width := widthOf(value); width > is.width {
+		is.upgrade(width)
+	}
+	pos, found := is.search(value)
+	if found {
+		return false
+	}
+	size := is.len()
+	is.contents = append(is.contents, make([]byte, is.width)...)
+	copy(is.contents[(pos+1)*is.width:], is.contents[pos*is.width:size*is.width])
+	is.set(pos, value)
+	return true
+}
+
+func (is *intSet) remove(value int64) bool {
+	pos, found := is.search(value)
+	if !found {
+		return false
+	}
+	is.contents = append(is.contents[:pos*is.width], is.contents[(pos+1)*is.width:]...)
+	return true
+}
+
+func (is *intSet) has(value int64) bool {
+	_, found := is.search(value)
+	return found
+}
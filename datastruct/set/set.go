@@ -1,18 +1,34 @@
 package set
 
 import (
+	"math/rand"
 	"myredis/datastruct/dict"
+	"myredis/datastruct/listpack"
 	"myredis/lib/wildcard"
+	"strconv"
 )
 
+// 集合使用紧凑编码的阈值，对应 set-max-intset-entries、set-max-listpack-entries 与 set-max-listpack-value
+var (
+	IntsetMaxEntries   = 512
+	ListpackMaxEntries = 128
+	ListpackMaxValue   = 64
+)
+
+// Set 根据元素选择内部编码，ints、lp 与 dict 中只有一个不为 nil：
+//   - 只包含整数且个数不超过 IntsetMaxEntries 时使用 intset
+//   - 元素个数与长度不超过 listpack 的阈值时使用 listpack
+//   - 否则使用 dict，之后不再转回紧凑编码
 type Set struct {
+	ints *intSet
+	lp   *listpack.ListPack
 	dict dict.Dict
 }
 
 /* Set 可使用两种低层实现，Simple Dict 和 Concurrent Dict */
 func Make(members ...string) *Set {
 	set := &Set{
-		dict: dict.MakeSimple(),
+		ints: makeIntSet(),
 	}
 	for _, member := range members {
 		set.Add(member)
@@ -20,6 +36,7 @@ func Make(members ...string) *Set {
 	return set
 }
 
+// 并发安全的集合始终使用 Concurrent Dict
 func MakeConcurrentSafe(members ...string) *Set {
 	set := &Set{
 		dict: dict.MakeConcurrent(1),
@@ -30,47 +47,145 @@ func MakeConcurrentSafe(members ...string) *Set {
 	return set
 }
 
+// 返回内部编码，intset、listpack 或 hashtable
+func (s *Set) Encoding() string {
+	if s.ints != nil {
+		return "intset"
+	} else if s.lp != nil {
+		return "listpack"
+	}
+	return "hashtable"
+}
+
+// 判断加入 val 后是否可以使用 listpack 编码
+func (s *Set) fitsListpack(val string) bool {
+	if s.Len()+1 > ListpackMaxEntries || len(val) > ListpackMaxValue {
+		return false
+	}
+	fits := true
+	s.ForEach(func(member string) bool {
+		fits = len(member) <= ListpackMaxValue
+		return fits
+	})
+	return fits
+}
+
+func (s *Set) toListpack() {
+	lp := listpack.Make()
+	s.ForEach(func(member string) bool {
+		lp.Append([]byte(member))
+		return true
+	})
+	s.ints = nil
+	s.lp = lp
+}
+
+func (s *Set) toDict() {
+	simple := dict.MakeSimple()
+	s.ForEach(func(member string) bool {
+		simple.Put(member, nil)
+		return true
+	})
+	s.ints = nil
+	s.lp = nil
+	s.dict = simple
+}
+
 func (s *Set) Add(val string) int {
+	if s.ints != nil {
+		if value, ok := parseIntMember(val); ok {
+			if s.ints.has(value) {
+				return 0
+			}
+			if s.ints.len()+1 <= IntsetMaxEntries {
+				s.ints.add(value)
+				return 1
+			}
+			s.toDict()
+		} else if s.fitsListpack(val) {
+			s.toListpack()
+		} else {
+			s.toDict()
+		}
+	}
+	if s.lp != nil {
+		if s.lp.Find([]byte(val), 0, 1) >= 0 {
+			return 0
+		}
+		if s.lp.Len()+1 <= ListpackMaxEntries && len(val) <= ListpackMaxValue {
+			s.lp.Append([]byte(val))
+			return 1
+		}
+		s.toDict()
+	}
 	return s.dict.Put(val, nil)
 }
 
 func (s *Set) Remove(val string) int {
+	if s.ints != nil {
+		value, ok := parseIntMember(val)
+		if ok && s.ints.remove(value) {
+			return 1
+		}
+		return 0
+	} else if s.lp != nil {
+		index := s.lp.Find([]byte(val), 0, 1)
+		if index < 0 {
+			return 0
+		}
+		s.lp.Delete(index, 1)
+		return 1
+	}
 	_, ret := s.dict.Remove(val)
 	return ret
 }
 
 func (s *Set) Has(val string) bool {
-	if s == nil || s.dict == nil {
+	if s == nil {
 		return false
 	}
+	if s.ints != nil {
+		value, ok := parseIntMember(val)
+		return ok && s.ints.has(value)
+	} else if s.lp != nil {
+		return s.lp.Find([]byte(val), 0, 1) >= 0
+	}
 	_, exists := s.dict.Get(val)
 	return exists
 }
 
 func (s *Set) Len() int {
-	if s == nil || s.dict == nil {
+	if s == nil {
 		return 0
 	}
+	if s.ints != nil {
+		return s.ints.len()
+	} else if s.lp != nil {
+		return s.lp.Len()
+	}
 	return s.dict.Len()
 }
 
 func (s *Set) ToSlice() []string {
-	slice := make([]string, s.Len())
-	i := 0
-	s.dict.ForEach(func(key string, val interface{}) bool {
-		if i < len(slice) {
-			slice[i] = key
-		} else {
-			slice = append(slice, key)
-		}
-		i++
+	slice := make([]string, 0, s.Len())
+	s.ForEach(func(member string) bool {
+		slice = append(slice, member)
 		return true
 	})
 	return slice
 }
 
 func (s *Set) ForEach(consumer func(member string) bool) {
-	if s == nil || s.dict == nil {
+	if s == nil {
+		return
+	}
+	if s.ints != nil || s.lp != nil {
+		// 先复制出全部元素，允许在 consumer 中修改集合
+		for _, member := range s.compactMembers() {
+			if !consumer(member) {
+				return
+			}
+		}
 		return
 	}
 	s.dict.ForEach(func(key string, val interface{}) bool {
@@ -78,6 +193,22 @@ func (s *Set) ForEach(consumer func(member string) bool) {
 	})
 }
 
+func (s *Set) compactMembers() []string {
+	if s.ints != nil {
+		members := make([]string, s.ints.len())
+		for i := range members {
+			members[i] = strconv.FormatInt(s.ints.get(i), 10)
+		}
+		return members
+	}
+	members := make([]string, 0, s.lp.Len())
+	s.lp.ForEach(func(i int, val []byte) bool {
+		members = append(members, string(val))
+		return true
+	})
+	return members
+}
+
 func (s *Set) ShallowCopy() *Set {
 	result := Make()
 	s.ForEach(func(member string) bool {
@@ -140,28 +271,103 @@ func Diff(sets ...*Set) *Set {
 	return result
 }
 
+// IntersectCard 返回交集的元素个数，limit 大于 0 时计数达到 limit 后立即返回
+//
+// 遍历最小的集合并在其余集合中查找，不构造交集本身
+func IntersectCard(limit int, sets ...*Set) int {
+	if len(sets) == 0 {
+		return 0
+	}
+	smallest := 0
+	for i, set := range sets {
+		if set.Len() == 0 {
+			return 0
+		}
+		if set.Len() < sets[smallest].Len() {
+			smallest = i
+		}
+	}
+	count := 0
+	sets[smallest].ForEach(func(member string) bool {
+		for i, set := range sets {
+			if i != smallest && !set.Has(member) {
+				return true
+			}
+		}
+		count++
+		return limit <= 0 || count < limit
+	})
+	return count
+}
+
 func (s *Set) RandomMembers(limit int) []string {
-	if s == nil || s.dict == nil {
+	if s == nil {
 		return nil
 	}
-	return s.dict.RandomKeys(limit)
+	if s.dict != nil {
+		return s.dict.RandomKeys(limit)
+	}
+	members := s.ToSlice()
+	if len(members) == 0 {
+		return make([]string, 0)
+	}
+	result := make([]string, limit)
+	for i := range result {
+		result[i] = members[rand.Intn(len(members))]
+	}
+	return result
 }
 
 func (s *Set) RandomDistinctMembers(limit int) []string {
-	return s.dict.RandomDistinctKeys(limit)
+	if s.dict != nil {
+		return s.dict.RandomDistinctKeys(limit)
+	}
+	members := s.ToSlice()
+	rand.Shuffle(len(members), func(i, j int) {
+		members[i], members[j] = members[j], members[i]
+	})
+	if limit < len(members) {
+		members = members[:limit]
+	}
+	return members
 }
 
+// Pop 随机移除至多 count 个不重复的元素并返回
+func (s *Set) Pop(count int) []string {
+	if s.Len() == 0 || count <= 0 {
+		return nil
+	}
+	members := s.RandomDistinctMembers(count)
+	for _, member := range members {
+		s.Remove(member)
+	}
+	return members
+}
+
+// SetScan 紧凑编码时一次返回全部元素，dict 编码时使用反向二进制游标增量遍历
 func (s *Set) SetScan(cursor int, count int, pattern string) ([][]byte, int) {
 	result := make([][]byte, 0)
 	matchKey, err := wildcard.CompilePattern(pattern)
 	if err != nil {
 		return result, -1
 	}
-	s.ForEach(func(member string) bool {
+	var members []string
+	nextCursor := 0
+	if simple, ok := s.dict.(*dict.SimpleDict); ok {
+		members, nextCursor = simple.ScanKeys(cursor, count)
+	} else if s.dict != nil {
+		keys, next := s.dict.DictScan(cursor, count, "*")
+		for _, key := range keys {
+			members = append(members, string(key))
+		}
+		nextCursor = next
+	} else {
+		members = s.ToSlice()
+	}
+	for _, member := range members {
 		if pattern == "*" || matchKey.IsMatch(member) {
 			result = append(result, []byte(member))
 		}
-		return true
-	})
-	return result, 0
+	}
+	return result, nextCursor
 }
@@ -0,0 +1,89 @@
+package set
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestIntSet(t *testing.T) {
+	s := Make()
+	for _, member := range []string{"5", "-3", "70000", "1", "5"} {
+		s.Add(member)
+	}
+	if s.Encoding() != "intset" || s.Len() != 4 {
+		t.Errorf("expect intset with 4 members, actually %s with %d", s.Encoding(), s.Len())
+	}
+	// intset 按从小到大的顺序保存
+	expected := []string{"-3", "1", "5", "70000"}
+	for i, member := range s.ToSlice() {
+		if member != expected[i] {
+			t.Errorf("expect %s, actually %s", expected[i], member)
+		}
+	}
+	// 非规范格式的整数不能保存在 intset 中
+	if s.Has("05") || s.Remove("+5") != 0 {
+		t.Error("non-canonical integers should not match")
+	}
+	s.Add("05")
+	if s.Encoding() != "listpack" || !s.Has("05") || !s.Has("5") || s.Len() != 5 {
+		t.Error("expect listpack containing both 5 and 05")
+	}
+}
+
+func TestSetConversion(t *testing.T) {
+	s := Make()
+	for i := 0; i <= IntsetMaxEntries; i++ {
+		s.Add(strconv.Itoa(i))
+	}
+	if s.Encoding() != "hashtable" || s.Len() != IntsetMaxEntries+1 {
+		t.Errorf("expect hashtable with %d members", IntsetMaxEntries+1)
+	}
+
+	s = Make("a")
+	for i := 0; i < ListpackMaxEntries; i++ {
+		s.Add(strconv.Itoa(i))
+	}
+	if s.Encoding() != "hashtable" || s.Len() != ListpackMaxEntries+1 || !s.Has("a") {
+		t.Errorf("expect hashtable with %d members", ListpackMaxEntries+1)
+	}
+
+	a := Make("1", "2", "3")
+	b := Make("2", "3", "x")
+	if Intersect(a, b).Len() != 2 || Union(a, b).Len() != 4 || Diff(a, b).Len() != 1 {
+		t.Error("wrong result of set algebra")
+	}
+	if len(a.RandomDistinctMembers(5)) != 3 || len(a.RandomMembers(5)) != 5 {
+		t.Error("wrong number of random members")
+	}
+}
+
+func TestIntersectCard(t *testing.T) {
+	a := Make("1", "2", "3", "4", "x")
+	b := Make("2", "3", "4", "x", "y")
+	c := Make("3", "4", "x")
+	if n := IntersectCard(0, a, b, c); n != 3 {
+		t.Errorf("expect 3, actually %d", n)
+	}
+	if n := IntersectCard(2, a, b, c); n != 2 {
+		t.Errorf("expect 2 with limit, actually %d", n)
+	}
+	if n := IntersectCard(0, a, b, Make()); n != 0 {
+		t.Errorf("expect 0 with empty set, actually %d", n)
+	}
+}
+
+func TestPop(t *testing.T) {
+	s := Make("a", "b", "c")
+	popped := s.Pop(2)
+	if len(popped) != 2 || s.Len() != 1 {
+		t.Errorf("expect 2 popped and 1 left, actually %d and %d", len(popped), s.Len())
+	}
+	for _, member := range popped {
+		if s.Has(member) {
+			t.Errorf("%s should be removed", member)
+		}
+	}
+	if popped = s.Pop(5); len(popped) != 1 || s.Len() != 0 {
+		t.Error("expect the last member popped")
+	}
+}
@@ -0,0 +1,123 @@
+package sortedset
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// 有序集合使用 listpack 编码的阈值，对应 zset-max-listpack-entries 与 zset-max-listpack-value
+var (
+	ListpackMaxEntries = 128
+	ListpackMaxValue   = 64
+)
+
+// listpack 编码时 member 与 score 交替保存，并按 (score, member) 升序排列，
+// score 保存为 8 字节的 IEEE 754 表示。读取时解码为 []*Element，修改后整体重新编码
+
+func encodeScore(score float64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, math.Float64bits(score))
+	return buf
+}
+
+func decodeScore(buf []byte) float64 {
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf))
+}
+
+// 按顺序解码全部元素，返回的元素可以被调用方持有
+func (sortedSet *SortedSet) lpElements() []*Element {
+	elements := make([]*Element, 0, sortedSet.lp.Len()/2)
+	var member string
+	sortedSet.lp.ForEach(func(i int, val []byte) bool {
+		if i%2 == 0 {
+			member = string(val)
+		} else {
+			elements = append(elements, &Element{
+				Member: member,
+				Score:  decodeScore(val),
+			})
+		}
+		return true
+	})
+	return elements
+}
+
+func (sortedSet *SortedSet) lpStore(elements []*Element) {
+	sortedSet.lp.Clear()
+	for _, element := range elements {
+		sortedSet.lp.Append([]byte(element.Member), encodeScore(element.Score))
+	}
+}
+
+// 返回 member 在 elements 中的下标，不存在时返回 -1
+func lpIndexOf(elements []*Element, member string) int {
+	for i, element := range elements {
+		if element.Member == member {
+			return i
+		}
+	}
+	return -1
+}
+
+// 返回 element 按 (score, member) 排序后应当插入的位置
+func lpSearch(elements []*Element, element *Element) int {
+	return sort.Search(len(elements), func(i int) bool {
+		current := elements[i]
+		if current.Score != element.Score {
+			return current.Score > element.Score
+		}
+		return current.Member > element.Member
+	})
+}
+
+// 转换为 dict + skiplist 编码，之后不再转回
+func (sortedSet *SortedSet) convert() {
+	elements := sortedSet.lpElements()
+	sortedSet.lp = nil
+	sortedSet.dict = make(map[string]*Element, len(elements))
+	sortedSet.skiplist = makeSkiplist()
+	for _, element := range elements {
+		sortedSet.dict[element.Member] = element
+		sortedSet.skiplist.insert(element.Member, element.Score)
+	}
+}
+
+func (sortedSet *SortedSet) lpAdd(member string, score float64) bool {
+	elements := sortedSet.lpElements()
+	index := lpIndexOf(elements, member)
+	if index >= 0 {
+		if elements[index].Score == score {
+			return false
+		}
+		elements = append(elements[:index], elements[index+1:]...)
+	}
+	element := &Element{Member: member, Score: score}
+	pos := lpSearch(elements, element)
+	elements = append(elements, nil)
+	copy(elements[pos+1:], elements[pos:])
+	elements[pos] = element
+	sortedSet.lpStore(elements)
+	return index < 0
+}
+
+// 删除 [start, end) 范围内的元素并返回
+func (sortedSet *SortedSet) lpRemoveRange(start int, end int) []*Element {
+	elements := sortedSet.lpElements()
+	removed := append([]*Element{}, elements[start:end]...)
+	sortedSet.lpStore(append(elements[:start], elements[end:]...))
+	return removed
+}
+
+// 返回在 min 与 max 之间的元素所在的下标范围 [start, end)
+func lpBorderRange(elements []*Element, min Border, max Border) (int, int) {
+	start := 0
+	for start < len(elements) && !min.less(elements[start]) {
+		start++
+	}
+	end := start
+	for end < len(elements) && max.greater(elements[end]) {
+		end++
+	}
+	return start, end
+}
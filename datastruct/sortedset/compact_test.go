@@ -0,0 +1,90 @@
+package sortedset
+
+import (
+	"strconv"
+	"testing"
+)
+
+// 分别构造 listpack 与 skiplist 编码的有序集合，比较两者的行为
+func makeBothEncodings(size int) (*SortedSet, *SortedSet) {
+	compact := Make()
+	full := Make()
+	for i := 0; i < size; i++ {
+		member := "m" + strconv.Itoa(i)
+		score := float64(i % 5)
+		compact.Add(member, score)
+		full.Add(member, score)
+	}
+	full.convert()
+	return compact, full
+}
+
+func elementsEqual(a []*Element, b []*Element) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Member != b[i].Member || a[i].Score != b[i].Score {
+			return false
+		}
+	}
+	return true
+}
+
+func TestListpackSortedSet(t *testing.T) {
+	compact, full := makeBothEncodings(20)
+	if compact.Encoding() != "listpack" || full.Encoding() != "skiplist" {
+		t.Fatal("wrong encoding")
+	}
+	for _, desc := range []bool{false, true} {
+		if !elementsEqual(compact.RangeByRank(2, 15, desc), full.RangeByRank(2, 15, desc)) {
+			t.Errorf("RangeByRank desc=%v mismatch", desc)
+		}
+		min := &ScoreBorder{Value: 1}
+		max := &ScoreBorder{Value: 3, Exclude: true}
+		if !elementsEqual(compact.Range(min, max, 1, 5, desc), full.Range(min, max, 1, 5, desc)) {
+			t.Errorf("Range desc=%v mismatch", desc)
+		}
+		if compact.GetRank("m7", desc) != full.GetRank("m7", desc) {
+			t.Errorf("GetRank desc=%v mismatch", desc)
+		}
+	}
+	min, _ := ParseLexBorder("[m1")
+	max, _ := ParseLexBorder("(m3")
+	if compact.RangeCount(min, max) != full.RangeCount(min, max) {
+		t.Error("RangeCount mismatch")
+	}
+
+	if !elementsEqual(compact.PopMin(3), full.PopMin(3)) {
+		t.Error("PopMin mismatch")
+	}
+	if compact.RemoveByRank(1, 4) != full.RemoveByRank(1, 4) {
+		t.Error("RemoveByRank mismatch")
+	}
+	border := &ScoreBorder{Value: 4}
+	if compact.RemoveRange(border, scorePositiveInfBorder) != full.RemoveRange(border, scorePositiveInfBorder) {
+		t.Error("RemoveRange mismatch")
+	}
+	compact.Add("m8", 10)
+	full.Add("m8", 10)
+	if !elementsEqual(compact.RangeByRank(0, compact.Len(), false), full.RangeByRank(0, full.Len(), false)) {
+		t.Error("content mismatch after updates")
+	}
+}
+
+func TestSortedSetConversion(t *testing.T) {
+	zset := Make()
+	for i := 0; i < ListpackMaxEntries; i++ {
+		zset.Add(strconv.Itoa(i), float64(i))
+	}
+	if zset.Encoding() != "listpack" {
+		t.Errorf("expect listpack, actually %s", zset.Encoding())
+	}
+	zset.Add("new", -1)
+	if zset.Encoding() != "skiplist" || zset.Len() != int64(ListpackMaxEntries+1) {
+		t.Errorf("expect skiplist with %d members", ListpackMaxEntries+1)
+	}
+	if zset.GetRank("new", false) != 0 || zset.GetRank("0", false) != 1 {
+		t.Error("wrong rank after conversion")
+	}
+}
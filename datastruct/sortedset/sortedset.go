@@ -1,24 +1,50 @@
 package sortedset
 
 import (
+	"math/rand"
+	"myredis/datastruct/dict"
+	"myredis/datastruct/listpack"
 	"myredis/lib/wildcard"
 	"strconv"
 )
 
+// SortedSet 元素较少时使用 listpack 编码，此时 dict 与 skiplist 为 nil；
+// 元素个数超过 ListpackMaxEntries 或 member 长度超过 ListpackMaxValue 时转换为 dict + skiplist
 type SortedSet struct {
+	lp       *listpack.ListPack
 	dict     map[string]*Element
 	skiplist *skiplist
+	// dict 编码下第一次增量扫描时创建，之后随成员的增删维护
+	scanIndex *dict.ScanIndex
 }
 
 func Make() *SortedSet {
 	return &SortedSet{
-		dict:     make(map[string]*Element),
-		skiplist: makeSkiplist(),
+		lp: listpack.Make(),
 	}
 }
 
+// 返回内部编码，listpack 或 skiplist
+func (sortedSet *SortedSet) Encoding() string {
+	if sortedSet.lp != nil {
+		return "listpack"
+	}
+	return "skiplist"
+}
+
 // 添加节点，如果成员之前存在，更新分数，返回 false；新成员返回 true
 func (sortedSet *SortedSet) Add(member string, score float64) bool {
+	if sortedSet.lp != nil {
+		_, exists := sortedSet.Get(member)
+		size := sortedSet.Len()
+		if !exists {
+			size++
+		}
+		if size <= int64(ListpackMaxEntries) && len(member) <= ListpackMaxValue {
+			return sortedSet.lpAdd(member, score)
+		}
+		sortedSet.convert()
+	}
 	// 获取原先的成员
 	element, ok := sortedSet.dict[member]
 	sortedSet.dict[member] = &Element{
@@ -35,21 +61,51 @@ func (sortedSet *SortedSet) Add(member string, score float64) bool {
 		return false
 	}
 	sortedSet.skiplist.insert(member, score)
+	if sortedSet.scanIndex != nil {
+		sortedSet.scanIndex.Add(member)
+	}
 	return true
 }
 
+// 从 dict 编码的字典中删除成员，跳表由调用者维护
+func (sortedSet *SortedSet) dictRemove(member string) {
+	delete(sortedSet.dict, member)
+	if sortedSet.scanIndex != nil {
+		sortedSet.scanIndex.Remove(member)
+	}
+}
+
 // 从集合中删除成员
 func (sortedSet *SortedSet) Remove(member string) bool {
+	if sortedSet.lp != nil {
+		index := sortedSet.lp.Find([]byte(member), 0, 2)
+		if index < 0 {
+			return false
+		}
+		sortedSet.lp.Delete(index, 2)
+		return true
+	}
 	val, ok := sortedSet.dict[member]
 	if ok {
 		sortedSet.skiplist.remove(member, val.Score)
-		delete(sortedSet.dict, member)
+		sortedSet.dictRemove(member)
 		return true
 	}
 	return false
 }
 
+// listpack 编码时返回的是元素的副本
 func (sortedSet *SortedSet) Get(member string) (element *Element, ok bool) {
+	if sortedSet.lp != nil {
+		index := sortedSet.lp.Find([]byte(member), 0, 2)
+		if index < 0 {
+			return nil, false
+		}
+		return &Element{
+			Member: member,
+			Score:  decodeScore(sortedSet.lp.Get(index + 1)),
+		}, true
+	}
 	element, ok = sortedSet.dict[member]
 	if !ok {
 		return nil, false
@@ -58,11 +114,25 @@ func (sortedSet *SortedSet) Get(member string) (element *Element, ok bool) {
 }
 
 func (sortedSet *SortedSet) Len() int64 {
+	if sortedSet.lp != nil {
+		return int64(sortedSet.lp.Len() / 2)
+	}
 	return int64(len(sortedSet.dict))
 }
 
 // 如果 rank 为 -1，查找失败
 func (sortedSet *SortedSet) GetRank(member string, desc bool) (rank int64) {
+	if sortedSet.lp != nil {
+		index := sortedSet.lp.Find([]byte(member), 0, 2)
+		if index < 0 {
+			return -1
+		}
+		rank = int64(index / 2)
+		if desc {
+			rank = sortedSet.Len() - 1 - rank
+		}
+		return rank
+	}
 	element, ok := sortedSet.dict[member]
 	if !ok {
 		return -1
@@ -79,6 +149,27 @@ func (sortedSet *SortedSet) GetRank(member string, desc bool) (rank int64) {
 
 // 对 min 和 max 边界的每一个元素进行遍历，支持 offset 以及 limit (limit < 0 代表没有限制)
 func (sortedSet *SortedSet) ForEach(min Border, max Border, offset int64, limit int64, desc bool, consumer func(element *Element) bool) {
+	if sortedSet.lp != nil {
+		elements := sortedSet.lpElements()
+		start, end := lpBorderRange(elements, min, max)
+		elements = elements[start:end]
+		for i := 0; i < len(elements); i++ {
+			if int64(i) < offset {
+				continue
+			}
+			if limit >= 0 && int64(i)-offset >= limit {
+				break
+			}
+			element := elements[i]
+			if desc {
+				element = elements[len(elements)-1-i]
+			}
+			if !consumer(element) {
+				break
+			}
+		}
+		return
+	}
 	var node *node
 	if desc {
 		node = sortedSet.skiplist.getLastInRange(min, max)
@@ -125,6 +216,20 @@ func (sortedSet *SortedSet) ForEachByRank(start int64, end int64, desc bool, con
 		panic("illegal end " + strconv.FormatInt(end, 10))
 	}
 
+	if sortedSet.lp != nil {
+		elements := sortedSet.lpElements()
+		for i := start; i < end; i++ {
+			element := elements[i]
+			if desc {
+				element = elements[size-1-i]
+			}
+			if !consumer(element) {
+				break
+			}
+		}
+		return
+	}
+
 	var node *node
 	if desc {
 		node = sortedSet.skiplist.tail
@@ -201,14 +306,29 @@ func (sortedSet *SortedSet) Range(min Border, max Border, offset int64, limit in
 }
 
 func (sortedSet *SortedSet) RemoveRange(min Border, max Border) int64 {
+	if sortedSet.lp != nil {
+		start, end := lpBorderRange(sortedSet.lpElements(), min, max)
+		return int64(len(sortedSet.lpRemoveRange(start, end)))
+	}
 	removed := sortedSet.skiplist.RemoveRange(min, max, 0)
 	for _, element := range removed {
-		delete(sortedSet.dict, element.Member)
+		sortedSet.dictRemove(element.Member)
 	}
 	return int64(len(removed))
 }
 
+// 弹出分数最小的 count 个元素，count 小于等于 0 时弹出全部
 func (sortedSet *SortedSet) PopMin(count int) []*Element {
+	if sortedSet.lp != nil {
+		size := int(sortedSet.Len())
+		if size == 0 {
+			return nil
+		}
+		if count <= 0 || count > size {
+			count = size
+		}
+		return sortedSet.lpRemoveRange(0, count)
+	}
 	firstNode := sortedSet.skiplist.getFirstInRange(scoreNegativeInfBorder, scorePositiveInfBorder)
 	if firstNode == nil {
 		return nil
@@ -219,34 +339,132 @@ func (sortedSet *SortedSet) PopMin(count int) []*Element {
 	}
 	removed := sortedSet.skiplist.RemoveRange(border, scorePositiveInfBorder, count)
 	for _, element := range removed {
-		delete(sortedSet.dict, element.Member)
+		sortedSet.dictRemove(element.Member)
+	}
+	return removed
+}
+
+// 弹出分数最大的 count 个元素，按分数从高到低返回，count 小于等于 0 时弹出全部
+func (sortedSet *SortedSet) PopMax(count int) []*Element {
+	size := int(sortedSet.Len())
+	if size == 0 {
+		return nil
+	}
+	if count <= 0 || count > size {
+		count = size
+	}
+	var removed []*Element
+	if sortedSet.lp != nil {
+		removed = sortedSet.lpRemoveRange(size-count, size)
+	} else {
+		removed = sortedSet.skiplist.RemoveRangeByRank(int64(size-count+1), int64(size+1))
+		for _, element := range removed {
+			sortedSet.dictRemove(element.Member)
+		}
+	}
+	for i, j := 0, len(removed)-1; i < j; i, j = i+1, j-1 {
+		removed[i], removed[j] = removed[j], removed[i]
 	}
 	return removed
 }
 
+// 按排名随机选取 count 个元素，distinct 为 true 时元素互不相同且最多返回全部元素，否则可以重复
+func (sortedSet *SortedSet) RandomElements(count int, distinct bool) []*Element {
+	size := int(sortedSet.Len())
+	if size == 0 || count <= 0 {
+		return nil
+	}
+	var ranks []int
+	if !distinct {
+		ranks = make([]int, count)
+		for i := range ranks {
+			ranks[i] = rand.Intn(size)
+		}
+	} else if count*3 < size {
+		// 需要的元素远少于总数时随机抽样，避免生成整个排列
+		picked := make(map[int]struct{}, count)
+		for len(ranks) < count {
+			rank := rand.Intn(size)
+			if _, ok := picked[rank]; !ok {
+				picked[rank] = struct{}{}
+				ranks = append(ranks, rank)
+			}
+		}
+	} else {
+		ranks = rand.Perm(size)
+		if count < size {
+			ranks = ranks[:count]
+		}
+	}
+
+	result := make([]*Element, len(ranks))
+	if sortedSet.lp != nil {
+		elements := sortedSet.lpElements()
+		for i, rank := range ranks {
+			element := *elements[rank]
+			result[i] = &element
+		}
+		return result
+	}
+	for i, rank := range ranks {
+		element := sortedSet.skiplist.getByRank(int64(rank + 1)).Element
+		result[i] = &element
+	}
+	return result
+}
+
+// 删除排名在 [start, end) 范围内的元素，排名从 0 开始
 func (sortedSet *SortedSet) RemoveByRank(start int64, end int64) int64 {
+	if sortedSet.lp != nil {
+		size := sortedSet.Len()
+		if start < 0 {
+			start = 0
+		}
+		if end > size {
+			end = size
+		}
+		if start >= end {
+			return 0
+		}
+		return int64(len(sortedSet.lpRemoveRange(int(start), int(end))))
+	}
 	removed := sortedSet.skiplist.RemoveRangeByRank(start+1, end+1)
 	for _, element := range removed {
-		delete(sortedSet.dict, element.Member)
+		sortedSet.dictRemove(element.Member)
 	}
 	return int64(len(removed))
 }
 
+// ZSetScan listpack 编码时一次返回全部元素，dict 编码时使用反向二进制游标增量遍历
 func (sortedSet *SortedSet) ZSetScan(cursor int, count int, pattern string) ([][]byte, int) {
 	result := make([][]byte, 0)
 	matchKey, err := wildcard.CompilePattern(pattern)
 	if err != nil {
 		return result, -1
 	}
-	for k := range sortedSet.dict {
-		if pattern == "*" || matchKey.IsMatch(k) {
-			elem, exists := sortedSet.dict[k]
-			if !exists {
-				continue
+	if sortedSet.lp != nil {
+		for _, elem := range sortedSet.lpElements() {
+			if pattern == "*" || matchKey.IsMatch(elem.Member) {
+				result = append(result, []byte(elem.Member))
+				result = append(result, []byte(strconv.FormatFloat(elem.Score, 'f', 10, 64)))
 			}
+		}
+		return result, 0
+	}
+	if sortedSet.scanIndex == nil {
+		members := make([]string, 0, len(sortedSet.dict))
+		for k := range sortedSet.dict {
+			members = append(members, k)
+		}
+		sortedSet.scanIndex = dict.MakeScanIndex(members)
+	}
+	members, nextCursor := sortedSet.scanIndex.Scan(cursor, count)
+	for _, k := range members {
+		if pattern == "*" || matchKey.IsMatch(k) {
+			elem := sortedSet.dict[k]
 			result = append(result, []byte(k))
 			result = append(result, []byte(strconv.FormatFloat(elem.Score, 'f', 10, 64)))
 		}
 	}
-	return result, 0
+	return result, nextCursor
 }
@@ -2,6 +2,7 @@ package sortedset
 
 import (
 	"myredis/lib/utils"
+	"strconv"
 	"testing"
 )
 
@@ -18,6 +19,53 @@ func TestSortedSet_PopMin(t *testing.T) {
 	}
 }
 
+func TestSortedSet_PopMax(t *testing.T) {
+	compact, full := makeBothEncodings(100)
+	for _, set := range []*SortedSet{compact, full} {
+		results := set.PopMax(3)
+		if len(results) != 3 || results[0].Score != 4 || results[2].Score != 4 || set.Len() != 97 {
+			t.Errorf("wrong pop result for %s", set.Encoding())
+		}
+		for _, element := range results {
+			if _, ok := set.Get(element.Member); ok {
+				t.Errorf("%s should be removed", element.Member)
+			}
+		}
+		if len(set.PopMax(0)) != 97 || set.Len() != 0 || set.PopMax(1) != nil {
+			t.Errorf("wrong pop all result for %s", set.Encoding())
+		}
+	}
+}
+
+func TestSortedSet_RandomElements(t *testing.T) {
+	for _, size := range []int{5, 100} {
+		compact, full := makeBothEncodings(size)
+		for _, set := range []*SortedSet{compact, full} {
+			for _, count := range []int{1, 3, size, size + 10} {
+				results := set.RandomElements(count, true)
+				expected := count
+				if expected > size {
+					expected = size
+				}
+				seen := make(map[string]bool)
+				for _, element := range results {
+					stored, ok := set.Get(element.Member)
+					if !ok || stored.Score != element.Score || seen[element.Member] {
+						t.Errorf("wrong random element %v for %s", element, set.Encoding())
+					}
+					seen[element.Member] = true
+				}
+				if len(results) != expected {
+					t.Errorf("expect %d elements, actually %d", expected, len(results))
+				}
+			}
+			if results := set.RandomElements(size*2, false); len(results) != size*2 {
+				t.Errorf("expect %d elements, actually %d", size*2, len(results))
+			}
+		}
+	}
+}
+
 func TestSetScan(t *testing.T) {
 	set := Make()
 	size := 10
@@ -44,3 +92,38 @@ func TestSetScan(t *testing.T) {
 		return
 	}
 }
+
+func TestZSetScanAfterRemove(t *testing.T) {
+	set := Make()
+	size := 500
+	for i := 0; i < size; i++ {
+		set.Add("m"+strconv.Itoa(i), float64(i))
+	}
+	// 第一次扫描创建索引，之后的删除需要同步到索引
+	set.ZSetScan(0, 10, "*")
+	set.PopMin(100)
+	set.PopMax(100)
+	set.RemoveRange(&ScoreBorder{Value: 200}, &ScoreBorder{Value: 249})
+	set.Remove("m250")
+
+	seen := make(map[string]int)
+	cursor := 0
+	for {
+		var keys [][]byte
+		keys, cursor = set.ZSetScan(cursor, 10, "*")
+		for i := 0; i < len(keys); i += 2 {
+			seen[string(keys[i])]++
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(seen) != int(set.Len()) {
+		t.Errorf("expect %d members, actual: %d", set.Len(), len(seen))
+	}
+	for member, times := range seen {
+		if _, ok := set.Get(member); !ok || times != 1 {
+			t.Errorf("unexpected member %s returned %d times", member, times)
+		}
+	}
+}
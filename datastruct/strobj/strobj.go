@@ -0,0 +1,74 @@
+// Package strobj 实现字符串值的编码
+//
+// 字符串保存在 DataEntity.Data 中，有两种表示：
+//   - 规范格式的整数（例如 "123"，不包括 "0123" 和 "+1"）保存为 int64，读取时再转换为字节
+//   - 其他字符串保存为 []byte
+//
+// 0 到 SharedIntegers-1 之间的整数使用预先分配的共享对象，写入时不需要再分配内存
+package strobj
+
+import "strconv"
+
+// 共享整数的个数，与 Redis 的 OBJ_SHARED_INTEGERS 相同
+const SharedIntegers = 10000
+
+var sharedIntegers [SharedIntegers]interface{}
+
+func init() {
+	for i := range sharedIntegers {
+		sharedIntegers[i] = int64(i)
+	}
+}
+
+// 返回 value 对应的整数，只有规范格式的整数才能使用整数编码
+func ParseInt(value []byte) (int64, bool) {
+	if len(value) == 0 || len(value) > 20 {
+		return 0, false
+	}
+	i, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil || strconv.FormatInt(i, 10) != string(value) {
+		return 0, false
+	}
+	return i, true
+}
+
+// 返回保存字符串 value 使用的值，可以使用整数编码时返回 int64
+func Make(value []byte) interface{} {
+	if i, ok := ParseInt(value); ok {
+		return MakeInt(i)
+	}
+	return value
+}
+
+// 返回保存整数 value 使用的值，小整数返回共享对象
+func MakeInt(value int64) interface{} {
+	if value >= 0 && value < SharedIntegers {
+		return sharedIntegers[value]
+	}
+	return value
+}
+
+// 返回字符串的内容，data 不是字符串时返回 false
+//
+// 整数编码时每次返回新的切片，否则返回保存的切片本身
+func Bytes(data interface{}) ([]byte, bool) {
+	switch val := data.(type) {
+	case []byte:
+		return val, true
+	case int64:
+		return strconv.AppendInt(nil, val, 10), true
+	}
+	return nil, false
+}
+
+// 返回整数编码的值，data 不是整数编码时返回 false
+func Int(data interface{}) (int64, bool) {
+	i, ok := data.(int64)
+	return i, ok
+}
+
+// 判断 data 是否为共享的整数对象
+func IsShared(data interface{}) bool {
+	i, ok := data.(int64)
+	return ok && i >= 0 && i < SharedIntegers
+}
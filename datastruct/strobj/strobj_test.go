@@ -0,0 +1,28 @@
+package strobj
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestMake(t *testing.T) {
+	canonical := []string{"0", "-1", "9999", "10000", strconv.FormatInt(math.MinInt64, 10)}
+	for _, value := range canonical {
+		data := Make([]byte(value))
+		if _, ok := Int(data); !ok {
+			t.Errorf("%s should be int encoded", value)
+		}
+		if bytes, ok := Bytes(data); !ok || string(bytes) != value {
+			t.Errorf("expect %s, actually %s", value, bytes)
+		}
+	}
+	for _, value := range []string{"", "01", "+1", "-0", "1.0", "9223372036854775808", "abc"} {
+		if _, ok := Int(Make([]byte(value))); ok {
+			t.Errorf("%q should not be int encoded", value)
+		}
+	}
+	if !IsShared(MakeInt(SharedIntegers-1)) || IsShared(MakeInt(SharedIntegers)) || IsShared(MakeInt(-1)) {
+		t.Error("wrong shared integer range")
+	}
+}
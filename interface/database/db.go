@@ -25,6 +25,8 @@ type DBEngine interface {
 	ExecWithLock(conn myredis.Connection, cmdLine [][]byte) myredis.Reply
 	ExecMulti(conn myredis.Connection, watching map[string]uint32, cmdLines []CmdLine) myredis.Reply
 	GetUndoLogs(dbIndex int, cmdLine [][]byte) []CmdLine
+	GetVersion(dbIndex int, key string) uint32
+	AddVersion(dbIndex int, keys ...string)
 	RWLocks(dbIndex int, writeKeys []string, readKeys []string)
 	RWUnLocks(dbIndex int, writeKeys []string, readKeys []string)
 	GetDBSize(dbIndex int) (int, int)
@@ -32,7 +34,16 @@ type DBEngine interface {
 	GetExpiration(dbIndex int, key string) *time.Time
 	SetKeyInsertedCallback(cb KeyEventCallback)
 	SetKeyDeletedCallback(cb KeyEventCallback)
+	SetLoadedCallback(cb func())
 	ForEach(dbIndex int, callback func(key string, data *DataEntity, expiration *time.Time) bool)
+	Snapshot(onCut func()) Snapshot
+}
+
+// 数据库某一时刻的只读视图，用完后需要调用 Release 释放
+type Snapshot interface {
+	GetDBSize(dbIndex int) (int, int)
+	ForEach(dbIndex int, callback func(key string, data *DataEntity, expiration *time.Time) bool)
+	Release()
 }
 
 type DataEntity struct {
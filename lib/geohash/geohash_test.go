@@ -17,6 +17,20 @@ func TestToRange(t *testing.T) {
 	if expectedUpper != geoRange[1] {
 		t.Error("incorrect upper")
 	}
+	// 最后一个区块的上界不能回绕为 0
+	geoRange = toRange([]byte{0x80}, 1)
+	if geoRange[0] != 1<<63 || geoRange[1] != math.MaxUint64 {
+		t.Errorf("incorrect range of the last cell %x", geoRange)
+	}
+}
+
+func TestEstimatePrecisionByRadius(t *testing.T) {
+	if p := estimatePrecisionByRadius(15000*1000, 85); p != 1 {
+		t.Errorf("expect precision 1 for a large radius at high latitude, actually %d", p)
+	}
+	if p := estimatePrecisionByRadius(1000, 0); p <= estimatePrecisionByRadius(1000, 85) {
+		t.Errorf("expect a coarser precision at high latitude")
+	}
 }
 
 func TestEncode(t *testing.T) {
@@ -37,3 +51,50 @@ func TestGetNeighbours(t *testing.T) {
 	ranges := GetNeighbours(90, 180, 630*1000)
 	fmt.Printf("%#v", ranges)
 }
+
+func TestDistanceIfInBox(t *testing.T) {
+	// 中心 (37, 15)，宽 400km 高 400km
+	if _, ok := DistanceIfInBox(37, 15, 400*1000, 400*1000, 38.115556, 13.361389); !ok {
+		t.Error("expected point in box")
+	}
+	if _, ok := DistanceIfInBox(37, 15, 400*1000, 100*1000, 38.115556, 13.361389); ok {
+		t.Error("expected point out of box by latitude")
+	}
+	if _, ok := DistanceIfInBox(37, 15, 100*1000, 400*1000, 38.115556, 13.361389); ok {
+		t.Error("expected point out of box by longitude")
+	}
+	dist, _ := DistanceIfInBox(37, 15, 400*1000, 400*1000, 37.502669, 15.087269)
+	if math.Abs(dist-Distance(37, 15, 37.502669, 15.087269)) > 1e-6 {
+		t.Error("incorrect distance")
+	}
+}
+
+func TestGetNeighboursInBox(t *testing.T) {
+	lat, lng := 37.0, 15.0
+	width, height := 400*1000.0, 200*1000.0
+	ranges := GetNeighboursInBox(lat, lng, width, height)
+	if len(ranges) == 0 || len(ranges) > 9 {
+		t.Errorf("unexpected range count %d", len(ranges))
+	}
+	covered := func(code uint64) bool {
+		for _, r := range ranges {
+			if code >= r[0] && code < r[1] {
+				return true
+			}
+		}
+		return false
+	}
+	// 矩形内的点都应被某个范围覆盖
+	for i := -10; i <= 10; i++ {
+		for j := -10; j <= 10; j++ {
+			pLat := lat + float64(i)*0.09
+			pLng := lng + float64(j)*0.22
+			if _, ok := DistanceIfInBox(lat, lng, width, height, pLat, pLng); !ok {
+				continue
+			}
+			if !covered(Encode(pLat, pLng)) {
+				t.Errorf("point %f,%f is not covered", pLat, pLng)
+			}
+		}
+	}
+}
@@ -6,6 +6,7 @@
   - 根据搜索半径自动估算合适的 GeoHash 精度
   - 将经纬度编码为 GeoHash 并获取其数值范围
   - 获取指定坐标在给定半径内的 3x3 邻接 GeoHash 区域范围
+  - 获取覆盖给定矩形的邻接 GeoHash 区域范围，并判断坐标是否落在矩形内
 */
 
 package geohash
@@ -45,7 +46,8 @@ func estimatePrecisionByRadius(radisMeters float64, latitude float64) uint {
 	if radisMeters == 0 {
 		return defaultBitSize - 1
 	}
-	var level uint = 1
+	// 高纬度时 level 可能减到负数，使用有符号整数
+	level := 1
 	// 从搜索半径开始，直到 Web 墨卡托投影最大值
 	for radisMeters < mercatorMax {
 		radisMeters *= 2
@@ -65,15 +67,20 @@ func estimatePrecisionByRadius(radisMeters float64, latitude float64) uint {
 	if level > 32 {
 		level = 32
 	}
-	return level*2 - 1
+	return uint(level*2 - 1)
 }
 
 // toRange 将 GeoHash 前缀（字节形式）转换为对应的 uint64 数值范围 [min, max)
+//
+// 前缀全为 1 的最后一个区块上界超出 uint64，取 math.MaxUint64
 func toRange(scope []byte, precision uint) [2]uint64 {
 	lower := ToInt(scope) // 该 geohas 前缀开头的最小值
 	// 区间跨度，对应精度的块大小
 	radius := uint64(1 << (64 - precision)) // (64 - precision)：还剩下多少位是“可变的”
 	upper := lower + radius
+	if upper < lower {
+		upper = math.MaxUint64
+	}
 	return [2]uint64{lower, upper}
 }
 
@@ -113,9 +120,42 @@ func getValidLng(longitude float64) float64 {
 //	  3: 左中  4: 中心  5: 右中
 //	  6: 左下  7: 下中  8: 右下
 func GetNeighbours(latitude, longitude, radiusMeter float64) [][2]uint64 {
+	level, cells := neighbourCells(latitude, longitude, radiusMeter, radiusBoundingBox(latitude, longitude, radiusMeter))
+	result := make([][2]uint64, len(cells))
+	for i, cell := range cells {
+		result[i] = toRange(cell.code, level)
+	}
+	return result
+}
+
+// neighbourCell 3x3 网格中的一个 GeoHash 区块，box 为 [经度范围, 纬度范围]
+type neighbourCell struct {
+	code []byte
+	box  [2][2]float64
+}
+
+// neighbourCells 选择精度并返回以指定坐标所在区块为中心的 3x3 区块（顺序同 GetNeighbours）
+//
+// 与 Redis 相同，按半径估算的精度下 3x3 区块不能覆盖搜索范围的外接矩形 bounds 时降低精度，
+// bounds 的格式与区块的 box 相同
+func neighbourCells(latitude, longitude, radiusMeter float64, bounds [2][2]float64) (uint, [9]neighbourCell) {
 	// 选择 Geohash 精度
 	level := estimatePrecisionByRadius(radiusMeter, latitude)
+	for level > 1 {
+		_, box := encode(latitude, longitude, level)
+		width := box[0][1] - box[0][0]
+		height := box[1][1] - box[1][0]
+		if box[0][0]-width <= bounds[0][0] && box[0][1]+width >= bounds[0][1] &&
+			box[1][0]-height <= bounds[1][0] && box[1][1]+height >= bounds[1][1] {
+			break
+		}
+		level -= 2
+	}
+	return level, cellsAround(latitude, longitude, level)
+}
 
+// cellsAround 返回指定精度下以坐标所在区块为中心的 3x3 区块
+func cellsAround(latitude, longitude float64, level uint) [9]neighbourCell {
 	center, box := encode(latitude, longitude, level)
 	width := box[0][1] - box[0][0]
 	height := box[1][1] - box[1][0]
@@ -128,25 +168,102 @@ func GetNeighbours(latitude, longitude, radiusMeter float64) [][2]uint64 {
 	maxLng := getValidLng(centerLng + width) // 经度
 	minLng := getValidLng(centerLng - width)
 
-	// 3 * 3的边界
-	var result [9][2]uint64
-	leftUpper, _ := encode(maxLat, minLng, level)
-	result[0] = toRange(leftUpper, level)
-	upper, _ := encode(maxLat, centerLng, level)
-	result[1] = toRange(upper, level)
-	rightUpper, _ := encode(maxLat, maxLng, level)
-	result[2] = toRange(rightUpper, level)
-	left, _ := encode(centerLat, minLng, level)
-	result[3] = toRange(left, level)
-	result[4] = toRange(center, level)
-	right, _ := encode(centerLat, maxLng, level)
-	result[5] = toRange(right, level)
-	leftDown, _ := encode(minLat, minLng, level)
-	result[6] = toRange(leftDown, level)
-	down, _ := encode(minLat, centerLng, level)
-	result[7] = toRange(down, level)
-	rightDown, _ := encode(minLat, maxLng, level)
-	result[8] = toRange(rightDown, level)
-
-	return result[:] // 返回切片
+	// 3 * 3的边界，从左上到右下
+	points := [9][2]float64{
+		{maxLat, minLng}, {maxLat, centerLng}, {maxLat, maxLng},
+		{centerLat, minLng}, {centerLat, centerLng}, {centerLat, maxLng},
+		{minLat, minLng}, {minLat, centerLng}, {minLat, maxLng},
+	}
+	var cells [9]neighbourCell
+	for i, point := range points {
+		if i == 4 {
+			cells[i] = neighbourCell{code: center, box: box}
+			continue
+		}
+		code, cellBox := encode(point[0], point[1], level)
+		cells[i] = neighbourCell{code: code, box: cellBox}
+	}
+	return cells
+}
+
+// GetNeighboursInBox 返回覆盖以指定坐标为中心、宽 widthMeter 高 heightMeter 的矩形的 GeoHash 范围。
+//
+// 以矩形外接圆半径选择精度，保证 3x3 区块能覆盖整个矩形；与矩形不相交的区块以及重复区块会被剔除。
+// 返回的每个范围均为左闭右开区间 [min, max)。
+func GetNeighboursInBox(latitude, longitude, widthMeter, heightMeter float64) [][2]uint64 {
+	radius := math.Hypot(widthMeter/2, heightMeter/2)
+	minLat, maxLat, minLng, maxLng := boundingBox(latitude, longitude, widthMeter, heightMeter)
+	level, cells := neighbourCells(latitude, longitude, radius, [2][2]float64{{minLng, maxLng}, {minLat, maxLat}})
+	// 矩形跨越 ±180 度经线时不按经度剔除
+	checkLng := minLng >= -180 && maxLng <= 180
+
+	result := make([][2]uint64, 0, len(cells))
+	seen := make(map[[2]uint64]struct{}, len(cells))
+	for i, cell := range cells {
+		if i != 4 {
+			if cell.box[1][1] < minLat || cell.box[1][0] > maxLat {
+				continue
+			}
+			if checkLng && (cell.box[0][1] < minLng || cell.box[0][0] > maxLng) {
+				continue
+			}
+		}
+		area := toRange(cell.code, level)
+		if _, ok := seen[area]; ok {
+			continue
+		}
+		seen[area] = struct{}{}
+		result = append(result, area)
+	}
+	return result
+}
+
+// boundingBox 计算矩形的经纬度边界，经度跨度取离赤道较远一侧（跨度更大）的值
+//
+// 矩形越过极点或在该纬度上的宽度超过纬线长度时经度覆盖一整圈
+func boundingBox(latitude, longitude, widthMeter, heightMeter float64) (minLat, maxLat, minLng, maxLng float64) {
+	latDelta := rad2deg(heightMeter / 2 / earthRadius)
+	farLat := latitude + latDelta
+	if latitude < 0 {
+		farLat = latitude - latDelta
+	}
+	lngDelta := 180.0
+	if math.Abs(farLat) < 90 {
+		// 纬线上两点的球面距离为 widthMeter/2 时的经度差
+		if sin := math.Sin(widthMeter/4/earthRadius) / math.Cos(deg2rad(farLat)); sin < 1 {
+			lngDelta = math.Min(rad2deg(2*math.Asin(sin)), 180)
+		}
+	}
+	return latitude - latDelta, latitude + latDelta, longitude - lngDelta, longitude + lngDelta
+}
+
+// radiusBoundingBox 计算圆形搜索范围的外接矩形，格式与区块的 box 相同
+//
+// 圆越过极点时经度覆盖一整圈
+func radiusBoundingBox(latitude, longitude, radiusMeter float64) [2][2]float64 {
+	latDelta := rad2deg(radiusMeter / earthRadius)
+	lngDelta := 180.0
+	if math.Abs(latitude)+latDelta < 90 {
+		lngDelta = rad2deg(math.Asin(math.Sin(radiusMeter/earthRadius) / math.Cos(deg2rad(latitude))))
+	}
+	return [2][2]float64{
+		{longitude - lngDelta, longitude + lngDelta},
+		{latitude - latDelta, latitude + latDelta},
+	}
+}
+
+// DistanceIfInBox 判断坐标是否落在以 (centerLat, centerLng) 为中心、宽 widthMeter 高 heightMeter 的矩形内，
+// 在矩形内时同时返回其与中心的球面距离
+func DistanceIfInBox(centerLat, centerLng, widthMeter, heightMeter, latitude, longitude float64) (float64, bool) {
+	// 纬度方向的距离计算代价更低，先检查
+	latDistance := earthRadius * math.Abs(deg2rad(latitude)-deg2rad(centerLat))
+	if latDistance > heightMeter/2 {
+		return 0, false
+	}
+	// 经度方向的距离在目标点所在纬度上计算
+	lngDistance := Distance(latitude, centerLng, latitude, longitude)
+	if lngDistance > widthMeter/2 {
+		return 0, false
+	}
+	return Distance(centerLat, centerLng, latitude, longitude), true
 }
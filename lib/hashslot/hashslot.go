@@ -0,0 +1,34 @@
+// Package hashslot 计算 key 所属的集群槽位，服务端与集群客户端共用同一套规则
+package hashslot
+
+import (
+	"hash/crc32"
+	"strings"
+)
+
+// 集群中槽位的总数
+const SlotCount int = 1024
+
+// 计算 key 所属的槽位
+//
+// 如果 key 中包含 {hashtag}，则只使用花括号内的部分计算，
+// 使得相关的 key 能够落在同一个槽位
+func GetSlot(key string) uint32 {
+	partitionKey := getPartitionKey(key)
+	return crc32.ChecksumIEEE([]byte(partitionKey)) % uint32(SlotCount)
+}
+
+// 提取 key 中用于计算槽位的部分
+// for example: user:{1000}:name -> 1000
+func getPartitionKey(key string) string {
+	beg := strings.Index(key, "{")
+	if beg == -1 {
+		return key
+	}
+	end := strings.Index(key[beg+1:], "}")
+	// 没有闭合的括号，或者括号内为空
+	if end <= 0 {
+		return key
+	}
+	return key[beg+1 : beg+1+end]
+}
@@ -0,0 +1,24 @@
+package hashslot
+
+import "testing"
+
+func TestGetSlot(t *testing.T) {
+	if GetSlot("user:{1000}:name") != GetSlot("user:{1000}:age") {
+		t.Error("keys with same hashtag should be in same slot")
+	}
+	if GetSlot("{1000}") != GetSlot("1000") {
+		t.Error("hashtag should be used as partition key")
+	}
+	// 空的 hashtag 使用整个 key
+	if getPartitionKey("a{}b") != "a{}b" {
+		t.Error("empty hashtag should be ignored")
+	}
+	if getPartitionKey("a{b") != "a{b" {
+		t.Error("unclosed hashtag should be ignored")
+	}
+	for _, key := range []string{"", "a", "foo", "{x}y"} {
+		if GetSlot(key) >= uint32(SlotCount) {
+			t.Errorf("slot of %s out of range", key)
+		}
+	}
+}
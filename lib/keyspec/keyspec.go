@@ -0,0 +1,420 @@
+// Package keyspec 描述每个命令读写哪些 key，服务端与集群客户端共用同一张表
+package keyspec
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Prepare 从命令参数（不含命令名）中提取写键和读键
+type Prepare func(args [][]byte) (write []string, read []string)
+
+// 键为小写的命令名，不涉及 key 的命令使用 NoKeys，特殊命令不在表中
+var specs = map[string]Prepare{
+	// bitmap
+	"bitop":       BitOp,
+	"bitfield":    WriteFirstKey,
+	"bitfield_ro": ReadFirstKey,
+
+	// dump
+	"dump":    ReadFirstKey,
+	"restore": WriteFirstKey,
+	"migrate": Migrate,
+
+	// geo
+	"geoadd":            WriteFirstKey,
+	"geopos":            ReadFirstKey,
+	"geodist":           ReadFirstKey,
+	"geohash":           ReadFirstKey,
+	"georadius":         GeoRadius(5),
+	"georadiusbymember": GeoRadius(4),
+	"geosearch":         ReadFirstKey,
+	"geosearchstore":    ZRangeStore,
+
+	// hash
+	"hset":         WriteFirstKey,
+	"hsetnx":       WriteFirstKey,
+	"hget":         ReadFirstKey,
+	"hgetall":      ReadFirstKey,
+	"hexists":      ReadFirstKey,
+	"hkeys":        ReadFirstKey,
+	"hvals":        ReadFirstKey,
+	"hdel":         WriteFirstKey,
+	"hlen":         ReadFirstKey,
+	"hstrlen":      ReadFirstKey,
+	"hmset":        WriteFirstKey,
+	"hmget":        ReadFirstKey,
+	"hincrby":      WriteFirstKey,
+	"hincrbyfloat": WriteFirstKey,
+	"hrandfield":   ReadFirstKey,
+	"hscan":        ReadFirstKey,
+	"hexpire":      WriteFirstKey,
+	"hpexpire":     WriteFirstKey,
+	"hexpireat":    WriteFirstKey,
+	"hpexpireat":   WriteFirstKey,
+	"httl":         ReadFirstKey,
+	"hpttl":        ReadFirstKey,
+	"hexpiretime":  ReadFirstKey,
+	"hpexpiretime": ReadFirstKey,
+	"hpersist":     WriteFirstKey,
+	"hgetex":       WriteFirstKey,
+	"hsetex":       WriteFirstKey,
+	"hgetdel":      WriteFirstKey,
+
+	// keys
+	"del":         WriteAllKeys,
+	"unlink":      WriteAllKeys,
+	"exists":      ReadAllKeys,
+	"touch":       ReadAllKeys,
+	"ttl":         ReadFirstKey,
+	"pttl":        ReadFirstKey,
+	"type":        ReadFirstKey,
+	"rename":      Rename,
+	"renamenx":    Rename,
+	"expire":      WriteFirstKey,
+	"expireat":    WriteFirstKey,
+	"expiretime":  ReadFirstKey,
+	"pexpire":     WriteFirstKey,
+	"pexpireat":   WriteFirstKey,
+	"pexpiretime": ReadFirstKey,
+	"persist":     WriteFirstKey,
+	"keys":        NoKeys,
+	"scan":        NoKeys,
+	"copy":        Copy,
+
+	// list
+	"lindex":    ReadFirstKey,
+	"llen":      ReadFirstKey,
+	"lpop":      WriteFirstKey,
+	"lpush":     WriteFirstKey,
+	"lpushx":    WriteFirstKey,
+	"lrange":    ReadFirstKey,
+	"lrem":      WriteFirstKey,
+	"lset":      WriteFirstKey,
+	"rpop":      WriteFirstKey,
+	"rpoplpush": WriteFirstTwoKeys,
+	"rpush":     WriteFirstKey,
+	"rpushx":    WriteFirstKey,
+	"ltrim":     WriteFirstKey,
+	"linsert":   WriteFirstKey,
+	"lmove":     WriteFirstTwoKeys,
+	"lpos":      ReadFirstKey,
+	"lmpop":     WriteNumKeys,
+	"blmpop":    BLMPop,
+
+	// object
+	"object": Object,
+
+	// set
+	"sadd":        WriteFirstKey,
+	"sismember":   ReadFirstKey,
+	"srem":        WriteFirstKey,
+	"smismember":  ReadFirstKey,
+	"spop":        WriteFirstKey,
+	"smove":       WriteFirstTwoKeys,
+	"scard":       ReadFirstKey,
+	"smembers":    ReadFirstKey,
+	"sinter":      ReadAllKeys,
+	"sinterstore": WriteFirstReadRest,
+	"sintercard":  ReadNumKeys,
+	"sunion":      ReadAllKeys,
+	"sunionstore": WriteFirstReadRest,
+	"sdiff":       ReadAllKeys,
+	"sdiffstore":  WriteFirstReadRest,
+	"srandmember": ReadFirstKey,
+	"sscan":       ReadFirstKey,
+
+	// sort
+	"sort":    Sort,
+	"sort_ro": ReadFirstKey,
+
+	// sortedset
+	"zadd":             WriteFirstKey,
+	"zrem":             WriteFirstKey,
+	"zremrangebyscore": WriteFirstKey,
+	"zremrangebyrank":  WriteFirstKey,
+	"zincrby":          WriteFirstKey,
+	"zpopmin":          WriteFirstKey,
+	"zpopmax":          WriteFirstKey,
+	"bzpopmin":         BlockingPop,
+	"bzpopmax":         BlockingPop,
+	"zmpop":            WriteNumKeys,
+	"zrangestore":      ZRangeStore,
+	"zscore":           ReadFirstKey,
+	"zmscore":          ReadFirstKey,
+	"zrandmember":      ReadFirstKey,
+	"zrank":            ReadFirstKey,
+	"zrevrank":         ReadFirstKey,
+	"zcount":           ReadFirstKey,
+	"zcard":            ReadFirstKey,
+	"zrange":           ReadFirstKey,
+	"zrevrange":        ReadFirstKey,
+	"zrangebyscore":    ReadFirstKey,
+	"zrevrangebyscore": ReadFirstKey,
+	"zlexcount":        ReadFirstKey,
+	"zrangebylex":      ReadFirstKey,
+	"zremrangebylex":   WriteFirstKey,
+	"zrevrangebylex":   ReadFirstKey,
+	"zscan":            ReadFirstKey,
+	"zunion":           ReadNumKeys,
+	"zinter":           ReadNumKeys,
+	"zdiff":            ReadNumKeys,
+	"zintercard":       ReadNumKeys,
+	"zunionstore":      WriteFirstReadNumKeys,
+	"zinterstore":      WriteFirstReadNumKeys,
+	"zdiffstore":       WriteFirstReadNumKeys,
+
+	// string
+	"set":         WriteFirstKey,
+	"setnx":       WriteFirstKey,
+	"setex":       WriteFirstKey,
+	"psetex":      WriteFirstKey,
+	"mset":        MSet,
+	"msetnx":      MSet,
+	"msetex":      MSetEX,
+	"get":         ReadFirstKey,
+	"mget":        ReadAllKeys,
+	"getex":       WriteFirstKey,
+	"getset":      WriteFirstKey,
+	"getdel":      WriteFirstKey,
+	"incr":        WriteFirstKey,
+	"incrby":      WriteFirstKey,
+	"incrbyfloat": WriteFirstKey,
+	"decr":        WriteFirstKey,
+	"decrby":      WriteFirstKey,
+	"strlen":      ReadFirstKey,
+	"append":      WriteFirstKey,
+	"setrange":    WriteFirstKey,
+	"getrange":    ReadFirstKey,
+	"substr":      ReadFirstKey,
+	"getbit":      ReadFirstKey,
+	"setbit":      WriteFirstKey,
+	"bitcount":    ReadFirstKey,
+	"bitpos":      ReadFirstKey,
+	"randomkey":   ReadAllKeys,
+	"lcs":         ReadFirstTwoKeys,
+}
+
+// Lookup 返回命令的 Prepare 函数，命令不在表中时返回 nil
+func Lookup(name string) Prepare {
+	return specs[strings.ToLower(name)]
+}
+
+// GetRelatedKeys 返回命令涉及的写键和读键，cmdLine 包含命令名
+// 命令不存在或没有 key 规格时返回 nil
+func GetRelatedKeys(cmdLine [][]byte) ([]string, []string) {
+	prepare := Lookup(string(cmdLine[0]))
+	if prepare == nil {
+		return nil, nil
+	}
+	return prepare(cmdLine[1:])
+}
+
+// 命令只读取其第一个参数作为键
+// for example: GET <key>, EXISTS <key>, TTL <key>
+func ReadFirstKey(args [][]byte) ([]string, []string) {
+	return nil, []string{string(args[0])}
+}
+
+// 命令会读取其所有参数作为键
+// for example: MGET key1 key2 key3, SINTER key1 key2
+func ReadAllKeys(args [][]byte) ([]string, []string) {
+	return nil, toStrings(args)
+}
+
+// 命令只写入其第一个参数作为键
+// for example: SET <key> <value>, DEL <key>, INCR <key>
+func WriteFirstKey(args [][]byte) ([]string, []string) {
+	return []string{string(args[0])}, nil
+}
+
+// 命令会写入其所有参数作为键
+// for example: DEL key1 key2 key3
+func WriteAllKeys(args [][]byte) ([]string, []string) {
+	return toStrings(args), nil
+}
+
+// 命令不涉及对任何特定键的读写操作
+// for example: PING, ECHO
+func NoKeys(args [][]byte) ([]string, []string) {
+	return nil, nil
+}
+
+// 写入第一个参数，读取其余参数
+// for example: SINTERSTORE destination set1 set2 set3
+func WriteFirstReadRest(args [][]byte) ([]string, []string) {
+	return []string{string(args[0])}, toStrings(args[1:])
+}
+
+// 读取前两个参数
+// for example: LCS key1 key2
+func ReadFirstTwoKeys(args [][]byte) ([]string, []string) {
+	return nil, []string{string(args[0]), string(args[1])}
+}
+
+// 写入前两个参数
+// for example: RPOPLPUSH source destination, SMOVE source destination member
+func WriteFirstTwoKeys(args [][]byte) ([]string, []string) {
+	return []string{string(args[0]), string(args[1])}, nil
+}
+
+// NumKeys 返回 numkeys 之后的键，numkeys 无效时返回 nil，由命令本身报告错误
+// for example: numkeys key [key ...]
+func NumKeys(args [][]byte) []string {
+	if len(args) == 0 {
+		return nil
+	}
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil || numKeys <= 0 || numKeys > len(args)-1 {
+		return nil
+	}
+	return toStrings(args[1 : numKeys+1])
+}
+
+// 读取 numkeys 之后的键
+// for example: ZUNION numkeys key [key ...]
+func ReadNumKeys(args [][]byte) ([]string, []string) {
+	return nil, NumKeys(args)
+}
+
+// 写入 numkeys 之后的键
+// for example: ZMPOP numkeys key [key ...] MIN|MAX
+func WriteNumKeys(args [][]byte) ([]string, []string) {
+	return NumKeys(args), nil
+}
+
+// 写入 destination，读取 numkeys 之后的键
+// for example: ZUNIONSTORE destination numkeys key [key ...]
+func WriteFirstReadNumKeys(args [][]byte) ([]string, []string) {
+	return []string{string(args[0])}, NumKeys(args[1:])
+}
+
+// BLMPOP timeout numkeys key [key ...] LEFT|RIGHT
+func BLMPop(args [][]byte) ([]string, []string) {
+	return NumKeys(args[1:]), nil
+}
+
+// 最后一个参数为超时时间，其余参数都是写键
+// for example: BLPOP key [key ...] timeout
+func BlockingPop(args [][]byte) ([]string, []string) {
+	return toStrings(args[:len(args)-1]), nil
+}
+
+// ZRANGESTORE dst src min max
+func ZRangeStore(args [][]byte) ([]string, []string) {
+	return []string{string(args[0])}, []string{string(args[1])}
+}
+
+// RENAME key newkey
+func Rename(args [][]byte) ([]string, []string) {
+	return []string{string(args[0])}, []string{string(args[1])}
+}
+
+// COPY source destination [DB destination-db] [REPLACE]
+func Copy(args [][]byte) ([]string, []string) {
+	return []string{string(args[1])}, []string{string(args[0])}
+}
+
+// BITOP operation destkey key [key ...]
+func BitOp(args [][]byte) ([]string, []string) {
+	return []string{string(args[1])}, toStrings(args[2:])
+}
+
+// MSET key value [key value ...]
+func MSet(args [][]byte) ([]string, []string) {
+	keys := make([]string, len(args)/2)
+	for i := range keys {
+		keys[i] = string(args[i*2])
+	}
+	return keys, nil
+}
+
+// MSETEX numkeys key value [key value ...] [options]
+func MSetEX(args [][]byte) ([]string, []string) {
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil || numKeys <= 0 || 1+numKeys*2 > len(args) {
+		return nil, nil
+	}
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = string(args[1+i*2])
+	}
+	return keys, nil
+}
+
+// OBJECT subcommand key
+func Object(args [][]byte) ([]string, []string) {
+	if len(args) < 2 {
+		return nil, nil
+	}
+	return nil, []string{string(args[1])}
+}
+
+// SORT key [... STORE destination]，指定 STORE 时目标 key 为写 key
+func Sort(args [][]byte) ([]string, []string) {
+	var write []string
+	for i := 1; i+1 < len(args); i++ {
+		if strings.ToUpper(string(args[i])) == "STORE" {
+			write = []string{string(args[i+1])}
+		}
+	}
+	return write, []string{string(args[0])}
+}
+
+// GeoRadiusStoreKey 返回 STORE/STOREDIST 指定的目标 key，未指定时返回空串，
+// optStart 为第一个可选参数的位置
+func GeoRadiusStoreKey(args [][]byte, optStart int) string {
+	storeKey := ""
+	for i := optStart; i < len(args)-1; i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "STORE", "STOREDIST":
+			storeKey = string(args[i+1])
+			i++
+		case "COUNT":
+			i++
+		}
+	}
+	return storeKey
+}
+
+// GeoRadius 返回读写的 key，指定 STORE/STOREDIST 时目标 key 为写 key
+func GeoRadius(optStart int) Prepare {
+	return func(args [][]byte) ([]string, []string) {
+		if storeKey := GeoRadiusStoreKey(args, optStart); storeKey != "" {
+			return []string{storeKey}, []string{string(args[0])}
+		}
+		return nil, []string{string(args[0])}
+	}
+}
+
+// MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [AUTH password]
+// [AUTH2 username password] [KEYS key [key ...]]
+//
+// MIGRATE 会删除迁移成功的键，因此所有键都是写键
+func Migrate(args [][]byte) ([]string, []string) {
+	if len(args) < 5 {
+		return nil, nil
+	}
+	if len(args[2]) != 0 {
+		return []string{string(args[2])}, nil
+	}
+	for i := 5; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "auth":
+			i++
+		case "auth2":
+			i += 2
+		case "keys":
+			return toStrings(args[i+1:]), nil
+		}
+	}
+	return nil, nil
+}
+
+func toStrings(args [][]byte) []string {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
+	}
+	return keys
+}
@@ -0,0 +1,33 @@
+package keyspec
+
+import (
+	"myredis/lib/utils"
+	"reflect"
+	"testing"
+)
+
+func TestGetRelatedKeys(t *testing.T) {
+	cases := []struct {
+		cmdLine     []string
+		write, read []string
+	}{
+		{[]string{"GET", "a"}, nil, []string{"a"}},
+		{[]string{"mset", "a", "1", "b", "2"}, []string{"a", "b"}, nil},
+		{[]string{"ZUNIONSTORE", "d", "2", "a", "b", "WEIGHTS", "1", "2"}, []string{"d"}, []string{"a", "b"}},
+		{[]string{"ZUNION", "x"}, nil, nil},
+		{[]string{"BLMPOP", "0", "2", "a", "b", "LEFT"}, []string{"a", "b"}, nil},
+		{[]string{"MSETEX", "2", "a", "1", "b", "2", "EX", "10"}, []string{"a", "b"}, nil},
+		{[]string{"SORT", "a", "BY", "w_*", "STORE", "d"}, []string{"d"}, []string{"a"}},
+		{[]string{"GEORADIUSBYMEMBER", "a", "m", "1", "km", "COUNT", "1", "STORE", "d"}, []string{"d"}, []string{"a"}},
+		{[]string{"MIGRATE", "h", "6379", "a", "0", "100"}, []string{"a"}, nil},
+		{[]string{"MIGRATE", "h", "6379", "", "0", "100", "AUTH", "keys", "KEYS", "a", "b"}, []string{"a", "b"}, nil},
+		{[]string{"PING"}, nil, nil},
+		{[]string{"no-such-command", "a"}, nil, nil},
+	}
+	for _, c := range cases {
+		write, read := GetRelatedKeys(utils.ToCmdLine(c.cmdLine...))
+		if !reflect.DeepEqual(write, c.write) || !reflect.DeepEqual(read, c.read) {
+			t.Errorf("%v: expect %v %v, actually %v %v", c.cmdLine, c.write, c.read, write, read)
+		}
+	}
+}
@@ -72,8 +72,9 @@ type Client struct {
 	pendingReqs chan *request // 待发送请求的缓冲队列
 	waitingReqs chan *request // 等待响应的请求队列
 
-	addr   string
-	status int32
+	addr    string
+	status  int32
+	timeout time.Duration // 等待回复的超时时间
 
 	ticker  *time.Ticker
 	working *sync.WaitGroup // 正在处理的请求计数
@@ -94,13 +95,27 @@ func NewClient(addr string) (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
+	return makeClient(conn, addr, maxWait), nil
+}
+
+// 建立连接与等待每个回复都不超过 timeout 的客户端
+func NewClientWithTimeout(addr string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return makeClient(conn, addr, timeout), nil
+}
+
+func makeClient(conn net.Conn, addr string, timeout time.Duration) *Client {
 	return &Client{
 		conn:        conn,
 		addr:        addr,
+		timeout:     timeout,
 		pendingReqs: make(chan *request, chanSize),
 		waitingReqs: make(chan *request, chanSize),
 		working:     &sync.WaitGroup{},
-	}, nil
+	}
 }
 
 func (client *Client) RemoteAddress() string {
@@ -132,8 +147,41 @@ func (client *Client) Send(args [][]byte) myredis.Reply {
 	defer client.working.Done()
 	// 加入发送缓冲队列
 	client.pendingReqs <- req
-	timeout := req.waiting.WaitWithTimeout(maxWait)
-	if timeout {
+	return req.waitReply(client.timeout)
+}
+
+// 批量发送命令（pipeline）：所有请求依次写入连接后再统一等待回复，
+// 回复顺序与 cmdLines 一致
+func (client *Client) Pipeline(cmdLines [][][]byte) []myredis.Reply {
+	replies := make([]myredis.Reply, len(cmdLines))
+	if atomic.LoadInt32(&client.status) != running {
+		for i := range replies {
+			replies[i] = protocol.MakeErrReply("client closed")
+		}
+		return replies
+	}
+	client.working.Add(1)
+	defer client.working.Done()
+	reqs := make([]*request, len(cmdLines))
+	for i, args := range cmdLines {
+		req := &request{
+			args:      args,
+			heartbeat: false,
+			waiting:   &wait.Wait{},
+		}
+		req.waiting.Add(1)
+		client.pendingReqs <- req
+		reqs[i] = req
+	}
+	for i, req := range reqs {
+		replies[i] = req.waitReply(client.timeout)
+	}
+	return replies
+}
+
+// 等待请求完成，超时或发送失败时返回错误回复
+func (req *request) waitReply(timeout time.Duration) myredis.Reply {
+	if req.waiting.WaitWithTimeout(timeout) {
 		return protocol.MakeErrReply("server time out")
 	}
 	if req.err != nil {
@@ -142,12 +190,26 @@ func (client *Client) Send(args [][]byte) myredis.Reply {
 	return req.reply
 }
 
+// 判断是否为连接层面的错误（而非服务端返回的业务错误）
+func IsConnErr(reply myredis.Reply) bool {
+	errReply, ok := reply.(protocol.ErrorReply)
+	if !ok {
+		return false
+	}
+	msg := errReply.Error()
+	return msg == "client closed" || msg == "server time out" || strings.HasPrefix(msg, "request failed")
+}
+
 // 关闭客户端连接
 //
 // 顺序：切换状态/关闭定时器/关闭发送缓冲/关闭连接和结果队列
+//
+// 重连失败时也会关闭客户端，重复调用直接返回
 func (client *Client) Close() {
 	// 切换状态，关闭定时器
-	atomic.StoreInt32(&client.status, closed)
+	if atomic.SwapInt32(&client.status, closed) == closed {
+		return
+	}
 	client.ticker.Stop()
 	close(client.pendingReqs)
 
@@ -0,0 +1,369 @@
+// ClusterClient 是集群模式下的客户端：
+//
+//   - 通过 CLUSTER SLOTS 获取槽位到节点的映射，按 key 所属槽位选择节点
+//   - 遇到 MOVED 时更新映射并重定向，遇到 ASK 时先发送 ASKING 再重试
+//   - 连接出错或被重定向后刷新集群拓扑
+//   - 为每个节点维护一个连接池，批量命令按节点拆分后并发发送
+package client
+
+import (
+	"errors"
+	"fmt"
+	"myredis/interface/myredis"
+	"myredis/lib/hashslot"
+	"myredis/lib/keyspec"
+	"myredis/lib/logger"
+	"myredis/lib/pool"
+	"myredis/lib/utils"
+	"myredis/protocol"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 单条命令最多重定向的次数，避免集群拓扑异常时无限重试
+const maxRedirects = 5
+
+var clusterPoolConfig = pool.PoolConfig{
+	MaxIdle:     1,
+	MaxActivate: 16,
+}
+
+type ClusterClient struct {
+	seeds []string // 初始节点，拓扑信息全部失效时使用
+
+	mu    sync.RWMutex
+	slots []string              // 槽位 -> 节点地址
+	pools map[string]*pool.Pool // 节点地址 -> 连接池
+
+	refreshing int32 // 是否有正在进行的拓扑刷新
+}
+
+// 创建集群客户端，seeds 中至少需要有一个可用节点
+func NewClusterClient(seeds []string) (*ClusterClient, error) {
+	if len(seeds) == 0 {
+		return nil, errors.New("at least one seed node is required")
+	}
+	cc := &ClusterClient{
+		seeds: seeds,
+		slots: make([]string, hashslot.SlotCount),
+		pools: make(map[string]*pool.Pool),
+	}
+	if err := cc.RefreshTopology(); err != nil {
+		cc.Close()
+		return nil, err
+	}
+	return cc, nil
+}
+
+// 获取指定节点的连接池，不存在时创建
+func (cc *ClusterClient) getPool(addr string) *pool.Pool {
+	cc.mu.RLock()
+	p := cc.pools[addr]
+	cc.mu.RUnlock()
+	if p != nil {
+		return p
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if p = cc.pools[addr]; p != nil {
+		return p
+	}
+	factory := func() (interface{}, error) {
+		c, err := NewClient(addr)
+		if err != nil {
+			return nil, err
+		}
+		c.Start()
+		return c, nil
+	}
+	finalizer := func(x interface{}) {
+		if c, ok := x.(*Client); ok {
+			c.Close()
+		}
+	}
+	p = pool.NewPool(factory, finalizer, clusterPoolConfig)
+	cc.pools[addr] = p
+	return p
+}
+
+// 从节点连接池借用连接执行 fn，执行完毕后归还
+func (cc *ClusterClient) withClient(addr string, fn func(c *Client)) error {
+	p := cc.getPool(addr)
+	raw, err := p.Get()
+	if err != nil {
+		return err
+	}
+	c, ok := raw.(*Client)
+	if !ok {
+		return errors.New("connection pool make wrong type")
+	}
+	defer p.Put(c)
+	fn(c)
+	return nil
+}
+
+// ******************** Topology ********************
+
+// 从任意可用节点获取 CLUSTER SLOTS，重建槽位映射
+func (cc *ClusterClient) RefreshTopology() error {
+	cc.mu.RLock()
+	candidates := make([]string, 0, len(cc.pools)+len(cc.seeds))
+	for addr := range cc.pools {
+		candidates = append(candidates, addr)
+	}
+	cc.mu.RUnlock()
+	candidates = append(candidates, cc.seeds...)
+
+	var lastErr error
+	for _, addr := range candidates {
+		var reply myredis.Reply
+		err := cc.withClient(addr, func(c *Client) {
+			reply = c.Send(utils.ToCmdLine("CLUSTER", "SLOTS"))
+		})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		slots, err := parseClusterSlots(reply)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %v", addr, err)
+			continue
+		}
+		cc.mu.Lock()
+		cc.slots = slots
+		cc.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("refresh cluster topology failed: %v", lastErr)
+}
+
+// 异步刷新拓扑，同一时刻最多只有一个刷新任务
+func (cc *ClusterClient) refreshAsync() {
+	if !atomic.CompareAndSwapInt32(&cc.refreshing, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&cc.refreshing, 0)
+		if err := cc.RefreshTopology(); err != nil {
+			logger.Warn(err)
+		}
+	}()
+}
+
+// 解析 CLUSTER SLOTS 的回复：[[start, end, [host, port, ...], ...], ...]
+func parseClusterSlots(reply myredis.Reply) ([]string, error) {
+	slots := make([]string, hashslot.SlotCount)
+	if protocol.IsEmptyMultiBulkReply(reply) {
+		return slots, nil
+	}
+	ranges, ok := reply.(*protocol.MultiRawReply)
+	if !ok {
+		return nil, fmt.Errorf("unexpected reply: %s", strings.TrimSpace(string(reply.ToBytes())))
+	}
+	for _, raw := range ranges.Replies {
+		item, ok := raw.(*protocol.MultiRawReply)
+		if !ok || len(item.Replies) < 3 {
+			return nil, errors.New("illegal slot range")
+		}
+		start, ok1 := item.Replies[0].(*protocol.IntReply)
+		end, ok2 := item.Replies[1].(*protocol.IntReply)
+		master, ok3 := item.Replies[2].(*protocol.MultiRawReply)
+		if !ok1 || !ok2 || !ok3 || len(master.Replies) < 2 {
+			return nil, errors.New("illegal slot range")
+		}
+		host, ok1 := master.Replies[0].(*protocol.BulkReply)
+		port, ok2 := master.Replies[1].(*protocol.IntReply)
+		if !ok1 || !ok2 {
+			return nil, errors.New("illegal node address")
+		}
+		if start.Code < 0 || end.Code >= int64(hashslot.SlotCount) || start.Code > end.Code {
+			return nil, errors.New("slot out of range")
+		}
+		addr := net.JoinHostPort(string(host.Arg), strconv.FormatInt(port.Code, 10))
+		for i := start.Code; i <= end.Code; i++ {
+			slots[i] = addr
+		}
+	}
+	return slots, nil
+}
+
+// ******************** Routing ********************
+
+// 计算命令所属的槽位，不涉及 key 的命令返回 -1
+func cmdSlot(args [][]byte) (int, error) {
+	write, read := keyspec.GetRelatedKeys(args)
+	keys := append(write, read...)
+	// 未知命令，按照第一个参数为 key 处理
+	if len(keys) == 0 && len(args) > 1 && !isKeylessCommand(args) {
+		keys = []string{string(args[1])}
+	}
+	slot := -1
+	for _, key := range keys {
+		s := int(hashslot.GetSlot(key))
+		if slot != -1 && s != slot {
+			return 0, errors.New("CROSSSLOT Keys in request don't hash to the same slot")
+		}
+		slot = s
+	}
+	return slot, nil
+}
+
+// 判断命令是否不涉及 key
+func isKeylessCommand(args [][]byte) bool {
+	switch strings.ToLower(string(args[0])) {
+	case "ping", "echo", "info", "auth", "select", "cluster", "command", "dbsize", "flushdb", "flushall":
+		return true
+	}
+	return false
+}
+
+// 选择负责槽位的节点，槽位未知时使用任意节点
+func (cc *ClusterClient) pickNode(slot int) string {
+	cc.mu.RLock()
+	defer cc.mu.RUnlock()
+	if slot >= 0 && cc.slots[slot] != "" {
+		return cc.slots[slot]
+	}
+	for _, addr := range cc.slots {
+		if addr != "" {
+			return addr
+		}
+	}
+	return cc.seeds[0]
+}
+
+// 解析 MOVED/ASK 错误，返回重定向类型与目标地址
+// for example: MOVED 3999 127.0.0.1:6381
+func parseRedirect(reply myredis.Reply) (kind string, slot int, addr string) {
+	errReply, ok := reply.(protocol.ErrorReply)
+	if !ok {
+		return "", 0, ""
+	}
+	fields := strings.Fields(errReply.Error())
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return "", 0, ""
+	}
+	slot, err := strconv.Atoi(fields[1])
+	if err != nil || slot < 0 || slot >= hashslot.SlotCount {
+		return "", 0, ""
+	}
+	return fields[0], slot, fields[2]
+}
+
+// 向集群发送命令，自动处理重定向
+func (cc *ClusterClient) Send(args [][]byte) myredis.Reply {
+	slot, err := cmdSlot(args)
+	if err != nil {
+		return protocol.MakeErrReply(err.Error())
+	}
+	return cc.sendToSlot(slot, args)
+}
+
+func (cc *ClusterClient) sendToSlot(slot int, args [][]byte) myredis.Reply {
+	addr := cc.pickNode(slot)
+	asking := false
+	var reply myredis.Reply
+	for i := 0; i <= maxRedirects; i++ {
+		err := cc.withClient(addr, func(c *Client) {
+			if asking {
+				c.Send(utils.ToCmdLine("ASKING"))
+			}
+			reply = c.Send(args)
+		})
+		if err != nil || IsConnErr(reply) {
+			// 节点不可用，刷新拓扑后重新选择节点
+			if refreshErr := cc.RefreshTopology(); refreshErr != nil {
+				logger.Warn(refreshErr)
+			}
+			if err != nil {
+				reply = protocol.MakeErrReply(err.Error())
+			}
+			addr, asking = cc.pickNode(slot), false
+			continue
+		}
+		kind, movedSlot, target := parseRedirect(reply)
+		switch kind {
+		case "MOVED":
+			// 槽位已永久迁移，更新本地映射并刷新拓扑
+			cc.mu.Lock()
+			cc.slots[movedSlot] = target
+			cc.mu.Unlock()
+			cc.refreshAsync()
+			addr, asking = target, false
+		case "ASK":
+			// 槽位迁移中，仅本次请求发往目标节点
+			addr, asking = target, true
+		default:
+			return reply
+		}
+	}
+	return reply
+}
+
+// 批量发送命令，按节点拆分后各自以 pipeline 方式并发发送
+//
+// 回复顺序与 cmdLines 一致；被重定向的命令会单独重试
+func (cc *ClusterClient) Pipeline(cmdLines [][][]byte) []myredis.Reply {
+	replies := make([]myredis.Reply, len(cmdLines))
+	slots := make([]int, len(cmdLines))
+	groups := make(map[string][]int) // 节点地址 -> 命令位置
+	for i, args := range cmdLines {
+		slot, err := cmdSlot(args)
+		if err != nil {
+			replies[i] = protocol.MakeErrReply(err.Error())
+			continue
+		}
+		slots[i] = slot
+		addr := cc.pickNode(slot)
+		groups[addr] = append(groups[addr], i)
+	}
+
+	var wg sync.WaitGroup
+	for addr, indexes := range groups {
+		wg.Add(1)
+		go func(addr string, indexes []int) {
+			defer wg.Done()
+			batch := make([][][]byte, len(indexes))
+			for i, index := range indexes {
+				batch[i] = cmdLines[index]
+			}
+			var results []myredis.Reply
+			err := cc.withClient(addr, func(c *Client) {
+				results = c.Pipeline(batch)
+			})
+			for i, index := range indexes {
+				if err != nil {
+					replies[index] = protocol.MakeErrReply(err.Error())
+				} else {
+					replies[index] = results[i]
+				}
+			}
+		}(addr, indexes)
+	}
+	wg.Wait()
+
+	// 重定向或连接失败的命令逐条重试
+	for i, reply := range replies {
+		if reply == nil {
+			continue
+		}
+		if kind, _, _ := parseRedirect(reply); kind != "" || IsConnErr(reply) {
+			replies[i] = cc.sendToSlot(slots[i], cmdLines[i])
+		}
+	}
+	return replies
+}
+
+// 关闭所有节点的连接池
+func (cc *ClusterClient) Close() {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	for _, p := range cc.pools {
+		p.Close()
+	}
+	cc.pools = make(map[string]*pool.Pool)
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"myredis/interface/myredis"
+	"myredis/lib/hashslot"
+	"myredis/lib/utils"
+	"myredis/protocol"
+	"testing"
+)
+
+func makeNode(host string, port int64) myredis.Reply {
+	return protocol.MakeMultiRawReply([]myredis.Reply{
+		protocol.MakeBulkReply([]byte(host)),
+		protocol.MakeIntReply(port),
+	})
+}
+
+func TestParseClusterSlots(t *testing.T) {
+	reply := protocol.MakeMultiRawReply([]myredis.Reply{
+		protocol.MakeMultiRawReply([]myredis.Reply{
+			protocol.MakeIntReply(0),
+			protocol.MakeIntReply(511),
+			makeNode("127.0.0.1", 6399),
+		}),
+		protocol.MakeMultiRawReply([]myredis.Reply{
+			protocol.MakeIntReply(512),
+			protocol.MakeIntReply(int64(hashslot.SlotCount - 1)),
+			makeNode("127.0.0.1", 6400),
+			makeNode("127.0.0.1", 6401),
+		}),
+	})
+	slots, err := parseClusterSlots(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slots[0] != "127.0.0.1:6399" || slots[511] != "127.0.0.1:6399" {
+		t.Errorf("wrong owner of first range: %s", slots[0])
+	}
+	if slots[512] != "127.0.0.1:6400" || slots[hashslot.SlotCount-1] != "127.0.0.1:6400" {
+		t.Errorf("wrong owner of second range: %s", slots[512])
+	}
+
+	_, err = parseClusterSlots(protocol.MakeErrReply("ERR unknown command 'cluster'"))
+	if err == nil {
+		t.Error("expect error for error reply")
+	}
+}
+
+func TestParseRedirect(t *testing.T) {
+	kind, slot, addr := parseRedirect(protocol.MakeErrReply("MOVED 12 127.0.0.1:6381"))
+	if kind != "MOVED" || slot != 12 || addr != "127.0.0.1:6381" {
+		t.Errorf("wrong redirect: %s %d %s", kind, slot, addr)
+	}
+	kind, _, _ = parseRedirect(protocol.MakeErrReply("ASK 12 127.0.0.1:6381"))
+	if kind != "ASK" {
+		t.Errorf("expect ASK, actually %s", kind)
+	}
+	kind, _, _ = parseRedirect(protocol.MakeErrReply("ERR no such key"))
+	if kind != "" {
+		t.Errorf("expect no redirect, actually %s", kind)
+	}
+}
+
+func TestCmdSlot(t *testing.T) {
+	slot, err := cmdSlot(utils.ToCmdLine("GET", "a"))
+	if err != nil || slot != int(hashslot.GetSlot("a")) {
+		t.Errorf("wrong slot %d, %v", slot, err)
+	}
+	slot, err = cmdSlot(utils.ToCmdLine("PING"))
+	if err != nil || slot != -1 {
+		t.Errorf("keyless command should have no slot, actually %d", slot)
+	}
+	_, err = cmdSlot(utils.ToCmdLine("MSET", "{a}1", "1", "{a}2", "2"))
+	if err != nil {
+		t.Errorf("keys with same hashtag should be allowed: %v", err)
+	}
+	_, err = cmdSlot(utils.ToCmdLine("MGET", "a", "b"))
+	if err == nil && hashslot.GetSlot("a") != hashslot.GetSlot("b") {
+		t.Error("expect cross slot error")
+	}
+}
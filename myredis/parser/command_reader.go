@@ -0,0 +1,137 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// 单条命令允许的最大参数个数与单个参数的最大长度，超过时视为文件损坏
+const (
+	maxCommandArgs = 1 << 20
+	maxBulkLen     = 512 << 20
+)
+
+// 命令流中的格式错误，Offset 为出错命令的起始位置
+type CorruptionError struct {
+	Offset int64
+	Msg    string
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("bad format at offset %d: %s", e.Offset, e.Msg)
+}
+
+// CommandReader 严格地从 AOF 等命令流中逐条读取命令
+//
+// 与 ParseStream 不同，遇到格式错误时不会跳过，而是返回错误与出错位置：
+//   - io.EOF: 在命令边界处正常结束
+//   - io.ErrUnexpectedEOF: 最后一条命令不完整，Offset() 为最后一条完整命令的结束位置
+//   - *CorruptionError: 格式错误
+//
+// 以 '#' 开头的行为注释（例如 AOF 中的时间戳 #TS:<unix>），读取时跳过
+type CommandReader struct {
+	reader *bufio.Reader
+	offset int64 // 已读取的完整命令的结束位置
+	read   int64 // 当前命令已读取的字节数
+
+	// 读到注释时调用，annotation 不含 '#' 与 CRLF，offset 为注释的起始位置
+	OnAnnotation func(annotation string, offset int64)
+}
+
+// offset 为 reader 在文件中的起始位置，用于计算错误位置
+func NewCommandReader(reader io.Reader, offset int64) *CommandReader {
+	return &CommandReader{
+		reader: bufio.NewReader(reader),
+		offset: offset,
+	}
+}
+
+// 返回最后一条完整命令的结束位置
+func (r *CommandReader) Offset() int64 {
+	return r.offset
+}
+
+// 读取下一条命令，格式为全部由 bulk string 组成的数组
+func (r *CommandReader) ReadCommand() ([][]byte, error) {
+	var header []byte
+	for {
+		r.read = 0
+		line, err := r.readLine()
+		if err != nil {
+			if err == io.EOF && r.read == 0 {
+				return nil, io.EOF
+			}
+			return nil, r.wrapErr(err)
+		}
+		if line[0] != '#' {
+			header = line
+			break
+		}
+		if r.OnAnnotation != nil {
+			r.OnAnnotation(string(line[1:]), r.offset)
+		}
+		r.offset += r.read
+	}
+	if header[0] != '*' {
+		return nil, r.corrupt("expect array header, got " + strconv.Quote(string(header)))
+	}
+	n, err := strconv.ParseInt(string(header[1:]), 10, 64)
+	if err != nil || n <= 0 || n > maxCommandArgs {
+		return nil, r.corrupt("illegal array header " + strconv.Quote(string(header)))
+	}
+	args := make([][]byte, 0, n)
+	for i := int64(0); i < n; i++ {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, r.wrapErr(err)
+		}
+		if line[0] != '$' {
+			return nil, r.corrupt("expect bulk string header, got " + strconv.Quote(string(line)))
+		}
+		size, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil || size < 0 || size > maxBulkLen {
+			return nil, r.corrupt("illegal bulk string header " + strconv.Quote(string(line)))
+		}
+		body := make([]byte, size+2)
+		n, err := io.ReadFull(r.reader, body)
+		r.read += int64(n)
+		if err != nil {
+			return nil, r.wrapErr(err)
+		}
+		if !bytes.HasSuffix(body, []byte{'\r', '\n'}) {
+			return nil, r.corrupt("bulk string is not terminated by CRLF")
+		}
+		args = append(args, body[:size])
+	}
+	r.offset += r.read
+	return args, nil
+}
+
+// 读取一行并去除 CRLF
+func (r *CommandReader) readLine() ([]byte, error) {
+	line, err := r.reader.ReadBytes('\n')
+	r.read += int64(len(line))
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, r.corrupt("illegal line " + strconv.Quote(string(line)))
+	}
+	return line[:len(line)-2], nil
+}
+
+// 命令中途遇到 EOF 说明命令不完整
+func (r *CommandReader) wrapErr(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (r *CommandReader) corrupt(msg string) error {
+	return &CorruptionError{Offset: r.offset, Msg: msg}
+}
@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"myredis/protocol"
+	"testing"
+)
+
+func TestCommandReader(t *testing.T) {
+	set := protocol.MakeMultiBulkReply([][]byte{[]byte("SET"), []byte("a"), []byte("a\r\nb")}).ToBytes()
+	del := protocol.MakeMultiBulkReply([][]byte{[]byte("DEL"), []byte("a")}).ToBytes()
+	data := append(append([]byte{}, set...), del...)
+
+	reader := NewCommandReader(bytes.NewReader(data), 10)
+	cmd, err := reader.ReadCommand()
+	if err != nil || len(cmd) != 3 || string(cmd[2]) != "a\r\nb" {
+		t.Fatalf("wrong command %q %v", cmd, err)
+	}
+	if reader.Offset() != int64(10+len(set)) {
+		t.Errorf("wrong offset %d", reader.Offset())
+	}
+	if _, err = reader.ReadCommand(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = reader.ReadCommand(); err != io.EOF {
+		t.Errorf("expect EOF, actually %v", err)
+	}
+
+	// 末尾的命令不完整
+	for i := 1; i < len(del); i++ {
+		reader = NewCommandReader(bytes.NewReader(data[:len(set)+i]), 0)
+		_, _ = reader.ReadCommand()
+		if _, err = reader.ReadCommand(); err != io.ErrUnexpectedEOF {
+			t.Errorf("expect unexpected EOF at %d, actually %v", i, err)
+		}
+		if reader.Offset() != int64(len(set)) {
+			t.Errorf("wrong offset %d", reader.Offset())
+		}
+	}
+
+	// 格式错误
+	bad := [][]byte{
+		[]byte("+OK\r\n"),
+		[]byte("*2\r\n$3\r\nDEL\r\n:1\r\n"),
+		[]byte("*x\r\n"),
+		[]byte("*1\r\n$3\r\nDELxx"),
+		[]byte("*1\n"),
+	}
+	for _, b := range bad {
+		reader = NewCommandReader(bytes.NewReader(append(append([]byte{}, set...), b...)), 0)
+		_, _ = reader.ReadCommand()
+		_, err = reader.ReadCommand()
+		var corruption *CorruptionError
+		if !errors.As(err, &corruption) || corruption.Offset != int64(len(set)) {
+			t.Errorf("expect corruption at %d for %q, actually %v", len(set), b, err)
+		}
+	}
+}
+
+func TestCommandReaderAnnotation(t *testing.T) {
+	set := protocol.MakeMultiBulkReply([][]byte{[]byte("SET"), []byte("a"), []byte("1")}).ToBytes()
+	data := append([]byte("#TS:100\r\n"), set...)
+	data = append(data, "#TS:101\r\n"...)
+
+	reader := NewCommandReader(bytes.NewReader(data), 0)
+	var annotations []string
+	var offsets []int64
+	reader.OnAnnotation = func(annotation string, offset int64) {
+		annotations = append(annotations, annotation)
+		offsets = append(offsets, offset)
+	}
+	if cmd, err := reader.ReadCommand(); err != nil || string(cmd[0]) != "SET" {
+		t.Fatalf("wrong command %q %v", cmd, err)
+	}
+	if _, err := reader.ReadCommand(); err != io.EOF {
+		t.Errorf("expect EOF, actually %v", err)
+	}
+	if len(annotations) != 2 || annotations[1] != "TS:101" || offsets[1] != int64(9+len(set)) {
+		t.Errorf("wrong annotations %v %v", annotations, offsets)
+	}
+	if reader.Offset() != int64(len(data)) {
+		t.Errorf("wrong offset %d", reader.Offset())
+	}
+}
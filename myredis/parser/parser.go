@@ -215,38 +215,116 @@ func parseArray(header []byte, reader *bufio.Reader, ch chan<- *Payload) error {
 		}
 		return nil
 	}
-	lines := make([][]byte, 0, nStrs)
-	for i := int64(0); i < nStrs; i++ {
-		var line []byte
-		line, err = reader.ReadBytes('\n')
+	reply, err := readArrayBody(nStrs, reader)
+	if err != nil {
+		if _, ok := err.(*protocolErr); ok {
+			protocolError(ch, err.Error())
+			return nil
+		}
+		return err
+	}
+	ch <- &Payload{
+		Data: reply,
+	}
+	return nil
+}
+
+// 协议格式错误，与 IO 错误区分：前者只丢弃当前回复，后者需要关闭解析流
+type protocolErr struct {
+	msg string
+}
+
+func (e *protocolErr) Error() string {
+	return e.msg
+}
+
+// 读取数组中的 n 个元素
+//
+// 元素全部为 bulk string 时返回 MultiBulkReply（命令请求总是这种形式），
+// 否则（例如嵌套数组、整数，常见于 CLUSTER SLOTS 等回复）返回 MultiRawReply
+func readArrayBody(n int64, reader *bufio.Reader) (myredis.Reply, error) {
+	lines := make([][]byte, 0, n)
+	replies := make([]myredis.Reply, 0, n)
+	allBulk := true
+	for i := int64(0); i < n; i++ {
+		line, err := reader.ReadBytes('\n')
 		if err != nil {
-			return err
+			return nil, err
 		}
 		length := len(line)
-		if length < 4 || line[length-2] != '\r' || line[0] != '$' {
-			protocolError(ch, "illegal bulk string header "+string(line))
-			break
+		if length < 3 || line[length-2] != '\r' {
+			return nil, &protocolErr{"illegal array element header " + string(line)}
 		}
-		// 类似于 Bulk String
-		strLen, err := strconv.ParseInt(string(line[1:length-2]), 10, 64)
-		if err != nil || strLen < -1 {
-			protocolError(ch, "illegal bulk string length "+string(line))
-			break
-		} else if strLen == -1 {
-			lines = append(lines, []byte{})
-		} else {
-			body := make([]byte, strLen+2)
-			_, err := io.ReadFull(reader, body)
+		line = line[:length-2]
+		if line[0] == '$' {
+			// 类似于 Bulk String
+			body, err := readBulkBody(line, reader)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			lines = append(lines, body[:len(body)-2])
+			if body == nil {
+				lines = append(lines, []byte{})
+				replies = append(replies, protocol.MakeNullBulkReply())
+			} else {
+				lines = append(lines, body)
+				replies = append(replies, protocol.MakeBulkReply(body))
+			}
+			continue
 		}
+		allBulk = false
+		elem, err := readElement(line, reader)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, elem)
 	}
-	ch <- &Payload{
-		Data: protocol.MakeMultiBulkReply(lines),
+	if allBulk {
+		return protocol.MakeMultiBulkReply(lines), nil
 	}
-	return nil
+	return protocol.MakeMultiRawReply(replies), nil
+}
+
+// 读取 bulk string 的内容，header 不含 CRLF，长度为 -1 时返回 nil
+func readBulkBody(header []byte, reader *bufio.Reader) ([]byte, error) {
+	strLen, err := strconv.ParseInt(string(header[1:]), 10, 64)
+	if err != nil || strLen < -1 {
+		return nil, &protocolErr{"illegal bulk string length " + string(header)}
+	} else if strLen == -1 {
+		return nil, nil
+	}
+	body := make([]byte, strLen+2)
+	_, err = io.ReadFull(reader, body)
+	if err != nil {
+		return nil, err
+	}
+	return body[:len(body)-2], nil
+}
+
+// 读取数组中非 bulk string 的元素，header 不含 CRLF
+func readElement(header []byte, reader *bufio.Reader) (myredis.Reply, error) {
+	content := string(header[1:])
+	switch header[0] {
+	case '+':
+		return protocol.MakeStatusReply(content), nil
+	case '-':
+		return protocol.MakeErrReply(content), nil
+	case ':':
+		value, err := strconv.ParseInt(content, 10, 64)
+		if err != nil {
+			return nil, &protocolErr{"illegal number " + content}
+		}
+		return protocol.MakeIntReply(value), nil
+	case '*':
+		n, err := strconv.ParseInt(content, 10, 64)
+		if err != nil || n < -1 {
+			return nil, &protocolErr{"illegal array header " + content}
+		}
+		if n <= 0 {
+			return protocol.MakeEmptyMultiBulkReply(), nil
+		}
+		return readArrayBody(n, reader)
+	}
+	return nil, &protocolErr{"illegal array element header " + string(header)}
 }
 
 // 封装错误，通过 chan 传递到 PayLoad
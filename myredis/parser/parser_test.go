@@ -77,3 +77,33 @@ func TestParseOne(t *testing.T) {
 		fmt.Println(result, re)
 	}
 }
+
+func TestParseNestedArray(t *testing.T) {
+	reply := protocol.MakeMultiRawReply([]myredis.Reply{
+		protocol.MakeMultiRawReply([]myredis.Reply{
+			protocol.MakeIntReply(0),
+			protocol.MakeIntReply(511),
+			protocol.MakeMultiRawReply([]myredis.Reply{
+				protocol.MakeBulkReply([]byte("127.0.0.1")),
+				protocol.MakeIntReply(6399),
+			}),
+		}),
+		protocol.MakeStatusReply("OK"),
+		protocol.MakeNullBulkReply(),
+	})
+	result, err := ParseOne(reply.ToBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result.ToBytes(), reply.ToBytes()) {
+		t.Errorf("expected %q, actually %q", reply.ToBytes(), result.ToBytes())
+	}
+	// 只包含 bulk string 的数组仍解析为 MultiBulkReply
+	result, err = ParseOne(protocol.MakeMultiBulkReply([][]byte{[]byte("a"), []byte("b")}).ToBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result.(*protocol.MultiBulkReply); !ok {
+		t.Errorf("expected multi bulk reply, actually %T", result)
+	}
+}
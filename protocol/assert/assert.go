@@ -125,3 +125,15 @@ func AssertMultiBulkReplySize(t *testing.T, actual myredis.Reply, expected int)
 		return
 	}
 }
+
+func AssertNullBulk(t *testing.T, actual myredis.Reply) {
+	if !utils.BytesEquals(actual.ToBytes(), protocol.MakeNullBulkReply().ToBytes()) {
+		t.Errorf("expected null bulk protocol, actually %s, %s", actual.ToBytes(), printStack())
+	}
+}
+
+func AssertNullMultiBulk(t *testing.T, actual myredis.Reply) {
+	if !utils.BytesEquals(actual.ToBytes(), protocol.MakeNullMultiBulkReply().ToBytes()) {
+		t.Errorf("expected null multi bulk protocol, actually %s, %s", actual.ToBytes(), printStack())
+	}
+}
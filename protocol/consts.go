@@ -66,6 +66,19 @@ func IsEmptyMultiBulkReply(reply myredis.Reply) bool {
 	return bytes.Equal(reply.ToBytes(), emptyMultiBulkBytes)
 }
 
+// 阻塞命令超时等情况下返回空数组
+var nullMultiBulkBytes = []byte("*-1\r\n")
+
+type NullMultiBulkReply struct{}
+
+func (r *NullMultiBulkReply) ToBytes() []byte {
+	return nullMultiBulkBytes
+}
+
+func MakeNullMultiBulkReply() *NullMultiBulkReply {
+	return &NullMultiBulkReply{}
+}
+
 // 有些命令不返回任何内容
 type NoReply struct{}
 